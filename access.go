@@ -0,0 +1,157 @@
+package kubernetes
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// AccessChecker is implemented by the registry to check at startup that
+// RBAC lets it do what it is configured to, rather than failing with
+// forbidden errors once watching or registering.
+type AccessChecker interface {
+	// CheckAccess reviews the permissions the registry needs in its
+	// namespace, and the ones to register services when register is set.
+	// It returns a single error listing every permission missing.
+	CheckAccess(register bool) error
+}
+
+// permission is a verb on a resource, cluster wide unless namespaced, on
+// the named object only when a name is given.
+type permission struct {
+	verb       string
+	resource   string
+	name       string
+	namespaced bool
+}
+
+func (p permission) String() string {
+	s := p.verb + " " + p.resource
+	if len(p.name) > 0 {
+		s += "/" + p.name
+	}
+
+	if !p.namespaced {
+		s += " (cluster wide)"
+	}
+
+	return s
+}
+
+// CheckAccess reviews the permissions the registry needs through self
+// subject access reviews.
+func (c *kregistry) CheckAccess(register bool) error {
+	reviewer, ok := c.accessReviewer()
+	if !ok {
+		return ErrAccessReviewUnsupported
+	}
+
+	var missing []string
+
+	for _, p := range c.permissions(register) {
+		allowed, err := reviewer.ReviewAccess(p.verb, p.resource, p.name, p.namespaced)
+		if err != nil {
+			return errors.Wrapf(err, "failed to review access to %s", p)
+		}
+
+		if !allowed {
+			missing = append(missing, p.String())
+		}
+	}
+
+	if len(missing) > 0 {
+		return errors.Wrapf(ErrAccessDenied, "missing %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// accessReviewer returns the client reviewing access, the one wrapped when
+// discovering from named pods.
+func (c *kregistry) accessReviewer() (client.AccessReviewer, bool) {
+	kc := c.client
+	if named, ok := kc.(namedPodsClient); ok {
+		kc = named.Kubernetes
+	}
+
+	reviewer, ok := kc.(client.AccessReviewer)
+
+	return reviewer, ok
+}
+
+// permissions returns the permissions the registry needs as configured,
+// as documented in the RBAC section of the README.
+func (c *kregistry) permissions(register bool) []permission {
+	var perms []permission
+
+	switch named, ok := c.client.(namedPodsClient); {
+	case ok:
+		for _, name := range named.names {
+			perms = append(perms, permission{verb: "get", resource: "pods", name: name, namespaced: true})
+		}
+	case c.configMaps:
+		perms = append(perms,
+			permission{verb: "list", resource: "configmaps", namespaced: true},
+			permission{verb: "watch", resource: "configmaps", namespaced: true},
+		)
+	default:
+		perms = append(perms,
+			permission{verb: "list", resource: "pods", namespaced: true},
+			permission{verb: "watch", resource: "pods", namespaced: true},
+		)
+	}
+
+	for _, kubeService := range sortedValues(c.serviceSelectors) {
+		perms = append(perms, permission{verb: "get", resource: "services", name: kubeService, namespaced: true})
+	}
+
+	if c.skipCordoned {
+		perms = append(perms,
+			permission{verb: "list", resource: "nodes"},
+			permission{verb: "watch", resource: "nodes"},
+		)
+	}
+
+	if c.topology != nil && !c.skipCordoned {
+		perms = append(perms, permission{verb: "list", resource: "nodes"})
+	}
+
+	if !register {
+		return perms
+	}
+
+	// the self pod is got before being patched, it is unknown outside of
+	// a pod though.
+	podName, _ := getPodName()
+
+	perms = append(perms,
+		permission{verb: "get", resource: "pods", name: podName, namespaced: true},
+		permission{verb: "patch", resource: "pods", name: podName, namespaced: true},
+	)
+
+	if len(c.configMapTarget) > 0 {
+		perms = append(perms, permission{verb: "patch", resource: "configmaps", name: c.configMapTarget, namespaced: true})
+	}
+
+	return perms
+}
+
+// sortedValues returns the distinct values of a map, sorted.
+func sortedValues(m map[string]string) []string {
+	seen := make(map[string]bool, len(m))
+	values := make([]string, 0, len(m))
+
+	for _, v := range m {
+		if !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+
+	sort.Strings(values)
+
+	return values
+}
@@ -0,0 +1,101 @@
+package kubernetes
+
+import (
+	"strings"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// updatePod patches the labels and annotations of a pod, nil values
+// removing them, or applies them as the field manager when using
+// server-side apply.
+func (c *kregistry) updatePod(podName string, patch *client.Pod) error {
+	if len(c.fieldManager) == 0 {
+		p, err := c.client.UpdatePod(podName, patch)
+		if err == nil {
+			c.cacheWrite(p)
+		}
+
+		return err
+	}
+
+	pa, ok := c.client.(client.PodApplier)
+	if !ok {
+		return ErrApplyUnsupported
+	}
+
+	c.applyMtx.Lock()
+	defer c.applyMtx.Unlock()
+
+	p, err := c.client.GetPod(podName)
+	if err != nil {
+		return err
+	}
+
+	// the fields not applied are no longer owned, so every field of the
+	// registry is applied, as patched.
+	applied := &client.Pod{
+		Metadata: &client.Meta{
+			Labels:      make(map[string]*string),
+			Annotations: make(map[string]*string),
+		},
+	}
+
+	if p.Metadata != nil {
+		ownedFields(applied.Metadata.Labels, p.Metadata.Labels)
+		c.ownedSelectors(applied.Metadata.Labels, p.Metadata.Labels)
+		ownedFields(applied.Metadata.Annotations, p.Metadata.Annotations)
+	}
+
+	patchFields(applied.Metadata.Labels, patch.Metadata.Labels)
+	patchFields(applied.Metadata.Annotations, patch.Metadata.Annotations)
+
+	if p, err = pa.ApplyPod(podName, applied, c.fieldManager); err != nil {
+		return err
+	}
+
+	c.cacheWrite(p)
+
+	return nil
+}
+
+// registryKeyPrefixes are the prefixes of the labels and annotations the
+// registry sets on pods, or the keys themselves.
+var registryKeyPrefixes = []string{
+	labelTypeKey,
+	svcSelectorPrefix,
+	labelPayloadPrefix,
+	annotationServiceKeyPrefix,
+	annotationShardKeyPrefix,
+	annotationServicesKey,
+	annotationRegisteredByKey,
+}
+
+// ownedFields copies the labels or annotations set by the registry.
+func ownedFields(dst, src map[string]*string) {
+	for k, v := range src {
+		if v == nil {
+			continue
+		}
+
+		for _, prefix := range registryKeyPrefixes {
+			if strings.HasPrefix(k, prefix) {
+				dst[k] = v
+				break
+			}
+		}
+	}
+}
+
+// patchFields sets the labels or annotations of a patch, removing the ones
+// set to nil.
+func patchFields(dst, patch map[string]*string) {
+	for k, v := range patch {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+
+		dst[k] = v
+	}
+}
@@ -0,0 +1,56 @@
+package kubernetes
+
+import (
+	"time"
+
+	"go-micro.dev/v4/registry"
+)
+
+// audit actions.
+const (
+	AuditRegister   = "register"
+	AuditDeregister = "deregister"
+)
+
+// AuditRecord is a registration, or deregistration, of a service by the
+// registry, sent to the audit sink.
+type AuditRecord struct {
+	// Action is AuditRegister or AuditDeregister.
+	Action  string
+	Service string
+	Version string
+	// Pod is the pod the service was registered on, in Namespace, empty
+	// for the namespace of the registry.
+	Pod       string
+	Namespace string
+	// Instance identifies the registry that made the change.
+	Instance string
+	Time     time.Time
+	// Err is why the change failed, nil when it succeeded.
+	Err error
+}
+
+// audit sends a record of a change to the audit sink, if any, dropping it
+// when the sink is full rather than wait.
+func (c *kregistry) audit(action, namespace, podName string, s *registry.Service, err error) {
+	if c.auditSink == nil {
+		return
+	}
+
+	record := AuditRecord{
+		Action:    action,
+		Service:   c.normalize(s.Name),
+		Version:   s.Version,
+		Pod:       podName,
+		Namespace: namespace,
+		Instance:  c.instanceID,
+		Time:      time.Now(),
+		Err:       err,
+	}
+
+	select {
+	case c.auditSink <- record:
+	default:
+		c.logs.warnf("K8s Registry: audit sink full, dropping the record of %s %s", action, record.Service)
+	}
+}
@@ -0,0 +1,42 @@
+// Package backoff provides the strategies retries wait by between attempts.
+package backoff
+
+import "time"
+
+// Backoff tells how long to wait before an attempt.
+type Backoff interface {
+	// NextDelay returns the delay before an attempt, counted from 1 for the
+	// first retry.
+	NextDelay(attempt int) time.Duration
+}
+
+// Func adapts a function to a Backoff.
+type Func func(attempt int) time.Duration
+
+// NextDelay calls f.
+func (f Func) NextDelay(attempt int) time.Duration {
+	return f(attempt)
+}
+
+// Exponential doubles the delay from min on every attempt, up to max.
+func Exponential(min, max time.Duration) Backoff {
+	return Func(func(attempt int) time.Duration {
+		if attempt < 1 {
+			attempt = 1
+		}
+
+		d := min << (attempt - 1)
+		if d <= 0 || d > max || attempt > 62 {
+			return max
+		}
+
+		return d
+	})
+}
+
+// Constant waits the same delay before every attempt.
+func Constant(d time.Duration) Backoff {
+	return Func(func(int) time.Duration {
+		return d
+	})
+}
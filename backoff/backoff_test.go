@@ -0,0 +1,33 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExponential(t *testing.T) {
+	b := Exponential(100*time.Millisecond, time.Second)
+
+	for attempt, expected := range map[int]time.Duration{
+		0:   100 * time.Millisecond,
+		1:   100 * time.Millisecond,
+		2:   200 * time.Millisecond,
+		4:   800 * time.Millisecond,
+		5:   time.Second,
+		100: time.Second,
+	} {
+		if d := b.NextDelay(attempt); d != expected {
+			t.Fatalf("expected %s before attempt %d, got %s", expected, attempt, d)
+		}
+	}
+}
+
+func TestConstant(t *testing.T) {
+	b := Constant(time.Second)
+
+	for _, attempt := range []int{1, 2, 100} {
+		if d := b.NextDelay(attempt); d != time.Second {
+			t.Fatalf("expected 1s before attempt %d, got %s", attempt, d)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package kubernetes
+
+import (
+	"encoding/json"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/watch"
+)
+
+// addCache makes the pod cache of a watcher serve lookups until it stops.
+func (c *kregistry) addCache(k *k8sWatcher) {
+	c.cachesMtx.Lock()
+	defer c.cachesMtx.Unlock()
+
+	if c.caches == nil {
+		c.caches = make(map[*k8sWatcher]struct{})
+	}
+
+	c.caches[k] = struct{}{}
+}
+
+func (c *kregistry) removeCache(k *k8sWatcher) {
+	c.cachesMtx.Lock()
+	defer c.cachesMtx.Unlock()
+
+	delete(c.caches, k)
+}
+
+// cacheWrite handles a pod written by this registry as an event of the
+// watchers caching pods, when reading its own writes, so their cache holds
+// it as soon as written rather than once its watch event comes. Their
+// results are emitted then, and the watch event changes nothing anymore.
+// It blocks until the results are received.
+func (c *kregistry) cacheWrite(pod *client.Pod) {
+	if !c.readYourWrites || pod == nil || pod.Metadata == nil {
+		return
+	}
+
+	b, err := json.Marshal(pod)
+	if err != nil {
+		return
+	}
+
+	c.cachesMtx.Lock()
+	watchers := make([]*k8sWatcher, 0, len(c.caches))
+	for k := range c.caches {
+		watchers = append(watchers, k)
+	}
+	c.cachesMtx.Unlock()
+
+	for _, k := range watchers {
+		k.applyEvent(watch.Event{Type: watch.Modified, Object: b}, true)
+	}
+}
+
+// BreakerState returns the state of the circuit breaker of the client, as
+// client.Breaker.
+func (c *kregistry) BreakerState() client.BreakerState {
+	if b, ok := c.client.(client.Breaker); ok {
+		return b.BreakerState()
+	}
+
+	return client.BreakerClosed
+}
+
+// cachedService builds a service from the pod cache of a running watcher,
+// returning nil when none runs or it caches no pod of the service.
+func (c *kregistry) cachedService(name string) []*registry.Service {
+	var k *k8sWatcher
+
+	c.cachesMtx.Lock()
+	for w := range c.caches {
+		k = w
+		break
+	}
+	c.cachesMtx.Unlock()
+
+	if k == nil {
+		return nil
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	var pods []client.Pod
+
+	for _, pod := range k.pods {
+		if c.selects(pod.Metadata.Labels, name) {
+			pods = append(pods, *pod)
+		}
+	}
+
+	services, err := c.buildService(name, pods)
+	if err != nil {
+		return nil
+	}
+
+	return services
+}
@@ -40,6 +40,13 @@ var tests = []testcase{
 		Method: "GET",
 		URI:    "/api/v1/namespaces/test/services/bar",
 	},
+	{
+		ReqFn: func(opts *Options) *Request {
+			return NewRequest(opts).Get().Namespace("").Resource("nodes")
+		},
+		Method: "GET",
+		URI:    "/api/v1/nodes/",
+	},
 	{
 		ReqFn: func(opts *Options) *Request {
 			return NewRequest(opts).Get().Resource("pods").Params(&Params{LabelSelector: map[string]string{"foo": "bar"}})
@@ -47,6 +54,27 @@ var tests = []testcase{
 		Method: "GET",
 		URI:    "/api/v1/namespaces/default/pods/?labelSelector=foo%3Dbar",
 	},
+	{
+		ReqFn: func(opts *Options) *Request {
+			return NewRequest(opts).Get().Resource("pods").Params(&Params{FieldSelector: map[string]string{"metadata.name": "foo"}})
+		},
+		Method: "GET",
+		URI:    "/api/v1/namespaces/default/pods/?fieldSelector=metadata.name%3Dfoo",
+	},
+	{
+		ReqFn: func(opts *Options) *Request {
+			return NewRequest(opts).Get().Resource("pods").Params(&Params{LabelSelector: map[string]string{"foo": ""}})
+		},
+		Method: "GET",
+		URI:    "/api/v1/namespaces/default/pods/?labelSelector=foo",
+	},
+	{
+		ReqFn: func(opts *Options) *Request {
+			return NewRequest(opts).Get().Resource("pods").Params(&Params{Limit: 2, Continue: "abc"})
+		},
+		Method: "GET",
+		URI:    "/api/v1/namespaces/default/pods/?continue=abc&limit=2",
+	},
 	{
 		ReqFn: func(opts *Options) *Request {
 			return NewRequest(opts).Post().Resource("services").Name("foo").Body(map[string]string{"foo": "bar"})
@@ -79,6 +107,15 @@ var tests = []testcase{
 		URI:    "/api/v1/namespaces/default/endpoints/baz",
 		Header: map[string]string{"foo": "bar"},
 	},
+	{
+		ReqFn: func(opts *Options) *Request {
+			return NewRequest(opts).Apply("micro").Resource("pods").Name("foo").Body(map[string]string{"kind": "Pod"})
+		},
+		Method: "PATCH",
+		URI:    "/api/v1/namespaces/default/pods/foo?fieldManager=micro",
+		Body:   map[string]string{"kind": "Pod"},
+		Header: map[string]string{"Content-Type": "application/apply-patch+yaml"},
+	},
 }
 
 var wrappedHandler = func(t *testing.T, test *testcase) http.HandlerFunc {
@@ -3,23 +3,30 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/skiprco/go-micro-kubernetes-registry/client/watch"
 )
 
 // Request is used to construct a http request for the k8s API.
 type Request struct {
-	client    *http.Client
-	header    http.Header
-	params    url.Values
-	method    string
-	host      string
-	namespace string
+	client       *http.Client
+	header       http.Header
+	params       url.Values
+	method       string
+	host         string
+	apiPath      string
+	namespace    string
+	timeout      time.Duration
+	watchTimeout time.Duration
 
 	resource     string
 	resourceName *string
@@ -31,8 +38,13 @@ type Request struct {
 // Params is the object to pass in to set parameters
 // on a request.
 type Params struct {
+	// LabelSelector matches labels by value, or by key alone for an empty
+	// value.
 	LabelSelector map[string]string
+	FieldSelector map[string]string
 	Watch         bool
+	Limit         int
+	Continue      string
 }
 
 // Options ...
@@ -41,16 +53,23 @@ type Options struct {
 	Namespace   string
 	BearerToken *string
 	Client      *http.Client
+	// Timeout bounds requests, and establishing watches, when set.
+	Timeout time.Duration
+	// WatchTimeout is how long the API server keeps watches open, when set.
+	WatchTimeout time.Duration
 }
 
 // NewRequest creates a k8s api request.
 func NewRequest(opts *Options) *Request {
 	req := Request{
-		header:    make(http.Header),
-		params:    make(url.Values),
-		client:    opts.Client,
-		namespace: opts.Namespace,
-		host:      opts.Host,
+		header:       make(http.Header),
+		params:       make(url.Values),
+		client:       opts.Client,
+		namespace:    opts.Namespace,
+		host:         opts.Host,
+		apiPath:      "api/v1",
+		timeout:      opts.Timeout,
+		watchTimeout: opts.WatchTimeout,
 	}
 
 	if opts.BearerToken != nil {
@@ -87,12 +106,39 @@ func (r *Request) Patch() *Request {
 	return r.verb("PATCH").SetHeader("Content-Type", "application/strategic-merge-patch+json")
 }
 
+// Apply request, a server-side apply patch of the fields the field manager
+// owns, failing on conflicts with other managers.
+// https://kubernetes.io/docs/reference/using-api/server-side-apply/
+func (r *Request) Apply(fieldManager string) *Request {
+	r.params.Set("fieldManager", fieldManager)
+
+	return r.verb("PATCH").SetHeader("Content-Type", "application/apply-patch+yaml")
+}
+
+// AsTable requests objects as a table of the columns the API server
+// prints, each row holding the metadata of its object only, rather than the
+// whole objects. Servers that can't fall back to the objects.
+// https://kubernetes.io/docs/reference/using-api/api-concepts/#receiving-resources-as-tables
+func (r *Request) AsTable() *Request {
+	r.params.Set("includeObject", "Metadata")
+
+	return r.SetHeader("Accept", "application/json;as=Table;v=v1;g=meta.k8s.io,application/json")
+}
+
 // Delete request.
 func (r *Request) Delete() *Request {
 	return r.verb("DELETE")
 }
 
-// Namespace is to set the namespace to operate on.
+// Group targets the resources of an API group version, such as
+// "authorization.k8s.io/v1", rather than of the core API.
+func (r *Request) Group(groupVersion string) *Request {
+	r.apiPath = "apis/" + groupVersion
+	return r
+}
+
+// Namespace is to set the namespace to operate on, an empty namespace
+// targets cluster scoped resources such as nodes.
 func (r *Request) Namespace(s string) *Request {
 	r.namespace = s
 	return r
@@ -128,8 +174,12 @@ func (r *Request) Body(in interface{}) *Request {
 // Params isused to set parameters on a request.
 func (r *Request) Params(p *Params) *Request {
 	for k, v := range p.LabelSelector {
-		// create new key=value pair
+		// create new key=value pair, or select on the key alone
 		value := fmt.Sprintf("%s=%s", k, v)
+		if len(v) == 0 {
+			value = k
+		}
+
 		// check if there's an existing value
 		if label := r.params.Get("labelSelector"); len(label) > 0 {
 			value = fmt.Sprintf("%s,%s", label, value)
@@ -138,6 +188,23 @@ func (r *Request) Params(p *Params) *Request {
 		r.params.Set("labelSelector", value)
 	}
 
+	for k, v := range p.FieldSelector {
+		value := fmt.Sprintf("%s=%s", k, v)
+		if field := r.params.Get("fieldSelector"); len(field) > 0 {
+			value = fmt.Sprintf("%s,%s", field, value)
+		}
+
+		r.params.Set("fieldSelector", value)
+	}
+
+	if p.Limit > 0 {
+		r.params.Set("limit", strconv.Itoa(p.Limit))
+	}
+
+	if len(p.Continue) > 0 {
+		r.params.Set("continue", p.Continue)
+	}
+
 	return r
 }
 
@@ -150,7 +217,10 @@ func (r *Request) SetHeader(key, value string) *Request {
 
 // request builds the http.Request from the options.
 func (r *Request) request() (*http.Request, error) {
-	url := fmt.Sprintf("%s/api/v1/namespaces/%s/%s/", r.host, r.namespace, r.resource)
+	url := fmt.Sprintf("%s/%s/namespaces/%s/%s/", r.host, r.apiPath, r.namespace, r.resource)
+	if len(r.namespace) == 0 {
+		url = fmt.Sprintf("%s/%s/%s/", r.host, r.apiPath, r.resource)
+	}
 
 	// append resourceName if it is present
 	if r.resourceName != nil {
@@ -189,15 +259,29 @@ func (r *Request) Do() *Response {
 		}
 	}
 
+	// the deadline covers reading the body too, so is released on decode
+	var cancel context.CancelFunc = func() {}
+	if r.timeout > 0 {
+		var ctx context.Context
+
+		ctx, cancel = context.WithTimeout(context.Background(), r.timeout)
+		req = req.WithContext(ctx)
+	}
+
 	res, err := r.client.Do(req)
 	if err != nil {
+		cancel()
+
 		return &Response{
 			err: err,
 		}
 	}
 
 	// return res, err
-	return newResponse(res, err)
+	resp := newResponse(res, err)
+	resp.cancel = cancel
+
+	return resp
 }
 
 // Watch builds and triggers the request, but will watch instead of return
@@ -209,12 +293,17 @@ func (r *Request) Watch() (watch.Watch, error) {
 
 	r.params.Set("watch", "true")
 
+	// the server closes the watch once timed out, in whole seconds
+	if r.watchTimeout > 0 {
+		r.params.Set("timeoutSeconds", strconv.Itoa(int(math.Ceil(r.watchTimeout.Seconds()))))
+	}
+
 	req, err := r.request()
 	if err != nil {
 		return nil, err
 	}
 
-	w, err := watch.NewBodyWatcher(req, r.client)
+	w, err := watch.NewBodyWatcherTimeout(req, r.client, r.timeout)
 
 	return w, err
 }
@@ -13,14 +13,17 @@ import (
 var (
 	ErrNoPodName = errors.New("no pod name provided")
 	ErrNotFound  = errors.New("pod not found")
+	ErrForbidden = errors.New("forbidden by the API server")
+	ErrConflict  = errors.New("conflicts with another field manager")
 	ErrDecode    = errors.New("error decoding")
 	ErrOther     = errors.New("unspecified error occurred in k8s registry")
 )
 
 // Response ...
 type Response struct {
-	res *http.Response
-	err error
+	res    *http.Response
+	err    error
+	cancel func()
 }
 
 // Error returns an error.
@@ -35,6 +38,10 @@ func (r *Response) StatusCode() int {
 
 // Decode decodes body into `data`.
 func (r *Response) Decode(data interface{}) error {
+	if r.cancel != nil {
+		defer r.cancel()
+	}
+
 	if r.err != nil {
 		return r.err
 	}
@@ -77,6 +84,16 @@ func newResponse(r *http.Response, err error) *Response {
 		return resp
 	}
 
+	if resp.res.StatusCode == http.StatusForbidden {
+		resp.err = ErrForbidden
+		return resp
+	}
+
+	if resp.res.StatusCode == http.StatusConflict {
+		resp.err = ErrConflict
+		return resp
+	}
+
 	log.Errorf("K8s: request failed with code %v", resp.res.StatusCode)
 
 	b, err := io.ReadAll(resp.res.Body)
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/backoff"
+)
+
+// ErrCircuitOpen is returned for requests the circuit breaker short-circuits
+// while the API server is failing.
+var ErrCircuitOpen = errors.New("circuit breaker open, the API server is failing")
+
+// BreakerState is the state of the circuit breaker of a client.
+type BreakerState int
+
+const (
+	// BreakerClosed lets requests through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen short-circuits requests until the cooldown ends.
+	BreakerOpen
+	// BreakerHalfOpen lets a single request through to probe the API
+	// server, closing the breaker when it succeeds.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker is implemented by clients to tell the state of their circuit
+// breaker, always closed without one.
+type Breaker interface {
+	BreakerState() BreakerState
+}
+
+// breaker is a circuit breaker around the transport to the API server. It
+// opens after a number of consecutive failures, either errors or overloaded
+// responses, and probes the API server again after a cooldown, depending on
+// how many times in a row it opened.
+type breaker struct {
+	next      http.RoundTripper
+	threshold int
+	cooldown  backoff.Backoff
+
+	sync.Mutex
+	state    BreakerState
+	failures int
+	opened   time.Time
+	opens    int
+}
+
+func newBreaker(next http.RoundTripper, threshold int, cooldown backoff.Backoff) *breaker {
+	return &breaker{next: next, threshold: threshold, cooldown: cooldown}
+}
+
+func (b *breaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !b.allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	res, err := b.next.RoundTrip(req)
+	b.record(err == nil && !overloaded(res.StatusCode))
+
+	return res, err
+}
+
+// allow reports whether a request may go through, letting a single probe
+// through once the cooldown ended.
+func (b *breaker) allow() bool {
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.opened) < b.cooldown.NextDelay(b.opens) {
+			return false
+		}
+
+		b.state = BreakerHalfOpen
+
+		return true
+	case BreakerHalfOpen:
+		return false
+	default:
+		return true
+	}
+}
+
+func (b *breaker) record(ok bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	if ok {
+		b.state = BreakerClosed
+		b.failures = 0
+		b.opens = 0
+
+		return
+	}
+
+	b.failures++
+
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.opened = time.Now()
+		b.opens++
+	}
+}
+
+func (b *breaker) State() BreakerState {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.state
+}
+
+// overloaded reports whether a status tells the API server is failing or
+// throttling, rather than rejecting the request itself.
+func overloaded(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
@@ -3,13 +3,18 @@ package client
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"errors"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 
 	"go-micro.dev/v4/logger"
+	"golang.org/x/net/http/httpproxy"
 
+	"github.com/skiprco/go-micro-kubernetes-registry/backoff"
 	"github.com/skiprco/go-micro-kubernetes-registry/client/api"
 	"github.com/skiprco/go-micro-kubernetes-registry/client/watch"
 )
@@ -23,90 +28,237 @@ var (
 
 // Client ...
 type client struct {
-	opts *api.Options
+	opts    *api.Options
+	breaker *breaker
+
+	// whether pods are listed and watched as tables.
+	podTables bool
 }
 
 // NewClientByHost sets up a client by host.
-func NewClientByHost(host string) Kubernetes {
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			//nolint:gosec
-			InsecureSkipVerify: true,
-		},
-		DisableCompression: true,
+func NewClientByHost(host string, opts ...Option) Kubernetes {
+	o := newOptions(opts...)
+	if o.Proxy == nil {
+		o.Proxy = environmentProxy()
 	}
 
+	tr, b := newRoundTripper(&tls.Config{
+		//nolint:gosec
+		InsecureSkipVerify: true,
+	}, o)
+
 	c := &http.Client{
 		Transport: tr,
 	}
 
 	return &client{
 		opts: &api.Options{
-			Client:    c,
-			Host:      host,
-			Namespace: "default",
+			Client:       c,
+			Host:         host,
+			Namespace:    "default",
+			Timeout:      o.Timeout,
+			WatchTimeout: o.WatchTimeout,
 		},
+		breaker:   b,
+		podTables: o.PodTables,
 	}
 }
 
 // NewClientInCluster should work similarly to the official api
 // NewInClient by setting up a client configuration for use within
-// a k8s pod.
-func NewClientInCluster() Kubernetes {
-	host := "https://" + os.Getenv("KUBERNETES_SERVICE_HOST") + ":" + os.Getenv("KUBERNETES_SERVICE_PORT")
+// a k8s pod. The API server coordinates and service account files can be
+// overridden through options.
+func NewClientInCluster(opts ...Option) Kubernetes {
+	o := newOptions(opts...)
 
-	s, err := os.Stat(serviceAccountPath)
-	if err != nil {
-		logger.Fatal(err)
+	if len(o.Host) == 0 {
+		o.Host = os.Getenv("KUBERNETES_SERVICE_HOST")
 	}
 
-	if s == nil || !s.IsDir() {
-		logger.Fatal(errors.New("no k8s service account found"))
+	if len(o.Port) == 0 {
+		o.Port = os.Getenv("KUBERNETES_SERVICE_PORT")
 	}
 
-	t, err := os.ReadFile(path.Join(serviceAccountPath, "token"))
+	host := "https://" + net.JoinHostPort(o.Host, o.Port)
+
+	// the in-cluster API server is reached directly unless told otherwise
+	if o.Proxy == nil {
+		o.Proxy = environmentProxy(o.Host)
+	}
+
+	if len(o.TokenPath) == 0 || len(o.CAPath) == 0 || len(o.NamespacePath) == 0 {
+		s, err := os.Stat(serviceAccountPath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		if s == nil || !s.IsDir() {
+			logger.Fatal(errors.New("no k8s service account found"))
+		}
+	}
+
+	t, err := os.ReadFile(orDefault(o.TokenPath, path.Join(serviceAccountPath, "token")))
 	if err != nil {
 		logger.Fatal(err)
 	}
 
 	token := string(t)
 
-	ns, err := detectNamespace()
+	ns, err := detectNamespace(orDefault(o.NamespacePath, path.Join(serviceAccountPath, "namespace")))
 	if err != nil {
 		logger.Fatal(err)
 	}
 
-	crt, err := CertPoolFromFile(path.Join(serviceAccountPath, "ca.crt"))
+	crt, err := CertPoolFromFile(orDefault(o.CAPath, path.Join(serviceAccountPath, "ca.crt")))
 	if err != nil {
 		logger.Fatal(err)
 	}
 
+	tr, b := newRoundTripper(&tls.Config{
+		RootCAs:    crt,
+		MinVersion: tls.VersionTLS12,
+	}, o)
+
 	c := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs:    crt,
-				MinVersion: tls.VersionTLS12,
-			},
-			DisableCompression: true,
-		},
+		Transport: tr,
 	}
 
 	return &client{
 		opts: &api.Options{
-			Client:      c,
-			Host:        host,
-			Namespace:   ns,
-			BearerToken: &token,
+			Client:       c,
+			Host:         host,
+			Namespace:    ns,
+			BearerToken:  &token,
+			Timeout:      o.Timeout,
+			WatchTimeout: o.WatchTimeout,
 		},
+		breaker:   b,
+		podTables: o.PodTables,
+	}
+}
+
+// newRoundTripper builds the transport to the API server, wrapped in a
+// circuit breaker when one is configured.
+func newRoundTripper(tlsConfig *tls.Config, o Options) (http.RoundTripper, *breaker) {
+	tr := newTransport(tlsConfig, o)
+	if o.BreakerThreshold <= 0 {
+		return tr, nil
+	}
+
+	cooldown := o.BreakerBackoff
+	if cooldown == nil {
+		cooldown = backoff.Constant(o.BreakerCooldown)
+	}
+
+	b := newBreaker(tr, o.BreakerThreshold, cooldown)
+
+	return b, b
+}
+
+// newTransport builds the http transport used to talk to the API server,
+// with its connection pool sized from the options.
+func newTransport(tlsConfig *tls.Config, o Options) *http.Transport {
+	return &http.Transport{
+		TLSClientConfig:     tlsConfig,
+		DisableCompression:  o.DisableCompression,
+		MaxIdleConns:        o.MaxIdleConns,
+		MaxIdleConnsPerHost: o.MaxIdleConnsPerHost,
+		IdleConnTimeout:     o.IdleConnTimeout,
+		Proxy:               o.Proxy,
 	}
 }
 
+// environmentProxy selects proxies from HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY as set when called, never proxying the hosts given.
+func environmentProxy(direct ...string) func(*http.Request) (*url.URL, error) {
+	proxy := httpproxy.FromEnvironment().ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		for _, host := range direct {
+			if req.URL.Hostname() == host {
+				return nil, nil
+			}
+		}
+
+		return proxy(req.URL)
+	}
+}
+
+// InNamespace returns a client operating on the given namespace, sharing
+// the connection pool of this one.
+func (c *client) InNamespace(namespace string) Kubernetes {
+	opts := *c.opts
+	opts.Namespace = namespace
+
+	return &client{opts: &opts, breaker: c.breaker, podTables: c.podTables}
+}
+
+// BreakerState returns the state of the circuit breaker of the client.
+func (c *client) BreakerState() BreakerState {
+	if c.breaker == nil {
+		return BreakerClosed
+	}
+
+	return c.breaker.State()
+}
+
 // ListPods ...
 func (c *client) ListPods(labels map[string]string) (*PodList, error) {
-	var pods PodList
-	err := api.NewRequest(c.opts).Get().Resource("pods").Params(&api.Params{LabelSelector: labels}).Do().Decode(&pods)
+	return c.listPods(c.podsRequest().Params(&api.Params{LabelSelector: labels}))
+}
+
+// ListPodsPage ...
+func (c *client) ListPodsPage(labels map[string]string, limit int, continueToken string) (*PodList, error) {
+	return c.listPods(c.podsRequest().Params(&api.Params{
+		LabelSelector: labels,
+		Limit:         limit,
+		Continue:      continueToken,
+	}))
+}
+
+// podsRequest returns a request getting pods, as tables when asked to.
+func (c *client) podsRequest() *api.Request {
+	req := api.NewRequest(c.opts).Get().Resource("pods")
+	if c.podTables {
+		req = req.AsTable()
+	}
+
+	return req
+}
+
+// listPods decodes the pods listed, from a table when asked for one.
+func (c *client) listPods(req *api.Request) (*PodList, error) {
+	if !c.podTables {
+		var pods PodList
+		err := req.Do().Decode(&pods)
+
+		return &pods, err
+	}
+
+	var data json.RawMessage
+	if err := req.Do().Decode(&data); err != nil {
+		return &PodList{}, err
+	}
+
+	return decodePods(data)
+}
+
+// watchPods watches pods, as tables when asked to.
+func (c *client) watchPods(req *api.Request) (watch.Watch, error) {
+	w, err := req.Watch()
+	if err != nil || !c.podTables {
+		return w, err
+	}
 
-	return &pods, err
+	return newTableWatch(w), nil
+}
+
+// GetPod ...
+func (c *client) GetPod(name string) (*Pod, error) {
+	var pod Pod
+	err := api.NewRequest(c.opts).Get().Resource("pods").Name(name).Do().Decode(&pod)
+
+	return &pod, err
 }
 
 // UpdatePod ...
@@ -117,14 +269,110 @@ func (c *client) UpdatePod(name string, p *Pod) (*Pod, error) {
 	return &pod, err
 }
 
+// ApplyPod ...
+func (c *client) ApplyPod(name string, p *Pod, fieldManager string) (*Pod, error) {
+	meta := Meta{Name: name}
+	if p.Metadata != nil {
+		meta.Labels = p.Metadata.Labels
+		meta.Annotations = p.Metadata.Annotations
+	}
+
+	body := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   &meta,
+	}
+
+	var pod Pod
+	err := api.NewRequest(c.opts).Apply(fieldManager).Resource("pods").Name(name).Body(body).Do().Decode(&pod)
+
+	return &pod, err
+}
+
 // WatchPods ...
 func (c *client) WatchPods(labels map[string]string) (watch.Watch, error) {
-	return api.NewRequest(c.opts).Get().Resource("pods").Params(&api.Params{LabelSelector: labels}).Watch()
+	return c.watchPods(c.podsRequest().Params(&api.Params{LabelSelector: labels}))
+}
+
+// WatchPod ...
+func (c *client) WatchPod(name string) (watch.Watch, error) {
+	return c.watchPods(c.podsRequest().Params(&api.Params{
+		FieldSelector: map[string]string{"metadata.name": name},
+	}))
 }
 
-func detectNamespace() (string, error) {
-	nsPath := path.Join(serviceAccountPath, "namespace")
+// ListConfigMaps ...
+func (c *client) ListConfigMaps(labels map[string]string) (*ConfigMapList, error) {
+	var cms ConfigMapList
+	err := api.NewRequest(c.opts).Get().Resource("configmaps").Params(&api.Params{LabelSelector: labels}).Do().Decode(&cms)
 
+	return &cms, err
+}
+
+// WatchConfigMaps ...
+func (c *client) WatchConfigMaps(labels map[string]string) (watch.Watch, error) {
+	return api.NewRequest(c.opts).Get().Resource("configmaps").Params(&api.Params{LabelSelector: labels}).Watch()
+}
+
+// PatchConfigMap ...
+func (c *client) PatchConfigMap(name string, data map[string]*string) (*ConfigMap, error) {
+	var cm ConfigMap
+	err := api.NewRequest(c.opts).Patch().Resource("configmaps").Name(name).Body(map[string]interface{}{"data": data}).Do().Decode(&cm)
+
+	return &cm, err
+}
+
+// GetService ...
+func (c *client) GetService(name string) (*Service, error) {
+	var svc Service
+	err := api.NewRequest(c.opts).Get().Resource("services").Name(name).Do().Decode(&svc)
+
+	return &svc, err
+}
+
+// ReviewAccess ...
+func (c *client) ReviewAccess(verb, resource, name string, namespaced bool) (bool, error) {
+	attributes := map[string]string{"verb": verb, "resource": resource}
+	if len(name) > 0 {
+		attributes["name"] = name
+	}
+
+	if namespaced {
+		attributes["namespace"] = c.opts.Namespace
+	}
+
+	body := map[string]interface{}{
+		"apiVersion": "authorization.k8s.io/v1",
+		"kind":       "SelfSubjectAccessReview",
+		"spec":       map[string]interface{}{"resourceAttributes": attributes},
+	}
+
+	var review struct {
+		Status struct {
+			Allowed bool `json:"allowed"`
+		} `json:"status"`
+	}
+
+	err := api.NewRequest(c.opts).Post().Group("authorization.k8s.io/v1").Namespace("").
+		Resource("selfsubjectaccessreviews").Body(body).Do().Decode(&review)
+
+	return review.Status.Allowed, err
+}
+
+// ListNodes ...
+func (c *client) ListNodes() (*NodeList, error) {
+	var nodes NodeList
+	err := api.NewRequest(c.opts).Get().Namespace("").Resource("nodes").Do().Decode(&nodes)
+
+	return &nodes, err
+}
+
+// WatchNodes ...
+func (c *client) WatchNodes() (watch.Watch, error) {
+	return api.NewRequest(c.opts).Get().Namespace("").Resource("nodes").Watch()
+}
+
+func detectNamespace(nsPath string) (string, error) {
 	// Make sure it's a file and we can read it
 	if s, err := os.Stat(nsPath); err != nil {
 		return "", err
@@ -140,3 +388,11 @@ func detectNamespace() (string, error) {
 
 	return string(ns), nil
 }
+
+func orDefault(v, def string) string {
+	if len(v) == 0 {
+		return def
+	}
+
+	return v
+}
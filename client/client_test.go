@@ -0,0 +1,712 @@
+package client
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/backoff"
+)
+
+func TestClientOptions(t *testing.T) {
+	c, ok := NewClientByHost("http://localhost", MaxIdleConnsPerHost(10), IdleConnTimeout(time.Minute)).(*client)
+	if !ok {
+		t.Fatal("expected NewClientByHost to return a *client")
+	}
+
+	tr, ok := c.opts.Client.Transport.(*http.Transport)
+	if !ok {
+		t.Fatal("expected client to use an *http.Transport")
+	}
+
+	if tr.MaxIdleConnsPerHost != 10 {
+		t.Fatalf("expected MaxIdleConnsPerHost 10, got %d", tr.MaxIdleConnsPerHost)
+	}
+
+	if tr.IdleConnTimeout != time.Minute {
+		t.Fatalf("expected IdleConnTimeout 1m, got %v", tr.IdleConnTimeout)
+	}
+
+	// untouched options keep the defaults
+	if tr.MaxIdleConns != 200 {
+		t.Fatalf("expected default MaxIdleConns 200, got %d", tr.MaxIdleConns)
+	}
+}
+
+func TestClientProxy(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://proxy.example.com:3128")
+	t.Setenv("NO_PROXY", "internal.example.com")
+
+	tests := []struct {
+		name  string
+		proxy func(*http.Request) (*url.URL, error)
+		host  string
+		want  string
+	}{
+		{"environment", environmentProxy(), "https://10.96.0.1:443", "http://proxy.example.com:3128"},
+		{"no proxy", environmentProxy(), "https://internal.example.com:443", ""},
+		{"in-cluster", environmentProxy("10.96.0.1"), "https://10.96.0.1:443", ""},
+		{"in-cluster other host", environmentProxy("10.96.0.1"), "https://10.0.0.1:443", "http://proxy.example.com:3128"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest(http.MethodGet, test.host, nil)
+
+		u, err := test.proxy(req)
+		if err != nil {
+			t.Fatalf("%s: did not expect proxy selection to fail: %v", test.name, err)
+		}
+
+		var got string
+		if u != nil {
+			got = u.String()
+		}
+
+		if got != test.want {
+			t.Fatalf("%s: expected proxy %q, got %q", test.name, test.want, got)
+		}
+	}
+
+	// an explicit proxy overrides the environment
+	direct := func(*http.Request) (*url.URL, error) { return nil, nil }
+
+	c, _ := NewClientByHost("https://10.96.0.1:443", Proxy(direct)).(*client)
+	tr, _ := c.opts.Client.Transport.(*http.Transport)
+
+	req, _ := http.NewRequest(http.MethodGet, "https://10.96.0.1:443", nil)
+	if u, _ := tr.Proxy(req); u != nil {
+		t.Fatalf("expected the proxy option to override the environment, got %v", u)
+	}
+}
+
+func TestClientInClusterOverrides(t *testing.T) {
+	var gotAuth, gotPath string
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotPath = r.URL.Path
+		fmt.Fprint(w, `{"items":[]}`)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		return p
+	}
+
+	ca := write("ca.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+	token := write("token", []byte("secret"))
+	ns := write("namespace", []byte("team"))
+
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the environment is ignored when overridden
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.96.0.1")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "443")
+
+	c := NewClientInCluster(InClusterHost(host), InClusterPort(port), TokenPath(token), CAPath(ca), NamespacePath(ns))
+
+	if _, err := c.ListPods(map[string]string{}); err != nil {
+		t.Fatalf("did not expect listing pods to fail: %v", err)
+	}
+
+	if gotAuth != "Bearer secret" {
+		t.Fatalf("expected the overridden token, got %q", gotAuth)
+	}
+
+	if gotPath != "/api/v1/namespaces/team/pods/" {
+		t.Fatalf("expected the overridden namespace, got path %q", gotPath)
+	}
+}
+
+func TestClientFromKubeconfig(t *testing.T) {
+	var gotAuth, gotPath string
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotPath = r.URL.Path
+		fmt.Fprint(w, `{"items":[]}`)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		return p
+	}
+
+	write("ca.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+	write("token", []byte("secret\n"))
+
+	// files relative to the kubeconfig, no namespace in the context
+	kubeconfig := write("config", []byte(`
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: `+srv.URL+`
+    certificate-authority: ca.crt
+contexts:
+- name: other
+  context:
+    cluster: none
+- name: dev
+  context:
+    cluster: dev
+    user: dev
+users:
+- name: dev
+  user:
+    tokenFile: token
+`))
+
+	t.Setenv("KUBECONFIG", kubeconfig)
+
+	c, err := NewClientFromKubeconfig("")
+	if err != nil {
+		t.Fatalf("did not expect NewClientFromKubeconfig to fail: %v", err)
+	}
+
+	if _, err := c.ListPods(map[string]string{}); err != nil {
+		t.Fatalf("did not expect listing pods to fail: %v", err)
+	}
+
+	if gotAuth != "Bearer secret" {
+		t.Fatalf("expected the token of the file, got %q", gotAuth)
+	}
+
+	if gotPath != "/api/v1/namespaces/default/pods/" {
+		t.Fatalf("expected the default namespace, got path %q", gotPath)
+	}
+
+	write("config", []byte("current-context: missing\n"))
+
+	if _, err := NewClientFromKubeconfig(kubeconfig); !errors.Is(err, ErrKubeconfigContext) {
+		t.Fatalf("expected ErrKubeconfigContext, got %v", err)
+	}
+}
+
+func TestClientCompression(t *testing.T) {
+	var accepted atomic.Bool
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gzipped := strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
+		accepted.Store(gzipped)
+
+		body := `{"items":[{"metadata":{"name":"pod-1"},"status":{"phase":"Running"}}]}`
+		if r.URL.Query().Get("watch") == "true" {
+			body = `{"type":"ADDED","object":{"metadata":{"name":"pod-1"}}}` + "\n"
+		}
+
+		if !gzipped {
+			fmt.Fprint(w, body)
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+
+		gz := gzip.NewWriter(w)
+		fmt.Fprint(gz, body)
+
+		// flush the event without ending the stream, as the API server does
+		if err := gz.Flush(); err != nil {
+			t.Error(err)
+		}
+
+		w.(http.Flusher).Flush()
+
+		if r.URL.Query().Get("watch") == "true" {
+			<-r.Context().Done()
+		}
+
+		gz.Close()
+	}))
+	defer srv.Close()
+
+	c := NewClientByHost(srv.URL)
+
+	pods, err := c.ListPods(map[string]string{})
+	if err != nil {
+		t.Fatalf("did not expect listing pods to fail: %v", err)
+	}
+
+	if !accepted.Load() || len(pods.Items) != 1 || pods.Items[0].Metadata.Name != "pod-1" {
+		t.Fatalf("expected a gzip encoded list of pod-1, got %+v", pods.Items)
+	}
+
+	w, err := c.WatchPods(map[string]string{})
+	if err != nil {
+		t.Fatalf("did not expect watching pods to fail: %v", err)
+	}
+	defer w.Stop()
+
+	select {
+	case event := <-w.ResultChan():
+		if !strings.Contains(string(event.Object), "pod-1") {
+			t.Fatalf("expected an event for pod-1, got %s", event.Object)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a gzip encoded watch event")
+	}
+
+	// compression can be turned off
+	if _, err := NewClientByHost(srv.URL, Compression(false)).ListPods(map[string]string{}); err != nil || accepted.Load() {
+		t.Fatalf("expected a plain list without compression, got gzip %v: %v", accepted.Load(), err)
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	var (
+		requests atomic.Int32
+		failing  atomic.Bool
+	)
+
+	failing.Store(true)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprint(w, `{"items":[]}`)
+	}))
+	defer srv.Close()
+
+	c := NewClientByHost(srv.URL, CircuitBreaker(2, 100*time.Millisecond))
+	b, ok := c.(Breaker)
+	if !ok {
+		t.Fatal("expected the client to implement Breaker")
+	}
+
+	// opened by consecutive failures
+	for i := 0; i < 2; i++ {
+		if b.BreakerState() != BreakerClosed {
+			t.Fatalf("expected the breaker closed after %d failures, got %v", i, b.BreakerState())
+		}
+
+		//nolint:errcheck
+		c.ListPods(map[string]string{})
+	}
+
+	if b.BreakerState() != BreakerOpen {
+		t.Fatalf("expected the breaker open, got %v", b.BreakerState())
+	}
+
+	// short-circuited while open
+	if _, err := c.ListPods(map[string]string{}); !errors.Is(err, ErrCircuitOpen) || requests.Load() != 2 {
+		t.Fatalf("expected ErrCircuitOpen without a request, got %d requests: %v", requests.Load(), err)
+	}
+
+	// probed again after the cooldown, closing once it succeeds
+	failing.Store(false)
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := c.ListPods(map[string]string{}); err != nil {
+		t.Fatalf("expected the probe to succeed, got %v", err)
+	}
+
+	if b.BreakerState() != BreakerClosed {
+		t.Fatalf("expected the breaker closed, got %v", b.BreakerState())
+	}
+}
+
+func BenchmarkListPodsDefaultPool(b *testing.B) {
+	// 2 is the net/http default for MaxIdleConnsPerHost
+	benchmarkListPods(b, MaxIdleConnsPerHost(2))
+}
+
+func BenchmarkListPodsTunedPool(b *testing.B) {
+	benchmarkListPods(b)
+}
+
+func benchmarkListPods(b *testing.B, opts ...Option) {
+	b.Helper()
+
+	var conns atomic.Int64
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(time.Millisecond)
+		fmt.Fprint(w, `{"items":[]}`)
+	}))
+	// count new connections, a small pool keeps dialing under load
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	c := NewClientByHost(ts.URL, opts...)
+
+	b.SetParallelism(16)
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := c.ListPods(map[string]string{"foo": "bar"}); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+
+	b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+}
+
+func TestClientGetService(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/default/services/orders" {
+			http.NotFound(w, r)
+			return
+		}
+
+		fmt.Fprint(w, `{"metadata":{"name":"orders"},"spec":{"selector":{"app":"orders"},"ports":[`+
+			`{"name":"http","port":80,"targetPort":8080},{"name":"grpc","port":90,"targetPort":"grpc"}]}}`)
+	}))
+	defer srv.Close()
+
+	svc, err := NewClientByHost(srv.URL).(ServiceGetter).GetService("orders")
+	if err != nil {
+		t.Fatalf("did not expect getting the service to fail: %v", err)
+	}
+
+	if svc.Spec.Selector["app"] != "orders" || len(svc.Spec.Ports) != 2 {
+		t.Fatalf("expected the selector and ports of orders, got %+v", svc.Spec)
+	}
+
+	// target ports are numbers or names
+	if svc.Spec.Ports[0].TargetPort.IntVal != 8080 || svc.Spec.Ports[1].TargetPort.StrVal != "grpc" {
+		t.Fatalf("expected target ports 8080 and grpc, got %+v", svc.Spec.Ports)
+	}
+}
+
+func TestClientReviewAccess(t *testing.T) {
+	var reviews []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/apis/authorization.k8s.io/v1/selfsubjectaccessreviews/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var review struct {
+			Spec struct {
+				Attributes map[string]string `json:"resourceAttributes"`
+			} `json:"spec"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		a := review.Spec.Attributes
+		reviews = append(reviews, a["verb"]+" "+a["resource"]+" "+a["name"]+" "+a["namespace"])
+
+		fmt.Fprintf(w, `{"kind":"SelfSubjectAccessReview","status":{"allowed":%t}}`, a["verb"] != "patch")
+	}))
+	defer srv.Close()
+
+	reviewer := NewClientByHost(srv.URL).(AccessReviewer)
+
+	allowed, err := reviewer.ReviewAccess("list", "pods", "", true)
+	if err != nil || !allowed {
+		t.Fatalf("expected list pods to be allowed, got %v, %v", allowed, err)
+	}
+
+	allowed, err = reviewer.ReviewAccess("patch", "pods", "pod-1", true)
+	if err != nil || allowed {
+		t.Fatalf("expected patch pods to be denied, got %v, %v", allowed, err)
+	}
+
+	if _, err := reviewer.ReviewAccess("list", "nodes", "", false); err != nil {
+		t.Fatalf("did not expect reviewing list nodes to fail: %v", err)
+	}
+
+	want := []string{"list pods  default", "patch pods pod-1 default", "list nodes  "}
+	if !reflect.DeepEqual(reviews, want) {
+		t.Fatalf("expected the reviews %q, got %q", want, reviews)
+	}
+}
+
+func TestClientCircuitBreakerBackoff(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	var opens []int
+
+	c := NewClientByHost(srv.URL, CircuitBreaker(1, time.Hour), CircuitBreakerBackoff(backoff.Func(func(attempt int) time.Duration {
+		opens = append(opens, attempt)
+		return 0
+	})))
+
+	// opened, then probed right away as the backoff tells, failing again
+	for i := 0; i < 2; i++ {
+		//nolint:errcheck
+		c.ListPods(map[string]string{})
+
+		if state := c.(Breaker).BreakerState(); state != BreakerOpen {
+			t.Fatalf("expected the breaker open, got %v", state)
+		}
+
+		//nolint:errcheck
+		c.ListPods(map[string]string{})
+	}
+
+	// the cooldown of the backoff overrides the one of CircuitBreaker
+	if len(opens) == 0 || opens[0] != 1 || opens[len(opens)-1] < 2 {
+		t.Fatalf("expected the cooldown asked by times opened in a row, got %v", opens)
+	}
+}
+
+func TestClientApplyPod(t *testing.T) {
+	var (
+		contentType string
+		query       url.Values
+		body        map[string]interface{}
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch || r.URL.Path != "/api/v1/namespaces/default/pods/pod-1" {
+			http.NotFound(w, r)
+			return
+		}
+
+		contentType, query = r.Header.Get("Content-Type"), r.URL.Query()
+
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("failed to decode the applied pod: %v", err)
+		}
+
+		fmt.Fprint(w, `{"metadata":{"name":"pod-1"}}`)
+	}))
+	defer srv.Close()
+
+	v := "foo"
+	pod := &Pod{
+		Metadata: &Meta{
+			Labels:      map[string]*string{"micro.mu/type": &v},
+			Annotations: map[string]*string{"micro.mu/service-foo": &v},
+		},
+		Status: &Status{Phase: "Running"},
+	}
+
+	if _, err := NewClientByHost(srv.URL).(PodApplier).ApplyPod("pod-1", pod, "go-micro"); err != nil {
+		t.Fatalf("did not expect applying the pod to fail: %v", err)
+	}
+
+	if contentType != "application/apply-patch+yaml" || query.Get("fieldManager") != "go-micro" {
+		t.Fatalf("expected a server-side apply as go-micro, got %s with %v", contentType, query)
+	}
+
+	want := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"name":        "pod-1",
+			"labels":      map[string]interface{}{"micro.mu/type": "foo"},
+			"annotations": map[string]interface{}{"micro.mu/service-foo": "foo"},
+		},
+	}
+
+	// only the fields owned are applied, not the status
+	if !reflect.DeepEqual(body, want) {
+		t.Fatalf("expected the applied pod %v, got %v", want, body)
+	}
+}
+
+func TestClientWatchTimeout(t *testing.T) {
+	queries := make(chan url.Values, 2)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.Query()
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		timeout time.Duration
+		want    string
+	}{
+		{0, ""},
+		// rounded up to whole seconds
+		{90*time.Second + time.Millisecond, "91"},
+	} {
+		w, err := NewClientByHost(srv.URL, WatchTimeout(tc.timeout)).WatchPods(map[string]string{})
+		if err != nil {
+			t.Fatalf("did not expect watching pods to fail: %v", err)
+		}
+
+		w.Stop()
+
+		q := <-queries
+		if q.Get("watch") != "true" || q.Get("timeoutSeconds") != tc.want {
+			t.Fatalf("expected a watch timing out after %q seconds, got %v", tc.want, q)
+		}
+	}
+}
+
+// podFixture is a pod as the API server returns it, specs and status whole.
+const podFixture = `{"metadata":{"name":"pod-1","namespace":"default","uid":"4f1b8c2e-9a57-4d38-b6b1-1f0e2c5a7d90",` +
+	`"resourceVersion":"123456","creationTimestamp":"2024-01-01T00:00:00Z",` +
+	`"labels":{"app":"foo","micro.mu/type":"service","micro.mu/selector-foo":"service","pod-template-hash":"6d4cf56db6"},` +
+	`"annotations":{"micro.mu/service-foo":"{\"name\":\"foo\",\"version\":\"1\",\"nodes\":[{\"id\":\"foo-1\",\"address\":\"10.0.0.1:8080\"}]}"},` +
+	`"ownerReferences":[{"apiVersion":"apps/v1","kind":"ReplicaSet","name":"foo-6d4cf56db6","uid":"0c9d3f1e-2b8a-4e67-9f10-3a5b7c9d1e2f","controller":true,"blockOwnerDeletion":true}]},` +
+	`"spec":{"volumes":[{"name":"kube-api-access-abcde","projected":{"sources":[{"serviceAccountToken":{"expirationSeconds":3607,"path":"token"}},` +
+	`{"configMap":{"name":"kube-root-ca.crt","items":[{"key":"ca.crt","path":"ca.crt"}]}},{"downwardAPI":{"items":[{"path":"namespace","fieldRef":{"apiVersion":"v1","fieldPath":"metadata.namespace"}}]}}],"defaultMode":420}}],` +
+	`"containers":[{"name":"foo","image":"registry.example.com/foo:1.2.3","ports":[{"name":"grpc","containerPort":8080,"protocol":"TCP"}],` +
+	`"env":[{"name":"MICRO_REGISTRY","value":"kubernetes"},{"name":"POD_NAME","valueFrom":{"fieldRef":{"apiVersion":"v1","fieldPath":"metadata.name"}}}],` +
+	`"resources":{"limits":{"cpu":"500m","memory":"256Mi"},"requests":{"cpu":"100m","memory":"128Mi"}},` +
+	`"volumeMounts":[{"name":"kube-api-access-abcde","readOnly":true,"mountPath":"/var/run/secrets/kubernetes.io/serviceaccount"}],` +
+	`"readinessProbe":{"grpc":{"port":8080,"service":""},"timeoutSeconds":1,"periodSeconds":10,"successThreshold":1,"failureThreshold":3},` +
+	`"terminationMessagePath":"/dev/termination-log","terminationMessagePolicy":"File","imagePullPolicy":"IfNotPresent"}],` +
+	`"restartPolicy":"Always","terminationGracePeriodSeconds":30,"dnsPolicy":"ClusterFirst","serviceAccountName":"foo","serviceAccount":"foo",` +
+	`"nodeName":"node-a","securityContext":{},"schedulerName":"default-scheduler","tolerations":[{"key":"node.kubernetes.io/not-ready","operator":"Exists","effect":"NoExecute","tolerationSeconds":300},` +
+	`{"key":"node.kubernetes.io/unreachable","operator":"Exists","effect":"NoExecute","tolerationSeconds":300}],"priority":0,"enableServiceLinks":true,"preemptionPolicy":"PreemptLowerPriority"},` +
+	`"status":{"phase":"Running","conditions":[{"type":"Initialized","status":"True","lastProbeTime":null,"lastTransitionTime":"2024-01-01T00:00:00Z"},` +
+	`{"type":"Ready","status":"True","lastProbeTime":null,"lastTransitionTime":"2024-01-01T00:00:05Z"},` +
+	`{"type":"ContainersReady","status":"True","lastProbeTime":null,"lastTransitionTime":"2024-01-01T00:00:05Z"},` +
+	`{"type":"PodScheduled","status":"True","lastProbeTime":null,"lastTransitionTime":"2024-01-01T00:00:00Z"}],` +
+	`"hostIP":"192.168.0.10","podIP":"10.0.0.1","podIPs":[{"ip":"10.0.0.1"}],"startTime":"2024-01-01T00:00:00Z",` +
+	`"containerStatuses":[{"name":"foo","state":{"running":{"startedAt":"2024-01-01T00:00:02Z"}},"lastState":{},"ready":true,"restartCount":0,` +
+	`"image":"registry.example.com/foo:1.2.3","imageID":"registry.example.com/foo@sha256:3b8f0c1d2e4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c",` +
+	`"containerID":"containerd://9f8e7d6c5b4a39281706f5e4d3c2b1a09f8e7d6c5b4a39281706f5e4d3c2b1a0","started":true}],"qosClass":"Burstable"}}`
+
+// tableFixture is the same pod as a table, its metadata only.
+const tableFixture = `{"kind":"Table","apiVersion":"meta.k8s.io/v1","metadata":{"resourceVersion":"123456"},` +
+	`"columnDefinitions":[{"name":"Name","type":"string"},{"name":"Ready","type":"string"},{"name":"Status","type":"string"},` +
+	`{"name":"Restarts","type":"string"},{"name":"Age","type":"string"},{"name":"IP","type":"string","priority":1},` +
+	`{"name":"Node","type":"string","priority":1},{"name":"Nominated Node","type":"string","priority":1},{"name":"Readiness Gates","type":"string","priority":1}],` +
+	`"rows":[{"cells":["pod-1","1/1","Running","0","5m","10.0.0.1","node-a","<none>","<none>"],` +
+	`"object":{"kind":"PartialObjectMetadata","apiVersion":"meta.k8s.io/v1","metadata":{"name":"pod-1","namespace":"default",` +
+	`"uid":"4f1b8c2e-9a57-4d38-b6b1-1f0e2c5a7d90","resourceVersion":"123456","creationTimestamp":"2024-01-01T00:00:00Z",` +
+	`"labels":{"app":"foo","micro.mu/type":"service","micro.mu/selector-foo":"service","pod-template-hash":"6d4cf56db6"},` +
+	`"annotations":{"micro.mu/service-foo":"{\"name\":\"foo\",\"version\":\"1\",\"nodes\":[{\"id\":\"foo-1\",\"address\":\"10.0.0.1:8080\"}]}"},` +
+	`"ownerReferences":[{"apiVersion":"apps/v1","kind":"ReplicaSet","name":"foo-6d4cf56db6","uid":"0c9d3f1e-2b8a-4e67-9f10-3a5b7c9d1e2f","controller":true,"blockOwnerDeletion":true}]}}}]}`
+
+func TestClientPodTables(t *testing.T) {
+	tables := make(chan bool, 2)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		table := strings.Contains(r.Header.Get("Accept"), "as=Table") && r.URL.Query().Get("includeObject") == "Metadata"
+		tables <- table
+
+		body := `{"items":[` + podFixture + `]}`
+		if table {
+			body = tableFixture
+		}
+
+		if r.URL.Query().Get("watch") == "true" {
+			fmt.Fprintf(w, `{"type":"MODIFIED","object":%s}`+"\n", body)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+
+			return
+		}
+
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	full, err := NewClientByHost(srv.URL).ListPods(map[string]string{})
+	if err != nil || <-tables {
+		t.Fatalf("did not expect listing whole pods to fail: %v", err)
+	}
+
+	c := NewClientByHost(srv.URL, PodTables(true))
+
+	pods, err := c.ListPods(map[string]string{})
+	if err != nil || !<-tables {
+		t.Fatalf("expected pods listed as a table, got %v", err)
+	}
+
+	// the same pod, as far as discovery is concerned
+	for _, list := range []*PodList{full, pods} {
+		if len(list.Items) != 1 {
+			t.Fatalf("expected pod-1, got %+v", list.Items)
+		}
+
+		p := list.Items[0]
+		if p.Metadata.Name != "pod-1" || *p.Metadata.Annotations["micro.mu/service-foo"] == "" ||
+			p.Status.Phase != "Running" || p.Status.PodIP != "10.0.0.1" || p.Spec.NodeName != "node-a" {
+			t.Fatalf("expected running pod-1 at 10.0.0.1 on node-a, got %+v %+v %+v", p.Metadata, p.Spec, p.Status)
+		}
+
+		ready := false
+		for _, c := range p.Status.Conditions {
+			ready = ready || c.Type == "Ready" && c.Status == "True"
+		}
+
+		if !ready {
+			t.Fatalf("expected pod-1 ready, got %+v", p.Status.Conditions)
+		}
+	}
+
+	w, err := c.WatchPods(map[string]string{})
+	if err != nil || !<-tables {
+		t.Fatalf("expected pods watched as a table, got %v", err)
+	}
+	defer w.Stop()
+
+	select {
+	case event := <-w.ResultChan():
+		var p Pod
+		if err := json.Unmarshal(event.Object, &p); err != nil || event.Type != "MODIFIED" || p.Metadata.Name != "pod-1" || p.Status.PodIP != "10.0.0.1" {
+			t.Fatalf("expected pod-1 modified, got %s %s: %v", event.Type, event.Object, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected an event for pod-1")
+	}
+
+	// the metadata and status columns alone are a fraction of the pod
+	if full, table := len(podFixture), len(tableFixture); table*2 > full {
+		t.Fatalf("expected the table of %d bytes to be less than half the pod of %d bytes", table, full)
+	}
+}
+
+func TestTablePhase(t *testing.T) {
+	for status, phase := range map[string]string{
+		"Running":           "Running",
+		"Terminating":       "Running",
+		"Completed":         "Succeeded",
+		"Failed":            "Failed",
+		"ContainerCreating": "Pending",
+		"CrashLoopBackOff":  "Pending",
+		"Init:0/1":          "Pending",
+	} {
+		if got := tablePhase(status); got != phase {
+			t.Errorf("expected status %s to be phase %s, got %s", status, phase, got)
+		}
+	}
+}
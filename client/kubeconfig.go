@@ -0,0 +1,226 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client/api"
+)
+
+// ErrKubeconfigContext error when the kubeconfig has no usable current
+// context.
+var ErrKubeconfigContext = errors.New("kubeconfig has no usable current context")
+
+// kubeconfig is the subset of a kubeconfig file the client understands.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// NewClientFromKubeconfig sets up a client from the current context of a
+// kubeconfig file, as kubectl does. An empty path means the first file of
+// KUBECONFIG, or else ~/.kube/config. Bearer tokens and client certificates
+// are supported, exec and auth provider plugins are not. Relative file
+// paths are resolved against the directory of the kubeconfig.
+func NewClientFromKubeconfig(path string, opts ...Option) (Kubernetes, error) {
+	if len(path) == 0 {
+		path = defaultKubeconfig()
+	}
+
+	b, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+
+	var kc kubeconfig
+	if err := yaml.Unmarshal(b, &kc); err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if len(p) == 0 || filepath.IsAbs(p) {
+			return p
+		}
+
+		return filepath.Join(dir, p)
+	}
+
+	var ctxCluster, ctxUser, ns string
+
+	found := false
+
+	for _, c := range kc.Contexts {
+		if c.Name == kc.CurrentContext {
+			ctxCluster, ctxUser, ns = c.Context.Cluster, c.Context.User, c.Context.Namespace
+			found = true
+
+			break
+		}
+	}
+
+	if !found {
+		return nil, ErrKubeconfigContext
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	var host string
+
+	for _, c := range kc.Clusters {
+		if c.Name != ctxCluster {
+			continue
+		}
+
+		host = c.Cluster.Server
+		//nolint:gosec
+		tlsConfig.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+
+		ca, err := fileOrData(resolve(c.Cluster.CertificateAuthority), c.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(ca) > 0 {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, errors.New("no certificate authority found in kubeconfig")
+			}
+
+			tlsConfig.RootCAs = pool
+		}
+	}
+
+	if len(host) == 0 {
+		return nil, ErrKubeconfigContext
+	}
+
+	var token *string
+
+	for _, u := range kc.Users {
+		if u.Name != ctxUser {
+			continue
+		}
+
+		t := u.User.Token
+		if len(t) == 0 && len(u.User.TokenFile) > 0 {
+			b, err := os.ReadFile(filepath.Clean(resolve(u.User.TokenFile)))
+			if err != nil {
+				return nil, err
+			}
+
+			t = strings.TrimSpace(string(b))
+		}
+
+		if len(t) > 0 {
+			token = &t
+		}
+
+		cert, err := fileOrData(resolve(u.User.ClientCertificate), u.User.ClientCertificateData)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := fileOrData(resolve(u.User.ClientKey), u.User.ClientKeyData)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(cert) > 0 {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, err
+			}
+
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+
+	if len(ns) == 0 {
+		ns = "default"
+	}
+
+	o := newOptions(opts...)
+	if o.Proxy == nil {
+		o.Proxy = environmentProxy()
+	}
+
+	tr, br := newRoundTripper(tlsConfig, o)
+
+	return &client{
+		opts: &api.Options{
+			Client:       &http.Client{Transport: tr},
+			Host:         strings.TrimSuffix(host, "/"),
+			Namespace:    ns,
+			BearerToken:  token,
+			Timeout:      o.Timeout,
+			WatchTimeout: o.WatchTimeout,
+		},
+		breaker:   br,
+		podTables: o.PodTables,
+	}, nil
+}
+
+// defaultKubeconfig returns the kubeconfig kubectl uses by default.
+func defaultKubeconfig() string {
+	if env := os.Getenv("KUBECONFIG"); len(env) > 0 {
+		return filepath.SplitList(env)[0]
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".kube", "config")
+}
+
+// fileOrData reads a kubeconfig entry given either as a file or inline as
+// base64, preferring the inline data.
+func fileOrData(file, data string) ([]byte, error) {
+	if len(data) > 0 {
+		return base64.StdEncoding.DecodeString(data)
+	}
+
+	if len(file) == 0 {
+		return nil, nil
+	}
+
+	return os.ReadFile(filepath.Clean(file))
+}
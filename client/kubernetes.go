@@ -1,35 +1,198 @@
 package client
 
-import "github.com/skiprco/go-micro-kubernetes-registry/client/watch"
+import (
+	"encoding/json"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client/watch"
+)
 
 // Kubernetes ...
 type Kubernetes interface {
 	ListPods(labels map[string]string) (*PodList, error)
+	GetPod(podName string) (*Pod, error)
 	UpdatePod(podName string, pod *Pod) (*Pod, error)
 	WatchPods(labels map[string]string) (watch.Watch, error)
+	WatchPod(podName string) (watch.Watch, error)
+	ListConfigMaps(labels map[string]string) (*ConfigMapList, error)
+	WatchConfigMaps(labels map[string]string) (watch.Watch, error)
+	ListNodes() (*NodeList, error)
+	WatchNodes() (watch.Watch, error)
+}
+
+// Namespacer is implemented by clients able to operate on another
+// namespace than their own.
+type Namespacer interface {
+	InNamespace(namespace string) Kubernetes
+}
+
+// Pager is implemented by clients able to list pods in pages.
+type Pager interface {
+	// ListPodsPage lists at most limit pods, starting at the continue token
+	// of the previous page, or at the first page for an empty one.
+	ListPodsPage(labels map[string]string, limit int, continueToken string) (*PodList, error)
+}
+
+// ConfigMapPatcher is implemented by clients able to write config maps.
+type ConfigMapPatcher interface {
+	// PatchConfigMap sets data entries of an existing config map, removing
+	// the ones set to nil.
+	PatchConfigMap(name string, data map[string]*string) (*ConfigMap, error)
+}
+
+// PodApplier is implemented by clients able to update pods through
+// server-side apply.
+type PodApplier interface {
+	// ApplyPod applies the labels and annotations of a pod as the field
+	// manager, removing the ones it applied before but no longer does.
+	// Fields another manager set differently fail with api.ErrConflict.
+	ApplyPod(podName string, pod *Pod, fieldManager string) (*Pod, error)
+}
+
+// AccessReviewer is implemented by clients able to review what the identity
+// they authenticate as may do, through self subject access reviews.
+type AccessReviewer interface {
+	// ReviewAccess reports whether a verb is allowed on a resource of the
+	// namespace of the client, or cluster wide when not namespaced, and on
+	// the named object only when a name is given.
+	ReviewAccess(verb, resource, name string, namespaced bool) (bool, error)
+}
+
+// ServiceGetter is implemented by clients able to get Kubernetes services.
+type ServiceGetter interface {
+	GetService(name string) (*Service, error)
 }
 
 // PodList ...
 type PodList struct {
-	Items []Pod `json:"items"`
+	Metadata *ListMeta `json:"metadata,omitempty"`
+	Items    []Pod     `json:"items"`
+}
+
+// Continue returns the token to list the next page, empty for the last one.
+func (l *PodList) Continue() string {
+	if l.Metadata == nil {
+		return ""
+	}
+
+	return l.Metadata.Continue
+}
+
+// ListMeta ...
+type ListMeta struct {
+	Continue string `json:"continue,omitempty"`
 }
 
 // Pod is the top level item for a pod.
 type Pod struct {
-	Metadata *Meta   `json:"metadata"`
-	Status   *Status `json:"status"`
+	Metadata *Meta    `json:"metadata"`
+	Spec     *PodSpec `json:"spec,omitempty"`
+	Status   *Status  `json:"status"`
+}
+
+// PodSpec ...
+type PodSpec struct {
+	NodeName  string `json:"nodeName,omitempty"`
+	Hostname  string `json:"hostname,omitempty"`
+	Subdomain string `json:"subdomain,omitempty"`
 }
 
 // Meta ...
 type Meta struct {
 	Name              string             `json:"name,omitempty"`
+	Namespace         string             `json:"namespace,omitempty"`
+	ResourceVersion   string             `json:"resourceVersion,omitempty"`
 	Labels            map[string]*string `json:"labels,omitempty"`
 	Annotations       map[string]*string `json:"annotations,omitempty"`
+	CreationTimestamp string             `json:"creationTimestamp,omitempty"`
 	DeletionTimestamp string             `json:"deletionTimestamp,omitempty"`
 }
 
 // Status ...
 type Status struct {
-	PodIP string `json:"podIP"`
-	Phase string `json:"phase"`
+	PodIP             string            `json:"podIP"`
+	Phase             string            `json:"phase"`
+	Conditions        []PodCondition    `json:"conditions,omitempty"`
+	ContainerStatuses []ContainerStatus `json:"containerStatuses,omitempty"`
+}
+
+// PodCondition ...
+type PodCondition struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+}
+
+// ContainerStatus ...
+type ContainerStatus struct {
+	Name  string `json:"name"`
+	Ready bool   `json:"ready"`
+}
+
+// ConfigMapList ...
+type ConfigMapList struct {
+	Items []ConfigMap `json:"items"`
+}
+
+// ConfigMap is the top level item for a config map.
+type ConfigMap struct {
+	Metadata *Meta             `json:"metadata"`
+	Data     map[string]string `json:"data,omitempty"`
+}
+
+// NodeList ...
+type NodeList struct {
+	Items []Node `json:"items"`
+}
+
+// Node is the top level item for a node.
+type Node struct {
+	Metadata *Meta     `json:"metadata"`
+	Spec     *NodeSpec `json:"spec,omitempty"`
+}
+
+// NodeSpec ...
+type NodeSpec struct {
+	Unschedulable bool `json:"unschedulable,omitempty"`
+}
+
+// Service is the top level item for a Kubernetes service.
+type Service struct {
+	Metadata *Meta       `json:"metadata"`
+	Spec     ServiceSpec `json:"spec"`
+}
+
+// ServiceSpec ...
+type ServiceSpec struct {
+	Selector map[string]string `json:"selector,omitempty"`
+	Ports    []ServicePort     `json:"ports,omitempty"`
+}
+
+// ServicePort ...
+type ServicePort struct {
+	Name       string      `json:"name,omitempty"`
+	Port       int         `json:"port"`
+	TargetPort IntOrString `json:"targetPort,omitempty"`
+}
+
+// IntOrString holds a port number or name, as target ports are either.
+type IntOrString struct {
+	IntVal int
+	StrVal string
+}
+
+// UnmarshalJSON decodes a number or a string.
+func (v *IntOrString) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &v.StrVal)
+	}
+
+	return json.Unmarshal(b, &v.IntVal)
+}
+
+// MarshalJSON encodes the string when set, the number otherwise.
+func (v IntOrString) MarshalJSON() ([]byte, error) {
+	if len(v.StrVal) > 0 {
+		return json.Marshal(v.StrVal)
+	}
+
+	return json.Marshal(v.IntVal)
 }
@@ -3,7 +3,10 @@ package mock
 
 import (
 	"encoding/json"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -12,74 +15,306 @@ import (
 	"github.com/skiprco/go-micro-kubernetes-registry/client/watch"
 )
 
+// resource kinds events are broadcast for.
+const (
+	kindPod       = "pods"
+	kindConfigMap = "configmaps"
+	kindNode      = "nodes"
+)
+
 // Client ...
 type Client struct {
 	sync.RWMutex
-	Pods     map[string]*client.Pod
-	events   chan watch.Event
+	Pods       map[string]*client.Pod
+	ConfigMaps map[string]*client.ConfigMap
+	Nodes      map[string]*client.Node
+	Services   map[string]*client.Service
+	events     chan mockEvent
+	watchers   []*mockWatcher
+
+	// serializes reading and updating pods, as done concurrently by
+	// registrations. Tests setting up Pods directly do it before use.
+	podsMtx sync.RWMutex
+
+	// the metadata last applied to pods, by pod and field manager.
+	applied map[string]*client.Meta
+
+	// the resource version of the last change of a pod.
+	resourceVersion uint64
+
+	// the verbs denied, by "verb resource".
+	denied map[string]bool
+}
+
+// mockEvent is an event along with the watchers open when it happened.
+type mockEvent struct {
+	event    watch.Event
 	watchers []*mockWatcher
+	closed   *mockWatcher
 }
 
 // NewClient ...
 func NewClient() *Client {
 	c := &Client{
-		Pods:   make(map[string]*client.Pod),
-		events: make(chan watch.Event),
+		Pods:       make(map[string]*client.Pod),
+		ConfigMaps: make(map[string]*client.ConfigMap),
+		Nodes:      make(map[string]*client.Node),
+		Services:   make(map[string]*client.Service),
+		events:     make(chan mockEvent),
 	}
 
-	// broadcast events to watchers
+	// broadcast events to watchers, this is the only goroutine sending on
+	// or closing their results channels.
 	go func() {
 		for e := range c.events {
-			c.RLock()
-			for _, w := range c.watchers {
+			if e.closed != nil {
+				close(e.closed.results)
+				continue
+			}
+
+			for _, w := range e.watchers {
+				// skip watchers stopped since, their results may be closed
 				select {
 				case <-w.stop:
+					continue
 				default:
-					w.results <- e
+				}
+
+				select {
+				case <-w.stop:
+				case w.results <- e.event:
 				}
 			}
-			c.RUnlock()
 		}
 	}()
 
 	return c
 }
 
+// GetPod ...
+func (c *Client) GetPod(podName string) (*client.Pod, error) {
+	if podName == "" {
+		return nil, errors.Wrap(api.ErrNoPodName, "failed to get pod")
+	}
+
+	c.podsMtx.RLock()
+	defer c.podsMtx.RUnlock()
+
+	p, ok := c.Pods[podName]
+	if !ok {
+		return nil, api.ErrNotFound
+	}
+
+	var pod client.Pod
+	if err := deepCopy(p, &pod); err != nil {
+		return nil, err
+	}
+
+	return &pod, nil
+}
+
 // UpdatePod ...
 func (c *Client) UpdatePod(podName string, pod *client.Pod) (*client.Pod, error) {
 	if podName == "" {
 		return nil, errors.Wrap(api.ErrNoPodName, "failed to update pod")
 	}
 
+	c.podsMtx.Lock()
+
 	p, ok := c.Pods[podName]
 	if !ok {
+		c.podsMtx.Unlock()
 		return nil, api.ErrNotFound
 	}
 
 	updateMetadata(p.Metadata, pod.Metadata)
 
-	pstr, err := json.Marshal(p)
+	c.changed(p)
+
+	var updated client.Pod
+	err := deepCopy(p, &updated)
+
+	c.podsMtx.Unlock()
+
 	if err != nil {
 		return nil, err
 	}
 
-	c.events <- watch.Event{
-		Type:   watch.Modified,
-		Object: json.RawMessage(pstr),
+	if err := c.emit(kindPod, watch.Modified, &updated); err != nil {
+		return nil, err
 	}
 
-	//nolint:nilnil
-	return nil, nil
+	return &updated, nil
+}
+
+// ApplyPod sets the labels and annotations of a pod, removing the ones the
+// field manager applied before but no longer does, and emits a modified
+// event to pod watchers. Conflicts with other managers aren't detected.
+func (c *Client) ApplyPod(podName string, pod *client.Pod, fieldManager string) (*client.Pod, error) {
+	if podName == "" {
+		return nil, errors.Wrap(api.ErrNoPodName, "failed to apply pod")
+	}
+
+	c.podsMtx.Lock()
+
+	p, ok := c.Pods[podName]
+	if !ok {
+		c.podsMtx.Unlock()
+		return nil, api.ErrNotFound
+	}
+
+	patch := &client.Meta{
+		Labels:      make(map[string]*string),
+		Annotations: make(map[string]*string),
+	}
+
+	if last, ok := c.applied[podName+"/"+fieldManager]; ok {
+		for k := range last.Labels {
+			patch.Labels[k] = nil
+		}
+
+		for k := range last.Annotations {
+			patch.Annotations[k] = nil
+		}
+	}
+
+	for k, v := range pod.Metadata.Labels {
+		patch.Labels[k] = v
+	}
+
+	for k, v := range pod.Metadata.Annotations {
+		patch.Annotations[k] = v
+	}
+
+	updateMetadata(p.Metadata, patch)
+
+	if c.applied == nil {
+		c.applied = make(map[string]*client.Meta)
+	}
+
+	c.applied[podName+"/"+fieldManager] = &client.Meta{Labels: pod.Metadata.Labels, Annotations: pod.Metadata.Annotations}
+
+	c.changed(p)
+
+	var updated client.Pod
+	err := deepCopy(p, &updated)
+
+	c.podsMtx.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.emit(kindPod, watch.Modified, &updated); err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+// changed gives a pod the resource version of a new change, as the API
+// server does on every write.
+func (c *Client) changed(p *client.Pod) {
+	if p.Metadata == nil {
+		return
+	}
+
+	c.resourceVersion++
+	p.Metadata.ResourceVersion = strconv.FormatUint(c.resourceVersion, 10)
+}
+
+// TerminatePod starts deleting a pod gracefully, setting its deletion
+// timestamp, and emits a modified event to pod watchers.
+func (c *Client) TerminatePod(podName string) error {
+	c.podsMtx.Lock()
+
+	p, ok := c.Pods[podName]
+	if !ok {
+		c.podsMtx.Unlock()
+		return api.ErrNotFound
+	}
+
+	p.Metadata.DeletionTimestamp = time.Now().UTC().Format(time.RFC3339)
+
+	c.changed(p)
+
+	var updated client.Pod
+	err := deepCopy(p, &updated)
+
+	c.podsMtx.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return c.emit(kindPod, watch.Modified, &updated)
+}
+
+// SetPodIP sets the IP of a pod, as once its sandbox is set up, and emits a
+// modified event to pod watchers.
+func (c *Client) SetPodIP(podName, ip string) error {
+	c.podsMtx.Lock()
+
+	p, ok := c.Pods[podName]
+	if !ok {
+		c.podsMtx.Unlock()
+		return api.ErrNotFound
+	}
+
+	if p.Status == nil {
+		p.Status = &client.Status{}
+	}
+
+	p.Status.PodIP = ip
+
+	c.changed(p)
+
+	var updated client.Pod
+	err := deepCopy(p, &updated)
+
+	c.podsMtx.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	return c.emit(kindPod, watch.Modified, &updated)
+}
+
+// DeletePod removes a pod, and emits a deleted event to pod watchers.
+func (c *Client) DeletePod(podName string) error {
+	c.podsMtx.Lock()
+
+	p, ok := c.Pods[podName]
+	delete(c.Pods, podName)
+
+	c.podsMtx.Unlock()
+
+	if !ok {
+		return api.ErrNotFound
+	}
+
+	return c.emit(kindPod, watch.Deleted, p)
 }
 
 // ListPods ...
 func (c *Client) ListPods(labels map[string]string) (*client.PodList, error) {
+	c.podsMtx.RLock()
+	defer c.podsMtx.RUnlock()
+
 	var pods []client.Pod
 
 	for _, v := range c.Pods {
-		if labelFilterMatch(v.Metadata.Labels, labels) {
-			pods = append(pods, *v)
+		if !labelFilterMatch(v.Metadata.Labels, labels) {
+			continue
+		}
+
+		var pod client.Pod
+		if err := deepCopy(v, &pod); err != nil {
+			return nil, err
 		}
+
+		pods = append(pods, pod)
 	}
 
 	p := client.PodList{
@@ -89,14 +324,236 @@ func (c *Client) ListPods(labels map[string]string) (*client.PodList, error) {
 	return &p, nil
 }
 
+// ListPodsPage lists pods ordered by name, the continue token being the name
+// of the last pod of the previous page.
+func (c *Client) ListPodsPage(labels map[string]string, limit int, continueToken string) (*client.PodList, error) {
+	c.podsMtx.RLock()
+	defer c.podsMtx.RUnlock()
+
+	names := make([]string, 0, len(c.Pods))
+
+	for name, p := range c.Pods {
+		if name > continueToken && labelFilterMatch(p.Metadata.Labels, labels) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	list := &client.PodList{}
+
+	if limit > 0 && len(names) > limit {
+		names = names[:limit]
+		list.Metadata = &client.ListMeta{Continue: names[limit-1]}
+	}
+
+	for _, name := range names {
+		var pod client.Pod
+		if err := deepCopy(c.Pods[name], &pod); err != nil {
+			return nil, err
+		}
+
+		list.Items = append(list.Items, pod)
+	}
+
+	return list, nil
+}
+
 // WatchPods ...
-func (c *Client) WatchPods(labels map[string]string) (watch.Watch, error) {
+func (c *Client) WatchPods(_ map[string]string) (watch.Watch, error) {
+	return c.watch(kindPod, ""), nil
+}
+
+// WatchPod ...
+func (c *Client) WatchPod(podName string) (watch.Watch, error) {
+	return c.watch(kindPod, podName), nil
+}
+
+// ListConfigMaps ...
+func (c *Client) ListConfigMaps(labels map[string]string) (*client.ConfigMapList, error) {
+	var cms []client.ConfigMap
+
+	for _, v := range c.ConfigMaps {
+		if !labelFilterMatch(v.Metadata.Labels, labels) {
+			continue
+		}
+
+		var cm client.ConfigMap
+		if err := deepCopy(v, &cm); err != nil {
+			return nil, err
+		}
+
+		cms = append(cms, cm)
+	}
+
+	return &client.ConfigMapList{Items: cms}, nil
+}
+
+// WatchConfigMaps ...
+func (c *Client) WatchConfigMaps(_ map[string]string) (watch.Watch, error) {
+	return c.watch(kindConfigMap, ""), nil
+}
+
+// SetConfigMap creates or replaces a config map, and emits the matching
+// event to config map watchers.
+func (c *Client) SetConfigMap(cm *client.ConfigMap) error {
+	eventType := watch.Modified
+	if _, ok := c.ConfigMaps[cm.Metadata.Name]; !ok {
+		eventType = watch.Added
+	}
+
+	c.ConfigMaps[cm.Metadata.Name] = cm
+
+	return c.emit(kindConfigMap, eventType, cm)
+}
+
+// PatchConfigMap sets data entries of an existing config map, removing the
+// ones set to nil, and emits a modified event to config map watchers.
+func (c *Client) PatchConfigMap(name string, data map[string]*string) (*client.ConfigMap, error) {
+	cm, ok := c.ConfigMaps[name]
+	if !ok {
+		return nil, api.ErrNotFound
+	}
+
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+
+	for k, v := range data {
+		if v == nil {
+			delete(cm.Data, k)
+			continue
+		}
+
+		cm.Data[k] = *v
+	}
+
+	if err := c.emit(kindConfigMap, watch.Modified, cm); err != nil {
+		return nil, err
+	}
+
+	return cm, nil
+}
+
+// DeleteConfigMap removes a config map, and emits a delete event to config
+// map watchers.
+func (c *Client) DeleteConfigMap(name string) error {
+	cm, ok := c.ConfigMaps[name]
+	if !ok {
+		return api.ErrNotFound
+	}
+
+	delete(c.ConfigMaps, name)
+
+	return c.emit(kindConfigMap, watch.Deleted, cm)
+}
+
+// GetService ...
+func (c *Client) GetService(name string) (*client.Service, error) {
+	svc, ok := c.Services[name]
+	if !ok {
+		return nil, api.ErrNotFound
+	}
+
+	var cp client.Service
+	if err := deepCopy(svc, &cp); err != nil {
+		return nil, err
+	}
+
+	return &cp, nil
+}
+
+// ListNodes ...
+func (c *Client) ListNodes() (*client.NodeList, error) {
+	nodes := make([]client.Node, 0, len(c.Nodes))
+
+	for _, n := range c.Nodes {
+		var node client.Node
+		if err := deepCopy(n, &node); err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return &client.NodeList{Items: nodes}, nil
+}
+
+// WatchNodes ...
+func (c *Client) WatchNodes() (watch.Watch, error) {
+	return c.watch(kindNode, ""), nil
+}
+
+// ReviewAccess allows every verb but the ones denied.
+func (c *Client) ReviewAccess(verb, resource, _ string, _ bool) (bool, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	return !c.denied[verb+" "+resource], nil
+}
+
+// DenyAccess denies a verb on a resource to access reviews.
+func (c *Client) DenyAccess(verb, resource string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.denied == nil {
+		c.denied = make(map[string]bool)
+	}
+
+	c.denied[verb+" "+resource] = true
+}
+
+// SetNode creates or replaces a node, and emits the matching event to node
+// watchers.
+func (c *Client) SetNode(n *client.Node) error {
+	eventType := watch.Modified
+	if _, ok := c.Nodes[n.Metadata.Name]; !ok {
+		eventType = watch.Added
+	}
+
+	c.Nodes[n.Metadata.Name] = n
+
+	return c.emit(kindNode, eventType, n)
+}
+
+func (c *Client) emit(kind string, eventType watch.EventType, obj interface{}) error {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	event := watch.Event{
+		Type:   eventType,
+		Object: json.RawMessage(b),
+	}
+
+	// pick the watchers now, so ones opened later don't get the event
+	var watchers []*mockWatcher
+
+	c.RLock()
+	for _, w := range c.watchers {
+		if w.kind == kind && w.matches(event) {
+			watchers = append(watchers, w)
+		}
+	}
+	c.RUnlock()
+
+	c.events <- mockEvent{event: event, watchers: watchers}
+
+	return nil
+}
+
+// watch registers a watcher for a kind of resource, optionally scoped to
+// a single object by name.
+func (c *Client) watch(kind, name string) *mockWatcher {
 	w := &mockWatcher{
+		kind:    kind,
+		name:    name,
 		results: make(chan watch.Event),
 		stop:    make(chan bool),
 	}
 
-	i := len(c.watchers) // length of watchers is current index
 	c.Lock()
 	c.watchers = append(c.watchers, w)
 	c.Unlock()
@@ -105,24 +562,51 @@ func (c *Client) WatchPods(labels map[string]string) (watch.Watch, error) {
 		<-w.stop
 
 		c.Lock()
-		c.watchers = append(c.watchers[:i], c.watchers[i+1:]...)
+		for i, cw := range c.watchers {
+			if cw == w {
+				c.watchers = append(c.watchers[:i], c.watchers[i+1:]...)
+				break
+			}
+		}
 		c.Unlock()
+
+		// queued behind the events picked for it already
+		c.events <- mockEvent{closed: w}
 	}()
 
-	return w, nil
+	return w
+}
+
+// Disconnect ends all open watches, as if the API server closed their
+// streams.
+func (c *Client) Disconnect() {
+	c.RLock()
+	defer c.RUnlock()
+
+	for _, w := range c.watchers {
+		w.Stop()
+	}
 }
 
 // Teardown ...
 func Teardown(c *Client) {
 	for _, p := range c.Pods {
 		//nolint:errcheck
-		pstr, _ := json.Marshal(p)
+		c.emit(kindPod, watch.Deleted, p)
+	}
 
-		c.events <- watch.Event{
-			Type:   watch.Deleted,
-			Object: json.RawMessage(pstr),
-		}
+	for _, cm := range c.ConfigMaps {
+		//nolint:errcheck
+		c.emit(kindConfigMap, watch.Deleted, cm)
 	}
 
 	c.Pods = make(map[string]*client.Pod)
+	c.ConfigMaps = make(map[string]*client.ConfigMap)
+	c.Nodes = make(map[string]*client.Node)
+	c.Services = make(map[string]*client.Service)
+	c.applied = nil
+
+	c.Lock()
+	c.denied = nil
+	c.Unlock()
 }
@@ -1,13 +1,19 @@
 package mock
 
 import (
+	"encoding/json"
+	"sync"
+
 	"github.com/skiprco/go-micro-kubernetes-registry/client"
 	"github.com/skiprco/go-micro-kubernetes-registry/client/watch"
 )
 
 type mockWatcher struct {
+	kind    string
+	name    string
 	results chan watch.Event
 	stop    chan bool
+	once    sync.Once
 }
 
 // Changes returns the results channel.
@@ -15,15 +21,30 @@ func (w *mockWatcher) ResultChan() <-chan watch.Event {
 	return w.results
 }
 
-// Stop closes any channels.
+// Stop signals the watcher to stop, the client closes the results
+// channel once it is no longer broadcasting to it.
 func (w *mockWatcher) Stop() {
-	select {
-	case <-w.stop:
-		return
-	default:
+	w.once.Do(func() {
 		close(w.stop)
-		close(w.results)
+	})
+}
+
+// matches reports whether an event is for the object the watcher is
+// scoped to, if any.
+func (w *mockWatcher) matches(e watch.Event) bool {
+	if len(w.name) == 0 {
+		return true
 	}
+
+	var obj struct {
+		Metadata *client.Meta `json:"metadata"`
+	}
+
+	if err := json.Unmarshal(e.Object, &obj); err != nil || obj.Metadata == nil {
+		return false
+	}
+
+	return obj.Metadata.Name == w.name
 }
 
 func updateMetadata(a, b *client.Meta) {
@@ -61,7 +82,7 @@ func labelFilterMatch(a map[string]*string, b map[string]string) bool {
 
 	for lk, lv := range b {
 		ml, ok := a[lk]
-		if !ok || *ml != lv {
+		if !ok || (len(lv) > 0 && *ml != lv) {
 			match = false
 			break
 		}
@@ -69,3 +90,14 @@ func labelFilterMatch(a map[string]*string, b map[string]string) bool {
 
 	return match
 }
+
+// deepCopy copies an object through its JSON form, as the API server hands
+// out objects that don't share state with the ones it stores.
+func deepCopy(in, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, out)
+}
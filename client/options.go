@@ -0,0 +1,204 @@
+package client
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/backoff"
+)
+
+// Options configure the http transport the client talks to the
+// kubernetes API with, and where the in-cluster client finds the API.
+type Options struct {
+	// MaxIdleConns is the maximum number of idle connections kept
+	// across all hosts.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost is the maximum number of idle connections
+	// kept to the API server. Go defaults to 2, which serializes
+	// discovery heavy services.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept before
+	// being closed.
+	IdleConnTimeout time.Duration
+	// Proxy selects the proxy requests to the API server go through. By
+	// default it follows HTTP_PROXY, HTTPS_PROXY and NO_PROXY, but never
+	// proxies the in-cluster API server.
+	Proxy func(*http.Request) (*url.URL, error)
+	// Timeout bounds each request to the API server, and establishing
+	// watches, but not the watch streams. Zero means no timeout.
+	Timeout time.Duration
+	// WatchTimeout asks the API server to close watches after it, so they
+	// are established again periodically, rather than hang once a proxy
+	// silently drops them. Zero leaves watches open as long as the server
+	// keeps them.
+	WatchTimeout time.Duration
+	// PodTables lists and watches pods as tables, which hold the metadata
+	// of pods and the status columns printed for them rather than whole
+	// pods, cutting the bandwidth of large namespaces. See PodTables.
+	PodTables bool
+	// DisableCompression stops requesting gzip encoded responses, which
+	// are otherwise decompressed transparently, watch streams included.
+	DisableCompression bool
+	// BreakerThreshold is the number of consecutive failed requests
+	// opening the circuit breaker, zero for no breaker. BreakerCooldown is
+	// how long it stays open before probing the API server again.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// BreakerBackoff overrides BreakerCooldown, the cooldown depending on
+	// how many times in a row the breaker opened.
+	BreakerBackoff backoff.Backoff
+
+	// Host and Port of the API server used in-cluster, instead of
+	// KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT.
+	Host string
+	Port string
+	// TokenPath, CAPath and NamespacePath locate the files used in-cluster
+	// instead of the ones of the mounted service account.
+	TokenPath     string
+	CAPath        string
+	NamespacePath string
+}
+
+// Option sets a client option.
+type Option func(*Options)
+
+// MaxIdleConns sets the maximum number of idle connections.
+func MaxIdleConns(n int) Option {
+	return func(o *Options) {
+		o.MaxIdleConns = n
+	}
+}
+
+// MaxIdleConnsPerHost sets the maximum number of idle connections to the
+// API server.
+func MaxIdleConnsPerHost(n int) Option {
+	return func(o *Options) {
+		o.MaxIdleConnsPerHost = n
+	}
+}
+
+// IdleConnTimeout sets how long idle connections are kept open.
+func IdleConnTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.IdleConnTimeout = d
+	}
+}
+
+// Proxy sets how the proxy for requests to the API server is selected,
+// instead of from the environment. A func returning a nil URL connects
+// directly.
+func Proxy(fn func(*http.Request) (*url.URL, error)) Option {
+	return func(o *Options) {
+		o.Proxy = fn
+	}
+}
+
+// Timeout sets the timeout of each request to the API server.
+func Timeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.Timeout = d
+	}
+}
+
+// WatchTimeout sets how long the API server keeps watches open before
+// closing them, to be established again.
+func WatchTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.WatchTimeout = d
+	}
+}
+
+// Compression sets whether responses of the API server are requested gzip
+// encoded, which they are by default. Turn it off behind proxies mishandling
+// streamed gzip.
+func Compression(b bool) Option {
+	return func(o *Options) {
+		o.DisableCompression = !b
+	}
+}
+
+// PodTables makes the client list and watch pods as tables of the columns
+// the API server prints, each row holding the metadata of its pod only, so
+// specs and full statuses aren't transferred. Pods get their phase, IP,
+// readiness and node from the columns: pods running containers that fail
+// are pending, and container statuses are unknown, so port readiness gates
+// can't be told. Getting a single pod still returns it whole.
+func PodTables(b bool) Option {
+	return func(o *Options) {
+		o.PodTables = b
+	}
+}
+
+// CircuitBreaker makes the client short-circuit requests with
+// ErrCircuitOpen for the cooldown after threshold consecutive ones failed,
+// with an error or a 429 or 5xx status, sparing an overloaded API server.
+// A single request then probes it, closing the breaker when it succeeds.
+func CircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(o *Options) {
+		o.BreakerThreshold = threshold
+		o.BreakerCooldown = cooldown
+	}
+}
+
+// CircuitBreakerBackoff sets the cooldown of the circuit breaker by the
+// number of times in a row it opened, without the API server recovering in
+// between, for instance to back off exponentially.
+func CircuitBreakerBackoff(b backoff.Backoff) Option {
+	return func(o *Options) {
+		o.BreakerBackoff = b
+	}
+}
+
+// InClusterHost sets the host of the API server used in-cluster.
+func InClusterHost(host string) Option {
+	return func(o *Options) {
+		o.Host = host
+	}
+}
+
+// InClusterPort sets the port of the API server used in-cluster.
+func InClusterPort(port string) Option {
+	return func(o *Options) {
+		o.Port = port
+	}
+}
+
+// TokenPath sets the file holding the bearer token used in-cluster.
+func TokenPath(p string) Option {
+	return func(o *Options) {
+		o.TokenPath = p
+	}
+}
+
+// CAPath sets the file holding the CA certificates of the API server used
+// in-cluster.
+func CAPath(p string) Option {
+	return func(o *Options) {
+		o.CAPath = p
+	}
+}
+
+// NamespacePath sets the file holding the namespace used in-cluster.
+func NamespacePath(p string) Option {
+	return func(o *Options) {
+		o.NamespacePath = p
+	}
+}
+
+// newOptions applies options over defaults above the ones of
+// http.DefaultTransport (100 idle conns, 2 per host, 90s idle timeout), as
+// the client only ever talks to the API server.
+func newOptions(opts ...Option) Options {
+	o := Options{
+		MaxIdleConns:        200,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     5 * time.Minute,
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
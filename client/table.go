@@ -0,0 +1,181 @@
+package client
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client/watch"
+)
+
+// podTable is a list of pods as a table of the columns the API server
+// prints, each row holding the metadata of its pod only.
+type podTable struct {
+	Kind     string    `json:"kind"`
+	Metadata *ListMeta `json:"metadata,omitempty"`
+	Columns  []struct {
+		Name string `json:"name"`
+	} `json:"columnDefinitions"`
+	Rows []struct {
+		Cells  []interface{} `json:"cells"`
+		Object struct {
+			Metadata *Meta `json:"metadata"`
+		} `json:"object"`
+	} `json:"rows"`
+}
+
+// tableKind is the kind of tables.
+const tableKind = "Table"
+
+// decodePods decodes a list of pods, or a table of them when the API server
+// returned one.
+func decodePods(data json.RawMessage) (*PodList, error) {
+	var table podTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return &PodList{}, err
+	}
+
+	if table.Kind != tableKind {
+		var pods PodList
+		err := json.Unmarshal(data, &pods)
+
+		return &pods, err
+	}
+
+	return &PodList{Metadata: table.Metadata, Items: table.pods()}, nil
+}
+
+// pods builds the pods of the rows of a table, with the status the columns
+// tell: the phase, IP and readiness of each pod and the node it runs on.
+func (t *podTable) pods() []Pod {
+	columns := make(map[string]int, len(t.Columns))
+	for i, c := range t.Columns {
+		columns[c.Name] = i
+	}
+
+	cell := func(cells []interface{}, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(cells) {
+			return ""
+		}
+
+		s, _ := cells[i].(string)
+		if s == "<none>" {
+			return ""
+		}
+
+		return s
+	}
+
+	pods := make([]Pod, 0, len(t.Rows))
+
+	for _, row := range t.Rows {
+		if row.Object.Metadata == nil {
+			continue
+		}
+
+		ready := "False"
+		if n, m, ok := strings.Cut(cell(row.Cells, "Ready"), "/"); ok && n == m && n != "0" {
+			ready = "True"
+		}
+
+		pods = append(pods, Pod{
+			Metadata: row.Object.Metadata,
+			Spec:     &PodSpec{NodeName: cell(row.Cells, "Node")},
+			Status: &Status{
+				PodIP:      cell(row.Cells, "IP"),
+				Phase:      tablePhase(cell(row.Cells, "Status")),
+				Conditions: []PodCondition{{Type: "Ready", Status: ready}},
+			},
+		})
+	}
+
+	return pods
+}
+
+// tablePhase returns the phase of a pod from its status column, which tells
+// the phase, or why its containers aren't running. Pods running containers
+// that fail are pending then, and pods being deleted are still running.
+func tablePhase(status string) string {
+	switch status {
+	case "Running", "Terminating":
+		return "Running"
+	case "Completed":
+		return "Succeeded"
+	case "Succeeded", "Failed", "Unknown":
+		return status
+	default:
+		return "Pending"
+	}
+}
+
+// tableWatch turns the events of a watch of tables of pods into events of
+// the pods of their rows, passing the others, such as errors, through.
+type tableWatch struct {
+	watch   watch.Watch
+	results chan watch.Event
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newTableWatch(w watch.Watch) watch.Watch {
+	tw := &tableWatch{
+		watch:   w,
+		results: make(chan watch.Event),
+		done:    make(chan struct{}),
+	}
+
+	go tw.run()
+
+	return tw
+}
+
+func (tw *tableWatch) run() {
+	defer close(tw.results)
+
+	for event := range tw.watch.ResultChan() {
+		for _, e := range tableEvents(event) {
+			select {
+			case <-tw.done:
+				return
+			case tw.results <- e:
+			}
+		}
+	}
+}
+
+// tableEvents returns the events of the pods of a table, or the event
+// itself when it isn't one.
+func tableEvents(event watch.Event) []watch.Event {
+	var table podTable
+	if err := json.Unmarshal(event.Object, &table); err != nil || table.Kind != tableKind {
+		return []watch.Event{event}
+	}
+
+	pods := table.pods()
+	events := make([]watch.Event, 0, len(pods))
+
+	for i := range pods {
+		b, err := json.Marshal(&pods[i])
+		if err != nil {
+			continue
+		}
+
+		events = append(events, watch.Event{Type: event.Type, Object: b})
+	}
+
+	return events
+}
+
+// ResultChan returns the events of the pods.
+func (tw *tableWatch) ResultChan() <-chan watch.Event {
+	return tw.results
+}
+
+// Stop stops the watch.
+func (tw *tableWatch) Stop() {
+	tw.once.Do(func() {
+		close(tw.done)
+		tw.watch.Stop()
+	})
+}
@@ -84,12 +84,36 @@ func (wr *bodyWatcher) stream() {
 
 // NewBodyWatcher creates a k8s body watcher for a given http request.
 func NewBodyWatcher(req *http.Request, client *http.Client) (Watch, error) {
+	return NewBodyWatcherTimeout(req, client, 0)
+}
+
+// NewBodyWatcherTimeout creates a k8s body watcher for a given http request,
+// failing with context.DeadlineExceeded when the watch isn't established
+// within the timeout. The stream itself isn't bounded.
+func NewBodyWatcherTimeout(req *http.Request, client *http.Client, timeout time.Duration) (Watch, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	req = req.WithContext(ctx)
 
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, cancel)
+	}
+
 	//nolint:bodyclose
 	res, err := client.Do(req)
+
+	if timer != nil && !timer.Stop() {
+		cancel()
+
+		if err == nil {
+			//nolint:errcheck,gosec
+			res.Body.Close()
+		}
+
+		return nil, errors.Wrap(context.DeadlineExceeded, "body watcher failed to establish the watch")
+	}
+
 	if err != nil {
 		cancel()
 		return nil, errors.Wrap(err, "body watcher failed to make http request")
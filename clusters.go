@@ -0,0 +1,150 @@
+package kubernetes
+
+import (
+	"sort"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/pkg/errors"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// metadataCluster is the node metadata key of the cluster a node runs in,
+// set when federating clusters.
+const metadataCluster = "cluster"
+
+// clusters are the clusters federated, with the name of the local one.
+type clusters struct {
+	local   string
+	remotes map[string]client.Kubernetes
+}
+
+// federate returns a registry per cluster federated, each discovering the
+// services of its cluster only. The registry of the local cluster is a copy
+// of the registry itself, so it doesn't federate again.
+func (c *kregistry) federate(fed clusters) map[string]*kregistry {
+	members := make(map[string]*kregistry, len(fed.remotes)+1)
+
+	c.cluster = fed.local
+	members[fed.local] = c.withClient(c.client)
+
+	for name, kc := range fed.remotes {
+		member := c.withClient(kc)
+		member.cluster = name
+
+		// the nodes of a cluster are only known to its API server
+		if c.topology != nil {
+			member.topology = newTopology(kc, c.logs)
+		}
+
+		members[name] = member
+	}
+
+	return members
+}
+
+// memberNames returns the names of the clusters federated, sorted.
+func (c *kregistry) memberNames() []string {
+	names := make([]string, 0, len(c.members))
+	for name := range c.members {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// clusterMetadata tags the nodes of a service with the cluster they run in,
+// when federating clusters.
+func (c *kregistry) clusterMetadata(svc *registry.Service) {
+	if len(c.cluster) > 0 {
+		setNodeMetadata(svc, metadataCluster, c.cluster)
+	}
+}
+
+// federatedService looks a service up in every cluster, merging the nodes
+// of its versions. Clusters failing are skipped while others find it.
+func (c *kregistry) federatedService(name string, opts ...registry.GetOption) ([]*registry.Service, error) {
+	svcs := make(map[string]*registry.Service)
+
+	var failed error
+
+	for _, cluster := range c.memberNames() {
+		services, err := c.members[cluster].GetService(name, opts...)
+		if errors.Is(err, registry.ErrNotFound) {
+			continue
+		}
+
+		if err != nil {
+			c.logs.warnf("K8s Registry: failed to get service %s from cluster %s: %v", name, cluster, err)
+			failed = errors.Wrapf(err, "cluster %s", cluster)
+
+			continue
+		}
+
+		mergeClusterServices(svcs, services)
+	}
+
+	if len(svcs) > 0 {
+		return serviceList(svcs), nil
+	}
+
+	if failed != nil {
+		return nil, failed
+	}
+
+	return nil, registry.ErrNotFound
+}
+
+// federatedServices lists the services of every cluster, failing only when
+// no cluster lists them.
+func (c *kregistry) federatedServices(opts ...registry.ListOption) ([]*registry.Service, error) {
+	svcs := make(map[string]*registry.Service)
+
+	var (
+		listed int
+		failed error
+	)
+
+	for _, cluster := range c.memberNames() {
+		services, err := c.members[cluster].ListServices(opts...)
+		if err != nil {
+			c.logs.warnf("K8s Registry: failed to list services of cluster %s: %v", cluster, err)
+			failed = errors.Wrapf(err, "cluster %s", cluster)
+
+			continue
+		}
+
+		listed++
+
+		mergeClusterServices(svcs, services)
+	}
+
+	if listed == 0 {
+		return nil, failed
+	}
+
+	return serviceList(svcs), nil
+}
+
+// mergeClusterServices merges services found in a cluster into the ones
+// found in others, by name and version, the nodes of each cluster being
+// distinct.
+func mergeClusterServices(svcs map[string]*registry.Service, services []*registry.Service) {
+	for _, svc := range services {
+		key := svc.Name + "/" + svc.Version
+
+		merged, ok := svcs[key]
+		if !ok {
+			cp := *svc
+			cp.Nodes = append([]*registry.Node(nil), svc.Nodes...)
+			svcs[key] = &cp
+
+			continue
+		}
+
+		merged.Nodes = append(merged.Nodes, svc.Nodes...)
+	}
+}
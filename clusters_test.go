@@ -0,0 +1,100 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/mock"
+)
+
+func TestClusters(t *testing.T) {
+	west := mock.NewClient()
+	west.Pods["pod-west"] = &client.Pod{
+		Metadata: &client.Meta{
+			Name:        "pod-west",
+			Labels:      make(map[string]*string),
+			Annotations: make(map[string]*string),
+		},
+		Status: &client.Status{PodIP: "10.1.0.1", Phase: podRunning},
+	}
+
+	setupPod("pod-east")
+
+	established := make(chan bool, 2)
+
+	r := setupRegistry(
+		Clusters("east", map[string]client.Kubernetes{"west": west}),
+		OnWatchEstablished(func() { established <- true }),
+	)
+	defer teardownRegistry()
+
+	w, err := r.Watch(registry.WatchService("foo.service"))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	// a watch per cluster
+	for i := 0; i < 2; i++ {
+		select {
+		case <-established:
+		case <-time.After(time.Second):
+			t.Fatal("expected both clusters watched")
+		}
+	}
+
+	// registered in the local cluster only
+	register(t, r, "pod-east", &registry.Service{Name: "foo.service", Version: "1"})
+
+	if labels := west.Pods["pod-west"].Metadata.Labels; len(labels) > 0 {
+		t.Fatalf("expected nothing registered in the remote cluster, got %v", labels)
+	}
+
+	res := expectAction(t, w, "foo.service", "create")
+	if cluster := res.Service.Nodes[0].Metadata[metadataCluster]; cluster != "east" {
+		t.Fatalf("expected a node of cluster east, got %q", cluster)
+	}
+
+	register(t, NewRegistry(Client(west)), "pod-west", &registry.Service{Name: "foo.service", Version: "1"})
+
+	res = expectAction(t, w, "foo.service", "create")
+	if cluster := res.Service.Nodes[0].Metadata[metadataCluster]; cluster != "west" {
+		t.Fatalf("expected a node of cluster west, got %q", cluster)
+	}
+
+	services, err := r.GetService("foo.service")
+	if err != nil {
+		t.Fatalf("did not expect GetService() to fail: %v", err)
+	}
+
+	if len(services) != 1 || len(services[0].Nodes) != 2 {
+		t.Fatalf("expected one version with a node per cluster, got %+v", services)
+	}
+
+	clusters := make(map[string]bool)
+	for _, node := range services[0].Nodes {
+		clusters[node.Metadata[metadataCluster]] = true
+	}
+
+	if !clusters["east"] || !clusters["west"] {
+		t.Fatalf("expected nodes of both clusters, got %v", clusters)
+	}
+
+	list, err := r.ListServices()
+	if err != nil {
+		t.Fatalf("did not expect ListServices() to fail: %v", err)
+	}
+
+	if len(list) != 1 || list[0].Name != "foo.service" {
+		t.Fatalf("expected foo.service listed once, got %+v", list)
+	}
+
+	w.Stop()
+
+	if _, err := w.Next(); err != ErrWatcherStopped {
+		t.Fatalf("expected the watcher stopped, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package kubernetes
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-micro.dev/v4/registry"
+)
+
+// compactor queues the results not received yet, keeping only the latest
+// one per service node, for consumers only interested in the end state.
+type compactor struct {
+	sync.Mutex
+	pending []*registry.Result
+	// signaled when pending changes.
+	changed chan struct{}
+	// minimum time between results received, zero for none.
+	interval time.Duration
+}
+
+func newCompactor(interval time.Duration) *compactor {
+	return &compactor{changed: make(chan struct{}, 1), interval: interval}
+}
+
+// resultKey identifies the nodes of a service a result is for.
+func resultKey(r *registry.Result) string {
+	ids := make([]string, 0, len(r.Service.Nodes))
+	for _, node := range r.Service.Nodes {
+		ids = append(ids, node.Id)
+	}
+
+	sort.Strings(ids)
+
+	return r.Service.Name + "\x00" + r.Service.Version + "\x00" + strings.Join(ids, "\x00")
+}
+
+// push queues results, replacing the pending ones for the same nodes.
+func (c *compactor) push(results []*registry.Result) {
+	if len(results) == 0 {
+		return
+	}
+
+	c.Lock()
+	for _, result := range results {
+		key := resultKey(result)
+		replaced := false
+
+		for i, p := range c.pending {
+			if resultKey(p) == key {
+				c.pending[i] = result
+				replaced = true
+
+				break
+			}
+		}
+
+		if !replaced {
+			c.pending = append(c.pending, result)
+		}
+	}
+	c.Unlock()
+
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// runCompacted sends the queued results down the wire until the watcher is
+// stopped. The result offered is picked again whenever the queue changes.
+func (k *k8sWatcher) runCompacted() {
+	c := k.compactor
+
+	for {
+		c.Lock()
+
+		var head *registry.Result
+		if len(c.pending) > 0 {
+			head = c.pending[0]
+		}
+		c.Unlock()
+
+		if head == nil {
+			select {
+			case <-k.done:
+				return
+			case <-c.changed:
+			}
+
+			continue
+		}
+
+		select {
+		case <-k.done:
+			return
+		case <-c.changed:
+		case k.next <- head:
+			c.Lock()
+			if len(c.pending) > 0 && c.pending[0] == head {
+				c.pending = c.pending[1:]
+			}
+			c.Unlock()
+
+			// results keep being merged meanwhile
+			if c.interval > 0 {
+				select {
+				case <-k.done:
+					return
+				case <-time.After(c.interval):
+				}
+			}
+		}
+	}
+}
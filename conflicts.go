@@ -0,0 +1,79 @@
+package kubernetes
+
+import (
+	"maps"
+
+	"go-micro.dev/v4/registry"
+)
+
+// ConflictCounter is implemented by the registry to tell how many times
+// lookups found pods advertising the same service version with conflicting
+// metadata.
+type ConflictCounter interface {
+	ConflictingRegistrations() uint64
+}
+
+// mergeService merges a service advertised by a pod into svcs, where the
+// same version advertised by other pods is merged under key, appending its
+// nodes. Pods advertising conflicting service metadata are misconfigured:
+// the conflict is logged and counted, and the metadata of the pod whose
+// name sorts first is kept, whatever order pods are listed in. owners maps
+// keys to that pod. Nodes at an address already merged are dropped unless
+// keeping duplicates, keeping the node whose id sorts first likewise.
+func (c *kregistry) mergeService(svcs map[string]*registry.Service, owners map[string]string, key, podName string, svc *registry.Service) {
+	merged, ok := svcs[key]
+	if !ok {
+		svcs[key] = svc
+		owners[key] = podName
+
+		return
+	}
+
+	for _, node := range svc.Nodes {
+		if i := c.duplicateNode(merged.Nodes, node); i >= 0 {
+			c.logs.warnf("K8s Registry: pod %s advertises %s version %s at %s, as another pod does", podName, svc.Name, svc.Version, node.Address)
+
+			if node.Id < merged.Nodes[i].Id {
+				merged.Nodes[i] = node
+			}
+
+			continue
+		}
+
+		merged.Nodes = append(merged.Nodes, node)
+	}
+
+	if maps.Equal(merged.Metadata, svc.Metadata) {
+		return
+	}
+
+	c.counters.conflicts.Add(1)
+	c.logs.warnf("K8s Registry: pods %s and %s advertise %s version %s with conflicting metadata", owners[key], podName, svc.Name, svc.Version)
+
+	if podName < owners[key] {
+		merged.Metadata = svc.Metadata
+		owners[key] = podName
+	}
+}
+
+// duplicateNode returns the index of the node at the same address as node,
+// or -1 when there is none or duplicates are kept.
+func (c *kregistry) duplicateNode(nodes []*registry.Node, node *registry.Node) int {
+	if !c.dedupNodes {
+		return -1
+	}
+
+	for i, n := range nodes {
+		if n.Address == node.Address {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// ConflictingRegistrations returns how many times lookups found conflicting
+// service metadata.
+func (c *kregistry) ConflictingRegistrations() uint64 {
+	return c.counters.conflicts.Load()
+}
@@ -0,0 +1,89 @@
+package kubernetes
+
+import (
+	"go-micro.dev/v4/registry"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// countNodes returns the number of nodes advertised by the cached pods for
+// the services of results, by service name, nil unless emitting changes of
+// ready counts.
+func (k *k8sWatcher) countNodes(results []*registry.Result) map[string]int {
+	if !k.readyCounts || len(results) == 0 {
+		return nil
+	}
+
+	counts := make(map[string]int, len(results))
+	for _, result := range results {
+		counts[result.Service.Name] = 0
+	}
+
+	for _, svc := range k.advertisedServices(counts) {
+		counts[svc.Name] += len(svc.Nodes)
+	}
+
+	return counts
+}
+
+// emitCounts emits an update of every version of the services whose
+// number of nodes advertised changed since counted, with all their nodes,
+// unless none is left, as deletes told already.
+func (k *k8sWatcher) emitCounts(before map[string]int) {
+	if len(before) == 0 {
+		return
+	}
+
+	after := make(map[string]int, len(before))
+	services := k.advertisedServices(before)
+
+	for _, svc := range services {
+		after[svc.Name] += len(svc.Nodes)
+	}
+
+	var results []*registry.Result
+
+	for _, svc := range services {
+		if after[svc.Name] != before[svc.Name] {
+			results = append(results, &registry.Result{Action: k.actions.Update, Service: svc})
+		}
+	}
+
+	// a node watched alone comes and goes, the count of others aside
+	if results = k.filterNode(results); len(results) == 0 {
+		return
+	}
+
+	k.order(results)
+
+	if k.hold(results) {
+		return
+	}
+
+	k.deliver(results)
+}
+
+// advertisedServices returns the versions of the named services the cached
+// pods advertise, with the nodes of every pod.
+func (k *k8sWatcher) advertisedServices(names map[string]int) []*registry.Service {
+	k.RLock()
+	pods := make([]*client.Pod, 0, len(k.pods))
+	for _, pod := range k.pods {
+		pods = append(pods, pod)
+	}
+	k.RUnlock()
+
+	svcs := make(map[string]*registry.Service)
+
+	for _, pod := range pods {
+		for _, result := range k.podResults(pod, nil) {
+			if _, ok := names[result.Service.Name]; !ok || result.Action != k.actions.Create {
+				continue
+			}
+
+			mergeClusterServices(svcs, []*registry.Service{result.Service})
+		}
+	}
+
+	return serviceList(svcs)
+}
@@ -0,0 +1,137 @@
+package kubernetes
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// starts an encrypted service payload, followed by the id of the key it
+// is encrypted with and the base64 encoded nonce and ciphertext, eg:
+// encryptedPayloadPrefix+"key-1:<base64>".
+var encryptedPayloadPrefix = "enc:v1:"
+
+// errPayloadKey is returned decrypting a payload encrypted with an unknown
+// key.
+var errPayloadKey = errors.New("payload encrypted with an unknown key")
+
+// payloadCipher encrypts service payloads with AES-GCM under the current
+// key, and decrypts them with any key known by id.
+type payloadCipher struct {
+	keyID string
+	aeads map[string]cipher.AEAD
+}
+
+func newPayloadCipher(keyID string, keys map[string][]byte) (*payloadCipher, error) {
+	c := &payloadCipher{
+		keyID: keyID,
+		aeads: make(map[string]cipher.AEAD, len(keys)),
+	}
+
+	for id, key := range keys {
+		if strings.Contains(id, ":") {
+			return nil, errors.Errorf("payload key id %q contains a colon", id)
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid payload key %s", id)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		c.aeads[id] = aead
+	}
+
+	if _, ok := c.aeads[keyID]; !ok {
+		return nil, errors.Wrapf(errPayloadKey, "current key %s", keyID)
+	}
+
+	return c, nil
+}
+
+// seal encrypts a payload under the current key.
+func (c *payloadCipher) seal(payload string) (string, error) {
+	aead := c.aeads[c.keyID]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(payload), []byte(c.keyID))
+
+	return encryptedPayloadPrefix + c.keyID + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts an encrypted payload, returning others as they are.
+func (c *payloadCipher) open(payload string) (string, error) {
+	if !strings.HasPrefix(payload, encryptedPayloadPrefix) {
+		return payload, nil
+	}
+
+	keyID, data, ok := strings.Cut(strings.TrimPrefix(payload, encryptedPayloadPrefix), ":")
+	if !ok {
+		return "", errors.New("malformed encrypted payload")
+	}
+
+	aead, ok := c.aeads[keyID]
+	if !ok {
+		return "", errors.Wrapf(errPayloadKey, "key %s", keyID)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted payload")
+	}
+
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(keyID))
+	if err != nil {
+		return "", err
+	}
+
+	return string(plain), nil
+}
+
+// openAnnotations replaces the encrypted service payloads of a pod with
+// their plaintext, so they are read as any other. Payloads that can't be
+// decrypted are dropped, as if the pod didn't advertise them.
+func (c *payloadCipher) openAnnotations(meta *client.Meta, logs *logThrottle) {
+	if c == nil || meta == nil {
+		return
+	}
+
+	for key, v := range meta.Annotations {
+		if v == nil || !strings.HasPrefix(key, annotationServiceKeyPrefix) {
+			continue
+		}
+
+		data, err := notation(meta, key)
+		if err != nil || !strings.HasPrefix(data, encryptedPayloadPrefix) {
+			continue
+		}
+
+		plain, err := c.open(data)
+		if err != nil {
+			logs.warnf("K8s Registry: dropping payload %s of pod %s: %v", key, meta.Name, err)
+			delete(meta.Annotations, key)
+
+			continue
+		}
+
+		meta.Annotations[key] = &plain
+	}
+}
@@ -0,0 +1,38 @@
+package kubernetes
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go-micro.dev/v4/registry"
+)
+
+// send hands a result to a consumer, reporting false once done is closed.
+// Results the consumer doesn't receive within the delivery timeout, if any,
+// are dropped and counted into dropped, so a consumer no longer calling
+// Next doesn't stop events from being handled.
+func (c *kregistry) send(next chan<- *registry.Result, done <-chan struct{}, result *registry.Result, dropped *atomic.Uint64) bool {
+	if c.deliveryTimeout <= 0 {
+		select {
+		case <-done:
+			return false
+		case next <- result:
+			return true
+		}
+	}
+
+	timer := time.NewTimer(c.deliveryTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return false
+	case next <- result:
+	case <-timer.C:
+		dropped.Add(1)
+		c.counters.dropped.Add(1)
+		c.logs.errorf("K8s Watcher: a consumer didn't receive a result within %s, dropping it", c.deliveryTimeout)
+	}
+
+	return true
+}
@@ -0,0 +1,53 @@
+package kubernetes
+
+import (
+	"time"
+)
+
+// defaultCacheTTL is how long a pod is cached without any event by default.
+const defaultCacheTTL = 2 * time.Hour
+
+// runEviction resyncs the cache whenever a cached pod wasn't refreshed
+// within the cache TTL, until the watcher is stopped.
+func (k *k8sWatcher) runEviction() {
+	ttl := k.registry.cacheTTL
+
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-k.done:
+			return
+		case now := <-ticker.C:
+			k.evict(now.Add(-ttl))
+		}
+	}
+}
+
+// evict resyncs the cache once a pod was last refreshed before a deadline,
+// as its delete event may have been missed. Pods listed again are kept, so
+// only the services of the pods gone are deleted.
+func (k *k8sWatcher) evict(deadline time.Time) {
+	k.RLock()
+	stale := false
+	for _, refreshed := range k.refreshed {
+		if refreshed.Before(deadline) {
+			stale = true
+			break
+		}
+	}
+	k.RUnlock()
+
+	if !stale {
+		return
+	}
+
+	changes, err := k.resync()
+	if err != nil {
+		k.registry.logs.errorf("K8s Watcher: failed to resync pods not refreshed within %s: %v", k.registry.cacheTTL, err)
+		return
+	}
+
+	k.hooks.resync(changes)
+}
@@ -0,0 +1,28 @@
+// Command registry-service serves the kubernetes registry over the registry
+// service RPC, for services using the "service" registry pointed at it.
+package main
+
+import (
+	"go-micro.dev/v4"
+	"go-micro.dev/v4/logger"
+
+	kubernetes "github.com/skiprco/go-micro-kubernetes-registry"
+	"github.com/skiprco/go-micro-kubernetes-registry/handler"
+)
+
+func main() {
+	reg := kubernetes.NewRegistry()
+
+	srv := micro.NewService(
+		micro.Name("go.micro.registry"),
+		micro.Registry(reg),
+	)
+
+	if err := micro.RegisterHandler(srv.Server(), handler.NewHandler(reg)); err != nil {
+		logger.Fatal(err)
+	}
+
+	if err := srv.Run(); err != nil {
+		logger.Fatal(err)
+	}
+}
@@ -0,0 +1,134 @@
+package kubernetes
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// NamespaceGauges are the state of the caches of the watchers of a
+// namespace, for capacity planning.
+type NamespaceGauges struct {
+	// Pods is the number of pods cached.
+	Pods int
+	// Services is the number of services the cached pods advertise.
+	Services int
+	// EventAge is how long ago the last event, or resync, of the namespace
+	// was handled. It rising tells the watch of the namespace stalled.
+	EventAge time.Duration
+}
+
+// CacheGauges is implemented by the registry to tell the state of the
+// caches of its watchers, by namespace.
+type CacheGauges interface {
+	// CacheGauges returns the gauges of every namespace watched, keyed by
+	// namespace.
+	CacheGauges() map[string]NamespaceGauges
+}
+
+// cacheGauges records the gauges of the namespaces watched, shared by the
+// registries watching each namespace or cluster.
+type cacheGauges struct {
+	mtx        sync.Mutex
+	namespaces map[string]namespaceGauges
+}
+
+// namespaceGauges are the gauges of a namespace, with when its last event
+// was handled.
+type namespaceGauges struct {
+	pods      int
+	services  int
+	lastEvent time.Time
+}
+
+func newCacheGauges() *cacheGauges {
+	return &cacheGauges{namespaces: make(map[string]namespaceGauges)}
+}
+
+// set records the gauges of a namespace, nothing when nil.
+func (g *cacheGauges) set(namespace string, gauges namespaceGauges) {
+	if g == nil {
+		return
+	}
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	g.namespaces[namespace] = gauges
+}
+
+// CacheGauges returns the gauges of the namespaces watched.
+func (c *kregistry) CacheGauges() map[string]NamespaceGauges {
+	gauges := make(map[string]NamespaceGauges)
+
+	if c.gauges == nil {
+		return gauges
+	}
+
+	c.gauges.mtx.Lock()
+	defer c.gauges.mtx.Unlock()
+
+	now := time.Now()
+
+	for ns, g := range c.gauges.namespaces {
+		gauges[ns] = NamespaceGauges{
+			Pods:     g.pods,
+			Services: g.services,
+			EventAge: now.Sub(g.lastEvent),
+		}
+	}
+
+	return gauges
+}
+
+// gauge records the gauges of namespaces from the cache, as an event or
+// resync of theirs was just handled.
+func (k *k8sWatcher) gauge(namespaces ...string) {
+	if k.registry.gauges == nil {
+		return
+	}
+
+	measured := make(map[string]bool, len(namespaces))
+	for _, ns := range namespaces {
+		measured[ns] = true
+	}
+
+	k.RLock()
+	pods := make([]*client.Pod, 0, len(k.pods))
+	for _, pod := range k.pods {
+		if measured[pod.Metadata.Namespace] {
+			pods = append(pods, pod)
+		}
+	}
+	k.RUnlock()
+
+	gauges := make(map[string]*namespaceGauges, len(measured))
+	services := make(map[string]map[string]bool, len(measured))
+
+	for ns := range measured {
+		gauges[ns] = &namespaceGauges{lastEvent: time.Now()}
+		services[ns] = make(map[string]bool)
+	}
+
+	for _, pod := range pods {
+		ns := pod.Metadata.Namespace
+		gauges[ns].pods++
+
+		if !k.advertised(pod) {
+			continue
+		}
+
+		for key := range pod.Metadata.Annotations {
+			if strings.HasPrefix(key, annotationServiceKeyPrefix) {
+				services[ns][key] = true
+			}
+		}
+	}
+
+	for ns, g := range gauges {
+		g.services = len(services[ns])
+		k.registry.gauges.set(ns, *g)
+	}
+}
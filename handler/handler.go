@@ -0,0 +1,124 @@
+// Package handler serves a registry over go-micro's registry service RPC,
+// so services can discover others through a central registry service
+// instead of each needing access to the kubernetes API.
+package handler
+
+import (
+	"context"
+	"time"
+
+	"go-micro.dev/v4/registry"
+	"go-micro.dev/v4/server"
+)
+
+// GetRequest asks for the versions of a service.
+type GetRequest struct {
+	Service string `json:"service"`
+}
+
+// GetResponse holds the versions of a service.
+type GetResponse struct {
+	Services []*registry.Service `json:"services"`
+}
+
+// ListRequest asks for all services.
+type ListRequest struct{}
+
+// ListResponse holds all services.
+type ListResponse struct {
+	Services []*registry.Service `json:"services"`
+}
+
+// WatchRequest starts a watch, optionally of a single service.
+type WatchRequest struct {
+	Service string `json:"service"`
+}
+
+// Result is a watch result streamed to the client.
+type Result struct {
+	Action    string            `json:"action"`
+	Service   *registry.Service `json:"service"`
+	Timestamp int64             `json:"timestamp"`
+}
+
+// Registry serves the read side of a registry, its endpoints are named
+// after the registry service's: Registry.GetService, Registry.ListServices
+// and Registry.Watch.
+type Registry struct {
+	registry registry.Registry
+}
+
+// NewHandler returns a handler serving the given registry, register it with
+// server.NewHandler.
+func NewHandler(r registry.Registry) *Registry {
+	return &Registry{registry: r}
+}
+
+// GetService returns the versions of a service.
+func (h *Registry) GetService(_ context.Context, req *GetRequest, rsp *GetResponse) error {
+	services, err := h.registry.GetService(req.Service)
+	if err != nil {
+		return err
+	}
+
+	rsp.Services = services
+
+	return nil
+}
+
+// ListServices returns all services.
+func (h *Registry) ListServices(_ context.Context, _ *ListRequest, rsp *ListResponse) error {
+	services, err := h.registry.ListServices()
+	if err != nil {
+		return err
+	}
+
+	rsp.Services = services
+
+	return nil
+}
+
+// Watch streams results until the client goes away or the watch ends.
+func (h *Registry) Watch(ctx context.Context, stream server.Stream) error {
+	var req WatchRequest
+	if err := stream.Recv(&req); err != nil {
+		return err
+	}
+
+	watcher, err := h.registry.Watch(registry.WatchService(req.Service))
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// unblock Next once the client is gone
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+
+		watcher.Stop()
+	}()
+
+	for {
+		result, err := watcher.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		if err := stream.Send(&Result{
+			Action:    result.Action,
+			Service:   result.Service,
+			Timestamp: time.Now().Unix(),
+		}); err != nil {
+			return err
+		}
+	}
+}
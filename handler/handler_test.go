@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go-micro.dev/v4/registry"
+	"go-micro.dev/v4/server"
+
+	kubernetes "github.com/skiprco/go-micro-kubernetes-registry"
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/mock"
+)
+
+// stream is a server stream receiving a single request and collecting
+// what is sent.
+type stream struct {
+	server.Stream
+	ctx     context.Context
+	req     interface{}
+	results chan *Result
+}
+
+func (s *stream) Context() context.Context { return s.ctx }
+
+func (s *stream) Recv(v interface{}) error {
+	b, err := json.Marshal(s.req)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+func (s *stream) Send(v interface{}) error {
+	s.results <- v.(*Result)
+	return nil
+}
+
+func setupHandler(t *testing.T, opts ...registry.Option) *Registry {
+	t.Helper()
+
+	c := mock.NewClient()
+	c.Pods["pod-1"] = &client.Pod{
+		Metadata: &client.Meta{
+			Name:        "pod-1",
+			Labels:      make(map[string]*string),
+			Annotations: make(map[string]*string),
+		},
+		Status: &client.Status{PodIP: "10.0.0.1", Phase: "Running"},
+	}
+
+	r := kubernetes.NewRegistry(append(opts, kubernetes.Client(c))...)
+
+	t.Setenv("HOSTNAME", "pod-1")
+
+	svc := &registry.Service{
+		Name:    "foo.service",
+		Version: "1",
+		Nodes:   []*registry.Node{{Id: "foo-1", Address: "10.0.0.1:8080"}},
+	}
+	if err := r.Register(svc); err != nil {
+		t.Fatalf("did not expect Register to fail: %v", err)
+	}
+
+	return NewHandler(r)
+}
+
+func TestGetService(t *testing.T) {
+	h := setupHandler(t)
+
+	var rsp GetResponse
+	if err := h.GetService(context.Background(), &GetRequest{Service: "foo.service"}, &rsp); err != nil {
+		t.Fatalf("did not expect GetService to fail: %v", err)
+	}
+
+	if len(rsp.Services) != 1 || rsp.Services[0].Name != "foo.service" {
+		t.Fatalf("expected foo.service, got %+v", rsp.Services)
+	}
+
+	if err := h.GetService(context.Background(), &GetRequest{Service: "bar.service"}, &rsp); err != registry.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestListServices(t *testing.T) {
+	h := setupHandler(t)
+
+	var rsp ListResponse
+	if err := h.ListServices(context.Background(), &ListRequest{}, &rsp); err != nil {
+		t.Fatalf("did not expect ListServices to fail: %v", err)
+	}
+
+	if len(rsp.Services) != 1 || rsp.Services[0].Name != "foo.service" {
+		t.Fatalf("expected foo.service, got %+v", rsp.Services)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	established := make(chan struct{}, 1)
+	h := setupHandler(t, kubernetes.OnWatchEstablished(func() { established <- struct{}{} }))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &stream{
+		ctx:     ctx,
+		req:     &WatchRequest{Service: "foo.service"},
+		results: make(chan *Result),
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- h.Watch(ctx, s)
+	}()
+
+	select {
+	case <-established:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the watch")
+	}
+
+	svc := &registry.Service{
+		Name:    "foo.service",
+		Version: "1",
+		Nodes:   []*registry.Node{{Id: "foo-1", Address: "10.0.0.1:8081"}},
+	}
+	if err := h.registry.Register(svc); err != nil {
+		t.Fatalf("did not expect Register to fail: %v", err)
+	}
+
+	select {
+	case result := <-s.results:
+		if result.Action != "update" || result.Service.Nodes[0].Address != "10.0.0.1:8081" || result.Timestamp == 0 {
+			t.Fatalf("unexpected result %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a result")
+	}
+
+	cancel()
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("expected Watch to end cleanly, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for Watch to return")
+	}
+}
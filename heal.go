@@ -0,0 +1,169 @@
+package kubernetes
+
+import (
+	"sync"
+	"time"
+
+	"go-micro.dev/v4/logger"
+	"go-micro.dev/v4/registry"
+)
+
+// healer re-registers the services of this registry removed from the self
+// pod by anything else, giving up on a service once re-added too often.
+type healer struct {
+	registry *kregistry
+	max      int
+	window   time.Duration
+	onGiveUp func(service string)
+
+	mtx      sync.Mutex
+	watcher  registry.Watcher
+	services map[string]*healedService
+}
+
+// healedService is a registered service, with when it was re-added.
+type healedService struct {
+	service *registry.Service
+	opts    []registry.RegisterOption
+	readded []time.Time
+	gaveUp  bool
+}
+
+func newHealer(kr *kregistry, maxReadds int, window time.Duration, onGiveUp func(string)) *healer {
+	return &healer{
+		registry: kr,
+		max:      maxReadds,
+		window:   window,
+		onGiveUp: onGiveUp,
+		services: make(map[string]*healedService),
+	}
+}
+
+// track starts healing a registered service, watching the self pod when
+// not done yet. A service given up on stays so until deregistered.
+func (h *healer) track(s *registry.Service, opts []registry.RegisterOption) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if hs, ok := h.services[s.Name]; ok {
+		hs.service, hs.opts = s, opts
+		return
+	}
+
+	h.services[s.Name] = &healedService{service: s, opts: opts}
+
+	if h.watcher != nil {
+		return
+	}
+
+	w, err := newWatcher(h.registry, SelfPod())
+	if err != nil {
+		logger.Errorf("K8s Registry: failed to watch the self pod, not re-registering removed services: %v", err)
+		return
+	}
+
+	h.watcher = w
+
+	go h.run(w)
+}
+
+// untrack stops healing a service, and watching the self pod once none is
+// left.
+func (h *healer) untrack(name string) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	delete(h.services, name)
+
+	if len(h.services) == 0 && h.watcher != nil {
+		h.watcher.Stop()
+		h.watcher = nil
+	}
+}
+
+// run re-registers the services deleted from the self pod until the
+// watcher stops.
+func (h *healer) run(w registry.Watcher) {
+	for {
+		res, err := w.Next()
+		if err != nil {
+			return
+		}
+
+		// deletes of a pod not ready, terminating or cordoned leave the
+		// annotation in place, only strips are healed.
+		if res.Action != h.registry.actions.Delete || !h.stripped(res.Service.Name) {
+			continue
+		}
+
+		s, opts, gaveUp := h.readd(res.Service.Name)
+		if gaveUp && h.onGiveUp != nil {
+			h.onGiveUp(res.Service.Name)
+		}
+
+		if s == nil {
+			continue
+		}
+
+		logger.Warnf("K8s Registry: service %s removed from the pod, re-registering it", s.Name)
+
+		if err := h.registry.Register(s, opts...); err != nil {
+			logger.Errorf("K8s Registry: failed to re-register service %s: %v", s.Name, err)
+		}
+	}
+}
+
+// stripped reports whether the annotation of a service was removed from the
+// self pod, as it still being there tells the pod is merely not advertised.
+func (h *healer) stripped(name string) bool {
+	podName, err := getPodName()
+	if err != nil {
+		return false
+	}
+
+	services, err := h.registry.registered(podName)
+	if err != nil {
+		h.registry.logs.errorf("K8s Registry: failed to check whether service %s was removed from the pod: %v", name, err)
+		return false
+	}
+
+	_, ok := services[h.registry.normalize(name)]
+
+	return !ok
+}
+
+// readd returns a removed service to register again, if healed, unless it
+// was re-added the maximum number of times within the window, reporting it
+// is given up on then.
+func (h *healer) readd(name string) (*registry.Service, []registry.RegisterOption, bool) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	hs, ok := h.services[name]
+	if !ok || hs.gaveUp {
+		return nil, nil, false
+	}
+
+	now := time.Now()
+
+	recent := hs.readded[:0]
+	for _, t := range hs.readded {
+		if now.Sub(t) < h.window {
+			recent = append(recent, t)
+		}
+	}
+
+	hs.readded = recent
+
+	if len(hs.readded) >= h.max {
+		hs.gaveUp = true
+
+		logger.Errorf("K8s Registry: service %s removed from the pod %d times within %s, giving up re-registering it", name, len(hs.readded)+1, h.window)
+
+		return nil, nil, true
+	}
+
+	hs.readded = append(hs.readded, now)
+
+	return hs.service, hs.opts, false
+}
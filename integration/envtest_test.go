@@ -0,0 +1,279 @@
+//go:build integration
+
+// Package integration runs the registry against a real API server and etcd
+// started by envtest, exercising what the mock client can't: patches,
+// watches ended by the API server and relists. The binaries are found
+// through KUBEBUILDER_ASSETS, as set up by setup-envtest, the tests are
+// skipped without it.
+package integration
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-micro.dev/v4/registry"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	kubernetes "github.com/skiprco/go-micro-kubernetes-registry"
+)
+
+var (
+	// kube sets pods up as the kubelet would, there is none.
+	kube ctrlclient.Client
+
+	// kubeconfig of a user the registry connects as.
+	kubeconfig string
+)
+
+func TestMain(m *testing.M) {
+	if len(os.Getenv("KUBEBUILDER_ASSETS")) == 0 {
+		fmt.Println("skipping integration tests, KUBEBUILDER_ASSETS is not set")
+		os.Exit(0)
+	}
+
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	env := &envtest.Environment{}
+
+	// watches are ended within seconds rather than half an hour, so
+	// watchers reconnect during the tests
+	env.ControlPlane.GetAPIServer().Configure().Set("min-request-timeout", "2")
+
+	cfg, err := env.Start()
+	if err != nil {
+		fmt.Printf("failed to start envtest: %v\n", err)
+		return 1
+	}
+
+	//nolint:errcheck
+	defer env.Stop()
+
+	kube, err = ctrlclient.New(cfg, ctrlclient.Options{})
+	if err != nil {
+		fmt.Printf("failed to set up client: %v\n", err)
+		return 1
+	}
+
+	user, err := env.AddUser(envtest.User{Name: "micro-registry", Groups: []string{"system:masters"}}, nil)
+	if err != nil {
+		fmt.Printf("failed to add user: %v\n", err)
+		return 1
+	}
+
+	b, err := user.KubeConfig()
+	if err != nil {
+		fmt.Printf("failed to get kubeconfig: %v\n", err)
+		return 1
+	}
+
+	dir, err := os.MkdirTemp("", "envtest")
+	if err != nil {
+		fmt.Printf("failed to create kubeconfig dir: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	kubeconfig = filepath.Join(dir, "kubeconfig")
+	if err := os.WriteFile(kubeconfig, b, 0o600); err != nil {
+		fmt.Printf("failed to write kubeconfig: %v\n", err)
+		return 1
+	}
+
+	return m.Run()
+}
+
+// setupRegistry connects a registry to the API server as the test user.
+func setupRegistry(t *testing.T, opts ...registry.Option) registry.Registry {
+	t.Helper()
+
+	r, err := kubernetes.NewFromKubeconfig(kubeconfig, opts...)
+	if err != nil {
+		t.Fatalf("failed to set up registry: %v", err)
+	}
+
+	return r
+}
+
+// runningPod creates a pod and marks it running and ready at the IP, as
+// the kubelet would, deleting it once the test ends. The registry runs in
+// it for the rest of the test.
+func runningPod(t *testing.T, name, ip string) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "service", Image: "service"}},
+		},
+	}
+
+	if err := kube.Create(ctx, pod); err != nil {
+		t.Fatalf("failed to create pod %s: %v", name, err)
+	}
+
+	t.Cleanup(func() {
+		//nolint:errcheck
+		kube.Delete(ctx, pod, ctrlclient.GracePeriodSeconds(0))
+	})
+
+	pod.Status = corev1.PodStatus{
+		Phase: corev1.PodRunning,
+		PodIP: ip,
+		Conditions: []corev1.PodCondition{
+			{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+		},
+	}
+
+	if err := kube.Status().Update(ctx, pod); err != nil {
+		t.Fatalf("failed to mark pod %s running: %v", name, err)
+	}
+
+	t.Setenv("HOSTNAME", name)
+}
+
+func service(name, ip string) *registry.Service {
+	return &registry.Service{
+		Name:    name,
+		Version: "1",
+		Nodes:   []*registry.Node{{Id: name + "-1", Address: ip + ":8080"}},
+	}
+}
+
+// expectAction waits for the next result of a watcher.
+func expectAction(t *testing.T, w registry.Watcher, name, action string) {
+	t.Helper()
+
+	type next struct {
+		res *registry.Result
+		err error
+	}
+
+	ch := make(chan next, 1)
+
+	go func() {
+		res, err := w.Next()
+		ch <- next{res, err}
+	}()
+
+	select {
+	case n := <-ch:
+		if n.err != nil {
+			t.Fatalf("did not expect Next() to fail: %v", n.err)
+		}
+
+		if n.res.Service.Name != name || n.res.Action != action {
+			t.Fatalf("expected %s of %s, got %s of %s", action, name, n.res.Action, n.res.Service.Name)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for %s of %s", action, name)
+	}
+}
+
+func TestRegisterDeregister(t *testing.T) {
+	runningPod(t, "register", "10.0.0.1")
+
+	r := setupRegistry(t)
+	svc := service("foo.service", "10.0.0.1")
+
+	if err := r.Register(svc); err != nil {
+		t.Fatalf("did not expect Register() to fail: %v", err)
+	}
+
+	services, err := r.GetService(svc.Name)
+	if err != nil || len(services) != 1 || len(services[0].Nodes) != 1 {
+		t.Fatalf("expected a single node, got %+v, %v", services, err)
+	}
+
+	if addr := services[0].Nodes[0].Address; addr != "10.0.0.1:8080" {
+		t.Fatalf("expected the node at 10.0.0.1:8080, got %s", addr)
+	}
+
+	// registering again patches the same annotations
+	if err := r.Register(svc); err != nil {
+		t.Fatalf("did not expect Register() to fail again: %v", err)
+	}
+
+	if err := r.Deregister(svc); err != nil {
+		t.Fatalf("did not expect Deregister() to fail: %v", err)
+	}
+
+	services, err = r.GetService(svc.Name)
+	if err == nil && len(services) > 0 {
+		t.Fatalf("expected the service to be gone, got %+v", services)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	runningPod(t, "watch", "10.0.0.2")
+
+	r := setupRegistry(t)
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	svc := service("bar.service", "10.0.0.2")
+
+	if err := r.Register(svc); err != nil {
+		t.Fatalf("did not expect Register() to fail: %v", err)
+	}
+
+	expectAction(t, w, "bar.service", "create")
+
+	if err := r.Deregister(svc); err != nil {
+		t.Fatalf("did not expect Deregister() to fail: %v", err)
+	}
+
+	expectAction(t, w, "bar.service", "delete")
+}
+
+func TestWatchReconnect(t *testing.T) {
+	runningPod(t, "reconnect", "10.0.0.3")
+
+	reconnected := make(chan error, 10)
+
+	r := setupRegistry(t, kubernetes.OnReconnect(func(err error) { reconnected <- err }))
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	// the API server ends the watch after its request timeout
+	select {
+	case err := <-reconnected:
+		if err != nil {
+			t.Fatalf("expected the watch to be re-established, got %v", err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("expected the watch to be ended and re-established")
+	}
+
+	svc := service("baz.service", "10.0.0.3")
+
+	if err := r.Register(svc); err != nil {
+		t.Fatalf("did not expect Register() to fail: %v", err)
+	}
+
+	expectAction(t, w, "baz.service", "create")
+
+	if err := r.Deregister(svc); err != nil {
+		t.Fatalf("did not expect Deregister() to fail: %v", err)
+	}
+
+	expectAction(t, w, "baz.service", "delete")
+}
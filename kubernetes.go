@@ -2,11 +2,18 @@
 package kubernetes
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go-micro.dev/v4/registry"
@@ -14,13 +21,112 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/skiprco/go-micro-kubernetes-registry/backoff"
 	"github.com/skiprco/go-micro-kubernetes-registry/client"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/api"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/watch"
 )
 
 type kregistry struct {
 	client  client.Kubernetes
 	timeout time.Duration
 	options registry.Options
+	actions Actions
+	hooks   hooks
+
+	summaryAnnotation bool
+	configMaps        bool
+	skipCordoned      bool
+	labelPrefix       string
+	priorityKey       string
+	weightKey         string
+	secureKey         string
+	alpnKey           string
+	cacheTTL          time.Duration
+	versionSelector   bool
+	mesh              string
+	versionLabel      string
+	podFilter         func(*client.Pod) bool
+	getRetry          getRetry
+	configMapTarget   string
+	network           string
+	ignoreSelf        bool
+	validate          bool
+	headlessDomain    string
+	expireServices    bool
+	defaultTTL        time.Duration
+	serveFromCache    bool
+	payloadLabels     bool
+	overwriteCollide  bool
+	dedupNodes        bool
+	nodeID            NodeIDFunc
+	portGates         map[string]string
+	foldNames         bool
+	serviceSelectors  map[string]string
+	deliveryTimeout   time.Duration
+	keepTerminating   bool
+	withoutPodIP      bool
+	fieldManager      string
+	auditSink         chan<- AuditRecord
+	maxNodes          int
+	legacyDecoder     LegacyDecoder
+	registerMerge     bool
+	clockSkew         time.Duration
+	readYourWrites    bool
+	selectorMapper    SelectorMapper
+
+	// the backoffs of reconnecting watches, and of retrying the watches of
+	// namespaces and clusters failing.
+	reconnectBackoff backoff.Backoff
+	watchBackoff     backoff.Backoff
+
+	// collapses repeated watcher errors, logging every one when nil.
+	logs *logThrottle
+
+	// the gauges of the namespaces watched, shared with the registries
+	// watching each namespace or cluster.
+	gauges *cacheGauges
+
+	// caches the topology of nodes, nil unless tagging it.
+	topology *topology
+
+	// the cluster the registry discovers, and the registries of the
+	// clusters federated, nil unless federating clusters.
+	cluster string
+	members map[string]*kregistry
+
+	// the watch shared by the watchers, nil unless sharing it.
+	shared *sharedWatch
+
+	// re-registers services removed from the self pod, nil unless healing.
+	healer *healer
+
+	// encrypts and decrypts payloads, nil unless encrypting them, or the
+	// error of its keys.
+	cipher    *payloadCipher
+	cipherErr error
+
+	// what watchers and lookups rejected, dropped or found conflicting,
+	// shared with the registries watching each namespace or cluster.
+	counters *registryCounters
+
+	// identifies the registrations of this registry, with their sequence.
+	instanceID  string
+	registrySeq atomic.Uint64
+
+	// serializes the read-modify-write of the summary annotation
+	summaryMtx sync.Mutex
+
+	// serializes the read-modify-apply of the self pod
+	applyMtx sync.Mutex
+
+	// serializes the read-modify-write of merged registrations
+	mergeMtx sync.Mutex
+
+	// watchers caching every service pod, serving GetService from cache
+	// when asked to, or while the circuit breaker of the client is open.
+	cachesMtx sync.Mutex
+	caches    map[*k8sWatcher]struct{}
 }
 
 var (
@@ -36,22 +142,89 @@ var (
 	// micro service by pod name.
 	annotationServiceKeyPrefix = "micro.mu/service-"
 
+	// used on config map data entries to hold a serialized
+	// micro service, eg: configMapServiceKeyPrefix+"svc.name"
+	configMapServiceKeyPrefix = "service-"
+
+	// human readable list of the services registered on a pod,
+	// never used for discovery.
+	annotationServicesKey = "micro.mu/services"
+
+	// the registry instance that last registered on a pod, with the
+	// sequence of that registration, eg: "<instance id>/3".
+	annotationRegisteredByKey = "micro.mu/registered-by"
+
 	// Pod status.
-	podRunning = "Running"
+	podRunning   = "Running"
+	podSucceeded = "Succeeded"
+
+	// Pod condition telling it is ready to serve.
+	podReadyCondition = "Ready"
+
+	// kinds of the objects in watch events, a Status telling the watch
+	// failed.
+	podKind       = "Pod"
+	configMapKind = "ConfigMap"
+	statusKind    = "Status"
 
 	// label name regex.
 	labelRe = regexp.MustCompilePOSIX("[-A-Za-z0-9_.]")
+
+	// the name part of a label or annotation key is at most 63 characters,
+	// which has to fit "selector-" or "service-" and the service name.
+	maxServiceNameLen = 63 - len("selector-")
+
+	// pods listed per page when listing all services.
+	listPageSize = 500
+)
+
+// default watcher result actions.
+const (
+	actionCreate = "create"
+	actionUpdate = "update"
+	actionDelete = "delete"
 )
 
 // Err are all package errors.
 var (
-	ErrNoHostname   = errors.New("failed to get podname from HOSTNAME variable")
-	ErrNoNodesFound = errors.New("you must provide at least one node")
+	ErrSelfPodUnknown    = errors.New("failed to resolve the pod this service runs in, set POD_NAME or HOSTNAME")
+	ErrNoNodesFound      = errors.New("you must provide at least one node")
+	ErrSelfPodConfigMaps = errors.New("the self pod watch option is not supported with config maps")
+
+	ErrNamespacesUnsupported = errors.New("the kubernetes client can't operate on other namespaces")
+	ErrServiceTooLarge       = errors.New("the service is too large to fit the annotations of a pod")
+	ErrConfigMapsUnsupported = errors.New("the kubernetes client can't write config maps")
+	ErrServiceNameCollision  = errors.New("another service is registered under the same annotation key")
+	ErrServicesUnsupported   = errors.New("the kubernetes client can't get services")
+	ErrNoServiceSelector     = errors.New("the kubernetes service selects no pods by label")
+	ErrNodeWithoutPod        = errors.New("the node doesn't tell the pod it was discovered from")
+	ErrApplyUnsupported      = errors.New("the kubernetes client can't apply pods")
+
+	ErrAccessReviewUnsupported = errors.New("the kubernetes client can't review access")
+	ErrAccessDenied            = errors.New("the service account lacks permissions, grant them through RBAC")
+
+	// ErrWatcherStopped is returned by the Next method of stopped watchers.
+	ErrWatcherStopped = errors.New("result chan closed")
+
+	// Deprecated: use ErrSelfPodUnknown.
+	ErrNoHostname = ErrSelfPodUnknown
 )
 
-// podSelector.
-var podSelector = map[string]string{
-	labelTypeKey: labelTypeValueService,
+// ServicePager is implemented by the registry to list services in pages.
+type ServicePager interface {
+	ListServicesPaged(ctx context.Context, pageSize int, continueToken string) ([]*registry.Service, string, error)
+}
+
+// ServiceRanger is implemented by the registry to stream services.
+type ServiceRanger interface {
+	RangeServices(ctx context.Context, fn func(*registry.Service) bool) error
+}
+
+// podSelector selects the pods of every service of the mesh.
+func (c *kregistry) podSelector() map[string]string {
+	return map[string]string{
+		labelTypeKey: c.meshValue(labelTypeValueService),
+	}
 }
 
 // compactEncode serializes a registry.Service to keep only the essential
@@ -67,16 +240,38 @@ func compactEncode(s *registry.Service) ([]byte, error) {
 }
 
 // compactDecode deserializes a registry.Service from the compact format
-func compactDecode(data []byte) (*registry.Service, error) {
+func compactDecode(data string) (*registry.Service, error) {
 	// JSON decode
 	var s registry.Service
-	if err := json.Unmarshal(data, &s); err != nil {
+	if err := unmarshalString(data, &s); err != nil {
 		return nil, err
 	}
 
 	return &s, nil
 }
 
+// decodeBuffers hold the bytes of the annotations being decoded, so they
+// aren't copied into a fresh slice each time.
+var decodeBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 1024)
+		return &b
+	},
+}
+
+// unmarshalString decodes JSON held in a string. The decoded value never
+// references the buffer, which is reused once decoded.
+func unmarshalString(data string, v interface{}) error {
+	buf, _ := decodeBuffers.Get().(*[]byte)
+	*buf = append((*buf)[:0], data...)
+
+	err := json.Unmarshal(*buf, v)
+
+	decodeBuffers.Put(buf)
+
+	return err
+}
+
 func init() {
 	cmd.DefaultRegistries["kubernetes"] = NewRegistry
 }
@@ -92,25 +287,272 @@ func configure(k *kregistry, opts ...registry.Option) error {
 		host = k.options.Addrs[0]
 	}
 
-	if k.options.Timeout == 0 {
-		k.options.Timeout = time.Second * 1
+	// every call is bounded by the registry timeout, but watch streams,
+	// when set only: lists of large namespaces take longer than a default.
+	var clientOpts []client.Option
+	if k.options.Timeout > 0 {
+		clientOpts = append(clientOpts, client.Timeout(k.options.Timeout))
+	}
+
+	if k.options.Context != nil {
+		opts, _ := k.options.Context.Value(clientOptionsKey{}).([]client.Option)
+		clientOpts = append(clientOpts, opts...)
+	}
+
+	var (
+		kubeconfig                string
+		fromKubeconfig, inCluster bool
+	)
+
+	// an empty kubeconfig path is the default kubeconfig
+	if k.options.Context != nil {
+		kubeconfig, fromKubeconfig = k.options.Context.Value(kubeconfigKey{}).(string)
+		inCluster, _ = k.options.Context.Value(inClusterKey{}).(bool)
 	}
 
 	// if no hosts setup, assume InCluster
 	var c client.Kubernetes
-	if len(host) == 0 {
-		c = client.NewClientInCluster()
-	} else {
-		c = client.NewClientByHost(host)
+
+	switch {
+	case k.options.Context != nil && k.options.Context.Value(clientKey{}) != nil:
+		c, _ = k.options.Context.Value(clientKey{}).(client.Kubernetes)
+	case fromKubeconfig:
+		kc, err := client.NewClientFromKubeconfig(kubeconfig, clientOpts...)
+		if err != nil {
+			return err
+		}
+
+		c = kc
+	case inCluster || len(host) == 0:
+		c = client.NewClientInCluster(clientOpts...)
+	default:
+		c = client.NewClientByHost(host, clientOpts...)
 	}
 
 	k.client = c
 	k.timeout = k.options.Timeout
+	if k.timeout == 0 {
+		k.timeout = time.Second * 1
+	}
+	k.actions = Actions{Create: actionCreate, Update: actionUpdate, Delete: actionDelete}
+	k.cacheTTL = defaultCacheTTL
+	k.dedupNodes = true
+	k.logs = newLogThrottle(defaultLogWindow)
+	k.gauges = newCacheGauges()
+	k.reconnectBackoff = defaultBackoff
+	k.watchBackoff = defaultBackoff
+
+	if k.options.Context == nil {
+		return nil
+	}
+
+	k.summaryAnnotation, _ = k.options.Context.Value(summaryAnnotationKey{}).(bool)
+	k.configMaps, _ = k.options.Context.Value(configMapsKey{}).(bool)
+	k.skipCordoned, _ = k.options.Context.Value(skipCordonedKey{}).(bool)
+	k.labelPrefix, _ = k.options.Context.Value(labelPrefixKey{}).(string)
+	k.versionSelector, _ = k.options.Context.Value(versionSelectorKey{}).(bool)
+	k.mesh, _ = k.options.Context.Value(meshKey{}).(string)
+	k.versionLabel, _ = k.options.Context.Value(versionLabelKey{}).(string)
+	k.podFilter, _ = k.options.Context.Value(podFilterKey{}).(func(*client.Pod) bool)
+	k.getRetry, _ = k.options.Context.Value(getRetryKey{}).(getRetry)
+	k.configMapTarget, _ = k.options.Context.Value(configMapTargetKey{}).(string)
+	k.network, _ = k.options.Context.Value(podNetworkKey{}).(string)
+	k.ignoreSelf, _ = k.options.Context.Value(ignoreSelfKey{}).(bool)
+	k.validate, _ = k.options.Context.Value(validateKey{}).(bool)
+	k.priorityKey, _ = k.options.Context.Value(priorityKey{}).(string)
+	k.weightKey, _ = k.options.Context.Value(weightKey{}).(string)
+	k.secureKey, _ = k.options.Context.Value(secureKey{}).(string)
+	k.alpnKey, _ = k.options.Context.Value(alpnKey{}).(string)
+	k.headlessDomain, _ = k.options.Context.Value(headlessDNSKey{}).(string)
+	k.expireServices, _ = k.options.Context.Value(expireServicesKey{}).(bool)
+	k.defaultTTL, _ = k.options.Context.Value(defaultTTLKey{}).(time.Duration)
+	k.serveFromCache, _ = k.options.Context.Value(serveFromCacheKey{}).(bool)
+	k.payloadLabels, _ = k.options.Context.Value(payloadLabelsKey{}).(bool)
+	k.overwriteCollide, _ = k.options.Context.Value(overwriteCollisionsKey{}).(bool)
+	k.nodeID, _ = k.options.Context.Value(nodeIDKey{}).(NodeIDFunc)
+	k.portGates, _ = k.options.Context.Value(portReadinessKey{}).(map[string]string)
+	k.foldNames, _ = k.options.Context.Value(caseInsensitiveNamesKey{}).(bool)
+	k.serviceSelectors, _ = k.options.Context.Value(serviceSelectorsKey{}).(map[string]string)
+	k.deliveryTimeout, _ = k.options.Context.Value(deliveryTimeoutKey{}).(time.Duration)
+	k.withoutPodIP, _ = k.options.Context.Value(withoutPodIPKey{}).(bool)
+	k.fieldManager, _ = k.options.Context.Value(fieldManagerKey{}).(string)
+	k.auditSink, _ = k.options.Context.Value(auditSinkKey{}).(chan<- AuditRecord)
+	k.maxNodes, _ = k.options.Context.Value(maxNodesKey{}).(int)
+	k.legacyDecoder, _ = k.options.Context.Value(legacyDecoderKey{}).(LegacyDecoder)
+	k.registerMerge, _ = k.options.Context.Value(registerMergeKey{}).(bool)
+	k.clockSkew, _ = k.options.Context.Value(clockSkewKey{}).(time.Duration)
+	k.readYourWrites, _ = k.options.Context.Value(readYourWritesKey{}).(bool)
+	k.selectorMapper, _ = k.options.Context.Value(selectorMapperKey{}).(SelectorMapper)
+
+	if deregister, ok := k.options.Context.Value(deregisterTerminatingKey{}).(bool); ok {
+		k.keepTerminating = !deregister
+	}
+
+	if enc, ok := k.options.Context.Value(encryptPayloadsKey{}).(encryptPayloads); ok {
+		k.cipher, k.cipherErr = newPayloadCipher(enc.keyID, enc.keys)
+		if k.cipherErr != nil {
+			return k.cipherErr
+		}
+	}
+
+	if named, ok := k.options.Context.Value(podNamesKey{}).(podNames); ok && len(named.names) > 0 {
+		if named.interval <= 0 {
+			named.interval = defaultPodNamesInterval
+		}
+
+		k.client = namedPodsClient{Kubernetes: k.client, names: named.names, interval: named.interval}
+	}
+
+	if window, ok := k.options.Context.Value(logThrottleKey{}).(time.Duration); ok {
+		k.logs = newLogThrottle(window)
+	}
+
+	if tag, _ := k.options.Context.Value(nodeTopologyKey{}).(bool); tag {
+		k.topology = newTopology(c, k.logs)
+	}
+
+	// kept on Init, with the watchers sharing it
+	if shared, _ := k.options.Context.Value(sharedWatchKey{}).(bool); shared && k.shared == nil {
+		k.shared = &sharedWatch{registry: k}
+	}
+
+	// kept on Init, with the services it heals
+	if heal, ok := k.options.Context.Value(selfHealKey{}).(selfHeal); ok && heal.max > 0 && k.healer == nil {
+		onGiveUp, _ := k.options.Context.Value(onGiveUpKey{}).(func(string))
+		k.healer = newHealer(k, heal.max, heal.window, onGiveUp)
+	}
+
+	if ttl, ok := k.options.Context.Value(cacheTTLKey{}).(time.Duration); ok {
+		k.cacheTTL = ttl
+	}
+
+	if dedup, ok := k.options.Context.Value(dedupNodesKey{}).(bool); ok {
+		k.dedupNodes = dedup
+	}
+
+	if b, ok := k.options.Context.Value(reconnectBackoffKey{}).(backoff.Backoff); ok && b != nil {
+		k.reconnectBackoff = b
+	}
+
+	if b, ok := k.options.Context.Value(watchBackoffKey{}).(backoff.Backoff); ok && b != nil {
+		k.watchBackoff = b
+	}
+
+	k.hooks.onReconnect, _ = k.options.Context.Value(onReconnectKey{}).(func(error))
+	k.hooks.onResync, _ = k.options.Context.Value(onResyncKey{}).(func(int))
+	k.hooks.onWatchEstablished, _ = k.options.Context.Value(onWatchEstablishedKey{}).(func())
+	k.hooks.onRawEvent, _ = k.options.Context.Value(onRawEventKey{}).(func(watch.Event))
+
+	if a, ok := k.options.Context.Value(actionsKey{}).(Actions); ok {
+		if len(a.Create) > 0 {
+			k.actions.Create = a.Create
+		}
+
+		if len(a.Update) > 0 {
+			k.actions.Update = a.Update
+		}
+
+		if len(a.Delete) > 0 {
+			k.actions.Delete = a.Delete
+		}
+
+		k.actions.Completed = a.Completed
+		k.actions.Replace = a.Replace
+	}
+
+	// last, as the registries of the clusters copy the registry
+	if fed, ok := k.options.Context.Value(clustersKey{}).(clusters); ok && len(fed.remotes) > 0 {
+		k.members = k.federate(fed)
+	}
 
 	return nil
 }
 
-// serviceName generates a valid service name for k8s labels.
+// serviceSelector selects the pods of a service, whatever the value of
+// their selector label unless mapped, of the mesh only when in one.
+func (c *kregistry) serviceSelector(name string) map[string]string {
+	selector := map[string]string{
+		svcSelectorPrefix + serviceName(name): "",
+	}
+
+	if c.selectorMapper != nil {
+		key, value := c.selectorMapper.Selector(name)
+		selector = map[string]string{key: value}
+	}
+
+	if len(c.mesh) > 0 {
+		selector[labelTypeKey] = c.meshValue(labelTypeValueService)
+	}
+
+	return selector
+}
+
+// selectorValue returns the value of the selector label of a service, its
+// sanitized version when selecting by version, suffixed with the mesh.
+func (c *kregistry) selectorValue(s *registry.Service) *string {
+	v := svcSelectorValue
+	if c.versionSelector && len(s.Version) > 0 {
+		v = serviceName(s.Version)
+	}
+
+	v = c.meshValue(v)
+
+	return &v
+}
+
+// typeValue returns the value of the type label of service pods.
+func (c *kregistry) typeValue() *string {
+	v := c.meshValue(labelTypeValueService)
+	return &v
+}
+
+// meshValue suffixes a label value with the mesh, when in one.
+func (c *kregistry) meshValue(v string) string {
+	if len(c.mesh) == 0 {
+		return v
+	}
+
+	return v + "." + serviceName(c.mesh)
+}
+
+// inMesh reports whether a pod belongs to the mesh of the registry, by its
+// type label. Pods without one belong to no mesh.
+func (c *kregistry) inMesh(pod *client.Pod) bool {
+	v, ok := pod.Metadata.Labels[labelTypeKey]
+	if !ok || v == nil {
+		return len(c.mesh) == 0
+	}
+
+	return *v == c.meshValue(labelTypeValueService)
+}
+
+// normalize returns the name services are registered and looked up by,
+// lowercased when names are case insensitive.
+func (c *kregistry) normalize(name string) string {
+	if !c.foldNames {
+		return name
+	}
+
+	return strings.ToLower(name)
+}
+
+// withName returns the service registered under its normalized name,
+// copying it rather than renaming the caller's service.
+func (c *kregistry) withName(s *registry.Service) *registry.Service {
+	if name := c.normalize(s.Name); name != s.Name {
+		svc := *s
+		svc.Name = name
+
+		return &svc
+	}
+
+	return s
+}
+
+// serviceName generates a valid service name for k8s labels and
+// annotations. Names too long for a key, or ending in a character a key
+// can't end with, are truncated and suffixed with a hash of the full name
+// so distinct services keep distinct keys.
 func serviceName(name string) string {
 	aname := make([]byte, len(name))
 
@@ -123,7 +565,24 @@ func serviceName(name string) string {
 		aname[i] = r
 	}
 
-	return string(aname)
+	if len(aname) > 0 && len(aname) <= maxServiceNameLen && isAlphanumeric(aname[len(aname)-1]) {
+		return string(aname)
+	}
+
+	h := fnv.New32a()
+	//nolint:errcheck
+	h.Write([]byte(name))
+	suffix := fmt.Sprintf("-%08x", h.Sum32())
+
+	if len(aname) > maxServiceNameLen-len(suffix) {
+		aname = aname[:maxServiceNameLen-len(suffix)]
+	}
+
+	return string(aname) + suffix
+}
+
+func isAlphanumeric(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9')
 }
 
 // Init allows reconfig of options.
@@ -137,42 +596,121 @@ func (c *kregistry) Options() registry.Options {
 }
 
 // Register sets a service selector label and an annotation with a
-// serialized version of the service passed in.
+// serialized version of the service passed in, also written to the config
+// map target, if any.
 func (c *kregistry) Register(s *registry.Service, opts ...registry.RegisterOption) error {
 	if len(s.Nodes) == 0 {
 		return ErrNoNodesFound
 	}
 
-	svcName := s.Name
-
 	// TODO: grab podname from somewhere better than this.
 	podName, err := getPodName()
 	if err != nil {
 		return errors.Wrap(err, "failed to register")
 	}
 
+	err = c.register(podName, s, opts...)
+	if err == nil && c.healer != nil {
+		c.healer.track(c.withName(s), opts)
+	}
+
+	c.audit(AuditRegister, "", podName, s, err)
+
+	return err
+}
+
+// register sets a service on a pod, and on the config map targeted if any.
+func (c *kregistry) register(podName string, s *registry.Service, opts ...registry.RegisterOption) error {
+	s = c.withName(s)
+	svcName := s.Name
+
+	s = c.withNodeIDs(podName, s)
+
+	if c.registerMerge {
+		c.mergeMtx.Lock()
+		defer c.mergeMtx.Unlock()
+
+		merged, err := c.mergeRegistered(podName, s)
+		if err != nil {
+			return err
+		}
+
+		s = merged
+	}
+
 	// encode micro service
-	b, err := compactEncode(s)
+	b, err := encodeService(s, c.registerTTL(opts...))
+	if err != nil {
+		return err
+	}
+
+	payload := string(b)
+
+	if c.cipherErr != nil {
+		return c.cipherErr
+	}
+
+	if err := c.checkCollision(podName, svcName); err != nil {
+		return err
+	}
+
+	if c.cipher != nil {
+		if payload, err = c.cipher.seal(payload); err != nil {
+			return err
+		}
+	}
+
+	err = c.registerPod(podName, s, payload)
+
+	if len(c.configMapTarget) == 0 {
+		return err
+	}
+
+	return c.targetsErr(podName, err, c.patchConfigMap(podName, svcName, &payload))
+}
+
+// registerPod sets the selector label and annotations of a service on the
+// pod.
+func (c *kregistry) registerPod(podName string, s *registry.Service, payload string) error {
+	svcName := s.Name
+
+	annotations, err := notationAnnotations(serviceName(svcName), payload)
 	if err != nil {
 		return err
 	}
 
-	svc := string(b)
+	selectorKey, selectorValue := c.selectorLabel(s)
 
 	pod := &client.Pod{
 		Metadata: &client.Meta{
 			Labels: map[string]*string{
-				labelTypeKey:                             &labelTypeValueService,
-				svcSelectorPrefix + serviceName(svcName): &svcSelectorValue,
-			},
-			Annotations: map[string]*string{
-				annotationServiceKeyPrefix + serviceName(svcName): &svc,
+				labelTypeKey: c.typeValue(),
+				selectorKey:  selectorValue,
 			},
+			Annotations: annotations,
 		},
 	}
 
-	if _, err := c.client.UpdatePod(podName, pod); err != nil {
-		return err
+	if c.payloadLabels {
+		storePayload(pod.Metadata, serviceName(svcName), payload)
+	}
+
+	c.tagRegistration(pod)
+
+	if c.summaryAnnotation {
+		c.summaryMtx.Lock()
+		defer c.summaryMtx.Unlock()
+
+		summary, err := c.servicesSummary(podName, svcName, true)
+		if err != nil {
+			return selfPodErr(podName, err)
+		}
+
+		pod.Metadata.Annotations[annotationServicesKey] = summary
+	}
+
+	if err := c.updatePod(podName, pod); err != nil {
+		return selfPodErr(podName, err)
 	}
 
 	return nil
@@ -184,7 +722,10 @@ func (c *kregistry) Deregister(s *registry.Service, opts ...registry.DeregisterO
 		return ErrNoNodesFound
 	}
 
-	svcName := s.Name
+	// not registered again once removed below
+	if c.healer != nil {
+		c.healer.untrack(c.normalize(s.Name))
+	}
 
 	// TODO: grab podname from somewhere better than env var.
 	podName, err := getPodName()
@@ -192,30 +733,251 @@ func (c *kregistry) Deregister(s *registry.Service, opts ...registry.DeregisterO
 		return errors.Wrap(err, "failed to deregister")
 	}
 
+	err = c.deregister(podName, s)
+	c.audit(AuditDeregister, "", podName, s, err)
+
+	return err
+}
+
+// deregister removes a service from a pod, and from the config map targeted
+// if any.
+func (c *kregistry) deregister(podName string, s *registry.Service) error {
+	svcName := c.normalize(s.Name)
+
+	if err := c.checkCollision(podName, svcName); err != nil {
+		return err
+	}
+
+	err := c.deregisterPod(podName, svcName)
+
+	if len(c.configMapTarget) == 0 {
+		return err
+	}
+
+	return c.targetsErr(podName, err, c.patchConfigMap(podName, svcName, nil))
+}
+
+// deregisterPod removes the selector label and annotations of a service
+// from the pod. Mapped selector labels are kept, as they may be of another
+// convention the pod is labelled by anyway, selecting no service without
+// its annotations.
+func (c *kregistry) deregisterPod(podName, svcName string) error {
 	pod := &client.Pod{
 		Metadata: &client.Meta{
-			Labels: map[string]*string{
-				svcSelectorPrefix + serviceName(svcName): nil,
-			},
-			Annotations: map[string]*string{
-				annotationServiceKeyPrefix + serviceName(svcName): nil,
-			},
+			Labels:      map[string]*string{},
+			Annotations: clearNotationAnnotations(serviceName(svcName)),
 		},
 	}
 
-	if _, err := c.client.UpdatePod(podName, pod); err != nil {
-		return err
+	if c.selectorMapper == nil {
+		pod.Metadata.Labels[svcSelectorPrefix+serviceName(svcName)] = nil
+	}
+
+	if c.payloadLabels {
+		pod.Metadata.Labels[labelPayloadPrefix+serviceName(svcName)] = nil
+	}
+
+	c.tagRegistration(pod)
+
+	if c.summaryAnnotation {
+		c.summaryMtx.Lock()
+		defer c.summaryMtx.Unlock()
+
+		summary, err := c.servicesSummary(podName, svcName, false)
+		if err != nil {
+			return selfPodErr(podName, err)
+		}
+
+		pod.Metadata.Annotations[annotationServicesKey] = summary
+	}
+
+	if err := c.updatePod(podName, pod); err != nil {
+		return selfPodErr(podName, err)
 	}
 
 	return nil
 }
 
+// tagRegistration marks a pod patch as done by this registry, so its
+// watchers can ignore the events it causes.
+func (c *kregistry) tagRegistration(pod *client.Pod) {
+	if !c.ignoreSelf {
+		return
+	}
+
+	tag := fmt.Sprintf("%s/%d", c.instanceID, c.registrySeq.Add(1))
+	pod.Metadata.Annotations[annotationRegisteredByKey] = &tag
+}
+
+// selfRegistration reports whether a pod changed by a registration of this
+// registry, rather than by anything else, since its cached version.
+func (c *kregistry) selfRegistration(pod, cache *client.Pod) bool {
+	if !c.ignoreSelf || pod.Metadata == nil {
+		return false
+	}
+
+	tag := pod.Metadata.Annotations[annotationRegisteredByKey]
+	if tag == nil || !strings.HasPrefix(*tag, c.instanceID+"/") {
+		return false
+	}
+
+	if cache != nil && cache.Metadata != nil {
+		if cached := cache.Metadata.Annotations[annotationRegisteredByKey]; cached != nil && *cached == *tag {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkCollision returns ErrServiceNameCollision when the pod holds the
+// payload of another service under the annotation key of the named one,
+// unless overwriting collisions.
+func (c *kregistry) checkCollision(podName, svcName string) error {
+	if c.overwriteCollide {
+		return nil
+	}
+
+	p, err := c.client.GetPod(podName)
+	if err != nil {
+		return selfPodErr(podName, err)
+	}
+
+	if p.Metadata == nil {
+		return nil
+	}
+
+	c.readPayloads([]client.Pod{*p})
+
+	data, err := notation(p.Metadata, annotationServiceKeyPrefix+serviceName(svcName))
+	if err != nil {
+		return nil
+	}
+
+	svc, err := c.decodeService(data)
+	if err != nil || c.normalize(svc.Name) == svcName {
+		return nil
+	}
+
+	return errors.Wrapf(ErrServiceNameCollision, "service %s collides with %s on pod %s", svcName, svc.Name, podName)
+}
+
+// servicesSummary builds the summary annotation value for a pod once the
+// named service has been registered on it, or removed from it. A nil value
+// is returned when no services remain, which removes the annotation.
+func (c *kregistry) servicesSummary(podName, svcName string, registered bool) (*string, error) {
+	p, err := c.client.GetPod(podName)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+
+	if p.Metadata != nil {
+		for k := range p.Metadata.Annotations {
+			if !strings.HasPrefix(k, annotationServiceKeyPrefix) {
+				continue
+			}
+
+			data, err := notation(p.Metadata, k)
+			if err != nil {
+				continue
+			}
+
+			svc, err := c.decodeService(data)
+			if err != nil {
+				continue
+			}
+
+			names[svc.Name] = true
+		}
+	}
+
+	names[svcName] = registered
+
+	list := make([]string, 0, len(names))
+
+	for name, ok := range names {
+		if ok {
+			list = append(list, name)
+		}
+	}
+
+	if len(list) == 0 {
+		return nil, nil
+	}
+
+	sort.Strings(list)
+	summary := strings.Join(list, ",")
+
+	return &summary, nil
+}
+
 // GetService will get all the pods with the given service selector,
 // and build services from the annotations.
 func (c *kregistry) GetService(name string, opts ...registry.GetOption) ([]*registry.Service, error) {
-	pods, err := c.client.ListPods(map[string]string{
-		svcSelectorPrefix + serviceName(name): svcSelectorValue,
-	})
+	name = c.normalize(name)
+
+	if len(c.members) > 0 {
+		services, err := c.federatedService(name, opts...)
+		return c.capNodes(services), err
+	}
+
+	services, err := c.getService(name)
+
+	if c.getRetry.attempts <= 1 {
+		return c.capNodes(services), err
+	}
+
+	var options registry.GetOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	ctx := options.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// not found until a pod of the rollout runs
+	for attempt := 1; attempt < c.getRetry.attempts && serviceNotFound(services, err); attempt++ {
+		select {
+		case <-ctx.Done():
+			return services, err
+		case <-time.After(c.getRetry.backoff.NextDelay(attempt)):
+		}
+
+		services, err = c.getService(name)
+	}
+
+	return c.capNodes(services), err
+}
+
+// serviceNotFound reports whether a lookup found no service.
+func serviceNotFound(services []*registry.Service, err error) bool {
+	return errors.Is(err, registry.ErrNotFound) || err == nil && len(services) == 0
+}
+
+// getService looks a service up once.
+func (c *kregistry) getService(name string) ([]*registry.Service, error) {
+	if kubeService, ok := c.serviceSelectors[name]; ok {
+		return c.selectedService(name, kubeService)
+	}
+
+	if c.serveFromCache {
+		if services := c.cachedService(name); len(services) > 0 {
+			return services, nil
+		}
+	}
+
+	pods, err := c.client.ListPods(c.serviceSelector(name))
+	if errors.Is(err, client.ErrCircuitOpen) {
+		// spare the API server while it is failing
+		if services := c.cachedService(name); len(services) > 0 {
+			return services, nil
+		}
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -224,37 +986,52 @@ func (c *kregistry) GetService(name string, opts ...registry.GetOption) ([]*regi
 		return nil, registry.ErrNotFound
 	}
 
-	// svcs mapped by version
+	c.readPayloads(pods.Items)
+
+	return c.buildService(name, pods.Items)
+}
+
+// buildService merges the versions of a service advertised by pods.
+func (c *kregistry) buildService(name string, pods []client.Pod) ([]*registry.Service, error) {
+	// svcs mapped by version, with the pod their metadata is from
 	svcs := make(map[string]*registry.Service)
+	owners := make(map[string]string)
+	now := time.Now()
 
 	// loop through items
-	for _, pod := range pods.Items {
-		if pod.Status.Phase != podRunning || pod.Metadata.DeletionTimestamp != "" {
+	for _, pod := range pods {
+		if pod.Status.Phase != podRunning || c.terminating(&pod) || c.pendingIP(&pod) || !c.included(&pod) {
 			continue
 		}
-		// get serialized service from annotation
-		svcStr, ok := pod.Metadata.Annotations[annotationServiceKeyPrefix+serviceName(name)]
-		if !ok {
+		// get serialized service from annotation, skipping incomplete shards
+		svcStr, err := notation(pod.Metadata, annotationServiceKeyPrefix+serviceName(name))
+		if err != nil || c.expiredBy(pod.Metadata, svcStr, now) {
 			continue
 		}
 
 		var svc registry.Service
 
 		// unmarshal service string
-		svcPtr, err := compactDecode([]byte(*svcStr))
+		svcPtr, err := c.decodeService(svcStr)
 		if err != nil {
 			return nil, fmt.Errorf("could not unmarshal service '%s' from pod annotation", name)
 		}
 		svc = *svcPtr
 
-		// merge up pod service & ip with versioned service.
-		vs, ok := svcs[svc.Version]
-		if !ok {
-			svcs[svc.Version] = &svc
+		// another service sanitized to the same key
+		if c.normalize(svc.Name) != name {
 			continue
 		}
 
-		vs.Nodes = append(vs.Nodes, svc.Nodes...)
+		svc.Name = name
+
+		c.nodeMetadata(&pod, &svc)
+
+		// merge up pod service & ip with versioned service, once per
+		// version of its version map if any.
+		for _, v := range withVersions(&svc, versionMap(pod.Metadata, annotationServiceKeyPrefix+serviceName(name))) {
+			c.mergeService(svcs, owners, v.Version, pod.Metadata.Name, v)
+		}
 	}
 
 	list := make([]*registry.Service, 0, len(svcs))
@@ -265,45 +1042,151 @@ func (c *kregistry) GetService(name string, opts ...registry.GetOption) ([]*regi
 	return list, nil
 }
 
-// ListServices will list all the service names.
+// ListServices will list all the service names, listing pods in pages
+// when the client supports it.
 func (c *kregistry) ListServices(opts ...registry.ListOption) ([]*registry.Service, error) {
-	pods, err := c.client.ListPods(podSelector)
-	if err != nil {
-		return nil, err
+	if len(c.members) > 0 {
+		return c.federatedServices(opts...)
 	}
 
-	// svcs mapped by name+version
+	// svcs mapped by name+version, with the pod their metadata is from
 	svcs := make(map[string]*registry.Service)
+	owners := make(map[string]string)
+
+	pager, ok := c.client.(client.Pager)
+	if !ok {
+		pods, err := c.client.ListPods(c.podSelector())
+		if err != nil {
+			return nil, err
+		}
+
+		c.addServices(svcs, owners, pods.Items)
+
+		return serviceList(svcs), nil
+	}
+
+	var continueToken string
+
+	for {
+		pods, err := pager.ListPodsPage(c.podSelector(), listPageSize, continueToken)
+		if err != nil {
+			return nil, err
+		}
+
+		c.addServices(svcs, owners, pods.Items)
+
+		if continueToken = pods.Continue(); len(continueToken) == 0 {
+			return serviceList(svcs), nil
+		}
+	}
+}
+
+// ListServicesPaged lists the services of a page of at most pageSize pods,
+// starting at the continue token returned by the previous page, or at the
+// first page for an empty one. It returns the token of the next page, empty
+// after the last page. A service spread across pages is returned on each,
+// with its nodes on that page.
+func (c *kregistry) ListServicesPaged(ctx context.Context, pageSize int, continueToken string) ([]*registry.Service, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	svcs := make(map[string]*registry.Service)
+	owners := make(map[string]string)
+
+	pager, ok := c.client.(client.Pager)
+	if !ok {
+		// a single page holding everything
+		pods, err := c.client.ListPods(c.podSelector())
+		if err != nil {
+			return nil, "", err
+		}
+
+		c.addServices(svcs, owners, pods.Items)
+
+		return serviceList(svcs), "", nil
+	}
+
+	pods, err := pager.ListPodsPage(c.podSelector(), pageSize, continueToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	c.addServices(svcs, owners, pods.Items)
+
+	return serviceList(svcs), pods.Continue(), nil
+}
+
+// RangeServices calls fn with each service while paging through pods,
+// without holding them all in memory, until fn returns false or the context
+// is done. Like ListServicesPaged, a service spread across pages is passed
+// once per page, with its nodes on that page.
+func (c *kregistry) RangeServices(ctx context.Context, fn func(*registry.Service) bool) error {
+	var continueToken string
+
+	for {
+		services, next, err := c.ListServicesPaged(ctx, listPageSize, continueToken)
+		if err != nil {
+			return err
+		}
+
+		for _, svc := range services {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if !fn(svc) {
+				return nil
+			}
+		}
+
+		if continueToken = next; len(continueToken) == 0 {
+			return nil
+		}
+	}
+}
+
+// addServices merges the services advertised by pods into svcs, mapped by
+// name+version, with the pod their metadata is from in owners.
+func (c *kregistry) addServices(svcs map[string]*registry.Service, owners map[string]string, pods []client.Pod) {
+	now := time.Now()
 
-	for _, pod := range pods.Items {
-		if pod.Status.Phase != podRunning || pod.Metadata.DeletionTimestamp != "" {
+	c.readPayloads(pods)
+
+	for _, pod := range pods {
+		if pod.Status.Phase != podRunning || c.terminating(&pod) || c.pendingIP(&pod) || !c.included(&pod) {
 			continue
 		}
 
-		for k, v := range pod.Metadata.Annotations {
+		for k := range pod.Metadata.Annotations {
 			if !strings.HasPrefix(k, annotationServiceKeyPrefix) {
 				continue
 			}
 
+			v, err := notation(pod.Metadata, k)
+			if err != nil || c.expiredBy(pod.Metadata, v, now) {
+				continue
+			}
+
 			// we have to unmarshal the annotation itself since the
 			// key is encoded to match the regex restriction.
-			svcPtr, err := compactDecode([]byte(*v))
+			svcPtr, err := c.decodeService(v)
 			if err != nil {
 				continue
 			}
 			svc := *svcPtr
+			svc.Name = c.normalize(svc.Name)
+			c.nodeMetadata(&pod, &svc)
 
-			s, ok := svcs[svc.Name+svc.Version]
-			if !ok {
-				svcs[svc.Name+svc.Version] = &svc
-				continue
+			// append to service:version nodes, of each version mapped
+			for _, v := range withVersions(&svc, versionMap(pod.Metadata, k)) {
+				c.mergeService(svcs, owners, v.Name+v.Version, pod.Metadata.Name, v)
 			}
-
-			// append to service:version nodes
-			s.Nodes = append(s.Nodes, svc.Nodes...)
 		}
 	}
+}
 
+func serviceList(svcs map[string]*registry.Service) []*registry.Service {
 	i := 0
 	list := make([]*registry.Service, len(svcs))
 
@@ -312,22 +1195,148 @@ func (c *kregistry) ListServices(opts ...registry.ListOption) ([]*registry.Servi
 		i++
 	}
 
-	return list, nil
+	return list
 }
 
 // Watch returns a kubernetes watcher.
 func (c *kregistry) Watch(opts ...registry.WatchOption) (registry.Watcher, error) {
+	var wo registry.WatchOptions
+	for _, o := range opts {
+		o(&wo)
+	}
+
+	if wo.Context == nil {
+		wo.Context = context.Background()
+	}
+
+	if name := c.normalize(wo.Service); name != wo.Service {
+		wo.Service = name
+		opts = append(opts, registry.WatchService(name))
+	}
+
+	if kubeService, ok := c.serviceSelectors[wo.Service]; ok {
+		return newSelectorWatcher(c, wo.Service, kubeService)
+	}
+
+	if namespaces, _ := wo.Context.Value(namespacesKey{}).([]string); len(namespaces) > 0 {
+		return newNamespacesWatcher(c, namespaces, opts...)
+	}
+
+	if len(c.members) > 0 {
+		return watchEach("cluster", c.members, opts...), nil
+	}
+
+	if c.shared != nil && wo.Context.Value(selfPodKey{}) == nil {
+		return c.shared.subscribe(wo)
+	}
+
 	return newWatcher(c, opts...)
 }
 
+// terminating reports whether a pod is being deleted, its services no
+// longer advertised then, unless kept until it is gone.
+func (c *kregistry) terminating(pod *client.Pod) bool {
+	return !c.keepTerminating && pod.Metadata.DeletionTimestamp != ""
+}
+
+// pendingIP reports whether a pod has no IP yet, its services advertised
+// once it has, unless advertised without.
+func (c *kregistry) pendingIP(pod *client.Pod) bool {
+	return !c.withoutPodIP && (pod.Status == nil || len(pod.Status.PodIP) == 0)
+}
+
+// included reports whether the services of a pod may be found, as it
+// belongs to the mesh, matches the pod filter, if any, and has an IP on the
+// network they are advertised on, if any.
+func (c *kregistry) included(pod *client.Pod) bool {
+	return c.inMesh(pod) && (c.podFilter == nil || c.podFilter(pod)) && c.onNetwork(pod)
+}
+
+// withClient returns a copy of the registry using another client.
+func (c *kregistry) withClient(kc client.Kubernetes) *kregistry {
+	return &kregistry{
+		client:            kc,
+		timeout:           c.timeout,
+		options:           c.options,
+		actions:           c.actions,
+		hooks:             c.hooks,
+		summaryAnnotation: c.summaryAnnotation,
+		configMaps:        c.configMaps,
+		skipCordoned:      c.skipCordoned,
+		labelPrefix:       c.labelPrefix,
+		priorityKey:       c.priorityKey,
+		weightKey:         c.weightKey,
+		secureKey:         c.secureKey,
+		alpnKey:           c.alpnKey,
+		cacheTTL:          c.cacheTTL,
+		versionSelector:   c.versionSelector,
+		mesh:              c.mesh,
+		versionLabel:      c.versionLabel,
+		podFilter:         c.podFilter,
+		getRetry:          c.getRetry,
+		configMapTarget:   c.configMapTarget,
+		network:           c.network,
+		ignoreSelf:        c.ignoreSelf,
+		validate:          c.validate,
+		headlessDomain:    c.headlessDomain,
+		expireServices:    c.expireServices,
+		defaultTTL:        c.defaultTTL,
+		serveFromCache:    c.serveFromCache,
+		payloadLabels:     c.payloadLabels,
+		overwriteCollide:  c.overwriteCollide,
+		dedupNodes:        c.dedupNodes,
+		nodeID:            c.nodeID,
+		portGates:         c.portGates,
+		foldNames:         c.foldNames,
+		serviceSelectors:  c.serviceSelectors,
+		deliveryTimeout:   c.deliveryTimeout,
+		keepTerminating:   c.keepTerminating,
+		withoutPodIP:      c.withoutPodIP,
+		fieldManager:      c.fieldManager,
+		auditSink:         c.auditSink,
+		maxNodes:          c.maxNodes,
+		legacyDecoder:     c.legacyDecoder,
+		registerMerge:     c.registerMerge,
+		clockSkew:         c.clockSkew,
+		readYourWrites:    c.readYourWrites,
+		selectorMapper:    c.selectorMapper,
+		reconnectBackoff:  c.reconnectBackoff,
+		watchBackoff:      c.watchBackoff,
+		logs:              c.logs,
+		gauges:            c.gauges,
+		topology:          c.topology,
+		cluster:           c.cluster,
+		cipher:            c.cipher,
+		cipherErr:         c.cipherErr,
+		instanceID:        c.instanceID,
+		counters:          c.counters,
+	}
+}
+
 func (c *kregistry) String() string {
 	return "kubernetes"
 }
 
+// registryCounters counts what the watchers and lookups of a registry
+// rejected, dropped or found conflicting.
+type registryCounters struct {
+	// service payloads rejected by watchers as invalid.
+	rejected atomic.Uint64
+
+	// service versions found with conflicting metadata by lookups.
+	conflicts atomic.Uint64
+
+	// results dropped by watchers as their buffer overflowed, or as they
+	// weren't received in time.
+	dropped atomic.Uint64
+}
+
 // NewRegistry creates a kubernetes registry.
 func NewRegistry(opts ...registry.Option) registry.Registry {
 	k := &kregistry{
-		options: registry.Options{},
+		options:    registry.Options{},
+		instanceID: newInstanceID(),
+		counters:   &registryCounters{},
 	}
 
 	//nolint:errcheck,gosec
@@ -336,11 +1345,35 @@ func NewRegistry(opts ...registry.Option) registry.Registry {
 	return k
 }
 
+// newInstanceID returns a random identifier for a registry instance.
+func newInstanceID() string {
+	b := make([]byte, 8)
+	//nolint:errcheck
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// getPodName resolves the name of the pod this service runs in, preferring
+// POD_NAME as set through the downward API over HOSTNAME.
 func getPodName() (string, error) {
-	podName := os.Getenv("HOSTNAME")
+	podName := os.Getenv("POD_NAME")
 	if len(podName) == 0 {
-		return "", ErrNoHostname
+		podName = os.Getenv("HOSTNAME")
+	}
+
+	if len(podName) == 0 {
+		return "", ErrSelfPodUnknown
 	}
 
 	return podName, nil
 }
+
+// selfPodErr reports a self pod that does not exist as ErrSelfPodUnknown.
+func selfPodErr(podName string, err error) error {
+	if errors.Is(err, api.ErrNotFound) {
+		return errors.Wrapf(ErrSelfPodUnknown, "pod %s not found", podName)
+	}
+
+	return err
+}
@@ -2,17 +2,29 @@ package kubernetes
 
 import (
 
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"go-micro.dev/v4/logger"
 	"go-micro.dev/v4/registry"
 
+	"github.com/skiprco/go-micro-kubernetes-registry/backoff"
 	"github.com/skiprco/go-micro-kubernetes-registry/client"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/api"
 	"github.com/skiprco/go-micro-kubernetes-registry/client/mock"
 )
 
@@ -70,7 +82,7 @@ func TestRegister(t *testing.T) {
 		// unmarshal service data from annotation and compare
 		// service passed in to .Register()
 		var err error
-		service, err = compactDecode([]byte(*svcData))
+		service, err = compactDecode(*svcData)
 		if err != nil {
 			t.Fatalf("did not expect register unmarshal to fail %v", err)
 		}
@@ -111,7 +123,7 @@ func TestRegisterTwoDifferentServicesOnePod(t *testing.T) {
 
 	// unmarshal service data from annotation and compare
 	// service passed in to .Register()
-	service1, err := compactDecode([]byte(*svcData1))
+	service1, err := compactDecode(*svcData1)
 	if err != nil {
 		t.Fatalf("did not expect register unmarshal to fail %v", err)
 	}
@@ -119,7 +131,7 @@ func TestRegisterTwoDifferentServicesOnePod(t *testing.T) {
 		t.Fatal("services did not match")
 	}
 
-	service2, err := compactDecode([]byte(*svcData2))
+	service2, err := compactDecode(*svcData2)
 	if err != nil {
 		t.Fatalf("did not expect register unmarshal to fail %v", err)
 	}
@@ -174,7 +186,7 @@ func TestRegisterTwoDifferentServicesTwoPods(t *testing.T) {
 	// unmarshal service data from annotation and compare
 	// service passed in to .Register()
 	var err error
-	service1, err := compactDecode([]byte(*svcData1))
+	service1, err := compactDecode(*svcData1)
 	if err != nil {
 		t.Fatalf("did not expect register unmarshal to fail %v", err)
 	}
@@ -182,7 +194,7 @@ func TestRegisterTwoDifferentServicesTwoPods(t *testing.T) {
 		t.Fatal("services did not match")
 	}
 
-	service2, err := compactDecode([]byte(*svcData2))
+	service2, err := compactDecode(*svcData2)
 	if err != nil {
 		t.Fatalf("did not expect register unmarshal to fail %v", err)
 	}
@@ -224,7 +236,7 @@ func TestRegisterSingleVersionedServiceTwoPods(t *testing.T) {
 	// unmarshal service data from annotation and compare
 	// service passed in to .Register()
 	var err error
-	service1, err := compactDecode([]byte(*svcData1))
+	service1, err := compactDecode(*svcData1)
 	if err != nil {
 		t.Fatalf("did not expect register unmarshal to fail %v", err)
 	}
@@ -232,7 +244,7 @@ func TestRegisterSingleVersionedServiceTwoPods(t *testing.T) {
 		t.Fatal("services did not match")
 	}
 
-	service2, err := compactDecode([]byte(*svcData2))
+	service2, err := compactDecode(*svcData2)
 	if err != nil {
 		t.Fatalf("did not expect register unmarshal to fail %v", err)
 	}
@@ -275,6 +287,162 @@ func TestDeregister(t *testing.T) {
 	}
 }
 
+func TestSelfHealGiveUp(t *testing.T) {
+	gaveUp := make(chan string, 1)
+
+	r := setupRegistry(SelfHeal(2, time.Minute), OnGiveUp(func(service string) { gaveUp <- service }))
+	defer teardownRegistry()
+
+	// another registry sees the strips and re-registrations
+	observer, err := NewRegistry(Client(mockClient)).Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer observer.Stop()
+
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+	expectAction(t, observer, svc.Name, "create")
+
+	strip := func() {
+		t.Helper()
+
+		annotations := map[string]*string{annotationServiceKeyPrefix + serviceName(svc.Name): nil}
+		if _, err := mockClient.UpdatePod("pod-1", &client.Pod{Metadata: &client.Meta{Annotations: annotations}}); err != nil {
+			t.Fatalf("did not expect UpdatePod() to fail: %v", err)
+		}
+
+		expectAction(t, observer, svc.Name, "delete")
+	}
+
+	for i := 0; i < 2; i++ {
+		strip()
+		expectAction(t, observer, svc.Name, "create")
+	}
+
+	// the third strip within the window is given up on
+	strip()
+
+	select {
+	case name := <-gaveUp:
+		if name != svc.Name {
+			t.Fatalf("expected to give up on %s, got %s", svc.Name, name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected to give up re-registering")
+	}
+
+	if _, ok := mockClient.Pods["pod-1"].Metadata.Annotations[annotationServiceKeyPrefix+serviceName(svc.Name)]; ok {
+		t.Fatal("expected the service not to be registered again")
+	}
+
+	if err := r.Deregister(svc); err != nil {
+		t.Fatalf("did not expect Deregister() to fail: %v", err)
+	}
+}
+
+func TestSelfHealNotAdvertised(t *testing.T) {
+	gaveUp := make(chan string, 1)
+
+	kc := &patchRecordingClient{Client: mockClient}
+	r := NewRegistry(Client(kc), SelfHeal(1, time.Minute), OnGiveUp(func(service string) { gaveUp <- service }))
+	defer teardownRegistry()
+
+	observer, err := NewRegistry(Client(mockClient)).Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer observer.Stop()
+
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+	expectAction(t, observer, svc.Name, "create")
+
+	kc.patched = nil
+
+	// the pod loses its IP, then terminates, its annotation untouched
+	if err := mockClient.SetPodIP("pod-1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	expectAction(t, observer, svc.Name, "delete")
+
+	if err := mockClient.TerminatePod("pod-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectAction(t, observer, svc.Name, "delete")
+
+	select {
+	case name := <-gaveUp:
+		t.Fatalf("did not expect to give up on %s", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if len(kc.patched) > 0 {
+		t.Fatalf("did not expect the service to be registered again, got %v", kc.patched)
+	}
+
+	// stops healing, and so watching the self pod
+	if err := r.Deregister(svc); err != nil {
+		t.Fatalf("did not expect Deregister() to fail: %v", err)
+	}
+}
+
+func TestRegisterConfigMap(t *testing.T) {
+	r := setupRegistry(RegisterConfigMap("services"))
+	defer teardownRegistry()
+
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+
+	// the config map doesn't exist, the pod is still written
+	t.Setenv("HOSTNAME", "pod-1")
+	setupPod("pod-1")
+
+	svc.Nodes = []*registry.Node{{Id: "foo-1", Address: "10.0.0.1:80"}}
+
+	err := r.Register(svc)
+
+	var targetErr *TargetError
+	if !errors.As(err, &targetErr) {
+		t.Fatalf("expected a TargetError, got %v", err)
+	}
+
+	if !reflect.DeepEqual(targetErr.Succeeded, []string{"pod/pod-1"}) || !errors.Is(targetErr.Failed["configmap/services"], api.ErrNotFound) {
+		t.Fatalf("expected the pod written but not the config map, got %v", targetErr)
+	}
+
+	if !errors.Is(err, api.ErrNotFound) {
+		t.Fatalf("expected the error to unwrap to the config map error, got %v", err)
+	}
+
+	if _, ok := mockClient.Pods["pod-1"].Metadata.Annotations[annotationServiceKeyPrefix+"foo.service"]; !ok {
+		t.Fatal("expected the service registered on the pod")
+	}
+
+	// both written once the config map exists
+	if err := mockClient.SetConfigMap(&client.ConfigMap{Metadata: &client.Meta{Name: "services"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Register(svc); err != nil {
+		t.Fatalf("did not expect Register() to fail: %v", err)
+	}
+
+	key := configMapServiceKeyPrefix + "foo.service.pod-1"
+	if _, ok := mockClient.ConfigMaps["services"].Data[key]; !ok {
+		t.Fatalf("expected the service written to the config map, got %v", mockClient.ConfigMaps["services"].Data)
+	}
+
+	if err := r.Deregister(svc); err != nil {
+		t.Fatalf("did not expect Deregister() to fail: %v", err)
+	}
+
+	if _, ok := mockClient.ConfigMaps["services"].Data[key]; ok {
+		t.Fatal("expected the service removed from the config map")
+	}
+}
+
 func TestGetService(t *testing.T) {
 	r := setupRegistry()
 	defer teardownRegistry()
@@ -319,6 +487,105 @@ func TestGetServiceSameServiceTwoPods(t *testing.T) {
 	}
 }
 
+func TestGetServiceConflictingMetadata(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	// registered in reverse order, the first pod by name still wins
+	svc2 := &registry.Service{Name: "foo.service", Version: "1", Metadata: map[string]string{"protocol": "http"}}
+	svc1 := &registry.Service{Name: "foo.service", Version: "1", Metadata: map[string]string{"protocol": "grpc"}}
+	register(t, r, "pod-2", svc2)
+	register(t, r, "pod-1", svc1)
+
+	service, err := r.GetService("foo.service")
+	if err != nil {
+		t.Fatalf("did not expect GetService to fail %v", err)
+	}
+
+	if len(service) != 1 || len(service[0].Nodes) != 2 {
+		t.Fatalf("expected a single service with 2 nodes, got %v", service)
+	}
+
+	if service[0].Metadata["protocol"] != "grpc" {
+		t.Fatalf("expected the metadata of pod-1 to win, got %v", service[0].Metadata)
+	}
+
+	if n := r.(ConflictCounter).ConflictingRegistrations(); n != 1 {
+		t.Fatalf("expected 1 conflict, got %d", n)
+	}
+}
+
+func TestGetServiceDuplicateNodes(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	// pod-2 misconfigured to advertise the address of pod-1
+	svc1 := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc1)
+
+	t.Setenv("HOSTNAME", "pod-2")
+	setupPod("pod-2")
+
+	svc2 := &registry.Service{Name: "foo.service", Version: "1", Nodes: []*registry.Node{{Id: "foo.service:pod-0", Address: svc1.Nodes[0].Address}}}
+	if err := r.Register(svc2); err != nil {
+		t.Fatalf("did not expect Register() to fail: %v", err)
+	}
+
+	for _, lookup := range []func() ([]*registry.Service, error){
+		func() ([]*registry.Service, error) { return r.GetService("foo.service") },
+		func() ([]*registry.Service, error) { return r.ListServices() },
+	} {
+		services, err := lookup()
+		if err != nil || len(services) != 1 {
+			t.Fatalf("expected a single service, got %+v, %v", services, err)
+		}
+
+		// the node whose id sorts first is kept
+		if len(services[0].Nodes) != 1 || services[0].Nodes[0].Id != "foo.service:pod-0" {
+			t.Fatalf("expected the node of pod-2 only, got %+v", services[0].Nodes)
+		}
+	}
+
+	// unless keeping duplicates
+	if err := r.Init(Client(mockClient), DedupNodes(false)); err != nil {
+		t.Fatalf("did not expect Init() to fail: %v", err)
+	}
+
+	services, err := r.GetService("foo.service")
+	if err != nil || len(services) != 1 || len(services[0].Nodes) != 2 {
+		t.Fatalf("expected both nodes, got %+v, %v", services, err)
+	}
+}
+
+func TestNodeIDs(t *testing.T) {
+	r := setupRegistry(NodeIDs(PodNodeID))
+	defer teardownRegistry()
+
+	t.Setenv("HOSTNAME", "pod-1")
+	setupPod("pod-1")
+
+	// as a server restarting with a new random node id
+	for _, id := range []string{"foo.service-0b6e", "foo.service-7f3a"} {
+		svc := &registry.Service{Name: "foo.service", Version: "1", Nodes: []*registry.Node{{Id: id, Address: "10.0.0.1:80"}}}
+		if err := r.Register(svc); err != nil {
+			t.Fatalf("did not expect Register() to fail: %v", err)
+		}
+
+		if svc.Nodes[0].Id != id {
+			t.Fatalf("expected the registered service to be left untouched, got node id %s", svc.Nodes[0].Id)
+		}
+
+		services, err := r.GetService("foo.service")
+		if err != nil || len(services) != 1 || len(services[0].Nodes) != 1 {
+			t.Fatalf("expected a single node, got %+v, %v", services, err)
+		}
+
+		if services[0].Nodes[0].Id != "foo.service-pod-1" {
+			t.Fatalf("expected the node id derived from the pod, got %s", services[0].Nodes[0].Id)
+		}
+	}
+}
+
 func TestGetServiceTwoVersionsTwoPods(t *testing.T) {
 	r := setupRegistry()
 	defer teardownRegistry()
@@ -575,11 +842,8 @@ func teardownRegistry() {
 	mock.Teardown(mockClient)
 }
 
-func setupRegistry(_ ...registry.Option) registry.Registry {
-	return &kregistry{
-		client:  mockClient,
-		timeout: time.Second * 1,
-	}
+func setupRegistry(opts ...registry.Option) registry.Registry {
+	return NewRegistry(append([]registry.Option{Client(mockClient)}, opts...)...)
 }
 
 func validateSrv(t *testing.T, service, s *registry.Service) {
@@ -612,3 +876,1560 @@ func validateSrv(t *testing.T, service, s *registry.Service) {
 		t.Fatalf("Expected node address %s got %s", service.Nodes[0].Address, node.Address)
 	}
 }
+
+func TestSummaryAnnotation(t *testing.T) {
+	r := setupRegistry(SummaryAnnotation(true))
+	defer teardownRegistry()
+
+	svc1 := &registry.Service{Name: "foo.service", Version: "1"}
+	svc2 := &registry.Service{Name: "bar.service", Version: "1"}
+
+	register(t, r, "pod-1", svc1)
+	expectSummary(t, "pod-1", "foo.service")
+
+	register(t, r, "pod-1", svc2)
+	expectSummary(t, "pod-1", "bar.service,foo.service")
+
+	deregister(t, r, "pod-1", svc1)
+	expectSummary(t, "pod-1", "bar.service")
+
+	deregister(t, r, "pod-1", svc2)
+
+	if _, ok := mockClient.Pods["pod-1"].Metadata.Annotations[annotationServicesKey]; ok {
+		t.Fatal("expected summary annotation to be removed with the last service")
+	}
+}
+
+// slowGetClient delays reading pods, widening the window in which
+// concurrent updates of a pod could interleave.
+type slowGetClient struct {
+	*mock.Client
+}
+
+func (c slowGetClient) GetPod(podName string) (*client.Pod, error) {
+	pod, err := c.Client.GetPod(podName)
+
+	time.Sleep(10 * time.Millisecond)
+
+	return pod, err
+}
+
+// rolloutClient lists no pods until its first lists are done, as during
+// the gap of a rolling update.
+type rolloutClient struct {
+	*mock.Client
+	gap   int32
+	lists atomic.Int32
+}
+
+func (c *rolloutClient) ListPods(labels map[string]string) (*client.PodList, error) {
+	if c.lists.Add(1) <= c.gap {
+		return &client.PodList{}, nil
+	}
+
+	return c.Client.ListPods(labels)
+}
+
+func TestGetServiceRetry(t *testing.T) {
+	kc := &rolloutClient{Client: mockClient, gap: 1}
+
+	r := NewRegistry(Client(kc), GetRetry(3, 10*time.Millisecond))
+	defer teardownRegistry()
+
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+
+	// the pod appears on the second attempt
+	services, err := r.GetService(svc.Name)
+	if err != nil {
+		t.Fatalf("did not expect GetService() to fail: %v", err)
+	}
+
+	if !hasServices(services, []*registry.Service{svc}) || kc.lists.Load() != 2 {
+		t.Fatalf("expected the service found on the second attempt, got %v after %d lists", services, kc.lists.Load())
+	}
+
+	// never found within the attempts
+	kc.lists.Store(0)
+	kc.gap = 5
+
+	if _, err := r.GetService(svc.Name); !errors.Is(err, registry.ErrNotFound) || kc.lists.Load() != 3 {
+		t.Fatalf("expected ErrNotFound after 3 attempts, got %v after %d lists", err, kc.lists.Load())
+	}
+}
+
+func TestGetServiceRetryBackoff(t *testing.T) {
+	kc := &rolloutClient{Client: mockClient, gap: 5}
+
+	var attempts []int
+
+	r := NewRegistry(Client(kc), GetRetryBackoff(3, backoff.Func(func(attempt int) time.Duration {
+		attempts = append(attempts, attempt)
+		return time.Millisecond
+	})))
+	defer teardownRegistry()
+
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+
+	if _, err := r.GetService("foo.service"); !errors.Is(err, registry.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// asked before each retry
+	if !reflect.DeepEqual(attempts, []int{1, 2}) {
+		t.Fatalf("expected the backoff asked for attempts 1 and 2, got %v", attempts)
+	}
+}
+
+func TestSummaryAnnotationConcurrentRegister(t *testing.T) {
+	r := NewRegistry(Client(slowGetClient{mockClient}), SummaryAnnotation(true))
+	defer teardownRegistry()
+
+	t.Setenv("HOSTNAME", "pod-1")
+	pod := setupPod("pod-1")
+
+	names := []string{"a.service", "b.service", "c.service", "d.service", "e.service"}
+	errs := make(chan error, len(names))
+
+	var wg sync.WaitGroup
+
+	for _, name := range names {
+		svc := &registry.Service{
+			Name:    name,
+			Version: "1",
+			Nodes:   []*registry.Node{{Id: name + ":pod-1", Address: pod.Status.PodIP + ":80"}},
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			errs <- r.Register(svc)
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("did not expect Register() to fail: %v", err)
+		}
+	}
+
+	// no registration may be lost from the summary
+	expectSummary(t, "pod-1", strings.Join(names, ","))
+}
+
+// expectSummary checks the summary annotation on a pod matches both the
+// expected value and the service notation annotations present.
+func expectSummary(t *testing.T, podName, expected string) {
+	t.Helper()
+
+	ann := mockClient.Pods[podName].Metadata.Annotations
+
+	summary, ok := ann[annotationServicesKey]
+	if !ok || *summary != expected {
+		t.Fatalf("expected summary annotation %q, got %v", expected, summary)
+	}
+
+	for _, name := range strings.Split(expected, ",") {
+		if _, ok := ann[annotationServiceKeyPrefix+serviceName(name)]; !ok {
+			t.Fatalf("summary lists %s but it has no service annotation", name)
+		}
+	}
+
+	notations := 0
+
+	for k := range ann {
+		if strings.HasPrefix(k, annotationServiceKeyPrefix) {
+			notations++
+		}
+	}
+
+	if notations != len(strings.Split(expected, ",")) {
+		t.Fatalf("expected %d service annotations, got %d", len(strings.Split(expected, ",")), notations)
+	}
+}
+
+func TestRegisterSelfPodUnknown(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	svc := &registry.Service{
+		Name:  "foo.service",
+		Nodes: []*registry.Node{{Id: "foo.service:1", Address: "10.0.0.1:80"}},
+	}
+
+	t.Setenv("POD_NAME", "")
+	t.Setenv("HOSTNAME", "")
+
+	if err := r.Register(svc); !errors.Is(err, ErrSelfPodUnknown) {
+		t.Fatalf("expected ErrSelfPodUnknown without pod name, got %v", err)
+	}
+
+	// a pod name that does not match any pod is equally unknown
+	t.Setenv("POD_NAME", "missing-pod")
+
+	if err := r.Register(svc); !errors.Is(err, ErrSelfPodUnknown) {
+		t.Fatalf("expected ErrSelfPodUnknown for missing pod, got %v", err)
+	}
+}
+
+func TestRegisterOddServiceNames(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	// name part of a qualified name, as validated by the API server
+	qualifiedName := regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+
+	names := []string{
+		"foo/bar",
+		"foo bar ",
+		"sérvice.ünïcødé",
+		"com.example." + strings.Repeat("very.long.", 10) + "service",
+	}
+
+	for i, name := range names {
+		podName := "pod-" + strconv.Itoa(i)
+		svc := &registry.Service{Name: name, Version: "1"}
+		register(t, r, podName, svc)
+
+		pod := mockClient.Pods[podName]
+
+		for key := range pod.Metadata.Labels {
+			if strings.HasPrefix(key, svcSelectorPrefix) {
+				if part := strings.TrimPrefix(key, "micro.mu/"); len(part) > 63 || !qualifiedName.MatchString(part) {
+					t.Fatalf("invalid label key %q for service %q", key, name)
+				}
+			}
+		}
+
+		for key := range pod.Metadata.Annotations {
+			if part := strings.TrimPrefix(key, "micro.mu/"); len(part) > 63 || !qualifiedName.MatchString(part) {
+				t.Fatalf("invalid annotation key %q for service %q", key, name)
+			}
+		}
+
+		// the sanitized keys still match the service on lookup
+		services, err := r.GetService(name)
+		if err != nil {
+			t.Fatalf("did not expect GetService(%q) to fail: %v", name, err)
+		}
+
+		if len(services) != 1 || services[0].Name != name {
+			t.Fatalf("expected to get service %q back, got %+v", name, services)
+		}
+	}
+
+	// truncated names stay distinct
+	long1 := strings.Repeat("a", 80) + ".one"
+	long2 := strings.Repeat("a", 80) + ".two"
+
+	if serviceName(long1) == serviceName(long2) {
+		t.Fatal("expected long names with a shared prefix to get distinct keys")
+	}
+}
+
+func TestServiceNameCollision(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	if serviceName("foo/bar") != serviceName("foo:bar") {
+		t.Fatal("expected both names to sanitize to the same key")
+	}
+
+	register(t, r, "pod-1", &registry.Service{Name: "foo/bar", Version: "1"})
+
+	t.Setenv("HOSTNAME", "pod-1")
+
+	other := &registry.Service{Name: "foo:bar", Version: "1", Nodes: []*registry.Node{{Id: "foo:bar-1", Address: "10.0.0.1:80"}}}
+
+	if err := r.Register(other); !errors.Is(err, ErrServiceNameCollision) {
+		t.Fatalf("expected Register() to fail with a collision, got %v", err)
+	}
+
+	if err := r.Deregister(other); !errors.Is(err, ErrServiceNameCollision) {
+		t.Fatalf("expected Deregister() to fail with a collision, got %v", err)
+	}
+
+	// the registered service is left as it was
+	services, err := r.GetService("foo/bar")
+	if err != nil || len(services) != 1 || services[0].Name != "foo/bar" {
+		t.Fatalf("expected foo/bar to stay registered, got %+v, %v", services, err)
+	}
+
+	// and isn't taken for the other one
+	if services, err := r.GetService("foo:bar"); !serviceNotFound(services, err) {
+		t.Fatalf("expected foo:bar not to be found, got %+v, %v", services, err)
+	}
+
+	setupPod("pod-2")
+
+	w, err := r.Watch(registry.WatchService("foo:bar"), InitialState(true))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	register(t, r, "pod-2", &registry.Service{Name: "foo:bar", Version: "1"})
+
+	// pod-1 holds foo/bar under the same key, only pod-2 is foo:bar
+	res, err := w.Next()
+	if err != nil || res.Service.Name != "foo:bar" || res.Service.Nodes[0].Id != "foo:bar:pod-2" {
+		t.Fatalf("expected the create of foo:bar on pod-2, got %+v, %v", res, err)
+	}
+
+	// unless overwriting them
+	r = setupRegistry(OverwriteNameCollisions(true))
+
+	t.Setenv("HOSTNAME", "pod-1")
+
+	if err := r.Register(other); err != nil {
+		t.Fatalf("did not expect Register() to fail: %v", err)
+	}
+}
+
+func TestLabelPrefixToMetadata(t *testing.T) {
+	r := setupRegistry(LabelPrefixToMetadata("route.example.com/"))
+	defer teardownRegistry()
+
+	shard, other := "a", "x"
+	pod := setupPod("pod-1")
+	pod.Metadata.Labels["route.example.com/shard"] = &shard
+	pod.Metadata.Labels["example.com/other"] = &other
+
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+
+	services, err := r.GetService(svc.Name)
+	if err != nil {
+		t.Fatalf("did not expect GetService() to fail: %v", err)
+	}
+
+	md := services[0].Nodes[0].Metadata
+	if md["shard"] != "a" {
+		t.Fatalf("expected prefixed label in node metadata, got %v", md)
+	}
+
+	// besides the pod the node is from
+	if _, ok := md["other"]; ok || len(md) != 2 {
+		t.Fatalf("expected only prefixed labels in node metadata, got %v", md)
+	}
+}
+
+func TestVersionLabel(t *testing.T) {
+	r := setupRegistry(VersionLabel("app.kubernetes.io/version"))
+	defer teardownRegistry()
+
+	version := "2.0.0"
+	pod := setupPod("pod-1")
+	pod.Metadata.Labels["app.kubernetes.io/version"] = &version
+	setupPod("pod-2")
+
+	// the label wins over the payload, which may leave it out
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+	register(t, r, "pod-2", &registry.Service{Name: "foo.service", Version: "1"})
+	register(t, r, "pod-1", &registry.Service{Name: "bar.service"})
+
+	services, err := r.GetService("foo.service")
+	if err != nil {
+		t.Fatalf("did not expect GetService() to fail: %v", err)
+	}
+
+	versions := make(map[string]int)
+	for _, svc := range services {
+		versions[svc.Version] = len(svc.Nodes)
+	}
+
+	if !reflect.DeepEqual(versions, map[string]int{"2.0.0": 1, "1": 1}) {
+		t.Fatalf("expected the labelled pod to run version 2.0.0, got %v", versions)
+	}
+
+	list, err := r.ListServices()
+	if err != nil {
+		t.Fatalf("did not expect ListServices() to fail: %v", err)
+	}
+
+	for _, svc := range list {
+		if svc.Name == "bar.service" && svc.Version != "2.0.0" {
+			t.Fatalf("expected the label to fill in the version, got %q", svc.Version)
+		}
+	}
+}
+
+func TestEncryptPayloads(t *testing.T) {
+	k1, k2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 16)
+
+	r := setupRegistry(EncryptPayloads("k1", map[string][]byte{"k1": k1}))
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	svc := &registry.Service{Name: "foo.service", Version: "1", Metadata: map[string]string{"route": "internal"}}
+	register(t, r, "pod-1", svc)
+
+	stored := *mockClient.Pods["pod-1"].Metadata.Annotations[annotationServiceKeyPrefix+"foo.service"]
+	if !strings.HasPrefix(stored, "enc:v1:k1:") || strings.Contains(stored, "internal") {
+		t.Fatalf("expected the payload encrypted with k1, got %q", stored)
+	}
+
+	res, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Action != "create" || res.Service.Metadata["route"] != "internal" {
+		t.Fatalf("expected the watcher to decrypt the payload, got %s of %+v", res.Action, res.Service)
+	}
+
+	// plaintext payloads are still read
+	register(t, setupRegistry(), "pod-2", &registry.Service{Name: "foo.service", Version: "1"})
+
+	// after rotating to k2, payloads encrypted with k1 are still read
+	rotated := setupRegistry(EncryptPayloads("k2", map[string][]byte{"k1": k1, "k2": k2}))
+
+	services, err := rotated.GetService(svc.Name)
+	if err != nil {
+		t.Fatalf("did not expect GetService() to fail: %v", err)
+	}
+
+	if len(services) != 1 || len(services[0].Nodes) != 2 || services[0].Metadata["route"] != "internal" {
+		t.Fatalf("expected both pods read with the decrypted metadata, got %+v", services)
+	}
+
+	// invalid keys never write plaintext
+	invalid := setupRegistry(EncryptPayloads("k3", map[string][]byte{"k3": []byte("short")}))
+	if err := invalid.Register(svc); err == nil {
+		t.Fatal("expected Register() to fail with an invalid key")
+	}
+}
+
+func TestPodNetwork(t *testing.T) {
+	r := setupRegistry(PodNetwork("macvlan"))
+	defer teardownRegistry()
+
+	status := `[
+		{"name": "cbr0", "interface": "eth0", "ips": ["10.244.0.7"], "default": true},
+		{"name": "default/macvlan", "interface": "net1", "ips": ["192.168.1.5", "fd00::5"]}
+	]`
+	pod := setupPod("pod-1")
+	pod.Metadata.Annotations["k8s.v1.cni.cncf.io/network-status"] = &status
+
+	// not attached to the network
+	setupPod("pod-2")
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+	register(t, r, "pod-2", &registry.Service{Name: "foo.service", Version: "1"})
+
+	services, err := r.GetService("foo.service")
+	if err != nil {
+		t.Fatalf("did not expect GetService() to fail: %v", err)
+	}
+
+	if len(services) != 1 || len(services[0].Nodes) != 1 || services[0].Nodes[0].Address != "192.168.1.5:80" {
+		t.Fatalf("expected a single node on the secondary network, got %+v", services)
+	}
+
+	res, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Action != "create" || res.Service.Nodes[0].Address != "192.168.1.5:80" {
+		t.Fatalf("expected the node created on the secondary network, got %s of %+v", res.Action, res.Service.Nodes[0])
+	}
+}
+
+func TestNodeSecureALPN(t *testing.T) {
+	r := setupRegistry(NodeSecure("example.com/tls"), NodeALPN("example.com/alpn"))
+	defer teardownRegistry()
+
+	secure, alpn := "true", "h2"
+	pod := setupPod("pod-1")
+	pod.Metadata.Labels["example.com/tls"] = &secure
+	pod.Metadata.Annotations["example.com/alpn"] = &alpn
+	setupPod("pod-2")
+
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+
+	other := &registry.Service{Name: "bar.service", Version: "1"}
+	register(t, r, "pod-2", other)
+
+	tests := []struct {
+		service string
+		secure  string
+		alpn    string
+	}{
+		{service: "foo.service", secure: "true", alpn: "h2"},
+		{service: "bar.service", secure: "false"},
+	}
+
+	for _, test := range tests {
+		services, err := r.GetService(test.service)
+		if err != nil {
+			t.Fatalf("did not expect GetService() to fail: %v", err)
+		}
+
+		md := services[0].Nodes[0].Metadata
+		if md["secure"] != test.secure || md["alpn"] != test.alpn {
+			t.Fatalf("%s: expected secure %q and alpn %q, got %v", test.service, test.secure, test.alpn, md)
+		}
+	}
+}
+
+func TestNodeTopology(t *testing.T) {
+	r := setupRegistry(NodeTopology(true))
+	defer teardownRegistry()
+
+	zoneA, zoneB, region := "eu-west-1a", "eu-west-1b", "eu-west-1"
+
+	for name, zone := range map[string]*string{"node-1": &zoneA, "node-2": &zoneB} {
+		mockClient.Nodes[name] = &client.Node{
+			Metadata: &client.Meta{
+				Name:   name,
+				Labels: map[string]*string{labelZone: zone, labelRegion: &region},
+			},
+		}
+	}
+
+	for pod, node := range map[string]string{"pod-1": "node-1", "pod-2": "node-2", "pod-3": "node-3"} {
+		setupPod(pod).Spec = &client.PodSpec{NodeName: node}
+		register(t, r, pod, &registry.Service{Name: "svc-" + pod, Version: "1"})
+	}
+
+	tests := []struct {
+		service string
+		zone    string
+		region  string
+	}{
+		{service: "svc-pod-1", zone: zoneA, region: region},
+		{service: "svc-pod-2", zone: zoneB, region: region},
+		{service: "svc-pod-3"},
+	}
+
+	for _, test := range tests {
+		services, err := r.GetService(test.service)
+		if err != nil {
+			t.Fatalf("did not expect GetService() to fail: %v", err)
+		}
+
+		md := services[0].Nodes[0].Metadata
+		if md["zone"] != test.zone || md["region"] != test.region {
+			t.Fatalf("%s: expected zone %q and region %q, got %v", test.service, test.zone, test.region, md)
+		}
+	}
+}
+
+func TestPayloadLabels(t *testing.T) {
+	r := setupRegistry(PayloadLabels(true))
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	// selected by label, too large for one so stored in annotations
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+	expectAction(t, w, "foo.service", "create")
+
+	pod := mockClient.Pods["pod-1"]
+	if pod.Metadata.Labels[svcSelectorPrefix+"foo.service"] == nil || pod.Metadata.Annotations[annotationServiceKeyPrefix+"foo.service"] == nil {
+		t.Fatalf("expected foo.service selected by label and stored in annotations, got %v", pod.Metadata)
+	}
+
+	if _, ok := pod.Metadata.Labels[labelPayloadPrefix+"foo.service"]; ok {
+		t.Fatal("did not expect foo.service to be stored in a label")
+	}
+
+	if services, err := r.GetService("foo.service"); err != nil || len(services) != 1 {
+		t.Fatalf("expected foo.service, got %v: %v", services, err)
+	}
+
+	// small enough for a label
+	payload, ok := labelPayload(`{"name":"a","nodes":[{"address":"x:1"}]}`)
+	if !ok {
+		t.Fatal("expected a minimal payload to fit a label")
+	}
+
+	selector := svcSelectorValue
+	small := setupPod("pod-2")
+	small.Metadata.Labels[labelTypeKey] = &labelTypeValueService
+	small.Metadata.Labels[svcSelectorPrefix+"a"] = &selector
+	small.Metadata.Labels[labelPayloadPrefix+"a"] = &payload
+
+	services, err := r.GetService("a")
+	if err != nil || len(services) != 1 || services[0].Nodes[0].Address != "x:1" {
+		t.Fatalf("expected service a stored in a label, got %v: %v", services, err)
+	}
+
+	// deregistering clears the annotations and the label
+	deregister(t, r, "pod-1", svc)
+
+	if _, ok := pod.Metadata.Annotations[annotationServiceKeyPrefix+"foo.service"]; ok {
+		t.Fatal("expected foo.service to be deregistered")
+	}
+}
+
+func TestListServicesPaged(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	for _, p := range []string{"pod-1", "pod-2", "pod-3"} {
+		register(t, r, p, &registry.Service{Name: "svc-" + p, Version: "1"})
+	}
+
+	pager, ok := r.(ServicePager)
+	if !ok {
+		t.Fatal("expected the registry to implement ServicePager")
+	}
+
+	page, next, err := pager.ListServicesPaged(context.Background(), 2, "")
+	if err != nil {
+		t.Fatalf("did not expect ListServicesPaged() to fail: %v", err)
+	}
+
+	if len(page) != 2 || len(next) == 0 {
+		t.Fatalf("expected a first page of 2 services and a next token, got %d and %q", len(page), next)
+	}
+
+	page, next, err = pager.ListServicesPaged(context.Background(), 2, next)
+	if err != nil {
+		t.Fatalf("did not expect ListServicesPaged() to fail: %v", err)
+	}
+
+	if len(page) != 1 || page[0].Name != "svc-pod-3" || len(next) != 0 {
+		t.Fatalf("expected a last page holding svc-pod-3, got %+v and %q", page, next)
+	}
+
+	// ListServices pages through everything
+	services, err := r.ListServices()
+	if err != nil || len(services) != 3 {
+		t.Fatalf("expected 3 services, got %d: %v", len(services), err)
+	}
+}
+
+// pageCountingClient counts the pages of pods listed.
+type pageCountingClient struct {
+	*mock.Client
+	pages int
+}
+
+func (c *pageCountingClient) ListPodsPage(labels map[string]string, limit int, continueToken string) (*client.PodList, error) {
+	c.pages++
+
+	return c.Client.ListPodsPage(labels, limit, continueToken)
+}
+
+func TestRangeServices(t *testing.T) {
+	defer func(size int) { listPageSize = size }(listPageSize)
+	listPageSize = 1
+
+	kc := &pageCountingClient{Client: mockClient}
+	r := NewRegistry(Client(kc))
+	defer teardownRegistry()
+
+	for _, p := range []string{"pod-1", "pod-2", "pod-3"} {
+		register(t, r, p, &registry.Service{Name: "svc-" + p, Version: "1"})
+	}
+
+	ranger, ok := r.(ServiceRanger)
+	if !ok {
+		t.Fatal("expected the registry to implement ServiceRanger")
+	}
+
+	var names []string
+
+	err := ranger.RangeServices(context.Background(), func(svc *registry.Service) bool {
+		names = append(names, svc.Name)
+		return true
+	})
+	if err != nil || len(names) != 3 || kc.pages != 3 {
+		t.Fatalf("expected 3 services over 3 pages, got %v over %d: %v", names, kc.pages, err)
+	}
+
+	// stopping early stops paging
+	kc.pages = 0
+
+	err = ranger.RangeServices(context.Background(), func(*registry.Service) bool { return false })
+	if err != nil || kc.pages != 1 {
+		t.Fatalf("expected a single page listed, got %d: %v", kc.pages, err)
+	}
+
+	// so does cancelling
+	kc.pages = 0
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err = ranger.RangeServices(ctx, func(*registry.Service) bool {
+		cancel()
+		return true
+	})
+	if !errors.Is(err, context.Canceled) || kc.pages != 1 {
+		t.Fatalf("expected cancelling to stop after a page, got %d: %v", kc.pages, err)
+	}
+}
+
+func TestRegistryWithoutTimeout(t *testing.T) {
+	// listing takes longer than the default timeout of the registry
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(1500 * time.Millisecond)
+		fmt.Fprint(w, `{"items":[]}`)
+	}))
+	defer ts.Close()
+
+	r := NewRegistry(registry.Addrs(ts.URL))
+
+	if _, err := r.ListServices(); err != nil {
+		t.Fatalf("did not expect ListServices() to be bounded without a timeout: %v", err)
+	}
+}
+
+func TestRegistryTimeout(t *testing.T) {
+	release := make(chan struct{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		select {
+		case <-release:
+		case <-time.After(5 * time.Second):
+		}
+
+		fmt.Fprint(w, `{"items":[]}`)
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	r := NewRegistry(registry.Addrs(ts.URL), registry.Timeout(50*time.Millisecond))
+
+	t.Setenv("HOSTNAME", "pod-1")
+
+	calls := map[string]func() error{
+		"ListServices": func() error { _, err := r.ListServices(); return err },
+		"GetService":   func() error { _, err := r.GetService("foo.service"); return err },
+		"Register": func() error {
+			return r.Register(&registry.Service{Name: "foo.service", Nodes: []*registry.Node{{Id: "foo-1"}}})
+		},
+		"Deregister": func() error {
+			return r.Deregister(&registry.Service{Name: "foo.service", Nodes: []*registry.Node{{Id: "foo-1"}}})
+		},
+		"Watch": func() error { _, err := r.Watch(); return err },
+	}
+
+	for name, call := range calls {
+		start := time.Now()
+
+		if err := call(); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected %s to exceed its deadline, got %v", name, err)
+		}
+
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("expected %s to fail in time, took %v", name, elapsed)
+		}
+	}
+}
+
+func TestVersionSelector(t *testing.T) {
+	r := setupRegistry(VersionSelector(true))
+	defer teardownRegistry()
+
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1.0.1"})
+	register(t, r, "pod-2", &registry.Service{Name: "foo.service", Version: "1.0.2"})
+
+	// a version is selected by label alone
+	pods, err := mockClient.ListPods(map[string]string{svcSelectorPrefix + "foo.service": "1.0.2"})
+	if err != nil {
+		t.Fatalf("did not expect ListPods() to fail: %v", err)
+	}
+
+	if len(pods.Items) != 1 || pods.Items[0].Metadata.Name != "pod-2" {
+		t.Fatalf("expected only pod-2 to be selected, got %d pods", len(pods.Items))
+	}
+
+	services, err := r.GetService("foo.service")
+	if err != nil {
+		t.Fatalf("did not expect GetService() to fail: %v", err)
+	}
+
+	if len(services) != 2 {
+		t.Fatalf("expected both versions, got %d", len(services))
+	}
+}
+
+func TestWaitForService(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	// the mock client pods can't be added while watched
+	setupPod("pod-2")
+	setupPod("pod-3")
+
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- r.(ServiceWaiter).WaitForService(context.Background(), "foo.service", 3)
+	}()
+
+	// nodes of other services or versions appearing meanwhile count by service
+	register(t, r, "pod-2", &registry.Service{Name: "bar.service", Version: "1"})
+	register(t, r, "pod-2", &registry.Service{Name: "foo.service", Version: "2"})
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected WaitForService() to block with 2 nodes, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	register(t, r, "pod-3", &registry.Service{Name: "foo.service", Version: "1"})
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("did not expect WaitForService() to fail: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected WaitForService() to return with 3 nodes")
+	}
+
+	// already enough nodes
+	if err := r.(ServiceWaiter).WaitForService(context.Background(), "foo.service", 2); err != nil {
+		t.Fatalf("did not expect WaitForService() to fail: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := r.(ServiceWaiter).WaitForService(ctx, "foo.service", 4); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the context error, got %v", err)
+	}
+}
+
+func TestCaseInsensitiveNames(t *testing.T) {
+	r := setupRegistry(CaseInsensitiveNames(true))
+	defer teardownRegistry()
+
+	setupPod("pod-1")
+	setupPod("pod-2")
+
+	w, err := r.Watch(registry.WatchService("ORDERS"))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	register(t, r, "pod-1", &registry.Service{Name: "Orders", Version: "1"})
+	expectAction(t, w, "orders", "create")
+
+	register(t, r, "pod-2", &registry.Service{Name: "orders", Version: "1"})
+	expectAction(t, w, "orders", "create")
+
+	if _, ok := mockClient.Pods["pod-1"].Metadata.Labels[svcSelectorPrefix+"orders"]; !ok {
+		t.Fatal("expected the selector label of the lowercase name")
+	}
+
+	services, err := r.GetService("oRdErS")
+	if err != nil {
+		t.Fatalf("did not expect GetService() to fail: %v", err)
+	}
+
+	if len(services) != 1 || services[0].Name != "orders" || len(services[0].Nodes) != 2 {
+		t.Fatalf("expected one service orders with 2 nodes, got %+v", services)
+	}
+
+	list, err := r.ListServices()
+	if err != nil {
+		t.Fatalf("did not expect ListServices() to fail: %v", err)
+	}
+
+	if len(list) != 1 || list[0].Name != "orders" {
+		t.Fatalf("expected orders listed once, got %+v", list)
+	}
+
+	deregister(t, r, "pod-1", &registry.Service{Name: "ORDERS", Version: "1", Nodes: []*registry.Node{{Id: "orders:pod-1"}}})
+	expectAction(t, w, "orders", "delete")
+
+	if _, ok := mockClient.Pods["pod-1"].Metadata.Labels[svcSelectorPrefix+"orders"]; ok {
+		t.Fatal("expected the selector label removed")
+	}
+}
+
+func TestGetServicesByPrefix(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	register(t, r, "pod-1", &registry.Service{Name: "com.acme.orders", Version: "1"})
+	register(t, r, "pod-1", &registry.Service{Name: "com.acme.orders.read", Version: "1"})
+	register(t, r, "pod-2", &registry.Service{Name: "com.acme.orders.write", Version: "1"})
+	register(t, r, "pod-2", &registry.Service{Name: "com.acme.ordersv2", Version: "1"})
+	register(t, r, "pod-3", &registry.Service{Name: "com.acme.payments", Version: "1"})
+
+	for _, tc := range []struct {
+		prefix   string
+		expected []string
+	}{
+		{"com.acme.orders", []string{"com.acme.orders", "com.acme.orders.read", "com.acme.orders.write"}},
+		{"com.acme.orders.", []string{"com.acme.orders", "com.acme.orders.read", "com.acme.orders.write"}},
+		{"com.acme.orders.read", []string{"com.acme.orders.read"}},
+		{"com.acme.order", nil},
+		{"", []string{"com.acme.orders", "com.acme.orders.read", "com.acme.orders.write", "com.acme.ordersv2", "com.acme.payments"}},
+	} {
+		services, err := r.(PrefixGetter).GetServicesByPrefix(tc.prefix)
+		if err != nil {
+			t.Fatalf("did not expect GetServicesByPrefix(%q) to fail: %v", tc.prefix, err)
+		}
+
+		var names []string
+		for _, svc := range services {
+			names = append(names, svc.Name)
+		}
+
+		if !reflect.DeepEqual(names, tc.expected) {
+			t.Fatalf("expected %v under %q, got %v", tc.expected, tc.prefix, names)
+		}
+	}
+}
+
+func TestPodForNode(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+
+	services, err := r.GetService(svc.Name)
+	if err != nil {
+		t.Fatalf("did not expect GetService() to fail: %v", err)
+	}
+
+	node := services[0].Nodes[0]
+	if name := node.Metadata[MetadataPodName]; name != "pod-1" {
+		t.Fatalf("expected the node tagged with pod-1, got %q", name)
+	}
+
+	pod, err := r.(PodResolver).PodForNode(node)
+	if err != nil {
+		t.Fatalf("did not expect PodForNode() to fail: %v", err)
+	}
+
+	if pod.Metadata.Name != "pod-1" || pod.Status.PodIP != mockClient.Pods["pod-1"].Status.PodIP {
+		t.Fatalf("expected pod-1 back, got %s at %s", pod.Metadata.Name, pod.Status.PodIP)
+	}
+
+	if _, err := r.(PodResolver).PodForNode(&registry.Node{Id: "foo"}); !errors.Is(err, ErrNodeWithoutPod) {
+		t.Fatalf("expected ErrNodeWithoutPod for a node not discovered, got %v", err)
+	}
+}
+
+// applyRecordingClient records the pods applied, and counts patches.
+type applyRecordingClient struct {
+	*mock.Client
+	patches  int
+	applied  []*client.Pod
+	managers []string
+}
+
+func (c *applyRecordingClient) UpdatePod(podName string, pod *client.Pod) (*client.Pod, error) {
+	c.patches++
+
+	return c.Client.UpdatePod(podName, pod)
+}
+
+func (c *applyRecordingClient) ApplyPod(podName string, pod *client.Pod, fieldManager string) (*client.Pod, error) {
+	c.applied = append(c.applied, pod)
+	c.managers = append(c.managers, fieldManager)
+
+	return c.Client.ApplyPod(podName, pod, fieldManager)
+}
+
+func TestServerSideApply(t *testing.T) {
+	kc := &applyRecordingClient{Client: mockClient}
+	r := NewRegistry(Client(kc), ServerSideApply("go-micro"))
+	defer teardownRegistry()
+
+	app := "orders"
+	setupPod("pod-1").Metadata.Labels["app"] = &app
+
+	foo := &registry.Service{Name: "foo.service", Version: "1"}
+	bar := &registry.Service{Name: "bar.service", Version: "1"}
+
+	register(t, r, "pod-1", foo)
+	register(t, r, "pod-1", bar)
+
+	if kc.patches > 0 {
+		t.Fatalf("expected the pod to be applied rather than patched, got %d patches", kc.patches)
+	}
+
+	for _, m := range kc.managers {
+		if m != "go-micro" {
+			t.Fatalf("expected the pod to be applied as go-micro, got %s", m)
+		}
+	}
+
+	// every field of the registry is applied, and only them
+	last := kc.applied[len(kc.applied)-1].Metadata
+	for _, key := range []string{annotationServiceKeyPrefix + "foo.service", annotationServiceKeyPrefix + "bar.service"} {
+		if last.Annotations[key] == nil {
+			t.Fatalf("expected %s to be applied, got %v", key, last.Annotations)
+		}
+	}
+
+	if _, ok := last.Labels["app"]; ok || last.Labels[labelTypeKey] == nil {
+		t.Fatalf("expected the labels of the registry only to be applied, got %v", last.Labels)
+	}
+
+	for _, v := range last.Annotations {
+		if v == nil {
+			t.Fatalf("expected no field to be applied unset, got %v", last.Annotations)
+		}
+	}
+
+	deregister(t, r, "pod-1", bar)
+
+	last = kc.applied[len(kc.applied)-1].Metadata
+	if _, ok := last.Annotations[annotationServiceKeyPrefix+"bar.service"]; ok {
+		t.Fatalf("expected bar.service no longer to be applied, got %v", last.Annotations)
+	}
+
+	if services, err := r.GetService("bar.service"); !serviceNotFound(services, err) {
+		t.Fatalf("expected bar.service to be deregistered, got %v: %v", services, err)
+	}
+
+	if services, err := r.GetService("foo.service"); serviceNotFound(services, err) {
+		t.Fatalf("expected foo.service to stay registered, got %v", err)
+	}
+
+	if p := mockClient.Pods["pod-1"]; p.Metadata.Labels["app"] == nil {
+		t.Fatal("expected the labels of other managers to be kept")
+	}
+}
+
+func TestServerSideApplyUnsupported(t *testing.T) {
+	// a client of the interface only
+	r := NewRegistry(Client(struct{ client.Kubernetes }{mockClient}), ServerSideApply("go-micro"))
+	defer teardownRegistry()
+
+	t.Setenv("HOSTNAME", "pod-1")
+	setupPod("pod-1")
+
+	err := r.Register(&registry.Service{Name: "foo.service", Nodes: []*registry.Node{{Id: "1", Address: "10.0.0.1:80"}}})
+	if !errors.Is(err, ErrApplyUnsupported) {
+		t.Fatalf("expected ErrApplyUnsupported, got %v", err)
+	}
+}
+
+func TestDoubleEncodedPayload(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	defaultLogger := logger.DefaultLogger
+	logs := &recordLogger{Logger: defaultLogger}
+	logger.DefaultLogger = logs
+
+	defer func() { logger.DefaultLogger = defaultLogger }()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	// registering through another registry, so the watcher handles the
+	// change of the pod
+	register(t, setupRegistry(), "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+	expectAction(t, w, "foo.service", "create")
+
+	// the notation stored as a JSON string of itself
+	p := mockClient.Pods["pod-1"]
+	key := annotationServiceKeyPrefix + "foo.service"
+
+	b, err := json.Marshal(*p.Metadata.Annotations[key])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded := strings.Replace(string(b), `\"version\":\"1\"`, `\"version\":\"2\"`, 1)
+	if _, err := mockClient.UpdatePod("pod-1", &client.Pod{Metadata: &client.Meta{Annotations: map[string]*string{key: &encoded}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	res := expectAction(t, w, "foo.service", "update")
+	if res.Service.Version != "2" {
+		t.Fatalf("expected the double-encoded version 2, got %s", res.Service.Version)
+	}
+
+	services, err := r.GetService("foo.service")
+	if err != nil || len(services) != 1 || services[0].Version != "2" {
+		t.Fatalf("expected the double-encoded payload to be found, got %v: %v", services, err)
+	}
+
+	if !strings.Contains(logs.String(), "payload "+key+" of pod pod-1 is double-encoded JSON") {
+		t.Fatalf("expected a warning about the double-encoded payload, got %q", logs.String())
+	}
+}
+
+func TestLegacyFormat(t *testing.T) {
+	// the format of the registry migrated from
+	legacy := func(data string) (*registry.Service, error) {
+		var old struct {
+			Service string `json:"svc"`
+			Version string `json:"ver"`
+			Address string `json:"addr"`
+		}
+
+		if err := json.Unmarshal([]byte(data), &old); err != nil {
+			return nil, err
+		}
+
+		return &registry.Service{
+			Name:    old.Service,
+			Version: old.Version,
+			Nodes:   []*registry.Node{{Id: "legacy-" + old.Service, Address: old.Address}},
+		}, nil
+	}
+
+	r := setupRegistry(LegacyFormat(legacy))
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	register(t, setupRegistry(), "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+	expectAction(t, w, "foo.service", "create")
+
+	// the pod annotated by the registry migrated from
+	key := annotationServiceKeyPrefix + "foo.service"
+	old := `{"svc":"foo.service","ver":"0.9","addr":"10.0.0.9:80"}`
+
+	if _, err := mockClient.UpdatePod("pod-1", &client.Pod{Metadata: &client.Meta{Annotations: map[string]*string{key: &old}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	res := expectAction(t, w, "foo.service", "update")
+	if res.Service.Version != "0.9" {
+		t.Fatalf("expected the legacy version 0.9, got %s", res.Service.Version)
+	}
+
+	services, err := r.GetService("foo.service")
+	if err != nil || len(services) != 1 || services[0].Version != "0.9" {
+		t.Fatalf("expected the legacy service to be found, got %v: %v", services, err)
+	}
+
+	if len(services[0].Nodes) != 1 || services[0].Nodes[0].Address != "10.0.0.9:80" {
+		t.Fatalf("expected the legacy node, got %+v", services[0].Nodes)
+	}
+
+	// without a legacy decoder the payload is unknown
+	if services, err := setupRegistry().GetService("foo.service"); !serviceNotFound(services, err) {
+		t.Fatalf("expected the legacy service not to be found without a decoder, got %v", services)
+	}
+}
+
+func TestRegisterMerge(t *testing.T) {
+	r := setupRegistry(RegisterMerge(true))
+	defer teardownRegistry()
+
+	setupPod("pod-1")
+	t.Setenv("HOSTNAME", "pod-1")
+
+	// two processes of the pod contributing a node each
+	other := setupRegistry(RegisterMerge(true))
+
+	for reg, svc := range map[registry.Registry]*registry.Service{
+		r: {
+			Name:     "foo.service",
+			Version:  "1",
+			Metadata: map[string]string{"a": "1"},
+			Nodes:    []*registry.Node{{Id: "foo-1", Address: "10.0.0.1:80", Metadata: map[string]string{"x": "1"}}},
+		},
+		other: {
+			Name:     "foo.service",
+			Version:  "1",
+			Metadata: map[string]string{"b": "2"},
+			Nodes:    []*registry.Node{{Id: "foo-2", Address: "10.0.0.1:81"}},
+		},
+	} {
+		if err := reg.Register(svc); err != nil {
+			t.Fatalf("did not expect Register() to fail: %v", err)
+		}
+	}
+
+	services, err := r.GetService("foo.service")
+	if err != nil || len(services) != 1 {
+		t.Fatalf("expected foo.service, got %v: %v", services, err)
+	}
+
+	if services[0].Metadata["a"] != "1" || services[0].Metadata["b"] != "2" {
+		t.Fatalf("expected the metadata of both registrations, got %v", services[0].Metadata)
+	}
+
+	nodes := make(map[string]*registry.Node)
+	for _, n := range services[0].Nodes {
+		nodes[n.Id] = n
+	}
+
+	if len(nodes) != 2 || nodes["foo-1"] == nil || nodes["foo-2"] == nil {
+		t.Fatalf("expected the nodes of both registrations, got %+v", services[0].Nodes)
+	}
+
+	// a node registered again is updated, its metadata merged
+	if err := r.Register(&registry.Service{
+		Name:    "foo.service",
+		Version: "1",
+		Nodes:   []*registry.Node{{Id: "foo-1", Address: "10.0.0.1:82", Metadata: map[string]string{"y": "2"}}},
+	}); err != nil {
+		t.Fatalf("did not expect Register() to fail: %v", err)
+	}
+
+	services, err = r.GetService("foo.service")
+	if err != nil || len(services) != 1 || len(services[0].Nodes) != 2 {
+		t.Fatalf("expected the 2 nodes of foo.service, got %v: %v", services, err)
+	}
+
+	for _, n := range services[0].Nodes {
+		if n.Id == "foo-1" && (n.Address != "10.0.0.1:82" || n.Metadata["x"] != "1" || n.Metadata["y"] != "2") {
+			t.Fatalf("expected foo-1 updated with merged metadata, got %+v", n)
+		}
+	}
+
+	// another version replaces it
+	if err := r.Register(&registry.Service{
+		Name:    "foo.service",
+		Version: "2",
+		Nodes:   []*registry.Node{{Id: "foo-3", Address: "10.0.0.1:83"}},
+	}); err != nil {
+		t.Fatalf("did not expect Register() to fail: %v", err)
+	}
+
+	services, err = r.GetService("foo.service")
+	if err != nil || len(services) != 1 || services[0].Version != "2" || len(services[0].Nodes) != 1 {
+		t.Fatalf("expected version 2 to replace version 1, got %v: %v", services, err)
+	}
+}
+
+func TestRegisterOnPod(t *testing.T) {
+	nsA := mock.NewClient()
+	r := NewRegistry(Client(namespacedClient{Client: mockClient, namespaces: map[string]client.Kubernetes{"a": nsA}}))
+	defer teardownRegistry()
+
+	// the operator runs in a pod of its own
+	t.Setenv("HOSTNAME", "operator")
+	setupPod("operator")
+	setupPod("pod-1")
+
+	nsA.Pods["pod-2"] = &client.Pod{
+		Metadata: &client.Meta{Name: "pod-2", Labels: map[string]*string{}, Annotations: map[string]*string{}},
+		Status:   &client.Status{PodIP: "10.0.1.2", Phase: podRunning},
+	}
+
+	foo := &registry.Service{Name: "foo.service", Version: "1", Nodes: []*registry.Node{{Id: "foo-1", Address: "10.0.0.2:80"}}}
+	if err := r.(PodRegistrar).RegisterOnPod("", "pod-1", foo); err != nil {
+		t.Fatalf("did not expect RegisterOnPod() to fail: %v", err)
+	}
+
+	bar := &registry.Service{Name: "bar.service", Version: "1", Nodes: []*registry.Node{{Id: "bar-1", Address: "10.0.1.2:80"}}}
+	if err := r.(PodRegistrar).RegisterOnPod("a", "pod-2", bar); err != nil {
+		t.Fatalf("did not expect RegisterOnPod() to fail: %v", err)
+	}
+
+	if mockClient.Pods["pod-1"].Metadata.Annotations[annotationServiceKeyPrefix+"foo.service"] == nil {
+		t.Fatal("expected foo.service to be registered on pod-1")
+	}
+
+	if nsA.Pods["pod-2"].Metadata.Annotations[annotationServiceKeyPrefix+"bar.service"] == nil {
+		t.Fatal("expected bar.service to be registered on pod-2 of namespace a")
+	}
+
+	if len(mockClient.Pods["operator"].Metadata.Annotations) > 0 {
+		t.Fatalf("expected the self pod untouched, got %v", mockClient.Pods["operator"].Metadata.Annotations)
+	}
+
+	services, err := r.GetService("foo.service")
+	if err != nil || len(services) != 1 || services[0].Nodes[0].Id != "foo-1" {
+		t.Fatalf("expected foo.service to be found on pod-1, got %v: %v", services, err)
+	}
+
+	// the payload checks are the ones of Register
+	if err := r.(PodRegistrar).RegisterOnPod("", "pod-1", &registry.Service{Name: "baz/x", Nodes: foo.Nodes}); err != nil {
+		t.Fatalf("did not expect RegisterOnPod() to fail: %v", err)
+	}
+
+	collide := &registry.Service{Name: "baz:x", Nodes: foo.Nodes}
+	if err := r.(PodRegistrar).RegisterOnPod("", "pod-1", collide); !errors.Is(err, ErrServiceNameCollision) {
+		t.Fatalf("expected ErrServiceNameCollision, got %v", err)
+	}
+
+	if err := r.(PodRegistrar).DeregisterOnPod("", "pod-1", foo); err != nil {
+		t.Fatalf("did not expect DeregisterOnPod() to fail: %v", err)
+	}
+
+	if services, err := r.GetService("foo.service"); !serviceNotFound(services, err) {
+		t.Fatalf("expected foo.service to be deregistered, got %v: %v", services, err)
+	}
+
+	if err := r.(PodRegistrar).RegisterOnPod("", "pod-3", foo); !errors.Is(err, api.ErrNotFound) {
+		t.Fatalf("expected registering on a missing pod to fail, got %v", err)
+	}
+}
+
+func TestAuditSink(t *testing.T) {
+	sink := make(chan AuditRecord, 10)
+	r := setupRegistry(AuditSink(sink))
+	defer teardownRegistry()
+
+	start := time.Now()
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+
+	register(t, r, "pod-1", svc)
+	deregister(t, r, "pod-1", svc)
+
+	for _, action := range []string{AuditRegister, AuditDeregister} {
+		select {
+		case rec := <-sink:
+			if rec.Action != action || rec.Service != "foo.service" || rec.Version != "1" || rec.Pod != "pod-1" || rec.Err != nil {
+				t.Fatalf("expected a record of the %s of foo.service on pod-1, got %+v", action, rec)
+			}
+
+			if rec.Time.Before(start) || len(rec.Instance) == 0 {
+				t.Fatalf("expected the record to tell when and by whom, got %+v", rec)
+			}
+		default:
+			t.Fatalf("expected a record of the %s", action)
+		}
+	}
+
+	// a full sink doesn't block registrations
+	full := make(chan AuditRecord)
+	r = setupRegistry(AuditSink(full))
+
+	register(t, r, "pod-1", &registry.Service{Name: "bar.service", Version: "1"})
+}
+
+func TestMaxNodesPerService(t *testing.T) {
+	r := setupRegistry(MaxNodesPerService(3))
+	defer teardownRegistry()
+
+	w, err := r.Watch(ReadyCountChanges(true))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	reg := setupRegistry()
+
+	// at the cap
+	for i, p := range []string{"pod-1", "pod-2", "pod-3"} {
+		register(t, reg, p, &registry.Service{Name: "foo.service", Version: "1"})
+		expectAction(t, w, "foo.service", "create")
+
+		if res := expectAction(t, w, "foo.service", "update"); len(res.Service.Nodes) != i+1 {
+			t.Fatalf("expected an update with %d nodes, got %d", i+1, len(res.Service.Nodes))
+		}
+	}
+
+	services, err := r.GetService("foo.service")
+	if err != nil || len(services) != 1 || len(services[0].Nodes) != 3 {
+		t.Fatalf("expected the 3 nodes of foo.service, got %v: %v", services, err)
+	}
+
+	if _, ok := services[0].Metadata[MetadataTruncated]; ok {
+		t.Fatal("expected a service at the cap not to be truncated")
+	}
+
+	// beyond the cap, watchers emitting the services capped too
+	for _, p := range []string{"pod-4", "pod-5"} {
+		register(t, reg, p, &registry.Service{Name: "foo.service", Version: "1"})
+		expectAction(t, w, "foo.service", "create")
+
+		res := expectAction(t, w, "foo.service", "update")
+		if len(res.Service.Nodes) != 3 || res.Service.Metadata[MetadataTruncated] != "true" {
+			t.Fatalf("expected an update truncated to 3 nodes, got %+v", res.Service)
+		}
+	}
+
+	kept := func(services []*registry.Service) []string {
+		t.Helper()
+
+		if len(services) != 1 || len(services[0].Nodes) != 3 || services[0].Metadata[MetadataTruncated] != "true" {
+			t.Fatalf("expected foo.service truncated to 3 nodes, got %+v", services)
+		}
+
+		ids := make([]string, 0, 3)
+		for _, n := range services[0].Nodes {
+			ids = append(ids, n.Id)
+		}
+
+		sort.Strings(ids)
+
+		return ids
+	}
+
+	services, err = r.GetService("foo.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := kept(services)
+
+	for i := 0; i < 3; i++ {
+		services, err = r.GetService("foo.service")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ids := kept(services); !reflect.DeepEqual(ids, first) {
+			t.Fatalf("expected the same nodes kept every time, got %v then %v", first, ids)
+		}
+	}
+}
+
+func TestCheckAccess(t *testing.T) {
+	t.Setenv("HOSTNAME", "pod-1")
+
+	r := setupRegistry(RegisterConfigMap("registry"))
+	defer teardownRegistry()
+
+	checker := r.(AccessChecker)
+
+	if err := checker.CheckAccess(true); err != nil {
+		t.Fatalf("did not expect CheckAccess() to fail: %v", err)
+	}
+
+	mockClient.DenyAccess("watch", "pods")
+	mockClient.DenyAccess("patch", "pods")
+	mockClient.DenyAccess("patch", "configmaps")
+
+	// discovering only needs the pods to be listed and watched
+	err := checker.CheckAccess(false)
+	if !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("expected ErrAccessDenied, got %v", err)
+	}
+
+	if !strings.HasPrefix(err.Error(), "missing watch pods:") {
+		t.Fatalf("expected only watch pods to be missing, got %v", err)
+	}
+
+	if strings.Contains(err.Error(), "patch") {
+		t.Fatalf("did not expect patch to be checked without registering, got %v", err)
+	}
+
+	// every permission missing is listed at once
+	err = checker.CheckAccess(true)
+	if !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("expected ErrAccessDenied, got %v", err)
+	}
+
+	for _, missing := range []string{"watch pods", "patch pods/pod-1", "patch configmaps/registry"} {
+		if !strings.Contains(err.Error(), missing) {
+			t.Fatalf("expected %s to be listed as missing, got %v", missing, err)
+		}
+	}
+
+	if strings.Contains(err.Error(), "list pods") || strings.Contains(err.Error(), "get pods") {
+		t.Fatalf("did not expect allowed permissions to be listed, got %v", err)
+	}
+}
+
+// nameLabelMapper selects the pods of services by the well-known name label,
+// with dots of service names as dashes.
+type nameLabelMapper struct{}
+
+func (nameLabelMapper) Selector(name string) (string, string) {
+	return "app.kubernetes.io/name", strings.ReplaceAll(name, ".", "-")
+}
+
+func (nameLabelMapper) Service(key, value string) (string, bool) {
+	if key != "app.kubernetes.io/name" {
+		return "", false
+	}
+
+	return strings.ReplaceAll(value, "-", "."), true
+}
+
+func TestSelectorMapping(t *testing.T) {
+	kc := &listCountingClient{Client: mockClient}
+	r := NewRegistry(Client(kc), SelectorMapping(nameLabelMapper{}), ServeFromCache(true))
+	defer teardownRegistry()
+
+	// pod-2 is already labelled by the same convention
+	app := "bar-service"
+	setupPod("pod-2").Metadata.Labels["app.kubernetes.io/name"] = &app
+
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+	bar := &registry.Service{Name: "bar.service", Version: "1"}
+	register(t, r, "pod-2", bar)
+
+	pod, err := mockClient.GetPod("pod-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := pod.Metadata.Labels["app.kubernetes.io/name"]; v == nil || *v != "foo-service" {
+		t.Fatalf("expected the name label to select foo.service, got %v", v)
+	}
+
+	for key := range pod.Metadata.Labels {
+		if strings.HasPrefix(key, svcSelectorPrefix) {
+			t.Fatalf("did not expect the default selector label %s", key)
+		}
+	}
+
+	lookup := func(name, pod string) {
+		t.Helper()
+
+		services, err := r.GetService(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(services) != 1 || len(services[0].Nodes) != 1 || services[0].Nodes[0].Id != name+":"+pod {
+			t.Fatalf("expected %s on %s only, got %+v", name, pod, services)
+		}
+	}
+
+	lookup("foo.service", "pod-1")
+	lookup("bar.service", "pod-2")
+
+	// the cache selects by the inverse of the mapper
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	kc.lists.Store(0)
+
+	lookup("foo.service", "pod-1")
+
+	if n := kc.lists.Load(); n != 0 {
+		t.Fatalf("expected GetService() to be served from cache, got %d lists", n)
+	}
+
+	// the label of the convention outlives the registration
+	deregister(t, r, "pod-2", bar)
+
+	if pod, err = mockClient.GetPod("pod-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := pod.Metadata.Labels["app.kubernetes.io/name"]; v == nil || *v != "bar-service" {
+		t.Fatalf("expected the name label to be kept, got %v", v)
+	}
+}
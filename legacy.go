@@ -0,0 +1,25 @@
+package kubernetes
+
+import (
+	"go-micro.dev/v4/registry"
+)
+
+// LegacyDecoder decodes a service payload in the format of another
+// registry, such as the one being migrated from.
+type LegacyDecoder func(data string) (*registry.Service, error)
+
+// decodeService decodes a service payload, with the legacy decoder if any
+// when it isn't a service of this registry.
+func (c *kregistry) decodeService(data string) (*registry.Service, error) {
+	svc, err := compactDecode(data)
+	if c.legacyDecoder == nil || err == nil && len(svc.Name) > 0 {
+		return svc, err
+	}
+
+	legacy, lerr := c.legacyDecoder(data)
+	if lerr != nil || legacy == nil {
+		return svc, err
+	}
+
+	return legacy, nil
+}
@@ -0,0 +1,66 @@
+package kubernetes
+
+import (
+	"go-micro.dev/v4/registry"
+)
+
+// SelectorMapper maps the names of services to the labels selecting their
+// pods, for label conventions other than "micro.mu/selector-svc.name".
+type SelectorMapper interface {
+	// Selector returns the key and value of the label selecting the pods
+	// of a service.
+	Selector(name string) (key, value string)
+	// Service returns the name of the service a label selects, false when
+	// it selects none.
+	Service(key, value string) (string, bool)
+}
+
+// selectorLabel returns the key and value of the selector label Register
+// sets for a service.
+func (c *kregistry) selectorLabel(s *registry.Service) (string, *string) {
+	if c.selectorMapper == nil {
+		return svcSelectorPrefix + serviceName(s.Name), c.selectorValue(s)
+	}
+
+	key, value := c.selectorMapper.Selector(s.Name)
+
+	return key, &value
+}
+
+// selects reports whether the labels of a pod select a service.
+func (c *kregistry) selects(labels map[string]*string, name string) bool {
+	if c.selectorMapper == nil {
+		_, ok := labels[svcSelectorPrefix+serviceName(name)]
+		return ok
+	}
+
+	for key, value := range labels {
+		if value == nil {
+			continue
+		}
+
+		if svc, ok := c.selectorMapper.Service(key, *value); ok && c.normalize(svc) == c.normalize(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ownedSelectors copies the selector labels the mapper maps to a service,
+// set by the registry too.
+func (c *kregistry) ownedSelectors(dst, src map[string]*string) {
+	if c.selectorMapper == nil {
+		return
+	}
+
+	for key, value := range src {
+		if value == nil {
+			continue
+		}
+
+		if _, ok := c.selectorMapper.Service(key, *value); ok {
+			dst[key] = value
+		}
+	}
+}
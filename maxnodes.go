@@ -0,0 +1,81 @@
+package kubernetes
+
+import (
+	"hash/fnv"
+	"sort"
+
+	"go-micro.dev/v4/registry"
+)
+
+// MetadataTruncated is the service metadata key set to "true" when nodes
+// of the service were left out, as it had more than MaxNodesPerService.
+const MetadataTruncated = "micro.mu/truncated"
+
+// capNodes truncates services with more nodes than the maximum, if any, to
+// the nodes whose ID hashes lowest, so the same nodes are kept every time.
+func (c *kregistry) capNodes(services []*registry.Service) []*registry.Service {
+	if c.maxNodes <= 0 {
+		return services
+	}
+
+	capped := make([]*registry.Service, len(services))
+
+	for i, svc := range services {
+		capped[i] = c.capServiceNodes(svc)
+	}
+
+	return capped
+}
+
+// capResults truncates the services of results like capNodes, but the ones
+// of deletes, whose nodes all go.
+func (c *kregistry) capResults(results []*registry.Result) []*registry.Result {
+	if c.maxNodes <= 0 {
+		return results
+	}
+
+	for _, result := range results {
+		if result.Action != c.actions.Delete {
+			result.Service = c.capServiceNodes(result.Service)
+		}
+	}
+
+	return results
+}
+
+func (c *kregistry) capServiceNodes(svc *registry.Service) *registry.Service {
+	if svc == nil || len(svc.Nodes) <= c.maxNodes {
+		return svc
+	}
+
+	nodes := append([]*registry.Node(nil), svc.Nodes...)
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		hi, hj := nodeHash(nodes[i].Id), nodeHash(nodes[j].Id)
+		if hi != hj {
+			return hi < hj
+		}
+
+		return nodes[i].Id < nodes[j].Id
+	})
+
+	s := withNodes(svc, nodes[:c.maxNodes]...)
+
+	s.Metadata = make(map[string]string, len(svc.Metadata)+1)
+	for k, v := range svc.Metadata {
+		s.Metadata[k] = v
+	}
+
+	s.Metadata[MetadataTruncated] = "true"
+
+	return s
+}
+
+// nodeHash hashes the ID of a node.
+func nodeHash(id string) uint64 {
+	h := fnv.New64a()
+	//nolint:errcheck
+	h.Write([]byte(id))
+
+	return h.Sum64()
+}
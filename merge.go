@@ -0,0 +1,71 @@
+package kubernetes
+
+import (
+	"go-micro.dev/v4/registry"
+)
+
+// mergeRegistered merges a service into the one of the same version
+// registered on a pod, when merging registrations: nodes are merged by ID,
+// and the metadata of the service and of nodes of the same ID by key, the
+// ones registered now winning. A service of another version is replaced.
+func (c *kregistry) mergeRegistered(podName string, s *registry.Service) (*registry.Service, error) {
+	current, err := c.registered(podName)
+	if err != nil {
+		return nil, err
+	}
+
+	old, ok := current[c.normalize(s.Name)]
+	if !ok || old.Version != s.Version {
+		return s, nil
+	}
+
+	merged := *s
+	merged.Metadata = mergeMetadata(old.Metadata, s.Metadata)
+	merged.Nodes = make([]*registry.Node, 0, len(old.Nodes)+len(s.Nodes))
+
+	nodes := make(map[string]*registry.Node, len(s.Nodes))
+	for _, node := range s.Nodes {
+		nodes[node.Id] = node
+	}
+
+	// the nodes registered before keep their order, new ones come last
+	for _, node := range old.Nodes {
+		n, ok := nodes[node.Id]
+		if !ok {
+			merged.Nodes = append(merged.Nodes, node)
+			continue
+		}
+
+		cp := *n
+		cp.Metadata = mergeMetadata(node.Metadata, n.Metadata)
+		merged.Nodes = append(merged.Nodes, &cp)
+
+		delete(nodes, node.Id)
+	}
+
+	for _, node := range s.Nodes {
+		if _, ok := nodes[node.Id]; ok {
+			merged.Nodes = append(merged.Nodes, node)
+		}
+	}
+
+	return &merged, nil
+}
+
+// mergeMetadata returns the metadata of both, the ones of over winning.
+func mergeMetadata(base, over map[string]string) map[string]string {
+	if len(base) == 0 {
+		return over
+	}
+
+	merged := make(map[string]string, len(base)+len(over))
+	for k, v := range base {
+		merged[k] = v
+	}
+
+	for k, v := range over {
+		merged[k] = v
+	}
+
+	return merged
+}
@@ -0,0 +1,193 @@
+package kubernetes
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// node metadata keys of the SRV-like and TLS hints, and their defaults.
+const (
+	metadataPriority = "priority"
+	metadataWeight   = "weight"
+	metadataSecure   = "secure"
+	metadataALPN     = "alpn"
+
+	defaultPriority = "0"
+	defaultWeight   = "1"
+	defaultSecure   = "false"
+)
+
+// nodeMetadata adds what is configured to be derived from the pod to the
+// service, its version and the metadata of its nodes.
+func (c *kregistry) nodeMetadata(pod *client.Pod, svc *registry.Service) {
+	if pod == nil || pod.Metadata == nil {
+		return
+	}
+
+	c.readyNodes(pod, svc)
+
+	if v, ok := c.podVersion(pod.Metadata); ok {
+		svc.Version = v
+	}
+
+	c.labelMetadata(pod.Metadata, svc)
+
+	if len(c.priorityKey) > 0 {
+		setNodeMetadata(svc, metadataPriority, podHint(pod.Metadata, c.priorityKey, defaultPriority))
+	}
+
+	if len(c.weightKey) > 0 {
+		setNodeMetadata(svc, metadataWeight, podHint(pod.Metadata, c.weightKey, defaultWeight))
+	}
+
+	if len(c.secureKey) > 0 {
+		setNodeMetadata(svc, metadataSecure, podSecure(pod.Metadata, c.secureKey))
+	}
+
+	if len(c.alpnKey) > 0 {
+		if v, ok := podValue(pod.Metadata, c.alpnKey); ok && len(v) > 0 {
+			setNodeMetadata(svc, metadataALPN, v)
+		}
+	}
+
+	c.topologyMetadata(pod, svc)
+	c.clusterMetadata(svc)
+	podMetadata(pod, svc)
+
+	if len(c.network) > 0 {
+		if ip, ok := networkIP(pod.Metadata, c.network); ok {
+			setNodeHost(svc, ip)
+		}
+	}
+
+	if len(c.headlessDomain) > 0 {
+		if name := headlessName(pod, c.headlessDomain); len(name) > 0 {
+			setNodeHost(svc, name)
+		}
+	}
+}
+
+// podVersion reads the version of the services of a pod from the version
+// label, if any.
+func (c *kregistry) podVersion(meta *client.Meta) (string, bool) {
+	if len(c.versionLabel) == 0 || meta == nil {
+		return "", false
+	}
+
+	v, ok := meta.Labels[c.versionLabel]
+	if !ok || v == nil || len(*v) == 0 {
+		return "", false
+	}
+
+	return *v, true
+}
+
+// headlessName is the DNS name of a pod of a headless service, or empty
+// when the pod has none.
+func headlessName(pod *client.Pod, domain string) string {
+	if pod.Spec == nil || len(pod.Spec.Hostname) == 0 || len(pod.Spec.Subdomain) == 0 || len(pod.Metadata.Namespace) == 0 {
+		return ""
+	}
+
+	return strings.Join([]string{pod.Spec.Hostname, pod.Spec.Subdomain, pod.Metadata.Namespace, "svc", domain}, ".")
+}
+
+// setNodeHost replaces the host of the address of the service nodes,
+// keeping their port.
+func setNodeHost(svc *registry.Service, host string) {
+	for _, node := range svc.Nodes {
+		if _, port, err := net.SplitHostPort(node.Address); err == nil {
+			node.Address = net.JoinHostPort(host, port)
+		} else {
+			node.Address = host
+		}
+	}
+}
+
+// labelMetadata copies the pod labels starting with the label prefix, if
+// any, into the metadata of the service nodes, without the prefix.
+func (c *kregistry) labelMetadata(meta *client.Meta, svc *registry.Service) {
+	for k, v := range c.mirroredLabels(meta) {
+		setNodeMetadata(svc, k, v)
+	}
+}
+
+// mirroredLabels returns the pod labels starting with the label prefix, if
+// any, without the prefix.
+func (c *kregistry) mirroredLabels(meta *client.Meta) map[string]string {
+	if len(c.labelPrefix) == 0 || meta == nil {
+		return nil
+	}
+
+	labels := make(map[string]string)
+
+	for k, v := range meta.Labels {
+		if !strings.HasPrefix(k, c.labelPrefix) || v == nil {
+			continue
+		}
+
+		labels[strings.TrimPrefix(k, c.labelPrefix)] = *v
+	}
+
+	return labels
+}
+
+// podHint reads an SRV-like hint from a pod label, or else annotation,
+// falling back to the default when missing or out of the 0-65535 range.
+func podHint(meta *client.Meta, key, def string) string {
+	v, ok := podValue(meta, key)
+	if !ok {
+		return def
+	}
+
+	if _, err := strconv.ParseUint(v, 10, 16); err != nil {
+		return def
+	}
+
+	return v
+}
+
+// podSecure reads whether the pod serves TLS from a pod label, or else
+// annotation, as "true" or "false", which is the default.
+func podSecure(meta *client.Meta, key string) string {
+	v, ok := podValue(meta, key)
+	if !ok {
+		return defaultSecure
+	}
+
+	secure, err := strconv.ParseBool(v)
+	if err != nil {
+		return defaultSecure
+	}
+
+	return strconv.FormatBool(secure)
+}
+
+// podValue reads a pod label, or else annotation.
+func podValue(meta *client.Meta, key string) (string, bool) {
+	v, ok := meta.Labels[key]
+	if !ok || v == nil {
+		v, ok = meta.Annotations[key]
+	}
+
+	if !ok || v == nil {
+		return "", false
+	}
+
+	return *v, true
+}
+
+func setNodeMetadata(svc *registry.Service, key, value string) {
+	for _, node := range svc.Nodes {
+		if node.Metadata == nil {
+			node.Metadata = make(map[string]string)
+		}
+
+		node.Metadata[key] = value
+	}
+}
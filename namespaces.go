@@ -0,0 +1,249 @@
+package kubernetes
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// NamespaceHealth is implemented by the watchers returned when watching
+// several namespaces.
+type NamespaceHealth interface {
+	// Degraded returns the namespaces whose watch is currently failing,
+	// with the last error it failed with.
+	Degraded() map[string]error
+}
+
+// NamespaceLister is implemented by the registry to list the namespaces
+// hosting services.
+type NamespaceLister interface {
+	// ListNamespaces returns the namespaces with at least one running pod
+	// advertising a service, sorted.
+	ListNamespaces() ([]string, error)
+}
+
+// namespacesWatcher merges the results of one watcher per namespace, or per
+// cluster when federated. Each is watched, and retried on failure,
+// independently of the others, so a namespace the registry may not read, or
+// a cluster unreachable, doesn't stop the rest.
+type namespacesWatcher struct {
+	// what the watchers are keyed by, namespace or cluster.
+	scope string
+
+	next chan *registry.Result
+	done chan struct{}
+
+	sync.Mutex
+	degraded map[string]error
+	sync.Once
+	wg sync.WaitGroup
+}
+
+func newNamespacesWatcher(kr *kregistry, namespaces []string, opts ...registry.WatchOption) (registry.Watcher, error) {
+	nc, ok := kr.client.(client.Namespacer)
+	if !ok {
+		return nil, ErrNamespacesUnsupported
+	}
+
+	registries := make(map[string]*kregistry, len(namespaces))
+	for _, ns := range namespaces {
+		registries[ns] = kr.withClient(nc.InNamespace(ns))
+	}
+
+	return watchEach("namespace", registries, opts...), nil
+}
+
+// watchEach merges the results of a watcher per registry, keyed by the
+// namespace or cluster it watches.
+func watchEach(scope string, registries map[string]*kregistry, opts ...registry.WatchOption) *namespacesWatcher {
+	w := &namespacesWatcher{
+		scope:    scope,
+		next:     make(chan *registry.Result),
+		done:     make(chan struct{}),
+		degraded: make(map[string]error),
+	}
+
+	for name, kr := range registries {
+		w.wg.Add(1)
+
+		go func() {
+			defer w.wg.Done()
+
+			w.watch(name, kr, opts...)
+		}()
+	}
+
+	return w
+}
+
+// ListNamespaces lists the service pods of all namespaces, which needs
+// permission to list pods cluster wide, to return the namespaces they run in.
+func (c *kregistry) ListNamespaces() ([]string, error) {
+	nc, ok := c.client.(client.Namespacer)
+	if !ok {
+		return nil, ErrNamespacesUnsupported
+	}
+
+	pods, err := nc.InNamespace("").ListPods(c.podSelector())
+	if err != nil {
+		return nil, err
+	}
+
+	c.readPayloads(pods.Items)
+
+	seen := make(map[string]bool)
+
+	for _, pod := range pods.Items {
+		if seen[pod.Metadata.Namespace] || pod.Status.Phase != podRunning || c.terminating(&pod) {
+			continue
+		}
+
+		for k := range pod.Metadata.Annotations {
+			if strings.HasPrefix(k, annotationServiceKeyPrefix) {
+				seen[pod.Metadata.Namespace] = true
+				break
+			}
+		}
+	}
+
+	namespaces := make([]string, 0, len(seen))
+	for ns := range seen {
+		namespaces = append(namespaces, ns)
+	}
+
+	sort.Strings(namespaces)
+
+	return namespaces, nil
+}
+
+// watch forwards the results of a namespace until the watcher is stopped,
+// (re)creating its watcher whenever it can't be created or ends.
+func (w *namespacesWatcher) watch(ns string, kr *kregistry, opts ...registry.WatchOption) {
+	// failed reconnects of an established watch degrade the namespace too
+	onReconnect := kr.hooks.onReconnect
+	kr.hooks.onReconnect = func(err error) {
+		w.setHealth(ns, err)
+
+		if onReconnect != nil {
+			onReconnect(err)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-w.done:
+				return
+			case <-time.After(kr.watchBackoff.NextDelay(attempt)):
+			}
+		}
+
+		watcher, err := newWatcher(kr, opts...)
+		w.setHealth(ns, err)
+
+		if err != nil {
+			kr.logs.errorf("K8s Watcher: failed to watch %s %s: %v", w.scope, ns, err)
+			continue
+		}
+
+		attempt = 0
+
+		if !w.forward(watcher) {
+			return
+		}
+	}
+}
+
+// forward sends the results of a namespace watcher down the wire, it
+// returns false once the namespaces watcher is stopped.
+func (w *namespacesWatcher) forward(watcher registry.Watcher) bool {
+	defer watcher.Stop()
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+
+	// unblock Next once stopped
+	go func() {
+		select {
+		case <-w.done:
+			watcher.Stop()
+		case <-stopped:
+		}
+	}()
+
+	for {
+		result, err := watcher.Next()
+		if err != nil {
+			select {
+			case <-w.done:
+				return false
+			default:
+				return true
+			}
+		}
+
+		select {
+		case <-w.done:
+			return false
+		case w.next <- result:
+		}
+	}
+}
+
+func (w *namespacesWatcher) setHealth(ns string, err error) {
+	w.Lock()
+	defer w.Unlock()
+
+	if err != nil {
+		w.degraded[ns] = err
+		return
+	}
+
+	delete(w.degraded, ns)
+}
+
+// Degraded returns the namespaces whose watch is currently failing.
+func (w *namespacesWatcher) Degraded() map[string]error {
+	w.Lock()
+	defer w.Unlock()
+
+	degraded := make(map[string]error, len(w.degraded))
+	for ns, err := range w.degraded {
+		degraded[ns] = err
+	}
+
+	return degraded
+}
+
+// Next will block until a new result comes in from any namespace.
+func (w *namespacesWatcher) Next() (*registry.Result, error) {
+	// done is closed as soon as the watcher stops, while next is only
+	// closed once nothing sends results anymore.
+	select {
+	case <-w.done:
+		return nil, ErrWatcherStopped
+	case r, ok := <-w.next:
+		if !ok {
+			return nil, ErrWatcherStopped
+		}
+
+		return r, nil
+	}
+}
+
+// Stop stops the watchers of all namespaces, and closes channels.
+func (w *namespacesWatcher) Stop() {
+	w.Do(func() {
+		close(w.done)
+
+		go func() {
+			w.wg.Wait()
+			close(w.next)
+		}()
+	})
+}
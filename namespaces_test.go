@@ -0,0 +1,235 @@
+package kubernetes
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/api"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/mock"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/watch"
+)
+
+// namespacedClient hands out a client per namespace.
+type namespacedClient struct {
+	*mock.Client
+	namespaces map[string]client.Kubernetes
+}
+
+func (c namespacedClient) InNamespace(namespace string) client.Kubernetes {
+	return c.namespaces[namespace]
+}
+
+// forbiddenClient is denied reading pods, as without RBAC permission.
+type forbiddenClient struct {
+	*mock.Client
+}
+
+func (forbiddenClient) ListPods(map[string]string) (*client.PodList, error) {
+	return nil, api.ErrForbidden
+}
+
+func (forbiddenClient) WatchPods(map[string]string) (watch.Watch, error) {
+	return nil, api.ErrForbidden
+}
+
+func TestWatcherNamespacesIsolateFailures(t *testing.T) {
+	nsA, nsC := mock.NewClient(), mock.NewClient()
+
+	established := make(chan bool, 10)
+
+	r := NewRegistry(
+		Client(namespacedClient{
+			Client: mock.NewClient(),
+			namespaces: map[string]client.Kubernetes{
+				"a": nsA,
+				"b": forbiddenClient{mock.NewClient()},
+				"c": nsC,
+			},
+		}),
+		OnWatchEstablished(func() { established <- true }),
+	)
+
+	w, err := r.Watch(Namespaces("a", "b", "c"))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	expectHook(t, established, "watch of a namespace established")
+	expectHook(t, established, "watch of another namespace established")
+
+	for _, c := range []*mock.Client{nsA, nsC} {
+		c.Pods["pod-1"] = &client.Pod{
+			Metadata: &client.Meta{
+				Name:        "pod-1",
+				Labels:      make(map[string]*string),
+				Annotations: make(map[string]*string),
+			},
+			Status: &client.Status{PodIP: "10.0.0.1", Phase: podRunning},
+		}
+
+		t.Setenv("HOSTNAME", "pod-1")
+
+		svc := &registry.Service{
+			Name:    "foo.service",
+			Version: "1",
+			Nodes:   []*registry.Node{{Id: "foo-1", Address: "10.0.0.1:80"}},
+		}
+		if err := NewRegistry(Client(c)).Register(svc); err != nil {
+			t.Fatalf("did not expect Register() to fail: %v", err)
+		}
+
+		expectAction(t, w, svc.Name, "create")
+	}
+
+	health, ok := w.(NamespaceHealth)
+	if !ok {
+		t.Fatal("expected the watcher to implement NamespaceHealth")
+	}
+
+	deadline := time.After(time.Second)
+
+	for {
+		degraded := health.Degraded()
+		if len(degraded) == 1 && errors.Is(degraded["b"], api.ErrForbidden) {
+			break
+		}
+
+		select {
+		case <-deadline:
+			t.Fatalf("expected only namespace b to be degraded, got %v", degraded)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestWatcherNamespacesCounters(t *testing.T) {
+	nsA := mock.NewClient()
+
+	established := make(chan bool, 10)
+
+	r := NewRegistry(
+		Client(namespacedClient{
+			Client:     mock.NewClient(),
+			namespaces: map[string]client.Kubernetes{"a": nsA},
+		}),
+		ValidatePayloads(true),
+		DeliveryTimeout(50*time.Millisecond),
+		OnWatchEstablished(func() { established <- true }),
+	)
+
+	// never calls Next
+	w, err := r.Watch(Namespaces("a"))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	expectHook(t, established, "watch of the namespace established")
+
+	nsA.Pods["pod-1"] = &client.Pod{
+		Metadata: &client.Meta{
+			Name:        "pod-1",
+			Labels:      make(map[string]*string),
+			Annotations: make(map[string]*string),
+		},
+		Status: &client.Status{PodIP: "10.0.0.1", Phase: podRunning},
+	}
+
+	t.Setenv("HOSTNAME", "pod-1")
+
+	// the invalid payload is rejected, the first result held by the
+	// namespaces watcher and the next dropped
+	for _, svc := range []*registry.Service{
+		{Name: "bad.service", Nodes: []*registry.Node{{Id: "bad-1", Address: "nowhere"}}},
+		{Name: "foo.service", Version: "1", Nodes: []*registry.Node{{Id: "foo-1", Address: "10.0.0.1:80"}}},
+		{Name: "bar.service", Version: "1", Nodes: []*registry.Node{{Id: "bar-1", Address: "10.0.0.1:81"}}},
+	} {
+		if err := NewRegistry(Client(nsA)).Register(svc); err != nil {
+			t.Fatalf("did not expect Register() to fail: %v", err)
+		}
+	}
+
+	deadline := time.After(time.Second)
+
+	for r.(DropCounter).DroppedEvents() == 0 {
+		select {
+		case <-deadline:
+			t.Fatal("expected the registry to count the results dropped in the namespace")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if rejected := r.(RejectCounter).RejectedPayloads(); rejected == 0 {
+		t.Fatal("expected the registry to count the payload rejected in the namespace")
+	}
+}
+
+func TestWatcherNamespacesUnsupported(t *testing.T) {
+	r := setupRegistry()
+
+	if _, err := r.Watch(Namespaces("a")); !errors.Is(err, ErrNamespacesUnsupported) {
+		t.Fatalf("expected ErrNamespacesUnsupported, got %v", err)
+	}
+}
+
+func TestListNamespaces(t *testing.T) {
+	all := mock.NewClient()
+
+	notation := `{"name":"foo.service","version":"1","nodes":[{"id":"foo-1","address":"10.0.0.1:80"}]}`
+	serviceType := labelTypeValueService
+
+	for _, p := range []struct {
+		name, namespace, phase string
+		service                bool
+	}{
+		{"pod-1", "a", podRunning, true},
+		{"pod-2", "a", podRunning, true},
+		{"pod-3", "b", podRunning, true},
+		{"pod-4", "c", podRunning, false},
+		{"pod-5", "d", "Pending", true},
+	} {
+		pod := &client.Pod{
+			Metadata: &client.Meta{
+				Name:        p.name,
+				Namespace:   p.namespace,
+				Labels:      map[string]*string{labelTypeKey: &serviceType},
+				Annotations: make(map[string]*string),
+			},
+			Status: &client.Status{PodIP: "10.0.0.1", Phase: p.phase},
+		}
+
+		if p.service {
+			pod.Metadata.Annotations[annotationServiceKeyPrefix+"foo.service"] = &notation
+		}
+
+		all.Pods[p.name] = pod
+	}
+
+	r := NewRegistry(Client(namespacedClient{
+		Client:     mock.NewClient(),
+		namespaces: map[string]client.Kubernetes{"": all},
+	}))
+
+	lister, ok := r.(NamespaceLister)
+	if !ok {
+		t.Fatal("expected the registry to implement NamespaceLister")
+	}
+
+	namespaces, err := lister.ListNamespaces()
+	if err != nil {
+		t.Fatalf("did not expect ListNamespaces() to fail: %v", err)
+	}
+
+	if len(namespaces) != 2 || namespaces[0] != "a" || namespaces[1] != "b" {
+		t.Fatalf("expected namespaces [a b], got %v", namespaces)
+	}
+
+	if _, err := setupRegistry().(NamespaceLister).ListNamespaces(); !errors.Is(err, ErrNamespacesUnsupported) {
+		t.Fatalf("expected ErrNamespacesUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// pod annotations Multus lists the networks of a pod in, the second one
+// being deprecated.
+var networkStatusAnnotations = []string{
+	"k8s.v1.cni.cncf.io/network-status",
+	"k8s.v1.cni.cncf.io/networks-status",
+}
+
+// networkStatus is an entry of the network status annotation.
+type networkStatus struct {
+	Name string   `json:"name"`
+	IPs  []string `json:"ips"`
+}
+
+// networkIP returns the first IP of a pod on the named network, as listed
+// by the CNI, reporting whether it has one. Network names match with or
+// without their namespace.
+func networkIP(meta *client.Meta, network string) (string, bool) {
+	for _, key := range networkStatusAnnotations {
+		v := meta.Annotations[key]
+		if v == nil {
+			continue
+		}
+
+		var networks []networkStatus
+		if err := json.Unmarshal([]byte(*v), &networks); err != nil {
+			continue
+		}
+
+		for _, n := range networks {
+			if (n.Name == network || strings.HasSuffix(n.Name, "/"+network)) && len(n.IPs) > 0 {
+				return n.IPs[0], true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// onNetwork reports whether a pod has an IP on the network its services
+// are advertised on, if any.
+func (c *kregistry) onNetwork(pod *client.Pod) bool {
+	if len(c.network) == 0 {
+		return true
+	}
+
+	_, ok := networkIP(pod.Metadata, c.network)
+
+	return ok
+}
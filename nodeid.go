@@ -0,0 +1,40 @@
+package kubernetes
+
+import (
+	"strconv"
+
+	"go-micro.dev/v4/registry"
+)
+
+// NodeIDFunc derives the id of the i-th node of a service registered on the
+// named pod.
+type NodeIDFunc func(podName string, s *registry.Service, i int) string
+
+// PodNodeID derives node ids from the service and pod names, suffixed with
+// the node index past the first node, eg: "foo.service-pod-1".
+func PodNodeID(podName string, s *registry.Service, i int) string {
+	id := s.Name + "-" + podName
+	if i > 0 {
+		id += "-" + strconv.Itoa(i)
+	}
+
+	return id
+}
+
+// withNodeIDs returns a copy of a service registered on the named pod with
+// the ids of its nodes derived, leaving the service given untouched.
+func (c *kregistry) withNodeIDs(podName string, s *registry.Service) *registry.Service {
+	if c.nodeID == nil {
+		return s
+	}
+
+	nodes := make([]*registry.Node, len(s.Nodes))
+
+	for i, node := range s.Nodes {
+		n := *node
+		n.Id = c.nodeID(podName, s, i)
+		nodes[i] = &n
+	}
+
+	return withNodes(s, nodes...)
+}
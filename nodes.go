@@ -0,0 +1,130 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/watch"
+)
+
+// watchNodes caches which nodes are cordoned and opens a watch to keep the
+// cache up to date, so pods on them stop being advertised. Events are handled
+// once runNodes is started.
+func (k *k8sWatcher) watchNodes() error {
+	nodes, err := k.registry.client.ListNodes()
+	if err != nil {
+		return err
+	}
+
+	watcher, err := k.registry.client.WatchNodes()
+	if err != nil {
+		return err
+	}
+
+	k.Lock()
+	for _, node := range nodes.Items {
+		if node.Metadata != nil && node.Spec != nil && node.Spec.Unschedulable {
+			k.cordoned[node.Metadata.Name] = true
+		}
+	}
+	k.nodeWatcher = watcher
+	k.Unlock()
+
+	return nil
+}
+
+// runNodes handles node events until the watcher is stopped,
+// re-establishing the node watch whenever its stream ends.
+func (k *k8sWatcher) runNodes() {
+	for attempt := 0; ; attempt++ {
+		k.RLock()
+		watcher := k.nodeWatcher
+		k.RUnlock()
+
+		for event := range watcher.ResultChan() {
+			attempt = 0
+			k.handleNodeEvent(event)
+		}
+
+		select {
+		case <-k.done:
+			return
+		case <-time.After(k.registry.reconnectBackoff.NextDelay(attempt + 1)):
+		}
+
+		watcher, err := k.registry.client.WatchNodes()
+		if err != nil {
+			k.registry.logs.errorf("K8s Watcher: failed to re-establish node watch: %v", err)
+			continue
+		}
+
+		k.Lock()
+		k.nodeWatcher = watcher
+		k.Unlock()
+
+		// stopped while reconnecting
+		select {
+		case <-k.done:
+			watcher.Stop()
+			return
+		default:
+		}
+	}
+}
+
+// handleNodeEvent updates the cordoned node cache, and emits results for the
+// pods on a node that got cordoned or uncordoned.
+func (k *k8sWatcher) handleNodeEvent(event watch.Event) {
+	if emptyObject(event.Object) {
+		return
+	}
+
+	var node client.Node
+	if err := json.Unmarshal(event.Object, &node); err != nil || node.Metadata == nil {
+		k.registry.logs.errorf("K8s Watcher: Couldnt unmarshal event object from node")
+		return
+	}
+
+	name := node.Metadata.Name
+	cordoned := event.Type != watch.Deleted && node.Spec != nil && node.Spec.Unschedulable
+
+	k.events.Lock()
+	defer k.events.Unlock()
+
+	var pods []*client.Pod
+
+	k.Lock()
+	if k.cordoned[name] != cordoned {
+		for _, pod := range k.pods {
+			if pod.Spec != nil && pod.Spec.NodeName == name {
+				pods = append(pods, pod)
+			}
+		}
+	}
+
+	if cordoned {
+		k.cordoned[name] = true
+	} else {
+		delete(k.cordoned, name)
+	}
+	k.Unlock()
+
+	// without a cache, results either create or delete all the services
+	// of a pod depending on whether it can be advertised now.
+	for _, pod := range pods {
+		k.emit(k.podResults(pod, nil))
+	}
+}
+
+// onCordonedNode reports whether a pod runs on a cordoned node.
+func (k *k8sWatcher) onCordonedNode(pod *client.Pod) bool {
+	if pod.Spec == nil || len(pod.Spec.NodeName) == 0 {
+		return false
+	}
+
+	k.RLock()
+	defer k.RUnlock()
+
+	return k.cordoned[pod.Spec.NodeName]
+}
@@ -0,0 +1,82 @@
+package kubernetes
+
+import (
+	"go-micro.dev/v4/registry"
+
+	"github.com/pkg/errors"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/api"
+)
+
+// PodRegistrar is implemented by the registry to register services on
+// behalf of other pods, such as by an operator managing their registration.
+type PodRegistrar interface {
+	// RegisterOnPod registers a service on the named pod, rather than on
+	// the pod this service runs in. An empty namespace is the namespace of
+	// the registry.
+	RegisterOnPod(namespace, podName string, s *registry.Service, opts ...registry.RegisterOption) error
+	// DeregisterOnPod deregisters a service from the named pod.
+	DeregisterOnPod(namespace, podName string, s *registry.Service) error
+}
+
+// RegisterOnPod registers a service on a pod like Register does on the
+// self pod, except it isn't healed.
+func (c *kregistry) RegisterOnPod(namespace, podName string, s *registry.Service, opts ...registry.RegisterOption) error {
+	if len(s.Nodes) == 0 {
+		return ErrNoNodesFound
+	}
+
+	kr, err := c.inNamespace(namespace)
+	if err != nil {
+		return errors.Wrapf(err, "failed to register on pod %s", podName)
+	}
+
+	err = podErr(podName, kr.register(podName, s, opts...))
+	c.audit(AuditRegister, namespace, podName, s, err)
+
+	return err
+}
+
+// DeregisterOnPod deregisters a service from a pod like Deregister does
+// from the self pod.
+func (c *kregistry) DeregisterOnPod(namespace, podName string, s *registry.Service) error {
+	if len(s.Nodes) == 0 {
+		return ErrNoNodesFound
+	}
+
+	kr, err := c.inNamespace(namespace)
+	if err != nil {
+		return errors.Wrapf(err, "failed to deregister on pod %s", podName)
+	}
+
+	err = podErr(podName, kr.deregister(podName, s))
+	c.audit(AuditDeregister, namespace, podName, s, err)
+
+	return err
+}
+
+// inNamespace returns the registry operating on a namespace, itself for an
+// empty one.
+func (c *kregistry) inNamespace(namespace string) (*kregistry, error) {
+	if len(namespace) == 0 {
+		return c, nil
+	}
+
+	nc, ok := c.client.(client.Namespacer)
+	if !ok {
+		return nil, ErrNamespacesUnsupported
+	}
+
+	return c.withClient(nc.InNamespace(namespace)), nil
+}
+
+// podErr reports a pod that does not exist as api.ErrNotFound, rather than
+// as the self pod unknown.
+func podErr(podName string, err error) error {
+	if errors.Is(err, ErrSelfPodUnknown) {
+		return errors.Wrapf(api.ErrNotFound, "pod %s", podName)
+	}
+
+	return err
+}
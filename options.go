@@ -0,0 +1,994 @@
+package kubernetes
+
+import (
+	"context"
+	"time"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/backoff"
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/watch"
+)
+
+type clientKey struct{}
+
+type actionsKey struct{}
+
+type summaryAnnotationKey struct{}
+
+type configMapsKey struct{}
+
+type selfPodKey struct{}
+
+type initialStateKey struct{}
+
+type initialStateActionKey struct{}
+
+type clientOptionsKey struct{}
+
+type skipCordonedKey struct{}
+
+type onReconnectKey struct{}
+
+type onResyncKey struct{}
+
+type onWatchEstablishedKey struct{}
+
+type onRawEventKey struct{}
+
+type namespacesKey struct{}
+
+type labelPrefixKey struct{}
+
+type priorityKey struct{}
+
+type servicesKey struct{}
+
+type cacheTTLKey struct{}
+
+type versionSelectorKey struct{}
+
+type meshKey struct{}
+
+type nodeResultsKey struct{}
+
+type watchNodeKey struct{}
+
+type compactKey struct{}
+
+type minIntervalKey struct{}
+
+type coalesceRestartsKey struct{}
+
+type ignoreSelfKey struct{}
+
+type validateKey struct{}
+
+type weightKey struct{}
+
+type headlessDNSKey struct{}
+
+type secureKey struct{}
+
+type alpnKey struct{}
+
+type expireServicesKey struct{}
+
+type defaultTTLKey struct{}
+
+type serveFromCacheKey struct{}
+
+type nodeTopologyKey struct{}
+
+type payloadLabelsKey struct{}
+
+type metadataChangesKey struct{}
+
+type inClusterKey struct{}
+
+type selfHealKey struct{}
+
+type versionLabelKey struct{}
+
+type podFilterKey struct{}
+
+type getRetryKey struct{}
+
+type configMapTargetKey struct{}
+
+type resetOnReconnectKey struct{}
+
+type dropOverflowKey struct{}
+
+type readyCountsKey struct{}
+
+type deregisterTerminatingKey struct{}
+
+type withoutPodIPKey struct{}
+
+type fieldManagerKey struct{}
+
+type auditSinkKey struct{}
+
+type maxNodesKey struct{}
+
+type legacyDecoderKey struct{}
+
+type registerMergeKey struct{}
+
+type clockSkewKey struct{}
+
+type readYourWritesKey struct{}
+
+type selectorMapperKey struct{}
+
+type encryptPayloadsKey struct{}
+
+type podNetworkKey struct{}
+
+type onGiveUpKey struct{}
+
+type kubeconfigKey struct{}
+
+type sharedWatchKey struct{}
+
+type sharedKey struct{}
+
+type overwriteCollisionsKey struct{}
+
+type podNamesKey struct{}
+
+type dedupNodesKey struct{}
+
+type nodeIDKey struct{}
+
+type portReadinessKey struct{}
+
+type logThrottleKey struct{}
+
+type caseInsensitiveNamesKey struct{}
+
+type clustersKey struct{}
+
+type serviceSelectorsKey struct{}
+
+type deliveryTimeoutKey struct{}
+
+type reconnectBackoffKey struct{}
+
+type watchBackoffKey struct{}
+
+// Actions are the action strings set on watcher results.
+type Actions struct {
+	Create string
+	Update string
+	Delete string
+	// Completed, when set, is the action of the services of a pod that
+	// succeeded while advertised, as job pods do, instead of Delete.
+	Completed string
+	// Replace, when set, is the action of the services of a pod restarted
+	// within the window of CoalesceRestarts, instead of Update.
+	Replace string
+}
+
+// Client sets the kubernetes client used by the registry, instead of
+// building one from the registry address or the in-cluster config.
+func Client(c client.Kubernetes) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, clientKey{}, c)
+	}
+}
+
+// ClientOptions sets the options used to build the kubernetes client,
+// such as its connection pool sizing.
+func ClientOptions(opts ...client.Option) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, clientOptionsKey{}, opts)
+	}
+}
+
+// ResultActions sets the action strings put on watcher results. Empty
+// fields keep their default of "create", "update" and "delete", while
+// completed pods are deleted unless Completed is set.
+func ResultActions(a Actions) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, actionsKey{}, a)
+	}
+}
+
+// SummaryAnnotation makes Register and Deregister keep a plain, comma
+// separated list of the services registered on a pod in the
+// "micro.mu/services" annotation. It is informational only and never
+// read for discovery.
+func SummaryAnnotation(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, summaryAnnotationKey{}, b)
+	}
+}
+
+// VersionSelector makes Register set the selector label of a service to its
+// version, sanitized like service names, instead of "service". A version of
+// a service can then be selected by label alone, for instance with
+// "micro.mu/selector-foo.service=1.0.1". Lookups select on the label key, so
+// pods registered either way are found.
+func VersionSelector(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, versionSelectorKey{}, b)
+	}
+}
+
+// Mesh sets the identifier of the mesh the registry belongs to, for meshes
+// sharing a cluster, or namespace, to only select their own pods. Register
+// suffixes the type and selector labels it sets with the identifier, eg:
+// "micro.mu/type=service.payments", and lists and watches select on it, so
+// pods of other meshes, or of none, are ignored. Config maps discovered
+// with ConfigMaps are labelled likewise.
+func Mesh(id string) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, meshKey{}, id)
+	}
+}
+
+// VersionLabel sets the pod label holding the version of the services it
+// runs, such as "app.kubernetes.io/version", overriding the version of their
+// payload, which may then leave it out. Pods without the label keep the
+// version of the payload. A pod changing the label deletes its services of
+// the previous version and creates the ones of the new version.
+func VersionLabel(key string) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, versionLabelKey{}, key)
+	}
+}
+
+// IgnoreSelf makes the watchers of the registry skip the changes made by its
+// own Register and Deregister calls, which then tag the pod with the
+// "micro.mu/registered-by" annotation. Their pods are still cached, so later
+// changes by others are diffed against them.
+func IgnoreSelf(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, ignoreSelfKey{}, b)
+	}
+}
+
+// OverwriteNameCollisions makes Register and Deregister skip checking the
+// service registered under the annotation key of a service is that service,
+// rather than another whose name sanitizes to the same key, as in
+// "foo/bar" and "foo:bar". The check reads the pod, and refuses with
+// ErrServiceNameCollision by default. Lookups and watchers always ignore
+// payloads of another service than the one their key is looked up for.
+func OverwriteNameCollisions(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, overwriteCollisionsKey{}, b)
+	}
+}
+
+// ValidatePayloads makes watchers check each service they decode has a name
+// and a node with a valid host:port address, skipping and logging the ones
+// that don't rather than returning them. The registry implements
+// RejectCounter to tell how many were rejected.
+func ValidatePayloads(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, validateKey{}, b)
+	}
+}
+
+// ExpireServices makes Register store the TTL of each registration, from
+// registry.RegisterTTL or else the DefaultTTL, in the service annotation.
+// Lookups then skip services not registered again within their TTL, and
+// watchers emit deletes for them, checking every second. Services without a
+// TTL never expire.
+func ExpireServices(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, expireServicesKey{}, b)
+	}
+}
+
+// DefaultTTL sets the TTL stored by Register when expiring services and
+// registry.RegisterTTL isn't given. It defaults to none.
+func DefaultTTL(d time.Duration) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, defaultTTLKey{}, d)
+	}
+}
+
+// RegisterConfigMap makes Register also write services to the config map of
+// that name, for redundancy when the pod can't be written. The config map
+// must exist, labelled "micro.mu/type=service" to be discovered with
+// ConfigMaps. Each pod writes a data entry per service, removed on
+// Deregister, so the entries of pods gone without deregistering remain.
+// When either write fails, a *TargetError tells which did.
+func RegisterConfigMap(name string) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, configMapTargetKey{}, name)
+	}
+}
+
+// GetRetry makes GetService try up to attempts times, waiting interval in
+// between, while the service is not found, to smooth over the gap with no
+// running pod of a rolling update. The context of the get options, if any,
+// bounds the retries.
+func GetRetry(attempts int, interval time.Duration) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, getRetryKey{}, getRetry{attempts: attempts, backoff: backoff.Constant(interval)})
+	}
+}
+
+// GetRetryBackoff is GetRetry waiting as long as b tells in between.
+func GetRetryBackoff(attempts int, b backoff.Backoff) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, getRetryKey{}, getRetry{attempts: attempts, backoff: b})
+	}
+}
+
+// getRetry holds the GetRetry option.
+type getRetry struct {
+	attempts int
+	backoff  backoff.Backoff
+}
+
+// ServeFromCache makes GetService build services from the pod cache of a
+// running watcher of all services, rather than listing pods, so read heavy
+// services spare the API server. It lists pods when no such watcher runs or
+// the service isn't cached. Results are as fresh as the watcher cache.
+func ServeFromCache(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, serveFromCacheKey{}, b)
+	}
+}
+
+// PayloadLabels lets service payloads be stored in pod labels as well as
+// annotations, independently of the labels pods are selected by. Register
+// stores a payload base64url encoded in the "micro.mu/payload-<name>" label
+// when it fits a label value, at most 63 characters, and in annotations
+// otherwise, which is where most payloads go. Payloads set in labels by
+// other tools are read too, annotations taking precedence.
+func PayloadLabels(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, payloadLabelsKey{}, b)
+	}
+}
+
+// EncryptPayloads makes Register encrypt service payloads with AES-GCM under
+// the key of keyID, so metadata isn't readable by anyone able to get pods.
+// Keys are 16, 24 or 32 bytes, by id. Payloads name the key they are
+// encrypted with and are decrypted with any of the keys, so keys can be
+// rotated by adding the new key everywhere before encrypting with it.
+// Plaintext payloads are still read. Register fails when the keys are
+// invalid rather than write plaintext.
+func EncryptPayloads(keyID string, keys map[string][]byte) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, encryptPayloadsKey{}, encryptPayloads{keyID: keyID, keys: keys})
+	}
+}
+
+// encryptPayloads holds the EncryptPayloads option.
+type encryptPayloads struct {
+	keyID string
+	keys  map[string][]byte
+}
+
+// ConfigMaps makes the watcher discover services from config maps labelled
+// "micro.mu/type=service" instead of pods. Each data entry keyed
+// "service-<name>" holds a serialized service.
+func ConfigMaps(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, configMapsKey{}, b)
+	}
+}
+
+// SelfHeal makes the registry register again its services removed from the
+// self pod by anything else, such as an operator or a policy controller. A
+// service removed again after maxReadds re-registrations within the window
+// is given up on, so the registry doesn't fight forever: it is logged as an
+// error and reported to the OnGiveUp hook. It stays so until deregistered.
+func SelfHeal(maxReadds int, window time.Duration) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, selfHealKey{}, selfHeal{max: maxReadds, window: window})
+	}
+}
+
+// OnGiveUp sets a hook called with the name of a service SelfHeal gave up
+// registering again.
+func OnGiveUp(fn func(service string)) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, onGiveUpKey{}, fn)
+	}
+}
+
+// selfHeal holds the SelfHeal option.
+type selfHeal struct {
+	max    int
+	window time.Duration
+}
+
+// PortReadiness advertises the nodes of pods with several ports only at the
+// ports ready, for pods starting to serve their ports in phases. Gates map
+// ports to a pod condition type, such as a readiness gate, or else the name
+// of a container, which must be true or ready for nodes at the port to be
+// advertised. Other ports are advertised while the pod is ready, but pods
+// are no longer withdrawn as a whole when not ready. Watchers emit node
+// results for the nodes a readiness change adds or removes.
+func PortReadiness(gates map[string]string) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, portReadinessKey{}, gates)
+	}
+}
+
+// LogThrottle sets the window in which identical watcher errors are logged
+// once, logging how many times they repeated when it ends. It defaults to
+// 10 seconds, 0 logs every error.
+func LogThrottle(window time.Duration) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, logThrottleKey{}, window)
+	}
+}
+
+// CaseInsensitiveNames lowercases service names registered, deregistered,
+// looked up, listed and watched, so "Orders" and "orders" are one service
+// and share the label and annotation keys of "orders".
+func CaseInsensitiveNames(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, caseInsensitiveNamesKey{}, b)
+	}
+}
+
+// Clusters federates the clusters of the remote clients, such as ones
+// created by client.NewClientFromKubeconfig, with the local cluster named
+// local, for one view of services run across clusters. Lookups, listings
+// and watches span every cluster, tagging nodes with the "cluster" metadata
+// of the one they run in, while services are registered in the local
+// cluster only. Watches retry clusters failing independently, reporting
+// them through NamespaceHealth, and watching namespaces watches the local
+// cluster only.
+func Clusters(local string, remotes map[string]client.Kubernetes) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, clustersKey{}, clusters{local: local, remotes: remotes})
+	}
+}
+
+// ServiceSelectors discovers services from the pods selected by Kubernetes
+// services rather than from annotations, mapping the names of the services
+// to the Kubernetes services. Looking one up or watching it lists or watches
+// the ready pods the selector of the Kubernetes service matches, with a
+// node per pod at the port its first port targets. Services discovered this
+// way aren't listed, and named target ports aren't supported. It needs get
+// permission on services.
+func ServiceSelectors(services map[string]string) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, serviceSelectorsKey{}, services)
+	}
+}
+
+// DeliveryTimeout drops the results a watcher doesn't receive within d,
+// rather than wait for it to call Next, so a stuck consumer doesn't stop the
+// events of the pods from being handled, nor the other watchers sharing a
+// watch from getting results. Watchers implement WatcherStats to tell how
+// many they dropped, and the registry DropCounter. It defaults to 0, waiting
+// as long as it takes.
+func DeliveryTimeout(d time.Duration) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, deliveryTimeoutKey{}, d)
+	}
+}
+
+// DeregisterOnTerminating sets whether the services of pods being deleted
+// stop being found as soon as their termination starts, which they do by
+// default. False keeps advertising them for the grace period, while the
+// pods run and are ready, so in-flight connections drain, until the pods
+// are gone.
+func DeregisterOnTerminating(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, deregisterTerminatingKey{}, b)
+	}
+}
+
+// AdvertiseWithoutPodIP sets whether the services of running pods are
+// advertised before the pods have an IP. By default they aren't, as the
+// nodes they register may not be reachable yet, and are advertised once an
+// event of the pods tells their IP.
+func AdvertiseWithoutPodIP(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, withoutPodIPKey{}, b)
+	}
+}
+
+// ServerSideApply updates the self pod on Register and Deregister through
+// server-side apply as the named field manager, rather than patching it.
+// The registry then owns its labels and annotations, and fails with
+// api.ErrConflict rather than clobbering them when another manager set them
+// differently. Clients not implementing client.PodApplier fail with
+// ErrApplyUnsupported.
+func ServerSideApply(fieldManager string) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, fieldManagerKey{}, fieldManager)
+	}
+}
+
+// AuditSink sends a record of every registration and deregistration made by
+// the registry, successful or not, to ch, for an audit trail. Records are
+// dropped rather than block registrations when ch is full, so it should be
+// buffered and drained. Off by default.
+func AuditSink(ch chan<- AuditRecord) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, auditSinkKey{}, ch)
+	}
+}
+
+// MaxNodesPerService caps the nodes of the services GetService returns, and
+// watchers emit, at n, so a service scaled to thousands of pods doesn't
+// overwhelm selectors. The nodes kept are chosen by a hash of their ID, the
+// same every time, and services truncated have MetadataTruncated set. Zero,
+// the default, doesn't cap them.
+func MaxNodesPerService(n int) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, maxNodesKey{}, n)
+	}
+}
+
+// LegacyFormat sets a decoder for the service payloads this registry
+// can't decode, or that decode to a service without a name, so services
+// registered by another registry are discovered too, such as while
+// migrating from it.
+func LegacyFormat(fn LegacyDecoder) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, legacyDecoderKey{}, fn)
+	}
+}
+
+// RegisterMerge makes Register merge a service into the one of the same
+// version registered on the pod rather than replace it, so processes of a
+// pod each contribute their nodes to the service: nodes are merged by ID,
+// and metadata by key. Deregister still removes the service at once.
+func RegisterMerge(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, registerMergeKey{}, b)
+	}
+}
+
+// ClockSkew tolerates the clocks of the pods registering services with a
+// TTL being off by up to d: services expire d after their TTL ends. A
+// registration stamped further ahead, which would outlive its TTL, is
+// warned about and dated from the creation of the pod instead, and one
+// stamped before the pod was created is dated from its creation.
+func ClockSkew(d time.Duration) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, clockSkewKey{}, d)
+	}
+}
+
+// ReadYourWrites makes Register and Deregister update the cache of the
+// running watchers of the registry as soon as the pod is written, rather
+// than once its watch event comes, so GetService served from cache finds
+// a service right after it is registered. They return once the watchers
+// returned the results of the write, so mustn't be called from the
+// goroutine calling Next.
+func ReadYourWrites(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, readYourWritesKey{}, b)
+	}
+}
+
+// SelectorMapping sets the mapper of service names to the labels selecting
+// their pods, so Register sets, and lookups select on, labels of an existing
+// convention, such as "app.kubernetes.io/name", rather than the
+// "micro.mu/selector-svc.name=service" ones. Lookups then select on the
+// value mapped as well, so a key can be shared by several services, and
+// Deregister leaves the labels mapped in place.
+func SelectorMapping(m SelectorMapper) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, selectorMapperKey{}, m)
+	}
+}
+
+// ReconnectBackoff sets how long watches wait before attempts to reconnect
+// once their watch of pods, nodes or config maps ended. It defaults to an
+// exponential backoff from 500ms up to 30s.
+func ReconnectBackoff(b backoff.Backoff) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, reconnectBackoffKey{}, b)
+	}
+}
+
+// WatchRetryBackoff sets how long the watches of several namespaces or
+// clusters wait before watching one failing again. It defaults to an
+// exponential backoff from 500ms up to 30s.
+func WatchRetryBackoff(b backoff.Backoff) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, watchBackoffKey{}, b)
+	}
+}
+
+// NodeIDs makes Register replace the ids of the nodes registered with ids
+// derived by fn, such as PodNodeID, so a pod registering a service again,
+// for instance after a restart, advertises the same node ids rather than
+// new ones each time.
+func NodeIDs(fn NodeIDFunc) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, nodeIDKey{}, fn)
+	}
+}
+
+// DedupNodes sets whether lookups drop the nodes of a service version at an
+// address another pod already advertises it at, as happens when pods are
+// misconfigured, logging them. The node whose id sorts first is kept. It
+// defaults to true, false keeps every node.
+func DedupNodes(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, dedupNodesKey{}, b)
+	}
+}
+
+// PodNames makes the registry discover services from the named pods only,
+// getting each by name instead of listing and watching pods, for clusters
+// where it may only get pods. Watchers get them again every interval, 30
+// seconds if zero, emitting the changes as on a reconnect, which calls the
+// OnReconnect and OnWatchEstablished hooks each time. Lookups get every pod
+// each time, so are slower than a list.
+func PodNames(interval time.Duration, names ...string) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, podNamesKey{}, podNames{names: names, interval: interval})
+	}
+}
+
+// podNames holds the PodNames option.
+type podNames struct {
+	names    []string
+	interval time.Duration
+}
+
+// PodFilter sets a predicate pods must match for their services to be
+// found, for custom inclusion rules such as an annotation matching the
+// active deployment color. Watchers drop the pods that stop matching from
+// their cache, deleting their services, and lookups skip them. The pods
+// given must not be modified.
+func PodFilter(fn func(*client.Pod) bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, podFilterKey{}, fn)
+	}
+}
+
+// LabelPrefixToMetadata copies the labels of a pod starting with prefix into
+// the metadata of the nodes of the services it runs, with the prefix
+// stripped. For instance with the prefix "route.example.com/", the label
+// "route.example.com/shard=a" sets the node metadata "shard=a".
+func LabelPrefixToMetadata(prefix string) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, labelPrefixKey{}, prefix)
+	}
+}
+
+// NodePriority sets the pod label, or else annotation, holding the SRV-like
+// priority of the nodes of the services it runs, put in their "priority"
+// metadata. It ranges from 0 to 65535, nodes with the lowest priority are
+// preferred and the others only used as a fallback. It defaults to 0 when
+// the pod doesn't set a valid one.
+func NodePriority(key string) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, priorityKey{}, key)
+	}
+}
+
+// NodeWeight sets the pod label, or else annotation, holding the SRV-like
+// weight of the nodes of the services it runs, put in their "weight"
+// metadata. It ranges from 0 to 65535, nodes of the same priority get a
+// share of the traffic relative to their weight. It defaults to 1 when the
+// pod doesn't set a valid one, so those nodes still get traffic.
+func NodeWeight(key string) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, weightKey{}, key)
+	}
+}
+
+// NodeSecure sets the pod label, or else annotation, telling whether the
+// nodes of the services it runs serve TLS, put as "true" or "false" in their
+// "secure" metadata so transports can pick TLS per node. It defaults to
+// "false" when the pod doesn't set a valid boolean.
+func NodeSecure(key string) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, secureKey{}, key)
+	}
+}
+
+// NodeALPN sets the pod label, or else annotation, holding the ALPN protocol
+// to negotiate with the nodes of the services it runs, such as "h2", put in
+// their "alpn" metadata. Nodes of pods not setting it get none.
+func NodeALPN(key string) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, alpnKey{}, key)
+	}
+}
+
+// NodeTopology puts the zone and region of the cluster node a pod runs on,
+// from its "topology.kubernetes.io/zone" and "topology.kubernetes.io/region"
+// labels, in the "zone" and "region" metadata of the nodes of the services
+// it runs, for zone aware routing. Nodes are cached, listed again at most
+// once a minute when a pod runs on one not cached. It needs list permission
+// on nodes.
+func NodeTopology(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, nodeTopologyKey{}, b)
+	}
+}
+
+// PodNetwork makes the nodes of the services run by a pod advertise its IP
+// on the named network instead of the registered host, keeping the port,
+// for pods attached to several networks such as with Multus. The IP is read
+// from the network status annotation the CNI sets, matching the network
+// name with or without its namespace. Pods without an IP on the network are
+// skipped.
+func PodNetwork(name string) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, podNetworkKey{}, name)
+	}
+}
+
+// SharedWatch makes the watchers of the registry share a single watch of
+// the pods of every service and its cache, started by the first watcher and
+// stopped with the last one, rather than each opening its own. Each watcher
+// still only returns the results of the services it is scoped to by
+// WatchService or WatchServices, and InitialState is honored from the shared
+// cache. Other watch options are ignored, and watches of namespaces or of
+// the self pod are never shared. Results are sent to the watchers in turn,
+// so one not calling Next holds the others back.
+func SharedWatch(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, sharedWatchKey{}, b)
+	}
+}
+
+// HeadlessDNS makes the nodes of the services run by pods of a headless
+// service, such as the ones of a StatefulSet, advertise the stable DNS name
+// of their pod in the given cluster domain instead of the registered host,
+// for instance "web-0.web.default.svc.cluster.local" for the domain
+// "cluster.local". The registered port is kept. Pods without both a hostname
+// and a subdomain keep their registered address.
+func HeadlessDNS(clusterDomain string) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, headlessDNSKey{}, clusterDomain)
+	}
+}
+
+// InitialState makes a watcher emit the services registered when it
+// starts, before any change, so consumers with an empty view catch up.
+func InitialState(b bool) registry.WatchOption {
+	return func(o *registry.WatchOptions) {
+		setWatchOption(o, initialStateKey{}, b)
+	}
+}
+
+// InitialStateAction sets the action put on the results emitted for the
+// initial state, which defaults to the create action. Use the update action
+// for downstreams that apply results idempotently on a cold start.
+func InitialStateAction(action string) registry.WatchOption {
+	return func(o *registry.WatchOptions) {
+		setWatchOption(o, initialStateActionKey{}, action)
+	}
+}
+
+// WatchServices scopes a watch to a set of services, only their results are
+// returned. One watch covers them all, selecting the pods of any service,
+// as label selectors can't match any of several labels.
+func WatchServices(names ...string) registry.WatchOption {
+	return func(o *registry.WatchOptions) {
+		setWatchOption(o, servicesKey{}, names)
+	}
+}
+
+// NodeResults makes a watch return a result per node rather than per
+// service: each result holds a single node, created, updated or deleted.
+// A pod changing a service only returns results for the nodes that changed.
+func NodeResults(b bool) registry.WatchOption {
+	return func(o *registry.WatchOptions) {
+		setWatchOption(o, nodeResultsKey{}, b)
+	}
+}
+
+// WatchNode scopes a watch to a single node, by ID or address, for
+// consumers tracking the health of a connection: it returns a create, with
+// the node only, when it becomes ready and a delete when it goes away, and
+// nothing else. Scope the watch to the service too when node IDs aren't
+// unique across services.
+func WatchNode(node string) registry.WatchOption {
+	return func(o *registry.WatchOptions) {
+		setWatchOption(o, watchNodeKey{}, node)
+	}
+}
+
+// Compact makes a watch keep only the latest result per service node until
+// it is received, so consumers only interested in the end state skip the
+// intermediate ones: a create followed by updates is received as the last
+// update. Results are queued rather than waiting to be received.
+func Compact(b bool) registry.WatchOption {
+	return func(o *registry.WatchOptions) {
+		setWatchOption(o, compactKey{}, b)
+	}
+}
+
+// MinInterval paces a watch so Next returns a result at most once per
+// interval, to protect fragile consumers. Results coming meanwhile are
+// merged as with Compact, keeping the latest one per service node.
+func MinInterval(d time.Duration) registry.WatchOption {
+	return func(o *registry.WatchOptions) {
+		setWatchOption(o, minIntervalKey{}, d)
+	}
+}
+
+// CoalesceRestarts makes a watch hold the deletes of services back for a
+// window, so a pod restarting in place, deleting then creating the same
+// service within it, is received as a result replacing its nodes, with the
+// Replace action, rather than leaving consumers without them meanwhile. The
+// nodes not registered again, under the same IDs, are deleted right after.
+// Deletes not followed by a create are received once the window is over.
+func CoalesceRestarts(window time.Duration) registry.WatchOption {
+	return func(o *registry.WatchOptions) {
+		setWatchOption(o, coalesceRestartsKey{}, window)
+	}
+}
+
+// MetadataChanges makes a watch also return an update for the services of
+// a pod when only the labels mirrored into their metadata change, as set
+// with LabelPrefixToMetadata, while their payload stays the same.
+func MetadataChanges(b bool) registry.WatchOption {
+	return func(o *registry.WatchOptions) {
+		setWatchOption(o, metadataChangesKey{}, b)
+	}
+}
+
+// ResetOnReconnect makes a watch return, on reconnect and on Refresh, a
+// delete for every service it returned, then a create for every service
+// found, rather than only the changes missed. Consumers get a clean slate
+// instead of reconciling differences.
+func ResetOnReconnect(b bool) registry.WatchOption {
+	return func(o *registry.WatchOptions) {
+		setWatchOption(o, resetOnReconnectKey{}, b)
+	}
+}
+
+// DropOverflow makes a paused watch drop the results it can't buffer
+// anymore, rather than stop handling events until resumed. The watcher
+// returned implements WatcherStats to tell how many it dropped, and the
+// registry DropCounter to tell how many all its watchers did.
+func DropOverflow(b bool) registry.WatchOption {
+	return func(o *registry.WatchOptions) {
+		setWatchOption(o, dropOverflowKey{}, b)
+	}
+}
+
+// ReadyCountChanges makes a watch emit an update of every version of a
+// service whose number of ready nodes changed, as pods advertising it scale
+// up or down or become ready or not, with the nodes of all its pods. It
+// follows the results of the pods changing, for consumers tracking instance
+// counts rather than instances.
+func ReadyCountChanges(b bool) registry.WatchOption {
+	return func(o *registry.WatchOptions) {
+		setWatchOption(o, readyCountsKey{}, b)
+	}
+}
+
+// SelfPod scopes a watch to the pod this service runs in, as resolved from
+// POD_NAME or HOSTNAME, so only results derived from its own registrations
+// are returned. It is useful to detect registrations removed by others.
+// Combined with registry.WatchService, only that service's results are
+// returned. Watching config maps doesn't support it.
+func SelfPod() registry.WatchOption {
+	return func(o *registry.WatchOptions) {
+		setWatchOption(o, selfPodKey{}, true)
+	}
+}
+
+// Namespaces makes a watch cover the given namespaces instead of the one of
+// the client, merging their results. Each namespace is watched on its own:
+// one failing, for instance as the registry may not read pods there, is
+// logged and retried while the others keep delivering results. The watcher
+// returned implements NamespaceHealth to tell which namespaces are failing.
+func Namespaces(names ...string) registry.WatchOption {
+	return func(o *registry.WatchOptions) {
+		setWatchOption(o, namespacesKey{}, names)
+	}
+}
+
+// SkipCordonedNodes makes the watcher stop advertising pods running on
+// nodes marked unschedulable, emitting deletes for their services, and
+// advertise them again once the node is uncordoned. It keeps a cache of
+// nodes, so needs list and watch permission on nodes.
+func SkipCordonedNodes(b bool) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, skipCordonedKey{}, b)
+	}
+}
+
+// CacheTTL sets how long the watcher keeps a pod it got no event for, as
+// its delete event may have been missed. Once a pod isn't refreshed by an
+// event or a resync within the TTL, the pods are listed again, deleting the
+// services of the ones gone only. It defaults to 2 hours, above how long
+// the API server keeps a watch open before ending it, which resyncs all
+// pods on reconnect. Zero disables it.
+func CacheTTL(d time.Duration) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, cacheTTLKey{}, d)
+	}
+}
+
+// OnReconnect sets a hook called each time the watcher tries to
+// re-establish a watch whose stream ended, with the error of the attempt or
+// nil when it succeeded. Hooks run on the watcher goroutine without any lock
+// held, they may use the registry or stop the watcher.
+func OnReconnect(fn func(err error)) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, onReconnectKey{}, fn)
+	}
+}
+
+// OnResync sets a hook called after the watcher resynced its cache on
+// reconnect, with the number of changes it found.
+func OnResync(fn func(changes int)) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, onResyncKey{}, fn)
+	}
+}
+
+// OnWatchEstablished sets a hook called each time the watcher has a watch
+// established, initially and after every reconnect.
+func OnWatchEstablished(fn func()) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, onWatchEstablishedKey{}, fn)
+	}
+}
+
+// OnRawEvent sets a hook called with every event of the watch streams of
+// the watcher, as sent by the API server, before it is decoded or filtered.
+// It is called from the goroutine handling events, so should be quick.
+func OnRawEvent(fn func(event watch.Event)) registry.Option {
+	return func(o *registry.Options) {
+		setOption(o, onRawEventKey{}, fn)
+	}
+}
+
+func setOption(o *registry.Options, key, value interface{}) {
+	if o.Context == nil {
+		o.Context = context.Background()
+	}
+
+	o.Context = context.WithValue(o.Context, key, value)
+}
+
+func setWatchOption(o *registry.WatchOptions, key, value interface{}) {
+	if o.Context == nil {
+		o.Context = context.Background()
+	}
+
+	o.Context = context.WithValue(o.Context, key, value)
+}
+
+// hooks are the watcher lifecycle callbacks, each may be nil.
+type hooks struct {
+	onReconnect        func(err error)
+	onResync           func(changes int)
+	onWatchEstablished func()
+	onRawEvent         func(event watch.Event)
+}
+
+func (h hooks) reconnect(err error) {
+	if h.onReconnect != nil {
+		h.onReconnect(err)
+	}
+}
+
+func (h hooks) resync(changes int) {
+	if h.onResync != nil {
+		h.onResync(changes)
+	}
+}
+
+func (h hooks) watchEstablished() {
+	if h.onWatchEstablished != nil {
+		h.onWatchEstablished()
+	}
+}
+
+func (h hooks) rawEvent(event watch.Event) {
+	if h.onRawEvent != nil {
+		h.onRawEvent(event)
+	}
+}
@@ -0,0 +1,207 @@
+package kubernetes
+
+import (
+	"sync"
+
+	"go-micro.dev/v4/registry"
+)
+
+// Pauser is implemented by the watchers returned, unless watching several
+// namespaces.
+type Pauser interface {
+	// Pause stops delivering results, buffering them until resumed.
+	Pause()
+	// Resume delivers the results buffered while paused, then the next
+	// ones as they come. It doesn't wait for them to be received.
+	Resume()
+}
+
+// WatcherStats is implemented by the watchers returned, unless watching
+// several namespaces or clusters.
+type WatcherStats interface {
+	// DroppedEvents returns how many results were dropped as the results
+	// buffered while paused overflowed, or weren't received within the
+	// delivery timeout, since the last reset.
+	DroppedEvents() uint64
+	// ResetStats resets the counters of the watcher, such as once an
+	// incident is acknowledged.
+	ResetStats()
+}
+
+// DropCounter is implemented by the registry to tell how many results its
+// watchers dropped in total.
+type DropCounter interface {
+	DroppedEvents() uint64
+}
+
+// maxPausedResults bounds the results buffered while paused, beyond which
+// events are no longer handled until the watcher is resumed, or dropped.
+var maxPausedResults = 1000
+
+// pauser buffers the results of a paused watcher, keeping the latest one
+// per service node in the order they first came.
+type pauser struct {
+	sync.Mutex
+	paused   bool
+	flushing bool
+	pending  []*registry.Result
+	// closed when pending is taken to be delivered, so there is room again.
+	room chan struct{}
+}
+
+// Pause stops delivering results until Resume is called.
+func (k *k8sWatcher) Pause() {
+	k.pause.Lock()
+	defer k.pause.Unlock()
+
+	k.pause.paused = true
+}
+
+// Resume delivers the results buffered while paused in the background,
+// followed by the ones coming meanwhile.
+func (k *k8sWatcher) Resume() {
+	k.pause.Lock()
+	defer k.pause.Unlock()
+
+	if !k.pause.paused {
+		return
+	}
+
+	k.pause.paused = false
+
+	if !k.pause.flushing {
+		k.pause.flushing = true
+		k.wg.Add(1)
+
+		go func() {
+			defer k.wg.Done()
+
+			k.flushPaused()
+		}()
+	}
+}
+
+// hold buffers results while the watcher is paused or delivering what it
+// buffered, reporting whether it did. When the buffer is full, it waits for
+// room or the watcher to stop, or drops the results when dropping them.
+func (k *k8sWatcher) hold(results []*registry.Result) bool {
+	for {
+		k.pause.Lock()
+		if !k.pause.paused && !k.pause.flushing {
+			k.pause.Unlock()
+			return false
+		}
+
+		if len(k.pause.pending) < maxPausedResults {
+			k.pause.pending = k.coalesce(k.pause.pending, results)
+			k.pause.Unlock()
+
+			return true
+		}
+
+		if k.dropOverflow {
+			k.pause.Unlock()
+			k.dropResults(len(results))
+
+			return true
+		}
+
+		if k.pause.room == nil {
+			k.pause.room = make(chan struct{})
+		}
+
+		room := k.pause.room
+		k.pause.Unlock()
+
+		select {
+		case <-k.done:
+			return true
+		case <-room:
+		}
+	}
+}
+
+// coalesce adds results to pending, replacing the ones for the same
+// service nodes in place. A service created then updated is still created.
+func (k *k8sWatcher) coalesce(pending, results []*registry.Result) []*registry.Result {
+	for _, result := range results {
+		key := resultKey(result)
+		replaced := false
+
+		for i, p := range pending {
+			if resultKey(p) != key {
+				continue
+			}
+
+			if p.Action == k.actions.Create && result.Action == k.actions.Update {
+				result = &registry.Result{Action: k.actions.Create, Service: result.Service}
+			}
+
+			pending[i] = result
+			replaced = true
+
+			break
+		}
+
+		if !replaced {
+			pending = append(pending, result)
+		}
+	}
+
+	return pending
+}
+
+// flushPaused delivers the buffered results until there are none left, or
+// the watcher is paused again.
+func (k *k8sWatcher) flushPaused() {
+	for {
+		k.pause.Lock()
+		if k.pause.paused || len(k.pause.pending) == 0 {
+			k.pause.flushing = false
+			k.pause.Unlock()
+
+			return
+		}
+
+		results := k.pause.pending
+		k.pause.pending = nil
+
+		if k.pause.room != nil {
+			close(k.pause.room)
+			k.pause.room = nil
+		}
+		k.pause.Unlock()
+
+		k.deliver(results)
+
+		select {
+		case <-k.done:
+			return
+		default:
+		}
+	}
+}
+
+// dropResults counts results dropped as the buffer overflowed.
+func (k *k8sWatcher) dropResults(n int) {
+	k.dropped.Add(uint64(n))
+	k.registry.counters.dropped.Add(uint64(n))
+	k.registry.logs.errorf("K8s Watcher: results buffered while paused overflowed, dropping results")
+}
+
+// DroppedEvents returns how many results the watcher dropped since the last
+// reset.
+func (k *k8sWatcher) DroppedEvents() uint64 {
+	return k.dropped.Load()
+}
+
+// ResetStats resets the counters of the watcher. The ones of the registry
+// keep counting.
+func (k *k8sWatcher) ResetStats() {
+	k.dropped.Store(0)
+}
+
+// DroppedEvents returns how many results watchers dropped.
+func (c *kregistry) DroppedEvents() uint64 {
+	return c.counters.dropped.Load()
+}
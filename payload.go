@@ -0,0 +1,111 @@
+package kubernetes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+var (
+	// used on pods as labels holding a service payload small enough for a
+	// label value, base64url encoded, eg: labelPayloadPrefix+"svc.name"
+	labelPayloadPrefix = "micro.mu/payload-"
+
+	// label values are at most 63 characters, starting and ending with an
+	// alphanumeric character.
+	labelValueRe = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?)?$`)
+)
+
+// labelPayload encodes a service payload as a label value, reporting
+// whether it fits one.
+func labelPayload(notation string) (string, bool) {
+	v := base64.RawURLEncoding.EncodeToString([]byte(notation))
+
+	return v, labelValueRe.MatchString(v)
+}
+
+// payloadsFromLabels copies the service payloads held in labels into the
+// annotations they are read from, unless set there already.
+func payloadsFromLabels(meta *client.Meta) {
+	if meta == nil {
+		return
+	}
+
+	for k, v := range meta.Labels {
+		if v == nil || !strings.HasPrefix(k, labelPayloadPrefix) {
+			continue
+		}
+
+		key := annotationServiceKeyPrefix + strings.TrimPrefix(k, labelPayloadPrefix)
+		if meta.Annotations[key] != nil {
+			continue
+		}
+
+		data, err := base64.RawURLEncoding.DecodeString(*v)
+		if err != nil {
+			continue
+		}
+
+		if meta.Annotations == nil {
+			meta.Annotations = make(map[string]*string)
+		}
+
+		notation := string(data)
+		meta.Annotations[key] = &notation
+	}
+}
+
+// readPayloads makes the service payloads held in the labels of pods
+// readable, when payloads may be stored in labels, and decrypts them when
+// configured.
+func (c *kregistry) readPayloads(pods []client.Pod) {
+	for _, pod := range pods {
+		if c.payloadLabels {
+			payloadsFromLabels(pod.Metadata)
+		}
+
+		c.cipher.openAnnotations(pod.Metadata, c.logs)
+		unwrapPayloads(pod.Metadata, c.logs)
+	}
+}
+
+// unwrapPayloads decodes the service payloads stored as a JSON string of
+// the notation rather than the notation itself, as some clients encode it
+// twice, warning about them as they would be dropped as corrupted otherwise.
+func unwrapPayloads(meta *client.Meta, logs *logThrottle) {
+	if meta == nil {
+		return
+	}
+
+	for key, v := range meta.Annotations {
+		if v == nil || !strings.HasPrefix(key, annotationServiceKeyPrefix) || !strings.HasPrefix(*v, `"`) {
+			continue
+		}
+
+		var notation string
+		if err := json.Unmarshal([]byte(*v), &notation); err != nil || !strings.HasPrefix(notation, "{") {
+			continue
+		}
+
+		logs.warnf("K8s Registry: payload %s of pod %s is double-encoded JSON, decoding it once more", key, meta.Name)
+
+		meta.Annotations[key] = &notation
+	}
+}
+
+// storePayload moves a service payload registered on a pod into a label
+// when it fits one, clearing its annotations, and otherwise clears the
+// label so only the annotations hold it.
+func storePayload(meta *client.Meta, name, notation string) {
+	v, ok := labelPayload(notation)
+	if !ok {
+		meta.Labels[labelPayloadPrefix+name] = nil
+		return
+	}
+
+	meta.Labels[labelPayloadPrefix+name] = &v
+	meta.Annotations = clearNotationAnnotations(name)
+}
@@ -0,0 +1,117 @@
+package kubernetes
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/api"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/watch"
+)
+
+// defaultPodNamesInterval is how often the named pods are got by default.
+const defaultPodNamesInterval = 30 * time.Second
+
+// namedPodsClient discovers services from a fixed set of pods, getting each
+// by name rather than listing and watching pods, for registries only
+// allowed to get pods. Watches end every interval without any event, so
+// watchers relist the pods then, emitting the changes.
+type namedPodsClient struct {
+	client.Kubernetes
+	names    []string
+	interval time.Duration
+}
+
+// ListPods gets the named pods matching the labels, skipping missing ones.
+func (c namedPodsClient) ListPods(labels map[string]string) (*client.PodList, error) {
+	list := &client.PodList{}
+
+	for _, name := range c.names {
+		pod, err := c.GetPod(name)
+		if errors.Is(err, api.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if pod.Metadata == nil || !labelsMatch(pod.Metadata.Labels, labels) {
+			continue
+		}
+
+		list.Items = append(list.Items, *pod)
+	}
+
+	return list, nil
+}
+
+// WatchPods returns a watch ending after the poll interval.
+func (c namedPodsClient) WatchPods(_ map[string]string) (watch.Watch, error) {
+	return newPollWatch(c.interval), nil
+}
+
+// WatchPod returns a watch ending after the poll interval.
+func (c namedPodsClient) WatchPod(_ string) (watch.Watch, error) {
+	return newPollWatch(c.interval), nil
+}
+
+// BreakerState returns the state of the circuit breaker of the client.
+func (c namedPodsClient) BreakerState() client.BreakerState {
+	if b, ok := c.Kubernetes.(client.Breaker); ok {
+		return b.BreakerState()
+	}
+
+	return client.BreakerClosed
+}
+
+// labelsMatch reports whether labels match a selector, an empty value only
+// requiring the label to be set.
+func labelsMatch(labels map[string]*string, selector map[string]string) bool {
+	for key, value := range selector {
+		v, ok := labels[key]
+		if !ok || v == nil || len(value) > 0 && *v != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// pollWatch is a watch without events, ending after an interval.
+type pollWatch struct {
+	results chan watch.Event
+	stop    chan struct{}
+	once    sync.Once
+}
+
+func newPollWatch(interval time.Duration) *pollWatch {
+	w := &pollWatch{
+		results: make(chan watch.Event),
+		stop:    make(chan struct{}),
+	}
+
+	go func() {
+		t := time.NewTimer(interval)
+		defer t.Stop()
+
+		select {
+		case <-t.C:
+		case <-w.stop:
+		}
+
+		close(w.results)
+	}()
+
+	return w
+}
+
+func (w *pollWatch) ResultChan() <-chan watch.Event {
+	return w.results
+}
+
+func (w *pollWatch) Stop() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+}
@@ -0,0 +1,50 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"go-micro.dev/v4/registry"
+)
+
+func TestPodNames(t *testing.T) {
+	setupPod("pod-2")
+	setupPod("pod-3")
+
+	// only allowed to get pods
+	kc := forbiddenClient{Client: mockClient}
+
+	r := NewRegistry(Client(kc), PodNames(50*time.Millisecond, "pod-1", "pod-2", "pod-missing"))
+	defer teardownRegistry()
+
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+	register(t, r, "pod-3", &registry.Service{Name: "foo.service", Version: "1"})
+
+	// pod-3 isn't named
+	services, err := r.GetService("foo.service")
+	if err != nil {
+		t.Fatalf("did not expect GetService() to fail: %v", err)
+	}
+
+	if len(services) != 1 || len(services[0].Nodes) != 1 || services[0].Nodes[0].Id != "foo.service:pod-1" {
+		t.Fatalf("expected the node of pod-1 only, got %+v", services)
+	}
+
+	services, err = r.ListServices()
+	if err != nil || len(services) != 1 {
+		t.Fatalf("expected foo.service to be listed, got %+v, %v", services, err)
+	}
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	// found once the pods are got again
+	register(t, r, "pod-2", &registry.Service{Name: "bar.service", Version: "1"})
+	expectAction(t, w, "bar.service", "create")
+
+	deregister(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1", Nodes: []*registry.Node{{Id: "foo.service:pod-1"}}})
+	expectAction(t, w, "foo.service", "delete")
+}
@@ -0,0 +1,52 @@
+package kubernetes
+
+import (
+	"go-micro.dev/v4/registry"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// reserved node metadata keys of the pod a node is discovered from. They
+// are set on every node found, replacing the values registered, if any.
+const (
+	MetadataPodName      = "micro.mu/pod"
+	MetadataPodNamespace = "micro.mu/namespace"
+)
+
+// PodResolver is implemented by the registry to get the pod a node found
+// was discovered from.
+type PodResolver interface {
+	PodForNode(node *registry.Node) (*client.Pod, error)
+}
+
+// podMetadata tags the nodes of a service with the pod they are from.
+func podMetadata(pod *client.Pod, svc *registry.Service) {
+	setNodeMetadata(svc, MetadataPodName, pod.Metadata.Name)
+
+	if len(pod.Metadata.Namespace) > 0 {
+		setNodeMetadata(svc, MetadataPodNamespace, pod.Metadata.Namespace)
+	}
+}
+
+// PodForNode gets the pod a node was discovered from, in its namespace and
+// cluster, if any.
+func (c *kregistry) PodForNode(node *registry.Node) (*client.Pod, error) {
+	name := node.Metadata[MetadataPodName]
+	if len(name) == 0 {
+		return nil, ErrNodeWithoutPod
+	}
+
+	kc := c.client
+
+	if member, ok := c.members[node.Metadata[metadataCluster]]; ok {
+		kc = member.client
+	}
+
+	if ns := node.Metadata[MetadataPodNamespace]; len(ns) > 0 {
+		if nc, ok := kc.(client.Namespacer); ok {
+			kc = nc.InNamespace(ns)
+		}
+	}
+
+	return kc.GetPod(name)
+}
@@ -0,0 +1,55 @@
+package kubernetes
+
+import (
+	"sort"
+	"strings"
+
+	"go-micro.dev/v4/registry"
+)
+
+// PrefixGetter is implemented by the registry to look up the services
+// named hierarchically under a prefix at once.
+type PrefixGetter interface {
+	GetServicesByPrefix(prefix string) ([]*registry.Service, error)
+}
+
+// GetServicesByPrefix returns the versions of the services named prefix or
+// under it, listing the service pods once. Names match at a dot boundary:
+// "com.acme.orders" matches "com.acme.orders" and "com.acme.orders.read",
+// not "com.acme.ordersv2". A trailing dot is ignored and an empty prefix
+// matches every service. Services are sorted by name, then version.
+func (c *kregistry) GetServicesByPrefix(prefix string) ([]*registry.Service, error) {
+	prefix = strings.TrimSuffix(c.normalize(prefix), ".")
+
+	services, err := c.ListServices()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := services[:0]
+
+	for _, svc := range services {
+		if underPrefix(svc.Name, prefix) {
+			matched = append(matched, svc)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].Name != matched[j].Name {
+			return matched[i].Name < matched[j].Name
+		}
+
+		return matched[i].Version < matched[j].Version
+	})
+
+	return matched, nil
+}
+
+// underPrefix reports whether a service name is the prefix or under it.
+func underPrefix(name, prefix string) bool {
+	if len(prefix) == 0 || name == prefix {
+		return true
+	}
+
+	return strings.HasPrefix(name, prefix+".")
+}
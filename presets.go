@@ -0,0 +1,50 @@
+package kubernetes
+
+import (
+	"go-micro.dev/v4/registry"
+)
+
+// presetOptions are the defaults of the preset constructors, applied
+// before the options given so those override them.
+func presetOptions() []registry.Option {
+	return []registry.Option{
+		// payloads missing a name or nodes are not returned
+		ValidatePayloads(true),
+	}
+}
+
+// NewInCluster creates a registry talking to the API server of the cluster
+// it runs in, through the mounted service account, whatever the registry
+// addresses. Client options, such as the in-cluster overrides, are given
+// with ClientOptions.
+func NewInCluster(opts ...registry.Option) registry.Registry {
+	opts = append(presetOptions(), opts...)
+	opts = append(opts, func(o *registry.Options) {
+		setOption(o, inClusterKey{}, true)
+	})
+
+	return NewRegistry(opts...)
+}
+
+// NewFromKubeconfig creates a registry talking to the API server of the
+// current context of a kubeconfig file, as kubectl does, for services run
+// out of the cluster. An empty path means the first file of KUBECONFIG, or
+// else ~/.kube/config. It fails when the kubeconfig can't be used.
+func NewFromKubeconfig(path string, opts ...registry.Option) (registry.Registry, error) {
+	k := &kregistry{
+		options:    registry.Options{},
+		instanceID: newInstanceID(),
+		counters:   &registryCounters{},
+	}
+
+	opts = append(presetOptions(), opts...)
+	opts = append(opts, func(o *registry.Options) {
+		setOption(o, kubeconfigKey{}, path)
+	})
+
+	if err := configure(k, opts...); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
@@ -0,0 +1,122 @@
+package kubernetes
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// apiServer serves empty pod lists over TLS, recording the path and
+// authorization of the last request.
+func apiServer(t *testing.T) (srv *httptest.Server, path, auth *string) {
+	t.Helper()
+
+	path, auth = new(string), new(string)
+
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*path = r.URL.Path
+		*auth = r.Header.Get("Authorization")
+		fmt.Fprint(w, `{"items":[]}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, path, auth
+}
+
+func TestNewFromKubeconfig(t *testing.T) {
+	srv, path, auth := apiServer(t)
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`
+apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: %s
+    certificate-authority-data: %s
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: dev
+    namespace: team
+users:
+- name: dev
+  user:
+    token: secret
+`, srv.URL, base64.StdEncoding.EncodeToString(ca))), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewFromKubeconfig(kubeconfig)
+	if err != nil {
+		t.Fatalf("did not expect NewFromKubeconfig() to fail: %v", err)
+	}
+
+	if !r.(*kregistry).validate {
+		t.Fatal("expected the preset to validate payloads")
+	}
+
+	if _, err := r.ListServices(); err != nil {
+		t.Fatalf("did not expect ListServices() to fail: %v", err)
+	}
+
+	if *path != "/api/v1/namespaces/team/pods/" || *auth != "Bearer secret" {
+		t.Fatalf("expected the kubeconfig context to be used, got path %q and authorization %q", *path, *auth)
+	}
+
+	if _, err := NewFromKubeconfig(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("expected NewFromKubeconfig() to fail without a kubeconfig")
+	}
+}
+
+func TestNewInCluster(t *testing.T) {
+	srv, path, auth := apiServer(t)
+
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		return p
+	}
+
+	ca := write("ca.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}))
+	token := write("token", []byte("secret"))
+	ns := write("namespace", []byte("team"))
+
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the registry addresses are ignored in-cluster
+	r := NewInCluster(
+		registry.Addrs("http://127.0.0.1:1"),
+		ClientOptions(client.InClusterHost(host), client.InClusterPort(port), client.TokenPath(token), client.CAPath(ca), client.NamespacePath(ns)),
+	)
+
+	if _, err := r.ListServices(); err != nil {
+		t.Fatalf("did not expect ListServices() to fail: %v", err)
+	}
+
+	if *path != "/api/v1/namespaces/team/pods/" || *auth != "Bearer secret" {
+		t.Fatalf("expected the in-cluster client to be used, got path %q and authorization %q", *path, *auth)
+	}
+}
@@ -0,0 +1,90 @@
+package kubernetes
+
+import (
+	"maps"
+	"net"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// readyNodes drops the nodes of a service run by a pod at ports not ready,
+// when gating ports on readiness. Ports without a gate of their own are
+// gated on the pod being ready.
+func (c *kregistry) readyNodes(pod *client.Pod, svc *registry.Service) {
+	if len(c.portGates) == 0 {
+		return
+	}
+
+	nodes := svc.Nodes[:0:0]
+
+	for _, node := range svc.Nodes {
+		if portReady(pod, c.portGate(node.Address)) {
+			nodes = append(nodes, node)
+		}
+	}
+
+	svc.Nodes = nodes
+}
+
+// portGate returns the gate of the port of an address, the pod Ready
+// condition unless it has its own.
+func (c *kregistry) portGate(address string) string {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return podReadyCondition
+	}
+
+	if gate, ok := c.portGates[port]; ok {
+		return gate
+	}
+
+	return podReadyCondition
+}
+
+// portReady reports whether a gate of a pod is open: the pod condition of
+// that type is true, or else the container of that name is ready. The pod
+// Ready condition is open when unknown, as podReady.
+func portReady(pod *client.Pod, gate string) bool {
+	if pod.Status == nil {
+		return false
+	}
+
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == gate {
+			return cond.Status == "True"
+		}
+	}
+
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == gate {
+			return cs.Ready
+		}
+	}
+
+	return gate == podReadyCondition
+}
+
+// portReadinessChanged reports whether any gate of a pod opened or closed
+// since cached, when gating ports on readiness.
+func (k *k8sWatcher) portReadinessChanged(pod *client.Pod, cache *client.Pod) bool {
+	gates := k.registry.portGates
+	if len(gates) == 0 {
+		return false
+	}
+
+	return !maps.Equal(gatesReady(pod, gates), gatesReady(cache, gates))
+}
+
+// gatesReady returns which gates of a pod are open, the pod Ready condition
+// included.
+func gatesReady(pod *client.Pod, gates map[string]string) map[string]bool {
+	ready := map[string]bool{podReadyCondition: portReady(pod, podReadyCondition)}
+
+	for _, gate := range gates {
+		ready[gate] = portReady(pod, gate)
+	}
+
+	return ready
+}
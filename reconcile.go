@@ -0,0 +1,133 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/pkg/errors"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// Reconciler is implemented by the registry to sync the services registered
+// on the pod this service runs in with a desired set, such as in the
+// reconcile loop of a controller.
+type Reconciler interface {
+	// Reconcile registers the desired services not registered as desired,
+	// and deregisters the ones registered but not desired, leaving the
+	// others untouched. Reconciling the same set again changes nothing.
+	Reconcile(desired []*registry.Service) error
+}
+
+// Reconcile applies the difference between the desired services and the
+// ones registered on the self pod.
+func (c *kregistry) Reconcile(desired []*registry.Service) error {
+	podName, err := getPodName()
+	if err != nil {
+		return errors.Wrap(err, "failed to reconcile")
+	}
+
+	current, err := c.registered(podName)
+	if err != nil {
+		return errors.Wrap(err, "failed to reconcile")
+	}
+
+	wanted := make(map[string]*registry.Service, len(desired))
+	for _, s := range desired {
+		wanted[c.normalize(s.Name)] = s
+	}
+
+	for _, name := range sortedNames(wanted) {
+		s := wanted[name]
+
+		if svc, ok := current[name]; ok && sameRegistration(svc, c.withNodeIDs(podName, c.withName(s))) {
+			continue
+		}
+
+		if err := c.Register(s); err != nil {
+			return errors.Wrapf(err, "failed to reconcile %s", s.Name)
+		}
+	}
+
+	for _, name := range sortedNames(current) {
+		if _, ok := wanted[name]; ok {
+			continue
+		}
+
+		if err := c.Deregister(current[name]); err != nil {
+			return errors.Wrapf(err, "failed to reconcile %s", name)
+		}
+	}
+
+	return nil
+}
+
+// registered returns the services registered on a pod, by name.
+func (c *kregistry) registered(podName string) (map[string]*registry.Service, error) {
+	p, err := c.client.GetPod(podName)
+	if err != nil {
+		return nil, selfPodErr(podName, err)
+	}
+
+	services := make(map[string]*registry.Service)
+
+	if p.Metadata == nil {
+		return services, nil
+	}
+
+	c.readPayloads([]client.Pod{*p})
+
+	for key := range p.Metadata.Annotations {
+		if !strings.HasPrefix(key, annotationServiceKeyPrefix) {
+			continue
+		}
+
+		data, err := notation(p.Metadata, key)
+		if err != nil {
+			continue
+		}
+
+		svc, err := c.decodeService(data)
+		if err != nil || len(svc.Nodes) == 0 {
+			continue
+		}
+
+		services[c.normalize(svc.Name)] = svc
+	}
+
+	return services, nil
+}
+
+// sameRegistration reports whether a registered service holds what
+// registering another would, endpoints aside as they aren't stored.
+func sameRegistration(registered, s *registry.Service) bool {
+	a, b := *registered, *s
+	a.Endpoints, b.Endpoints = nil, nil
+
+	ja, err := json.Marshal(&a)
+	if err != nil {
+		return false
+	}
+
+	jb, err := json.Marshal(&b)
+	if err != nil {
+		return false
+	}
+
+	return string(ja) == string(jb)
+}
+
+// sortedNames returns the names of services, sorted.
+func sortedNames(services map[string]*registry.Service) []string {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
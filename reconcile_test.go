@@ -0,0 +1,98 @@
+package kubernetes
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/mock"
+)
+
+// patchRecordingClient records the services registered, as +name, and
+// deregistered, as -name, by the patches of pods.
+type patchRecordingClient struct {
+	*mock.Client
+	patched []string
+}
+
+func (c *patchRecordingClient) UpdatePod(podName string, pod *client.Pod) (*client.Pod, error) {
+	for key, v := range pod.Metadata.Labels {
+		if !strings.HasPrefix(key, svcSelectorPrefix) {
+			continue
+		}
+
+		if v == nil {
+			c.patched = append(c.patched, "-"+strings.TrimPrefix(key, svcSelectorPrefix))
+		} else {
+			c.patched = append(c.patched, "+"+strings.TrimPrefix(key, svcSelectorPrefix))
+		}
+	}
+
+	return c.Client.UpdatePod(podName, pod)
+}
+
+func TestReconcile(t *testing.T) {
+	kc := &patchRecordingClient{Client: mockClient}
+	r := NewRegistry(Client(kc))
+	defer teardownRegistry()
+
+	foo := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", foo)
+	register(t, r, "pod-1", &registry.Service{Name: "bar.service", Version: "1"})
+	register(t, r, "pod-1", &registry.Service{Name: "baz.service", Version: "1"})
+
+	node := func(port string) []*registry.Node {
+		return []*registry.Node{{Id: "pod-1", Address: "10.0.0.1:" + port, Metadata: map[string]string{}}}
+	}
+
+	desired := []*registry.Service{
+		// unchanged
+		{Name: "foo.service", Version: "1", Nodes: foo.Nodes},
+		// changed
+		{Name: "bar.service", Version: "2", Nodes: node("80")},
+		// missing
+		{Name: "qux.service", Version: "1", Nodes: node("81")},
+	}
+
+	// baz.service extra
+	kc.patched = nil
+
+	if err := r.(Reconciler).Reconcile(desired); err != nil {
+		t.Fatalf("did not expect Reconcile() to fail: %v", err)
+	}
+
+	sort.Strings(kc.patched)
+
+	if want := []string{"+bar.service", "+qux.service", "-baz.service"}; !reflect.DeepEqual(kc.patched, want) {
+		t.Fatalf("expected only the diff %v to be applied, got %v", want, kc.patched)
+	}
+
+	services, err := r.ListServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(services) != 3 {
+		t.Fatalf("expected the desired services only, got %v", services)
+	}
+
+	bar, err := r.GetService("bar.service")
+	if err != nil || len(bar) != 1 || bar[0].Version != "2" {
+		t.Fatalf("expected bar.service to be updated, got %v: %v", bar, err)
+	}
+
+	// reconciled already
+	kc.patched = nil
+
+	if err := r.(Reconciler).Reconcile(desired); err != nil {
+		t.Fatalf("did not expect Reconcile() to fail: %v", err)
+	}
+
+	if len(kc.patched) > 0 {
+		t.Fatalf("expected reconciling again to change nothing, got %v", kc.patched)
+	}
+}
@@ -0,0 +1,165 @@
+package kubernetes
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-micro.dev/v4/registry"
+)
+
+// restarts holds back the deletes of the services of pods for a window, to
+// turn a delete followed by a create of the same service on the same pods,
+// as when a pod restarts in place, into a single replace.
+type restarts struct {
+	mtx    sync.Mutex
+	window time.Duration
+	// the deletes held back, by restartKey.
+	pending map[string]*heldDelete
+}
+
+// heldDelete is a delete held back, delivered once its timer fires.
+type heldDelete struct {
+	result *registry.Result
+	timer  *time.Timer
+}
+
+func newRestarts(window time.Duration) *restarts {
+	return &restarts{window: window, pending: make(map[string]*heldDelete)}
+}
+
+// restartKey identifies a version of a service on the pods its nodes are
+// from, the same before and after the pods restart.
+func restartKey(r *registry.Result) string {
+	pods := make([]string, 0, len(r.Service.Nodes))
+	for _, node := range r.Service.Nodes {
+		pods = append(pods, node.Metadata[MetadataPodNamespace]+"/"+node.Metadata[MetadataPodName])
+	}
+
+	sort.Strings(pods)
+
+	return r.Service.Name + "\x00" + r.Service.Version + "\x00" + strings.Join(pods, "\x00")
+}
+
+// coalesceRestarts returns the results to deliver now: deletes are held
+// back for the window, a create of the same service on the same pods
+// meanwhile replacing both by a replace with the nodes created, followed by
+// a delete of the nodes not created again, if any.
+// Deleting it again holds the latest delete for the window instead, and
+// other results of a service whose delete is held back deliver it first.
+func (k *k8sWatcher) coalesceRestarts(results []*registry.Result) []*registry.Result {
+	if k.restarts == nil {
+		return results
+	}
+
+	r := k.restarts
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	deliver := make([]*registry.Result, 0, len(results))
+
+	for _, result := range results {
+		key := restartKey(result)
+
+		held, ok := r.pending[key]
+		if ok {
+			held.timer.Stop()
+			delete(r.pending, key)
+		}
+
+		switch {
+		// deleted again, as pods not running are on every change, with the
+		// nodes registered meanwhile added to the ones held
+		case result.Action == k.actions.Delete:
+			if ok {
+				result = withHeldNodes(result, held.result)
+			}
+
+			r.pending[key] = &heldDelete{
+				result: result,
+				timer:  time.AfterFunc(r.window, func() { k.releaseDelete(key, result) }),
+			}
+		case ok && result.Action == k.actions.Create:
+			deliver = append(deliver, &registry.Result{Action: k.replaceAction(), Service: result.Service})
+
+			// consumers merge the nodes of replaces into the ones they
+			// hold, the nodes not created again, as restarted processes
+			// register under new IDs, are deleted once replaced.
+			if gone := goneNodes(held.result.Service, result.Service); len(gone) > 0 {
+				svc := *held.result.Service
+				svc.Nodes = gone
+				deliver = append(deliver, &registry.Result{Action: held.result.Action, Service: &svc})
+			}
+		case ok:
+			deliver = append(deliver, held.result, result)
+		default:
+			deliver = append(deliver, result)
+		}
+	}
+
+	return deliver
+}
+
+// withHeldNodes returns a delete with the nodes of a delete held back too.
+func withHeldNodes(result, held *registry.Result) *registry.Result {
+	svc := *result.Service
+	svc.Nodes = append(append([]*registry.Node(nil), svc.Nodes...), goneNodes(held.Service, result.Service)...)
+
+	return &registry.Result{Action: result.Action, Service: &svc}
+}
+
+// releaseDelete delivers a delete held back once its window is over, unless
+// a result of the same service took it meanwhile.
+func (k *k8sWatcher) releaseDelete(key string, result *registry.Result) {
+	r := k.restarts
+
+	r.mtx.Lock()
+	held, ok := r.pending[key]
+	if !ok || held.result != result {
+		r.mtx.Unlock()
+		return
+	}
+
+	delete(r.pending, key)
+
+	// added while locked, so stopping waits for it once past stopRestarts
+	k.wg.Add(1)
+	r.mtx.Unlock()
+
+	defer k.wg.Done()
+
+	results := []*registry.Result{result}
+	if k.hold(results) {
+		return
+	}
+
+	k.deliver(results)
+}
+
+// stopRestarts drops the deletes held back, as the watcher stops, before
+// waiting for the deletes being delivered.
+func (k *k8sWatcher) stopRestarts() {
+	if k.restarts == nil {
+		return
+	}
+
+	k.restarts.mtx.Lock()
+	defer k.restarts.mtx.Unlock()
+
+	for key, held := range k.restarts.pending {
+		held.timer.Stop()
+		delete(k.restarts.pending, key)
+	}
+}
+
+// replaceAction returns the action of the results replacing the nodes of a
+// service at once, an update unless set.
+func (k *k8sWatcher) replaceAction() string {
+	if len(k.actions.Replace) > 0 {
+		return k.actions.Replace
+	}
+
+	return k.actions.Update
+}
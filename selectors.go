@@ -0,0 +1,410 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/pkg/errors"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/watch"
+)
+
+// serviceTarget returns the selector of a Kubernetes service, and the port
+// its first port targets on the pods selected.
+func (c *kregistry) serviceTarget(kubeService string) (map[string]string, string, error) {
+	sg, ok := c.client.(client.ServiceGetter)
+	if !ok {
+		return nil, "", ErrServicesUnsupported
+	}
+
+	ks, err := sg.GetService(kubeService)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "failed to get service %s", kubeService)
+	}
+
+	// services without a selector select no pod, their endpoints are
+	// managed by others.
+	if len(ks.Spec.Selector) == 0 || len(ks.Spec.Ports) == 0 {
+		return nil, "", errors.Wrapf(ErrNoServiceSelector, "service %s", kubeService)
+	}
+
+	p := ks.Spec.Ports[0]
+	if len(p.TargetPort.StrVal) > 0 {
+		return nil, "", errors.Errorf("named target port %s of service %s is not supported", p.TargetPort.StrVal, kubeService)
+	}
+
+	port := p.TargetPort.IntVal
+	if port == 0 {
+		port = p.Port
+	}
+
+	return ks.Spec.Selector, strconv.Itoa(port), nil
+}
+
+// selectedService builds a service from the pods a Kubernetes service
+// selects, with a node per ready pod at the port the service targets.
+func (c *kregistry) selectedService(name, kubeService string) ([]*registry.Service, error) {
+	selector, port, err := c.serviceTarget(kubeService)
+	if err != nil {
+		return nil, err
+	}
+
+	pods, err := c.client.ListPods(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	svcs := c.selectedVersions(name, port, pods.Items)
+	if len(svcs) == 0 {
+		return nil, registry.ErrNotFound
+	}
+
+	return serviceList(svcs), nil
+}
+
+// selectedVersions builds the versions of a service from the pods selected,
+// by version.
+func (c *kregistry) selectedVersions(name, port string, pods []client.Pod) map[string]*registry.Service {
+	// svcs mapped by version, with the pod their metadata is from
+	svcs := make(map[string]*registry.Service)
+	owners := make(map[string]string)
+
+	for _, pod := range pods {
+		if pod.Status == nil || pod.Status.Phase != podRunning || len(pod.Status.PodIP) == 0 ||
+			c.terminating(&pod) || !podReady(&pod) || !c.included(&pod) {
+			continue
+		}
+
+		svc := &registry.Service{
+			Name: name,
+			Nodes: []*registry.Node{{
+				Id:       pod.Metadata.Name,
+				Address:  net.JoinHostPort(pod.Status.PodIP, port),
+				Metadata: make(map[string]string),
+			}},
+		}
+
+		c.nodeMetadata(&pod, svc)
+		c.mergeService(svcs, owners, svc.Version, pod.Metadata.Name, svc)
+	}
+
+	return svcs
+}
+
+// selectorWatcher watches a service discovered through the selector of a
+// Kubernetes service, keeping the pods the selector matches up to date from
+// their events to emit the nodes of the versions created, updated or
+// deleted. The Kubernetes service is only got again when the watch ends.
+type selectorWatcher struct {
+	registry    *kregistry
+	name        string
+	kubeService string
+
+	next chan *registry.Result
+	done chan struct{}
+	once sync.Once
+
+	// the selector and target port of the Kubernetes service, and the pods
+	// it selects, by name.
+	selector map[string]string
+	port     string
+	pods     map[string]client.Pod
+
+	// the versions last built, by version.
+	versions map[string]*registry.Service
+}
+
+func newSelectorWatcher(kr *kregistry, name, kubeService string) (*selectorWatcher, error) {
+	w := &selectorWatcher{
+		registry:    kr,
+		name:        name,
+		kubeService: kubeService,
+		next:        make(chan *registry.Result),
+		done:        make(chan struct{}),
+	}
+
+	// only the changes from now on are emitted
+	if err := w.list(); err != nil {
+		return nil, err
+	}
+
+	w.versions = w.build()
+
+	pw, err := kr.client.WatchPods(w.selector)
+	if err != nil {
+		return nil, err
+	}
+
+	go w.run(pw)
+
+	return w, nil
+}
+
+// list gets the selector of the Kubernetes service and the pods it selects.
+func (w *selectorWatcher) list() error {
+	selector, port, err := w.registry.serviceTarget(w.kubeService)
+	if err != nil {
+		return err
+	}
+
+	pods, err := w.registry.client.ListPods(selector)
+	if err != nil {
+		return err
+	}
+
+	w.selector, w.port = selector, port
+	w.pods = make(map[string]client.Pod, len(pods.Items))
+
+	for _, pod := range pods.Items {
+		if pod.Metadata != nil {
+			w.pods[pod.Metadata.Name] = pod
+		}
+	}
+
+	return nil
+}
+
+// build returns the versions of the service built from the pods selected,
+// by version, the pods in order of name so nodes are too.
+func (w *selectorWatcher) build() map[string]*registry.Service {
+	names := make([]string, 0, len(w.pods))
+	for name := range w.pods {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	pods := make([]client.Pod, 0, len(names))
+	for _, name := range names {
+		pods = append(pods, w.pods[name])
+	}
+
+	return w.registry.selectedVersions(w.name, w.port, pods)
+}
+
+// run updates the service on every event until stopped, listing the pods
+// again whenever the watch ends.
+func (w *selectorWatcher) run(pw watch.Watch) {
+	defer close(w.next)
+
+	for w.consume(pw) {
+		if pw = w.rewatch(); pw == nil {
+			return
+		}
+
+		// catch up with the changes missed meanwhile
+		if !w.update() {
+			pw.Stop()
+			return
+		}
+	}
+}
+
+// consume updates the service on every event of a watch, it returns false
+// once the watcher is stopped, and true when the watch ends.
+func (w *selectorWatcher) consume(pw watch.Watch) bool {
+	defer pw.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return false
+		case event, ok := <-pw.ResultChan():
+			if !ok {
+				return true
+			}
+
+			if !w.apply(event) {
+				continue
+			}
+
+			if !w.update() {
+				return false
+			}
+		}
+	}
+}
+
+// apply updates the pods selected from an event, reporting whether it
+// changed them. Pods no longer matching the selector are dropped.
+func (w *selectorWatcher) apply(event watch.Event) bool {
+	if event.Type != watch.Added && event.Type != watch.Modified && event.Type != watch.Deleted {
+		return false
+	}
+
+	var pod client.Pod
+	if err := json.Unmarshal(event.Object, &pod); err != nil || pod.Metadata == nil {
+		return false
+	}
+
+	if event.Type == watch.Deleted || !labelsMatch(pod.Metadata.Labels, w.selector) {
+		if _, ok := w.pods[pod.Metadata.Name]; !ok {
+			return false
+		}
+
+		delete(w.pods, pod.Metadata.Name)
+
+		return true
+	}
+
+	w.pods[pod.Metadata.Name] = pod
+
+	return true
+}
+
+// rewatch lists and watches the pods of the service again, backing off
+// between attempts, until it does or the watcher is stopped, returning nil
+// then.
+func (w *selectorWatcher) rewatch() watch.Watch {
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-w.done:
+			return nil
+		case <-time.After(w.registry.reconnectBackoff.NextDelay(attempt)):
+		}
+
+		if err := w.list(); err != nil {
+			w.registry.logs.errorf("K8s Watcher: failed to re-establish watch of service %s: %v", w.kubeService, err)
+			continue
+		}
+
+		pw, err := w.registry.client.WatchPods(w.selector)
+		if err != nil {
+			w.registry.logs.errorf("K8s Watcher: failed to re-establish watch of service %s: %v", w.kubeService, err)
+			continue
+		}
+
+		return pw
+	}
+}
+
+// update builds the service and emits what changed, it returns false once
+// the watcher is stopped. Nodes removed from a version are deleted, as
+// consumers merge the nodes of updates into the ones they hold.
+func (w *selectorWatcher) update() bool {
+	versions := w.build()
+
+	var removed, results []*registry.Result
+
+	for v, old := range w.versions {
+		if _, ok := versions[v]; !ok {
+			removed = append(removed, &registry.Result{Action: w.registry.actions.Delete, Service: old})
+		}
+	}
+
+	for v, svc := range versions {
+		old, ok := w.versions[v]
+		if !ok {
+			results = append(results, &registry.Result{Action: w.registry.actions.Create, Service: svc})
+			continue
+		}
+
+		gone := goneNodes(old, svc)
+
+		// what is left of the version once its nodes gone are deleted
+		kept := *old
+		kept.Nodes = keptNodes(old.Nodes, gone)
+
+		if !reflect.DeepEqual(&kept, svc) {
+			results = append(results, &registry.Result{Action: w.registry.actions.Update, Service: svc})
+		}
+
+		if len(gone) > 0 {
+			deleted := *old
+			deleted.Nodes = gone
+			results = append(results, &registry.Result{Action: w.registry.actions.Delete, Service: &deleted})
+		}
+	}
+
+	w.versions = versions
+
+	// versions deleted first, then by version, the nodes of a version
+	// updated before the ones gone are deleted so it is never empty.
+	sort.SliceStable(removed, func(i, j int) bool {
+		return removed[i].Service.Version < removed[j].Service.Version
+	})
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Service.Version < results[j].Service.Version
+	})
+
+	for _, result := range append(removed, results...) {
+		// consumers may append to the nodes received
+		svc := *result.Service
+		svc.Nodes = append([]*registry.Node(nil), svc.Nodes...)
+		result.Service = &svc
+
+		select {
+		case <-w.done:
+			return false
+		case w.next <- result:
+		}
+	}
+
+	return true
+}
+
+// goneNodes returns the nodes of a version no longer in it, by ID.
+func goneNodes(old, svc *registry.Service) []*registry.Node {
+	ids := make(map[string]bool, len(svc.Nodes))
+	for _, node := range svc.Nodes {
+		ids[node.Id] = true
+	}
+
+	var gone []*registry.Node
+
+	for _, node := range old.Nodes {
+		if !ids[node.Id] {
+			gone = append(gone, node)
+		}
+	}
+
+	return gone
+}
+
+// keptNodes returns the nodes but the ones gone.
+func keptNodes(nodes, gone []*registry.Node) []*registry.Node {
+	ids := make(map[string]bool, len(gone))
+	for _, node := range gone {
+		ids[node.Id] = true
+	}
+
+	kept := make([]*registry.Node, 0, len(nodes))
+
+	for _, node := range nodes {
+		if !ids[node.Id] {
+			kept = append(kept, node)
+		}
+	}
+
+	return kept
+}
+
+// Next blocks until the service changes.
+func (w *selectorWatcher) Next() (*registry.Result, error) {
+	select {
+	case <-w.done:
+		return nil, ErrWatcherStopped
+	case r, ok := <-w.next:
+		if !ok {
+			return nil, ErrWatcherStopped
+		}
+
+		return r, nil
+	}
+}
+
+// Stop stops the watcher.
+func (w *selectorWatcher) Stop() {
+	w.once.Do(func() {
+		close(w.done)
+	})
+}
@@ -0,0 +1,177 @@
+package kubernetes
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"go-micro.dev/v4/registry"
+	"go-micro.dev/v4/registry/cache"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+func TestServiceSelectors(t *testing.T) {
+	r := setupRegistry(ServiceSelectors(map[string]string{"orders.service": "orders"}))
+	defer teardownRegistry()
+
+	mockClient.Services["orders"] = &client.Service{
+		Metadata: &client.Meta{Name: "orders"},
+		Spec: client.ServiceSpec{
+			Selector: map[string]string{"app": "orders"},
+			Ports:    []client.ServicePort{{Port: 80, TargetPort: client.IntOrString{IntVal: 8080}}},
+		},
+	}
+
+	app := "orders"
+	other := "payments"
+
+	selected := setupPod("pod-1")
+	selected.Metadata.Labels["app"] = &app
+	setupPod("pod-2").Metadata.Labels["app"] = &other
+	setupPod("pod-3")
+
+	services, err := r.GetService("orders.service")
+	if err != nil {
+		t.Fatalf("did not expect GetService() to fail: %v", err)
+	}
+
+	if len(services) != 1 || len(services[0].Nodes) != 1 {
+		t.Fatalf("expected one service with a node, got %+v", services)
+	}
+
+	if node := services[0].Nodes[0]; node.Id != "pod-1" || node.Address != selected.Status.PodIP+":8080" {
+		t.Fatalf("expected a node of pod-1 at the target port, got %s at %s", node.Id, node.Address)
+	}
+
+	w, err := r.Watch(registry.WatchService("orders.service"))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	// another pod is selected
+	if _, err := mockClient.UpdatePod("pod-3", &client.Pod{
+		Metadata: &client.Meta{Labels: map[string]*string{"app": &app}},
+	}); err != nil {
+		t.Fatalf("failed to update pod: %v", err)
+	}
+
+	res := expectAction(t, w, "orders.service", "update")
+	if len(res.Service.Nodes) != 2 {
+		t.Fatalf("expected the nodes of both pods selected, got %d", len(res.Service.Nodes))
+	}
+
+	if _, err := r.GetService("payments.service"); err != registry.ErrNotFound {
+		t.Fatalf("expected services not mapped looked up by annotations, got %v", err)
+	}
+}
+
+// watchSignalingRegistry signals once watches are started.
+type watchSignalingRegistry struct {
+	registry.Registry
+	watching chan struct{}
+}
+
+func (r watchSignalingRegistry) Watch(opts ...registry.WatchOption) (registry.Watcher, error) {
+	w, err := r.Registry.Watch(opts...)
+
+	select {
+	case r.watching <- struct{}{}:
+	default:
+	}
+
+	return w, err
+}
+
+func TestServiceSelectorsScaleDown(t *testing.T) {
+	r := setupRegistry(ServiceSelectors(map[string]string{"orders.service": "orders"}))
+	defer teardownRegistry()
+
+	mockClient.Services["orders"] = &client.Service{
+		Metadata: &client.Meta{Name: "orders"},
+		Spec: client.ServiceSpec{
+			Selector: map[string]string{"app": "orders"},
+			Ports:    []client.ServicePort{{Port: 80, TargetPort: client.IntOrString{IntVal: 8080}}},
+		},
+	}
+
+	app := "orders"
+
+	for _, name := range []string{"pod-1", "pod-2"} {
+		setupPod(name).Metadata.Labels["app"] = &app
+	}
+
+	setupPod("pod-3")
+
+	// consumers merge updates into the nodes they hold
+	watching := make(chan struct{}, 1)
+	c := cache.New(watchSignalingRegistry{Registry: r, watching: watching})
+	defer c.Stop()
+
+	nodes := func() []string {
+		services, err := c.GetService("orders.service")
+		if err != nil || len(services) != 1 {
+			return nil
+		}
+
+		ids := make([]string, 0, len(services[0].Nodes))
+		for _, node := range services[0].Nodes {
+			ids = append(ids, node.Id)
+		}
+
+		sort.Strings(ids)
+
+		return ids
+	}
+
+	eventually := func(want ...string) {
+		t.Helper()
+
+		deadline := time.After(time.Second)
+
+		for {
+			ids := nodes()
+			if reflect.DeepEqual(ids, want) {
+				return
+			}
+
+			select {
+			case <-deadline:
+				t.Fatalf("expected the nodes of %v, got %v", want, ids)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	eventually("pod-1", "pod-2")
+
+	select {
+	case <-watching:
+	case <-time.After(time.Second):
+		t.Fatal("expected the cache to watch the service")
+	}
+
+	// scaled up once the cache watches the service
+	if _, err := mockClient.UpdatePod("pod-3", &client.Pod{
+		Metadata: &client.Meta{Labels: map[string]*string{"app": &app}},
+	}); err != nil {
+		t.Fatalf("failed to update pod: %v", err)
+	}
+
+	eventually("pod-1", "pod-2", "pod-3")
+
+	// scaled down to a single pod
+	if err := mockClient.DeletePod("pod-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := mockClient.UpdatePod("pod-3", &client.Pod{
+		Metadata: &client.Meta{Labels: map[string]*string{"app": nil}},
+	}); err != nil {
+		t.Fatalf("failed to update pod: %v", err)
+	}
+
+	eventually("pod-1")
+}
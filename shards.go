@@ -0,0 +1,141 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+var (
+	// annotationShardSize is the largest service notation held by a single
+	// annotation, larger ones are split across shard annotations.
+	annotationShardSize = 64 * 1024
+
+	// at most as many shards as fit the 256KiB the API server allows for
+	// all the annotations of an object.
+	maxAnnotationShards = 4
+
+	// used on pods to hold a shard of a serialized micro service, eg:
+	// annotationShardKeyPrefix+"0-svc.name". It is kept as short as the
+	// selector label prefix so any service name fits.
+	annotationShardKeyPrefix = "micro.mu/shard-"
+
+	// starts the notation of a sharded service, listing its shards.
+	shardManifestPrefix = `{"shards":`
+)
+
+// errShardsIncomplete is returned decoding a sharded notation missing some
+// of its shards, not fully written yet.
+var errShardsIncomplete = errors.New("incomplete service notation shards")
+
+// shardManifest replaces the notation of a sharded service.
+type shardManifest struct {
+	Shards   int    `json:"shards"`
+	Checksum string `json:"checksum"`
+}
+
+func shardKey(name string, i int) string {
+	return annotationShardKeyPrefix + strconv.Itoa(i) + "-" + name
+}
+
+func checksum(data string) string {
+	h := fnv.New64a()
+	//nolint:errcheck
+	h.Write([]byte(data))
+
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// notationAnnotations returns the annotations holding the notation of a
+// service, keyed by the sanitized service name. Notations too large for an
+// annotation are split across shards, while unused shards are removed.
+func notationAnnotations(name, notation string) (map[string]*string, error) {
+	annotations := make(map[string]*string, maxAnnotationShards+1)
+
+	for i := 0; i < maxAnnotationShards; i++ {
+		annotations[shardKey(name, i)] = nil
+	}
+
+	if len(notation) <= annotationShardSize {
+		annotations[annotationServiceKeyPrefix+name] = &notation
+		return annotations, nil
+	}
+
+	shards := (len(notation) + annotationShardSize - 1) / annotationShardSize
+	if shards > maxAnnotationShards {
+		return nil, errors.Wrapf(ErrServiceTooLarge, "%d bytes", len(notation))
+	}
+
+	for i := 0; i < shards; i++ {
+		shard := notation[i*annotationShardSize : min((i+1)*annotationShardSize, len(notation))]
+		annotations[shardKey(name, i)] = &shard
+	}
+
+	b, err := json.Marshal(shardManifest{Shards: shards, Checksum: checksum(notation)})
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := string(b)
+	annotations[annotationServiceKeyPrefix+name] = &manifest
+
+	return annotations, nil
+}
+
+// clearNotationAnnotations returns the annotations removing the notation of
+// a service, keyed by the sanitized service name.
+func clearNotationAnnotations(name string) map[string]*string {
+	annotations := make(map[string]*string, maxAnnotationShards+1)
+	annotations[annotationServiceKeyPrefix+name] = nil
+
+	for i := 0; i < maxAnnotationShards; i++ {
+		annotations[shardKey(name, i)] = nil
+	}
+
+	return annotations
+}
+
+// notation returns the service notation held by a service annotation,
+// reassembled from its shards when sharded. Shards missing or not matching
+// the manifest are reported as errShardsIncomplete.
+func notation(meta *client.Meta, key string) (string, error) {
+	v := meta.Annotations[key]
+	if v == nil {
+		return "", errShardsIncomplete
+	}
+
+	if !strings.HasPrefix(*v, shardManifestPrefix) {
+		return *v, nil
+	}
+
+	var m shardManifest
+	if err := json.Unmarshal([]byte(*v), &m); err != nil {
+		return "", err
+	}
+
+	name := strings.TrimPrefix(key, annotationServiceKeyPrefix)
+
+	var b strings.Builder
+
+	for i := 0; i < m.Shards; i++ {
+		shard := meta.Annotations[shardKey(name, i)]
+		if shard == nil {
+			return "", errShardsIncomplete
+		}
+
+		b.WriteString(*shard)
+	}
+
+	data := b.String()
+	if checksum(data) != m.Checksum {
+		return "", errShardsIncomplete
+	}
+
+	return data, nil
+}
@@ -0,0 +1,99 @@
+package kubernetes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// largeService returns a service whose notation needs several shards.
+func largeService() *registry.Service {
+	svc := &registry.Service{Name: "large.service", Version: "1"}
+
+	for i := 0; i < 1000; i++ {
+		svc.Nodes = append(svc.Nodes, &registry.Node{
+			Id:       fmt.Sprintf("large-%d", i),
+			Address:  fmt.Sprintf("10.0.%d.%d:80", i/256, i%256),
+			Metadata: map[string]string{"padding": strings.Repeat("x", 100)},
+		})
+	}
+
+	return svc
+}
+
+func TestRegisterShardedService(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	setupPod("pod-1")
+	t.Setenv("HOSTNAME", "pod-1")
+
+	svc := largeService()
+	if err := r.Register(svc); err != nil {
+		t.Fatalf("did not expect Register() to fail: %v", err)
+	}
+
+	pod := mockClient.Pods["pod-1"]
+	if _, ok := pod.Metadata.Annotations[shardKey("large.service", 1)]; !ok {
+		t.Fatal("expected the notation to be sharded")
+	}
+
+	services, err := r.GetService(svc.Name)
+	if err != nil {
+		t.Fatalf("did not expect GetService() to fail: %v", err)
+	}
+
+	if len(services) != 1 || len(services[0].Nodes) != len(svc.Nodes) {
+		t.Fatalf("expected the service to be reassembled with %d nodes", len(svc.Nodes))
+	}
+
+	// the watcher waits for all shards
+	k := &k8sWatcher{registry: r.(*kregistry), actions: r.(*kregistry).actions}
+
+	partial := &client.Pod{Metadata: &client.Meta{Annotations: make(map[string]*string)}}
+	for key, val := range pod.Metadata.Annotations {
+		if key != shardKey("large.service", 1) {
+			partial.Metadata.Annotations[key] = val
+		}
+	}
+
+	if results, _ := k.podBuildResult(partial, nil); len(results) != 0 {
+		t.Fatalf("expected no result for incomplete shards, got %d", len(results))
+	}
+
+	results, _ := k.podBuildResult(pod, partial)
+	if len(results) != 1 || len(results[0].Service.Nodes) != len(svc.Nodes) {
+		t.Fatal("expected a result once all shards are there")
+	}
+
+	// deregistering removes the shards
+	if err := r.Deregister(svc); err != nil {
+		t.Fatalf("did not expect Deregister() to fail: %v", err)
+	}
+
+	for key := range pod.Metadata.Annotations {
+		if strings.HasPrefix(key, annotationShardKeyPrefix) {
+			t.Fatalf("expected shard %s to be removed", key)
+		}
+	}
+}
+
+func TestRegisterServiceTooLarge(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	setupPod("pod-1")
+	t.Setenv("HOSTNAME", "pod-1")
+
+	svc := largeService()
+	svc.Nodes[0].Metadata["padding"] = strings.Repeat("x", maxAnnotationShards*annotationShardSize)
+
+	if err := r.Register(svc); !errors.Is(err, ErrServiceTooLarge) {
+		t.Fatalf("expected ErrServiceTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+package kubernetes
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// sharedWatch is a single watch of every service fanning its results out to
+// the watchers sharing it. It runs from the first of them started until the
+// last one stops.
+type sharedWatch struct {
+	registry *kregistry
+
+	// guards starting and stopping the watch.
+	mtx     sync.Mutex
+	watcher *k8sWatcher
+
+	// the watchers results are sent to, replaced as they start and stop so
+	// results are sent without holding mtx.
+	watchers atomic.Pointer[[]*sharedWatcher]
+}
+
+// sharedWatcher is a watcher getting the results of a shared watch for the
+// services it is scoped to.
+type sharedWatcher struct {
+	shared *sharedWatch
+
+	// scopes the results, empty and nil for all services.
+	service  string
+	services map[string]bool
+
+	next chan *registry.Result
+	done chan struct{}
+	once sync.Once
+
+	// results not received within the delivery timeout.
+	dropped atomic.Uint64
+
+	// the initial state, returned before any result.
+	mtx     sync.Mutex
+	pending []*registry.Result
+}
+
+// subscribe starts a watcher on the shared watch, starting it if needed.
+func (s *sharedWatch) subscribe(wo registry.WatchOptions) (registry.Watcher, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.watcher == nil {
+		w, err := newWatcher(s.registry, func(o *registry.WatchOptions) {
+			setWatchOption(o, sharedKey{}, s)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		s.watcher, _ = w.(*k8sWatcher)
+	}
+
+	w := &sharedWatcher{
+		shared:  s,
+		service: wo.Service,
+		next:    make(chan *registry.Result),
+		done:    make(chan struct{}),
+	}
+
+	if names, _ := wo.Context.Value(servicesKey{}).([]string); len(names) > 0 {
+		w.services = make(map[string]bool, len(names))
+		for _, name := range names {
+			w.services[name] = true
+		}
+	}
+
+	k := s.watcher
+
+	// no event is handled while the watcher joins, so its initial state
+	// is the one the results it gets next apply to.
+	k.events.Lock()
+	defer k.events.Unlock()
+
+	if replay, _ := wo.Context.Value(initialStateKey{}).(bool); replay {
+		action, _ := wo.Context.Value(initialStateActionKey{}).(string)
+		if len(action) == 0 {
+			action = k.actions.Create
+		}
+
+		w.pending = s.initialState(k, w, action)
+	}
+
+	var watchers []*sharedWatcher
+	if old := s.watchers.Load(); old != nil {
+		watchers = append(watchers, *old...)
+	}
+
+	watchers = append(watchers, w)
+	s.watchers.Store(&watchers)
+
+	return w, nil
+}
+
+// initialState returns the services up in the cache of the shared watch
+// that a watcher is scoped to, with the action given.
+func (s *sharedWatch) initialState(k *k8sWatcher, w *sharedWatcher, action string) []*registry.Result {
+	k.RLock()
+	pods := make([]*client.Pod, 0, len(k.pods))
+	for _, pod := range k.pods {
+		pods = append(pods, pod)
+	}
+	k.RUnlock()
+
+	var results []*registry.Result
+
+	for _, pod := range pods {
+		for _, result := range k.podResults(pod, nil) {
+			if result.Action != k.actions.Create || !w.watches(result.Service.Name) {
+				continue
+			}
+
+			result.Action = action
+			results = append(results, result)
+		}
+	}
+
+	k.order(results)
+
+	return results
+}
+
+// unsubscribe removes a stopped watcher, stopping the shared watch if it
+// was the last one.
+func (s *sharedWatch) unsubscribe(w *sharedWatcher) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var watchers []*sharedWatcher
+
+	if old := s.watchers.Load(); old != nil {
+		for _, sw := range *old {
+			if sw != w {
+				watchers = append(watchers, sw)
+			}
+		}
+	}
+
+	s.watchers.Store(&watchers)
+
+	if len(watchers) == 0 && s.watcher != nil {
+		s.watcher.Stop()
+		s.watcher = nil
+	}
+}
+
+// deliver sends results to each watcher in turn, skipping the ones stopped
+// meanwhile. Each watcher gets its own copy of the results.
+func (s *sharedWatch) deliver(results []*registry.Result) {
+	watchers := s.watchers.Load()
+	if watchers == nil {
+		return
+	}
+
+	for _, w := range *watchers {
+	results:
+		for _, result := range results {
+			if !w.watches(result.Service.Name) {
+				continue
+			}
+
+			r := &registry.Result{
+				Action:  result.Action,
+				Service: withNodes(result.Service, result.Service.Nodes...),
+			}
+
+			if !s.registry.send(w.next, w.done, r, &w.dropped) {
+				break results
+			}
+		}
+	}
+}
+
+// watches reports whether the watcher is scoped to a service.
+func (w *sharedWatcher) watches(name string) bool {
+	if len(w.service) > 0 && name != w.service {
+		return false
+	}
+
+	return w.services == nil || w.services[name]
+}
+
+// Next will block until a new result comes in.
+func (w *sharedWatcher) Next() (*registry.Result, error) {
+	w.mtx.Lock()
+	if len(w.pending) > 0 {
+		r := w.pending[0]
+		w.pending = w.pending[1:]
+		w.mtx.Unlock()
+
+		return r, nil
+	}
+	w.mtx.Unlock()
+
+	select {
+	case <-w.done:
+		return nil, ErrWatcherStopped
+	case r := <-w.next:
+		return r, nil
+	}
+}
+
+// Stop stops the watcher, and the shared watch if it is the last one.
+func (w *sharedWatcher) Stop() {
+	w.once.Do(func() {
+		close(w.done)
+		w.shared.unsubscribe(w)
+	})
+}
+
+// DroppedEvents returns how many results the watcher didn't receive in time
+// since the last reset.
+func (w *sharedWatcher) DroppedEvents() uint64 {
+	return w.dropped.Load()
+}
+
+// ResetStats resets the counters of the watcher.
+func (w *sharedWatcher) ResetStats() {
+	w.dropped.Store(0)
+}
@@ -0,0 +1,89 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/pkg/errors"
+)
+
+// snapshotVersion is the version of the snapshot format written.
+const snapshotVersion = 1
+
+// Snapshot is the JSON document written by ExportSnapshot, holding every
+// service version with its nodes in the go-micro registry.Service format.
+// Services are ordered by name then version, and their nodes by id, so the
+// same registry state always gives the same document but for Created.
+type Snapshot struct {
+	// Version of the snapshot format, 1.
+	Version int `json:"version"`
+	// Created is when the snapshot was taken.
+	Created time.Time `json:"created"`
+	// Services are the service versions found.
+	Services []*registry.Service `json:"services"`
+}
+
+// Snapshotter is implemented by the registry to export its contents.
+type Snapshotter interface {
+	ExportSnapshot(w io.Writer) error
+}
+
+// ExportSnapshot writes a Snapshot of all the services to w, as listed from
+// the API server.
+func (c *kregistry) ExportSnapshot(w io.Writer) error {
+	services, err := c.ListServices()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Name != services[j].Name {
+			return services[i].Name < services[j].Name
+		}
+
+		return services[i].Version < services[j].Version
+	})
+
+	for _, svc := range services {
+		sort.Slice(svc.Nodes, func(i, j int) bool {
+			return svc.Nodes[i].Id < svc.Nodes[j].Id
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(Snapshot{
+		Version:  snapshotVersion,
+		Created:  time.Now().UTC(),
+		Services: services,
+	})
+}
+
+// ImportSnapshot reads a Snapshot written by ExportSnapshot into a new
+// memory registry, set up with the options given, for instance to serve it
+// in tests or while debugging.
+func ImportSnapshot(r io.Reader, opts ...registry.Option) (registry.Registry, error) {
+	var snapshot Snapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return nil, errors.Wrap(err, "failed to decode snapshot")
+	}
+
+	if snapshot.Version != snapshotVersion {
+		return nil, errors.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+
+	m := registry.NewMemoryRegistry(opts...)
+
+	for _, svc := range snapshot.Services {
+		if err := m.Register(svc); err != nil {
+			return nil, errors.Wrapf(err, "failed to import service %s", svc.Name)
+		}
+	}
+
+	return m, nil
+}
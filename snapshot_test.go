@@ -0,0 +1,67 @@
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"go-micro.dev/v4/registry"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1", Metadata: map[string]string{"team": "a"}})
+	register(t, r, "pod-2", &registry.Service{Name: "foo.service", Version: "1"})
+	register(t, r, "pod-2", &registry.Service{Name: "bar.service", Version: "2"})
+
+	var exported bytes.Buffer
+	if err := r.(Snapshotter).ExportSnapshot(&exported); err != nil {
+		t.Fatalf("did not expect ExportSnapshot() to fail: %v", err)
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(exported.Bytes(), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	if snapshot.Version != 1 || len(snapshot.Services) != 2 || snapshot.Services[0].Name != "bar.service" {
+		t.Fatalf("expected the services ordered by name, got %+v", snapshot)
+	}
+
+	m, err := ImportSnapshot(bytes.NewReader(exported.Bytes()))
+	if err != nil {
+		t.Fatalf("did not expect ImportSnapshot() to fail: %v", err)
+	}
+
+	for _, name := range []string{"foo.service", "bar.service"} {
+		want, err := r.GetService(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := m.GetService(name)
+		if err != nil {
+			t.Fatalf("expected %s imported: %v", name, err)
+		}
+
+		if !hasServices(got, want) {
+			t.Fatalf("expected %s to round trip, got %+v, want %+v", name, got, want)
+		}
+	}
+
+	foo, err := m.GetService("foo.service")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(foo[0].Metadata, map[string]string{"team": "a"}) {
+		t.Fatalf("expected the service metadata imported, got %v", foo[0].Metadata)
+	}
+
+	if _, err := ImportSnapshot(bytes.NewReader([]byte(`{"version":2}`))); err == nil {
+		t.Fatal("expected ImportSnapshot() to reject an unknown version")
+	}
+}
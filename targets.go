@@ -0,0 +1,82 @@
+package kubernetes
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// TargetError is returned by Register and Deregister when writing to some
+// of their targets failed, the pod or the config map, telling which. Targets
+// are named "pod/<name>" and "configmap/<name>".
+type TargetError struct {
+	// Succeeded are the targets written.
+	Succeeded []string
+	// Failed are the errors of the targets not written.
+	Failed map[string]error
+}
+
+func (e *TargetError) Error() string {
+	failed := make([]string, 0, len(e.Failed))
+	for target, err := range e.Failed {
+		failed = append(failed, target+": "+err.Error())
+	}
+
+	sort.Strings(failed)
+
+	msg := "failed on " + strings.Join(failed, ", ")
+	if len(e.Succeeded) > 0 {
+		msg += "; succeeded on " + strings.Join(e.Succeeded, ", ")
+	}
+
+	return msg
+}
+
+// Unwrap returns the errors of the targets not written.
+func (e *TargetError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, err := range e.Failed {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// targetsErr aggregates the errors of writing to the pod and config map, nil
+// when both were written.
+func (c *kregistry) targetsErr(podName string, podErr, configMapErr error) error {
+	if podErr == nil && configMapErr == nil {
+		return nil
+	}
+
+	e := &TargetError{Failed: make(map[string]error)}
+
+	for target, err := range map[string]error{
+		"pod/" + podName:                 podErr,
+		"configmap/" + c.configMapTarget: configMapErr,
+	} {
+		if err != nil {
+			e.Failed[target] = err
+		} else {
+			e.Succeeded = append(e.Succeeded, target)
+		}
+	}
+
+	return e
+}
+
+// patchConfigMap sets the entry of a service registered on a pod in the
+// config map target, or removes it for a nil payload.
+func (c *kregistry) patchConfigMap(podName, svcName string, payload *string) error {
+	patcher, ok := c.client.(client.ConfigMapPatcher)
+	if !ok {
+		return ErrConfigMapsUnsupported
+	}
+
+	key := configMapServiceKeyPrefix + serviceName(svcName) + "." + podName
+
+	_, err := patcher.PatchConfigMap(c.configMapTarget, map[string]*string{key: payload})
+
+	return err
+}
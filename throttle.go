@@ -0,0 +1,75 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go-micro.dev/v4/logger"
+)
+
+// defaultLogWindow is how long identical errors are collapsed by default.
+const defaultLogWindow = 10 * time.Second
+
+// logThrottle collapses identical error and warning logs: the first one
+// within a window is logged, the next ones are counted and logged as one
+// line once the window ends, so a failing API server doesn't flood the logs.
+type logThrottle struct {
+	window time.Duration
+
+	mtx  sync.Mutex
+	seen map[string]int
+}
+
+func newLogThrottle(window time.Duration) *logThrottle {
+	return &logThrottle{
+		window: window,
+		seen:   make(map[string]int),
+	}
+}
+
+// errorf logs an error unless logged within the window, logging every
+// error when nil or without a window.
+func (t *logThrottle) errorf(format string, args ...interface{}) {
+	t.logf(logger.ErrorLevel, format, args...)
+}
+
+// warnf logs a warning like errorf.
+func (t *logThrottle) warnf(format string, args ...interface{}) {
+	t.logf(logger.WarnLevel, format, args...)
+}
+
+func (t *logThrottle) logf(level logger.Level, format string, args ...interface{}) {
+	if t == nil || t.window <= 0 {
+		logger.Logf(level, format, args...)
+		return
+	}
+
+	msg := fmt.Sprintf(format, args...)
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if _, ok := t.seen[msg]; ok {
+		t.seen[msg]++
+		return
+	}
+
+	t.seen[msg] = 0
+
+	logger.Log(level, msg)
+
+	time.AfterFunc(t.window, func() { t.flush(level, msg) })
+}
+
+// flush ends the window of a message, logging how many times it repeated.
+func (t *logThrottle) flush(level logger.Level, msg string) {
+	t.mtx.Lock()
+	n := t.seen[msg]
+	delete(t.seen, msg)
+	t.mtx.Unlock()
+
+	if n > 0 {
+		logger.Logf(level, "%s (%d more occurrences in the last %s)", msg, n, t.window)
+	}
+}
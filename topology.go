@@ -0,0 +1,89 @@
+package kubernetes
+
+import (
+	"sync"
+	"time"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// well-known node labels of the topology of a node, and the node metadata
+// keys they are put in.
+const (
+	labelZone   = "topology.kubernetes.io/zone"
+	labelRegion = "topology.kubernetes.io/region"
+
+	metadataZone   = "zone"
+	metadataRegion = "region"
+)
+
+// topologyRefresh is how often nodes are listed again at most, when looking
+// up a node not cached.
+const topologyRefresh = time.Minute
+
+// topology caches the zone and region of nodes, listing all nodes at once
+// rather than looking up one per pod.
+type topology struct {
+	sync.Mutex
+	client client.Kubernetes
+	logs   *logThrottle
+	nodes  map[string]map[string]string
+	listed time.Time
+}
+
+func newTopology(c client.Kubernetes, logs *logThrottle) *topology {
+	return &topology{client: c, logs: logs}
+}
+
+// metadata returns the topology metadata of a node, listing nodes when it
+// isn't cached and they weren't listed recently.
+func (t *topology) metadata(nodeName string) map[string]string {
+	t.Lock()
+	defer t.Unlock()
+
+	if md, ok := t.nodes[nodeName]; ok || time.Since(t.listed) < topologyRefresh {
+		return md
+	}
+
+	t.listed = time.Now()
+
+	nodes, err := t.client.ListNodes()
+	if err != nil {
+		t.logs.errorf("K8s Registry: failed to list nodes topology: %v", err)
+		return nil
+	}
+
+	t.nodes = make(map[string]map[string]string, len(nodes.Items))
+
+	for _, node := range nodes.Items {
+		if node.Metadata == nil {
+			continue
+		}
+
+		md := make(map[string]string, 2)
+
+		for label, key := range map[string]string{labelZone: metadataZone, labelRegion: metadataRegion} {
+			if v := node.Metadata.Labels[label]; v != nil {
+				md[key] = *v
+			}
+		}
+
+		t.nodes[node.Metadata.Name] = md
+	}
+
+	return t.nodes[nodeName]
+}
+
+// topologyMetadata puts the zone and region of the node a pod runs on in
+// the metadata of the service nodes.
+func (c *kregistry) topologyMetadata(pod *client.Pod, svc *registry.Service) {
+	if c.topology == nil || pod.Spec == nil || len(pod.Spec.NodeName) == 0 {
+		return
+	}
+
+	for key, value := range c.topology.metadata(pod.Spec.NodeName) {
+		setNodeMetadata(svc, key, value)
+	}
+}
@@ -0,0 +1,178 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// expiryInterval is how often watchers look for expired services.
+var expiryInterval = time.Second
+
+// servicePayload is the annotation payload of a service registered with a
+// TTL, which still decodes as the service alone.
+type servicePayload struct {
+	*registry.Service
+	TTL        string    `json:"ttl"`
+	Registered time.Time `json:"registered"`
+}
+
+// registerTTL returns the TTL Register stores with a service, zero for none.
+func (c *kregistry) registerTTL(opts ...registry.RegisterOption) time.Duration {
+	if !c.expireServices {
+		return 0
+	}
+
+	var o registry.RegisterOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.TTL > 0 {
+		return o.TTL
+	}
+
+	return c.defaultTTL
+}
+
+// encodeService serializes a service like compactEncode, along with its TTL
+// and the time it is registered at when it has one.
+func encodeService(s *registry.Service, ttl time.Duration) ([]byte, error) {
+	if ttl <= 0 {
+		return compactEncode(s)
+	}
+
+	s.Endpoints = []*registry.Endpoint{} // remove endpoints to reduce size
+
+	return json.Marshal(servicePayload{Service: s, TTL: ttl.String(), Registered: time.Now().UTC()})
+}
+
+// payloadExpiry returns when the service of a payload advertised by a pod
+// expires, or the zero time when it has no TTL.
+func (c *kregistry) payloadExpiry(meta *client.Meta, data string) time.Time {
+	var p struct {
+		TTL        string    `json:"ttl"`
+		Registered time.Time `json:"registered"`
+	}
+
+	if err := unmarshalString(data, &p); err != nil || len(p.TTL) == 0 {
+		return time.Time{}
+	}
+
+	ttl, err := time.ParseDuration(p.TTL)
+	if err != nil || ttl <= 0 {
+		return time.Time{}
+	}
+
+	return c.registeredAt(meta, p.Registered).Add(ttl + c.clockSkew)
+}
+
+// registeredAt returns when a pod registered a payload, as stamped by the
+// clock of the pod. When allowing a clock skew, a stamp before the pod was
+// created is moved to its creation, and a stamp further ahead than the skew
+// allowed, which would keep the service past its TTL, is replaced by the
+// creation of the pod when known.
+func (c *kregistry) registeredAt(meta *client.Meta, stamped time.Time) time.Time {
+	if c.clockSkew <= 0 {
+		return stamped
+	}
+
+	created, err := time.Parse(time.RFC3339, meta.CreationTimestamp)
+	known := err == nil
+
+	// a pod never registers before it is created
+	if known && stamped.Before(created) {
+		return created
+	}
+
+	if time.Until(stamped) <= c.clockSkew {
+		return stamped
+	}
+
+	c.logs.warnf("K8s Registry: pod %s registered a service at %s, further ahead than the clock skew allowed of %s",
+		meta.Name, stamped.Format(time.RFC3339), c.clockSkew)
+
+	if known {
+		return created
+	}
+
+	return stamped
+}
+
+// expiredBy tells whether the service of a payload advertised by a pod
+// expired by t, which is never the case when services don't expire.
+func (c *kregistry) expiredBy(meta *client.Meta, data string, t time.Time) bool {
+	if !c.expireServices {
+		return false
+	}
+
+	expiry := c.payloadExpiry(meta, data)
+
+	return !expiry.IsZero() && !expiry.After(t)
+}
+
+// runExpiry emits deletes for the services expiring until the watcher is
+// stopped.
+func (k *k8sWatcher) runExpiry() {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-k.done:
+			return
+		case now := <-ticker.C:
+			k.expire(now)
+		}
+	}
+}
+
+// expire emits deletes for the cached services that expired since the last
+// check. Until then, their payloads are handled as if they hadn't expired.
+func (k *k8sWatcher) expire(now time.Time) {
+	k.events.Lock()
+	defer k.events.Unlock()
+
+	var results []*registry.Result
+
+	k.RLock()
+	for _, pod := range k.pods {
+		for annKey := range pod.Metadata.Annotations {
+			if !strings.HasPrefix(annKey, annotationServiceKeyPrefix) {
+				continue
+			}
+
+			data, err := notation(pod.Metadata, annKey)
+			if err != nil {
+				continue
+			}
+
+			expiry := k.registry.payloadExpiry(pod.Metadata, data)
+			if expiry.IsZero() || !expiry.After(k.expiryChecked) || expiry.After(now) {
+				continue
+			}
+
+			rslt := &registry.Result{Action: k.actions.Delete}
+			if rslt.Service, err = k.registry.decodeService(data); err != nil || rslt.Service == nil {
+				continue
+			}
+
+			// never returned, so not deleted either
+			if k.registry.validate && validService(rslt.Service) != nil {
+				continue
+			}
+
+			k.registry.nodeMetadata(pod, rslt.Service)
+			results = append(results, rslt)
+		}
+	}
+	k.RUnlock()
+
+	k.expiryChecked = now
+
+	k.emit(k.filterServices(results))
+}
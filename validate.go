@@ -0,0 +1,69 @@
+package kubernetes
+
+import (
+	"errors"
+	"net"
+	"strconv"
+
+	"go-micro.dev/v4/registry"
+)
+
+// RejectCounter is implemented by the registry to tell how many service
+// payloads its watchers rejected as invalid.
+type RejectCounter interface {
+	RejectedPayloads() uint64
+}
+
+var (
+	errNoServiceName = errors.New("no service name")
+	errNoValidNode   = errors.New("no node with a valid address")
+)
+
+// validService checks the invariants of a decoded service.
+func validService(svc *registry.Service) error {
+	if len(svc.Name) == 0 {
+		return errNoServiceName
+	}
+
+	for _, node := range svc.Nodes {
+		if node != nil && validAddress(node.Address) {
+			return nil
+		}
+	}
+
+	return errNoValidNode
+}
+
+func validAddress(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || len(host) == 0 {
+		return false
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+
+	return err == nil && p > 0
+}
+
+// rejectPayload reports whether a decoded service is invalid, counting and
+// logging it, when validating payloads.
+func (c *kregistry) rejectPayload(podName string, svc *registry.Service) bool {
+	if !c.validate {
+		return false
+	}
+
+	err := validService(svc)
+	if err == nil {
+		return false
+	}
+
+	c.counters.rejected.Add(1)
+	c.logs.errorf("K8s Watcher: rejected service %q of pod %s: %v", svc.Name, podName, err)
+
+	return true
+}
+
+// RejectedPayloads returns how many service payloads watchers rejected.
+func (c *kregistry) RejectedPayloads() uint64 {
+	return c.counters.rejected.Load()
+}
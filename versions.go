@@ -0,0 +1,167 @@
+package kubernetes
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"go-micro.dev/v4/registry"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+)
+
+// annotationVersionsKeyPrefix is the prefix of the annotations mapping the
+// versions a pod advertises a service in to their metadata, such as
+// {"v1":{"compat":"true"},"v2":{}}, eg: annotationVersionsKeyPrefix+"svc.name".
+// The service is then advertised once per version, at the same nodes.
+const annotationVersionsKeyPrefix = "micro.mu/versions-"
+
+// versionMap returns the versions a pod advertises the service of a service
+// annotation in, with their metadata, nil when it has no version map.
+func versionMap(meta *client.Meta, annKey string) map[string]map[string]string {
+	if meta == nil {
+		return nil
+	}
+
+	key := annotationVersionsKeyPrefix + strings.TrimPrefix(annKey, annotationServiceKeyPrefix)
+
+	v, ok := meta.Annotations[key]
+	if !ok || v == nil {
+		return nil
+	}
+
+	var versions map[string]map[string]string
+	if err := unmarshalString(*v, &versions); err != nil || len(versions) == 0 {
+		return nil
+	}
+
+	return versions
+}
+
+// versionsChanged reports whether the version map of a service changed
+// since the pod was cached.
+func versionsChanged(pod, cache *client.Pod, annKey string) bool {
+	return !reflect.DeepEqual(versionMap(pod.Metadata, annKey), versionMap(cache.Metadata, annKey))
+}
+
+// withVersions returns a copy of a service per version of a version map,
+// sorted, the metadata of the version set over the one of the service. A
+// service without a version map is returned alone.
+func withVersions(svc *registry.Service, versions map[string]map[string]string) []*registry.Service {
+	if versions == nil {
+		return []*registry.Service{svc}
+	}
+
+	names := make([]string, 0, len(versions))
+	for v := range versions {
+		names = append(names, v)
+	}
+
+	sort.Strings(names)
+
+	services := make([]*registry.Service, 0, len(names))
+
+	for _, v := range names {
+		cp := *svc
+		cp.Version = v
+		if len(versions[v]) > 0 {
+			cp.Metadata = mergeMetadata(svc.Metadata, versions[v])
+		}
+
+		cp.Nodes = append([]*registry.Node(nil), svc.Nodes...)
+		services = append(services, &cp)
+	}
+
+	return services
+}
+
+// expandVersions turns the results of the services of a pod advertising a
+// version map, now or when cached, into results per version: creates and
+// deletes are of every version, and updates create the versions added,
+// delete the ones removed and update the others when they changed.
+func (k *k8sWatcher) expandVersions(pod, cache *client.Pod, results []*registry.Result) []*registry.Result {
+	expanded := make([]*registry.Result, 0, len(results))
+
+	for _, result := range results {
+		annKey := annotationServiceKeyPrefix + serviceName(result.Service.Name)
+
+		versions := versionMap(pod.Metadata, annKey)
+
+		var cached map[string]map[string]string
+		if cache != nil {
+			cached = versionMap(cache.Metadata, annKey)
+		}
+
+		if versions == nil && cached == nil {
+			expanded = append(expanded, result)
+			continue
+		}
+
+		switch {
+		case result.Action == k.actions.Create:
+			expanded = append(expanded, versionResults(result.Action, result.Service, versions)...)
+		case result.Action == k.actions.Update && cache != nil:
+			expanded = append(expanded, k.versionUpdates(result.Service, k.cachedPayload(cache, annKey), versions, cached)...)
+		case cache != nil:
+			expanded = append(expanded, versionResults(result.Action, result.Service, cached)...)
+		default:
+			expanded = append(expanded, versionResults(result.Action, result.Service, versions)...)
+		}
+	}
+
+	return expanded
+}
+
+// versionResults returns a result per version of a service.
+func versionResults(action string, svc *registry.Service, versions map[string]map[string]string) []*registry.Result {
+	services := withVersions(svc, versions)
+	results := make([]*registry.Result, 0, len(services))
+
+	for _, s := range services {
+		results = append(results, &registry.Result{Action: action, Service: s})
+	}
+
+	return results
+}
+
+// versionUpdates diffs the versions of an updated service against the
+// cached ones, by version.
+func (k *k8sWatcher) versionUpdates(svc, old *registry.Service, versions, cached map[string]map[string]string) []*registry.Result {
+	previous := make(map[string]*registry.Service)
+
+	if old != nil {
+		for _, s := range withVersions(old, cached) {
+			previous[s.Version] = s
+		}
+	}
+
+	var results []*registry.Result
+
+	for _, s := range withVersions(svc, versions) {
+		prev, ok := previous[s.Version]
+		delete(previous, s.Version)
+
+		switch {
+		case !ok:
+			results = append(results, &registry.Result{Action: k.actions.Create, Service: s})
+		case !reflect.DeepEqual(prev, s):
+			results = append(results, &registry.Result{Action: k.actions.Update, Service: s})
+		}
+	}
+
+	for _, s := range previous {
+		results = append(results, &registry.Result{Action: k.actions.Delete, Service: s})
+	}
+
+	return results
+}
+
+// cachedPayload returns the service a cached pod advertised in an
+// annotation, nil when it didn't.
+func (k *k8sWatcher) cachedPayload(cache *client.Pod, annKey string) *registry.Service {
+	if rslt := k.cachedDelete(cache, annKey); rslt != nil {
+		return rslt.Service
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package kubernetes
+
+import (
+	"context"
+
+	"go-micro.dev/v4/registry"
+)
+
+// ServiceWaiter is implemented by the registry to wait for services.
+type ServiceWaiter interface {
+	WaitForService(ctx context.Context, name string, minNodes int) error
+}
+
+// WaitForService blocks until the service has at least minNodes nodes run
+// by ready pods, whatever their version, or the context is done, returning
+// its error then. It watches the pods of the service meanwhile.
+func (c *kregistry) WaitForService(ctx context.Context, name string, minNodes int) error {
+	w, err := newWatcher(c, registry.WatchService(name), InitialState(true), NodeResults(true))
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	stop := context.AfterFunc(ctx, w.Stop)
+	defer stop()
+
+	// nodes by version and id, as results hold a single node
+	nodes := make(map[string]bool)
+
+	for len(nodes) < minNodes {
+		res, err := w.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			return err
+		}
+
+		for _, node := range res.Service.Nodes {
+			key := res.Service.Version + "/" + node.Id
+
+			if res.Action == c.actions.Create || res.Action == c.actions.Update {
+				nodes[key] = true
+			} else {
+				delete(nodes, key)
+			}
+		}
+	}
+
+	return nil
+}
@@ -1,55 +1,366 @@
 package kubernetes
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"go-micro.dev/v4/logger"
 	"go-micro.dev/v4/registry"
 
+	"github.com/skiprco/go-micro-kubernetes-registry/backoff"
 	"github.com/skiprco/go-micro-kubernetes-registry/client"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/api"
 	"github.com/skiprco/go-micro-kubernetes-registry/client/watch"
 )
 
-var (
-	deleteAction = "delete"
-)
+// Refresher is implemented by the watchers returned, unless watching
+// several namespaces.
+type Refresher interface {
+	// Refresh relists the watched objects and emits the results correcting
+	// any difference with what the watcher has seen.
+	Refresh() error
+}
+
+// watchSource lists and watches the kubernetes objects services are
+// discovered from. Objects are decoded into the annotated pod shape the
+// watcher cache and diff logic work on.
+type watchSource interface {
+	list(selector map[string]string) ([]client.Pod, error)
+	watch(selector map[string]string) (watch.Watch, error)
+	decode(object json.RawMessage) (*client.Pod, error)
+	kind() string
+}
+
+// podSource discovers services from pod annotations, and labels when
+// payloads may be stored in labels, decrypting payloads when configured.
+type podSource struct {
+	client        client.Kubernetes
+	payloadLabels bool
+	cipher        *payloadCipher
+	logs          *logThrottle
+}
+
+func (s podSource) list(selector map[string]string) ([]client.Pod, error) {
+	podList, err := s.client.ListPods(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pod := range podList.Items {
+		s.readPayloads(&pod)
+	}
+
+	return podList.Items, nil
+}
+
+func (s podSource) watch(selector map[string]string) (watch.Watch, error) {
+	return s.client.WatchPods(selector)
+}
+
+func (s podSource) decode(object json.RawMessage) (*client.Pod, error) {
+	var pod client.Pod
+	if err := json.Unmarshal(object, &pod); err != nil {
+		return nil, err
+	}
+
+	s.readPayloads(&pod)
+
+	return &pod, nil
+}
+
+func (s podSource) kind() string {
+	return podKind
+}
+
+func (s podSource) readPayloads(pod *client.Pod) {
+	if s.payloadLabels {
+		payloadsFromLabels(pod.Metadata)
+	}
+
+	s.cipher.openAnnotations(pod.Metadata, s.logs)
+	unwrapPayloads(pod.Metadata, s.logs)
+}
+
+// selfPodSource discovers services from the annotations of a single pod,
+// the one this service runs in, optionally only the watched service.
+type selfPodSource struct {
+	podSource
+	name    string
+	service string
+}
+
+func (s selfPodSource) list(_ map[string]string) ([]client.Pod, error) {
+	pod, err := s.client.GetPod(s.name)
+	if errors.Is(err, api.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	s.readPayloads(pod)
+
+	return []client.Pod{*s.filter(pod)}, nil
+}
+
+// watch selects the pod by name, the service is filtered on decode.
+func (s selfPodSource) watch(_ map[string]string) (watch.Watch, error) {
+	return s.client.WatchPod(s.name)
+}
+
+func (s selfPodSource) decode(object json.RawMessage) (*client.Pod, error) {
+	pod, err := s.podSource.decode(object)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.filter(pod), nil
+}
+
+// filter drops the notations of services other than the watched one.
+func (s selfPodSource) filter(pod *client.Pod) *client.Pod {
+	if len(s.service) == 0 || pod.Metadata == nil {
+		return pod
+	}
+
+	key := annotationServiceKeyPrefix + serviceName(s.service)
+
+	for annKey := range pod.Metadata.Annotations {
+		if strings.HasPrefix(annKey, annotationServiceKeyPrefix) && annKey != key {
+			delete(pod.Metadata.Annotations, annKey)
+		}
+	}
+
+	return pod
+}
+
+// configMapSource discovers services from config map data entries,
+// decrypting payloads when configured.
+type configMapSource struct {
+	client client.Kubernetes
+	cipher *payloadCipher
+	logs   *logThrottle
+}
+
+func (s configMapSource) list(selector map[string]string) ([]client.Pod, error) {
+	cmList, err := s.client.ListConfigMaps(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	pods := make([]client.Pod, 0, len(cmList.Items))
+	for i := range cmList.Items {
+		pod := configMapPod(&cmList.Items[i])
+		s.cipher.openAnnotations(pod.Metadata, s.logs)
+		pods = append(pods, *pod)
+	}
+
+	return pods, nil
+}
+
+func (s configMapSource) watch(selector map[string]string) (watch.Watch, error) {
+	return s.client.WatchConfigMaps(selector)
+}
+
+func (s configMapSource) decode(object json.RawMessage) (*client.Pod, error) {
+	var cm client.ConfigMap
+	if err := json.Unmarshal(object, &cm); err != nil {
+		return nil, err
+	}
+
+	pod := configMapPod(&cm)
+	s.cipher.openAnnotations(pod.Metadata, s.logs)
+
+	return pod, nil
+}
+
+func (s configMapSource) kind() string {
+	return configMapKind
+}
+
+// configMapPod presents a config map as a running pod, annotated with the
+// service notations held in its data entries.
+func configMapPod(cm *client.ConfigMap) *client.Pod {
+	meta := &client.Meta{
+		Annotations: make(map[string]*string, len(cm.Data)),
+	}
+
+	if cm.Metadata != nil {
+		meta.Name = cm.Metadata.Name
+		meta.Labels = cm.Metadata.Labels
+		meta.DeletionTimestamp = cm.Metadata.DeletionTimestamp
+	}
+
+	for key, val := range cm.Data {
+		if !strings.HasPrefix(key, configMapServiceKeyPrefix) {
+			continue
+		}
+
+		v := val
+		meta.Annotations[annotationServiceKeyPrefix+strings.TrimPrefix(key, configMapServiceKeyPrefix)] = &v
+	}
+
+	return &client.Pod{
+		Metadata: meta,
+		Status:   &client.Status{Phase: podRunning},
+	}
+}
+
+// defaultBackoff is the backoff of reconnects and watch retries by default.
+var defaultBackoff = backoff.Exponential(500*time.Millisecond, 30*time.Second)
 
 type k8sWatcher struct {
 	registry *kregistry
+	source   watchSource
+	selector map[string]string
 	watcher  watch.Watch
 	next     chan *registry.Result
+	done     chan struct{}
+	actions  Actions
+	hooks    hooks
+
+	// services watched when watching a set of them, nil for all.
+	services map[string]bool
+
+	// whether results hold a single node each.
+	nodeResults bool
+
+	// the node watched, by ID or address, when watching a single one.
+	node string
+
+	// whether changes of the labels mirrored into metadata are updates.
+	metadataChanges bool
+
+	// whether resyncs delete everything then create it again.
+	resetOnReconnect bool
+
+	// whether changes of the number of nodes of a service are updates.
+	readyCounts bool
+
+	// the watch fanning results out when shared, nil otherwise.
+	shared *sharedWatch
+
+	// queues results when compacting them, nil otherwise.
+	compactor *compactor
+
+	// holds deletes back to coalesce restarts, nil otherwise.
+	restarts *restarts
+
+	// buffers results while paused.
+	pause pauser
+
+	// drops results rather than waiting when the buffer overflows, with
+	// how many it dropped, or that weren't received in time.
+	dropOverflow bool
+	dropped      atomic.Uint64
+
+	// events serializes handling pod and node events, so results are
+	// always computed against, and sent in the order of, cache changes.
+	events sync.Mutex
+
+	// when expired services were last deleted, services that expired
+	// since are handled as if they hadn't. Guarded by events.
+	expiryChecked time.Time
+
+	// the resource versions of the pods written by the registry and cached
+	// before their watch event came, by pod. Guarded by events.
+	written map[string]string
 
 	sync.RWMutex
-	pods map[string]*client.Pod
+	pods        map[string]*client.Pod
+	refreshed   map[string]time.Time
+	cordoned    map[string]bool
+	nodeWatcher watch.Watch
 	sync.Once
+	wg sync.WaitGroup
 }
 
-// build a cache of pods when the watcher starts.
+// build a cache of pods when the watcher starts, or refresh it on resync,
+// returning the changes against what was cached before. Services of cached
+// pods that are gone are deleted. The new cache is swapped in at once.
 func (k *k8sWatcher) updateCache() ([]*registry.Result, error) {
-	podList, err := k.registry.client.ListPods(podSelector)
+	pods, err := k.source.list(k.registry.podSelector())
 	if err != nil {
 		return nil, err
 	}
 
+	// only replaced while handling events, which the caller serializes
+	k.RLock()
+	old := k.pods
+	k.RUnlock()
+
+	cache := make(map[string]*client.Pod, len(pods))
+
 	var results []*registry.Result
 
-	for _, p := range podList.Items {
-		// Copy to new var as p gets overwritten by the loop
-		pod := p
-		rslts := k.buildPodResults(&pod, nil)
-		results = append(results, rslts...)
+	for i := range pods {
+		pod := &pods[i]
 
-		k.Lock()
-		k.pods[pod.Metadata.Name] = &pod
-		k.Unlock()
+		// dropped, with its services if cached
+		if !k.registry.included(pod) {
+			continue
+		}
+
+		results = append(results, k.podResults(pod, old[pod.Metadata.Name])...)
+		cache[pod.Metadata.Name] = pod
+	}
+
+	for name, pod := range old {
+		if _, ok := cache[name]; !ok {
+			results = append(results, k.goneResults(pod)...)
+		}
+	}
+
+	now := time.Now()
+	refreshed := make(map[string]time.Time, len(cache))
+
+	for name := range cache {
+		refreshed[name] = now
+	}
+
+	k.Lock()
+	k.pods = cache
+	k.refreshed = refreshed
+	k.Unlock()
+
+	// the pods listed are as recent as the writes
+	k.written = nil
+
+	namespaces := make([]string, 0, len(cache))
+	for _, pod := range cache {
+		namespaces = append(namespaces, pod.Metadata.Namespace)
+	}
+
+	for _, pod := range old {
+		namespaces = append(namespaces, pod.Metadata.Namespace)
 	}
 
+	k.gauge(namespaces...)
+
 	return results, nil
 }
 
+// goneResults returns deletes for the services a cached pod advertised,
+// when it is gone without a delete event.
+func (k *k8sWatcher) goneResults(pod *client.Pod) []*registry.Result {
+	var results []*registry.Result
+
+	for _, result := range k.podResults(pod, nil) {
+		if result.Action == k.actions.Create {
+			result.Action = k.actions.Delete
+			results = append(results, result)
+		}
+	}
+
+	return results
+}
+
 // look through pod annotations, compare against cache if present
 // and return a list of results to send down the wire.
 func (k *k8sWatcher) buildPodResults(pod *client.Pod, cache *client.Pod) []*registry.Result {
@@ -58,13 +369,13 @@ func (k *k8sWatcher) buildPodResults(pod *client.Pod, cache *client.Pod) []*regi
 	ignore := make(map[string]bool)
 
 	if pod.Metadata != nil {
-		results, ignore = podBuildResult(pod, cache)
+		results, ignore = k.podBuildResult(pod, cache)
 	}
 
 	// loop through cache annotations to find services
 	// not accounted for above, and "delete" them.
 	if cache != nil && cache.Metadata != nil {
-		for annKey, annVal := range cache.Metadata.Annotations {
+		for annKey := range cache.Metadata.Annotations {
 			if ignore[annKey] {
 				continue
 			}
@@ -74,59 +385,105 @@ func (k *k8sWatcher) buildPodResults(pod *client.Pod, cache *client.Pod) []*regi
 				continue
 			}
 
-			rslt := &registry.Result{Action: deleteAction}
-
-			// unmarshal service notation from annotation value
-			if err := json.Unmarshal([]byte(*annVal), &rslt.Service); err != nil {
-				continue
+			if rslt := k.cachedDelete(cache, annKey); rslt != nil {
+				results = append(results, rslt)
 			}
+		}
+	}
+
+	results = k.expandVersions(pod, cache, results)
+
+	if k.registry.foldNames {
+		for _, result := range results {
+			result.Service.Name = k.registry.normalize(result.Service.Name)
+		}
+	}
+
+	return k.filterServices(results)
+}
+
+// filterServices drops the results of services not watched, when watching
+// a set of services.
+func (k *k8sWatcher) filterServices(results []*registry.Result) []*registry.Result {
+	if k.services == nil {
+		return results
+	}
+
+	filtered := results[:0]
 
-			results = append(results, rslt)
+	for _, result := range results {
+		if k.services[result.Service.Name] {
+			filtered = append(filtered, result)
 		}
 	}
 
-	return results
+	return filtered
 }
 
 // handleEvent will taken an event from the k8s pods API and do the correct
 // things with the result, based on the local cache.
 func (k *k8sWatcher) handleEvent(event watch.Event) {
-	var pod client.Pod
-	if err := json.Unmarshal([]byte(event.Object), &pod); err != nil {
-		logger.Error("K8s Watcher: Couldnt unmarshal event object from pod")
+	k.hooks.rawEvent(event)
+	k.applyEvent(event, false)
+}
+
+// applyEvent updates the cache from a pod event, or from a pod written by
+// the registry, emitting the results.
+func (k *k8sWatcher) applyEvent(event watch.Event, write bool) {
+	if emptyObject(event.Object) {
+		return
+	}
+
+	if !k.expectedKind(event.Object) {
+		return
+	}
+
+	p, err := k.source.decode(event.Object)
+	if err != nil || p.Metadata == nil {
+		k.registry.logs.errorf("K8s Watcher: Couldnt unmarshal event object from pod")
+		return
+	}
+
+	pod := *p
+
+	k.events.Lock()
+	defer k.events.Unlock()
+	defer k.gauge(pod.Metadata.Namespace)
+
+	if !k.inOrder(&pod, write) {
+		return
+	}
+
+	if !k.registry.included(&pod) {
+		k.drop(pod.Metadata.Name)
 		return
 	}
 
 	//nolint:exhaustive
 	switch event.Type {
-	// Pod was modified
-	case watch.Modified:
+	// Pod was added or modified
+	case watch.Added, watch.Modified:
 		k.RLock()
 		cache := k.pods[pod.Metadata.Name]
 		k.RUnlock()
 
-		// service could have been added, edited or removed.
+		// service could have been added, edited or removed, unless it was
+		// by a registration of this registry, only cached then.
 		var results []*registry.Result
-
-		if pod.Status.Phase == podRunning {
-			results = k.buildPodResults(&pod, cache)
-		} else {
-			// passing in cache might not return all results
-			results = k.buildPodResults(&pod, nil)
+		if !k.registry.selfRegistration(&pod, cache) {
+			results = k.podResults(&pod, cache)
 		}
 
-		for _, result := range results {
-			// pod isnt running
-			if pod.Status.Phase != podRunning || pod.Metadata.DeletionTimestamp != "" {
-				result.Action = deleteAction
-			}
-			k.next <- result
-		}
+		counts := k.countNodes(results)
+		k.emit(results)
 
 		k.Lock()
 		k.pods[pod.Metadata.Name] = &pod
+		k.refreshed[pod.Metadata.Name] = time.Now()
 		k.Unlock()
 
+		k.emitCounts(counts)
+
 		return
 
 	// Pod was deleted
@@ -135,89 +492,608 @@ func (k *k8sWatcher) handleEvent(event watch.Event) {
 		results := k.buildPodResults(&pod, nil)
 
 		for _, result := range results {
-			result.Action = deleteAction
-			k.next <- result
+			result.Action = k.actions.Delete
 		}
 
+		counts := k.countNodes(results)
+		k.emit(results)
+
 		k.Lock()
 		delete(k.pods, pod.Metadata.Name)
+		delete(k.refreshed, pod.Metadata.Name)
 		k.Unlock()
 
+		k.emitCounts(counts)
+
 		return
 	}
 }
 
-// Next will block until a new result comes in.
-func (k *k8sWatcher) Next() (*registry.Result, error) {
-	r, ok := <-k.next
+// inOrder reports whether a pod event is to be handled, which the watch
+// events of a pod written by the registry are not until the one of the
+// write comes, as they are older than what was cached.
+func (k *k8sWatcher) inOrder(pod *client.Pod, write bool) bool {
+	name, version := pod.Metadata.Name, pod.Metadata.ResourceVersion
+
+	if write {
+		if len(version) > 0 {
+			if k.written == nil {
+				k.written = make(map[string]string)
+			}
+
+			k.written[name] = version
+		}
+
+		return true
+	}
+
+	written, ok := k.written[name]
 	if !ok {
-		return nil, errors.New("result chan closed")
+		return true
+	}
+
+	if version != written {
+		return false
 	}
 
-	return r, nil
+	delete(k.written, name)
+
+	return true
 }
 
-// Stop will cancel any requests, and close channels.
-func (k *k8sWatcher) Stop() {
+// drop removes a pod from the cache, deleting the services it advertised.
+func (k *k8sWatcher) drop(name string) {
+	k.RLock()
+	cache, ok := k.pods[name]
+	k.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	k.emit(k.goneResults(cache))
+
+	k.Lock()
+	delete(k.pods, name)
+	delete(k.refreshed, name)
+	k.Unlock()
+}
+
+// advertised reports whether the services of a pod are advertised, which
+// they are while it runs, is ready and has an IP, unless on a cordoned node
+// skipped.
+func (k *k8sWatcher) advertised(pod *client.Pod) bool {
+	// readiness is judged per port when gating ports
+	if pod.Status == nil || pod.Status.Phase != podRunning || len(k.registry.portGates) == 0 && !podReady(pod) {
+		return false
+	}
+
+	if k.registry.terminating(pod) {
+		return false
+	}
+
+	// config maps have no IP, their nodes tell their address
+	if k.source.kind() == podKind && k.registry.pendingIP(pod) {
+		return false
+	}
+
+	return !k.registry.skipCordoned || !k.onCordonedNode(pod)
+}
+
+// podReady reports whether a pod is ready. Pods without a Ready condition
+// are, as only running is known of them.
+func podReady(pod *client.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == podReadyCondition {
+			return c.Status == "True"
+		}
+	}
+
+	return true
+}
+
+// watchObject is the part of an event object telling what it is, and what
+// went wrong when it is a Status.
+type watchObject struct {
+	Kind    string `json:"kind"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+// expectedKind reports whether an event object is of the kind the source
+// decodes, or has no kind. A Status, as sent when the watch fails, ends the
+// watch stream so it is re-established and the cache relisted. Objects of
+// other kinds are skipped.
+func (k *k8sWatcher) expectedKind(object json.RawMessage) bool {
+	var o watchObject
+	if err := json.Unmarshal(object, &o); err != nil || len(o.Kind) == 0 || o.Kind == k.source.kind() {
+		return true
+	}
+
+	if o.Kind != statusKind {
+		k.registry.logs.errorf("K8s Watcher: skipping unexpected %s object, expected %s", o.Kind, k.source.kind())
+		return false
+	}
+
+	k.registry.logs.errorf("K8s Watcher: watch failed with status %d %s: %s, relisting", o.Code, o.Reason, o.Message)
+
+	k.RLock()
 	k.watcher.Stop()
+	k.RUnlock()
 
-	select {
-	case <-k.next:
+	return false
+}
+
+// emptyObject reports whether an event object is empty, as in the keepalive
+// frames some proxies send, which are skipped quietly.
+func emptyObject(object json.RawMessage) bool {
+	o := bytes.TrimSpace(object)
+
+	return len(o) == 0 || bytes.Equal(o, []byte(`""`)) || bytes.Equal(o, []byte("null"))
+}
+
+// podResults returns the results for a pod that was added or modified,
+// turning them into deletes when the pod is no longer running.
+func (k *k8sWatcher) podResults(pod *client.Pod, cache *client.Pod) []*registry.Result {
+	running := k.advertised(pod)
+	completed := k.completed(pod, cache)
+
+	// passing in cache might not return all results, nor does it when
+	// the cached pod wasn't advertised, as all its services are new then.
+	if !running || cache != nil && !k.advertised(cache) {
+		cache = nil
+	}
+
+	results := k.buildPodResults(pod, cache)
+
+	// pod isnt running, or is terminating
+	if !running {
+		action := k.actions.Delete
+		if completed {
+			action = k.actions.Completed
+		}
+
+		for _, result := range results {
+			result.Action = action
+		}
+	}
+
+	return results
+}
+
+// completed reports whether a pod just succeeded, its services advertised
+// until then, when completions have their own action.
+func (k *k8sWatcher) completed(pod *client.Pod, cache *client.Pod) bool {
+	if len(k.actions.Completed) == 0 || pod.Status == nil || pod.Status.Phase != podSucceeded {
+		return false
+	}
+
+	return cache != nil && k.advertised(cache)
+}
+
+// removal reports whether a result action removes services.
+func (k *k8sWatcher) removal(action string) bool {
+	return action == k.actions.Delete || len(k.actions.Completed) > 0 && action == k.actions.Completed
+}
+
+// run handles watch events until the watcher is stopped, re-establishing
+// the watch whenever its stream ends.
+func (k *k8sWatcher) run() {
+	for {
+		k.RLock()
+		watcher := k.watcher
+		k.RUnlock()
+
+		for event := range watcher.ResultChan() {
+			k.handleEvent(event)
+		}
+
+		select {
+		case <-k.done:
+			return
+		default:
+		}
+
+		if !k.reconnect() {
+			return
+		}
+	}
+}
+
+// reconnect re-establishes the watch, backing off between failed attempts,
+// then resyncs the cache and emits the changes missed while disconnected.
+// It returns false when the watcher was stopped in the meantime.
+func (k *k8sWatcher) reconnect() bool {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-k.done:
+				return false
+			case <-time.After(k.registry.reconnectBackoff.NextDelay(attempt)):
+			}
+		}
+
+		watcher, err := k.source.watch(k.selector)
+		k.hooks.reconnect(err)
+
+		if err != nil {
+			k.registry.logs.errorf("K8s Watcher: failed to re-establish watch: %v", err)
+			continue
+		}
+
+		k.Lock()
+		k.watcher = watcher
+		k.Unlock()
+
+		// stopped while reconnecting
+		select {
+		case <-k.done:
+			watcher.Stop()
+			return false
+		default:
+		}
+
+		k.hooks.watchEstablished()
+
+		changes, err := k.resync()
+		if err != nil {
+			k.registry.logs.errorf("K8s Watcher: failed to resync cache: %v", err)
+			return true
+		}
+
+		k.hooks.resync(changes)
+
+		return true
+	}
+}
+
+// resync refreshes the cache and emits the changes found, as one event so
+// none is handled in between. It returns the number of changes.
+func (k *k8sWatcher) resync() (int, error) {
+	k.events.Lock()
+	defer k.events.Unlock()
+
+	k.RLock()
+	old := k.pods
+	k.RUnlock()
+
+	results, err := k.updateCache()
+	if err != nil {
+		return 0, err
+	}
+
+	if k.resetOnReconnect {
+		results = k.resetResults(old)
+	}
+
+	k.emit(results)
+
+	return len(results), nil
+}
+
+// resetResults returns deletes for the services the pods cached before a
+// resync advertised, and creates for the ones of the pods cached since.
+func (k *k8sWatcher) resetResults(old map[string]*client.Pod) []*registry.Result {
+	var results []*registry.Result
+
+	for _, pod := range old {
+		results = append(results, k.goneResults(pod)...)
+	}
+
+	// only replaced while handling events, which the caller serializes
+	k.RLock()
+	cache := k.pods
+	k.RUnlock()
+
+	for _, pod := range cache {
+		for _, result := range k.podResults(pod, nil) {
+			if result.Action == k.actions.Create {
+				results = append(results, result)
+			}
+		}
+	}
+
+	return results
+}
+
+// Refresh relists the watched objects and emits the results correcting any
+// difference with what the watcher has seen, as done on reconnect. It is
+// safe to call while events are handled, but blocks until its results are
+// received, so must not be called from the goroutine calling Next.
+func (k *k8sWatcher) Refresh() error {
+	_, err := k.resync()
+	return err
+}
+
+// emit sends the results of a single event down the wire, removals first
+// then by service name, so consumers applying them in order see the same
+// sequence every time. It gives up once the watcher is stopped.
+func (k *k8sWatcher) emit(results []*registry.Result) {
+	results = k.filterNode(k.splitNodes(results))
+
+	k.order(results)
+
+	results = k.coalesceRestarts(results)
+
+	if k.hold(results) {
 		return
-	default:
-		k.Do(func() {
-			close(k.next)
-		})
 	}
+
+	k.deliver(results)
+}
+
+// order sorts results removals first then by service name and version.
+func (k *k8sWatcher) order(results []*registry.Result) {
+	sort.SliceStable(results, func(i, j int) bool {
+		di, dj := k.removal(results[i].Action), k.removal(results[j].Action)
+		if di != dj {
+			return di
+		}
+
+		if results[i].Service.Name != results[j].Service.Name {
+			return results[i].Service.Name < results[j].Service.Name
+		}
+
+		return results[i].Service.Version < results[j].Service.Version
+	})
+}
+
+// deliver sends results down the wire, to the watchers sharing the watch
+// if shared, or queues them when compacting, with their nodes capped.
+func (k *k8sWatcher) deliver(results []*registry.Result) {
+	results = k.registry.capResults(results)
+
+	if k.shared != nil {
+		k.shared.deliver(results)
+		return
+	}
+
+	if k.compactor != nil {
+		k.compactor.push(results)
+		return
+	}
+
+	for _, result := range results {
+		if !k.registry.send(k.next, k.done, result, &k.dropped) {
+			return
+		}
+	}
+}
+
+// Next will block until a new result comes in.
+func (k *k8sWatcher) Next() (*registry.Result, error) {
+	// done is closed as soon as the watcher stops, while next is only
+	// closed once nothing sends results anymore.
+	select {
+	case <-k.done:
+		return nil, ErrWatcherStopped
+	case r, ok := <-k.next:
+		if !ok {
+			return nil, ErrWatcherStopped
+		}
+
+		return r, nil
+	}
+}
+
+// Stop will cancel any requests, and close channels.
+func (k *k8sWatcher) Stop() {
+	k.Do(func() {
+		close(k.done)
+
+		k.registry.removeCache(k)
+		k.stopRestarts()
+
+		k.RLock()
+		k.watcher.Stop()
+
+		if k.nodeWatcher != nil {
+			k.nodeWatcher.Stop()
+		}
+		k.RUnlock()
+
+		// only close once nothing sends results anymore, without waiting
+		// for it here so hooks can stop the watcher they are called from.
+		go func() {
+			k.wg.Wait()
+			close(k.next)
+		}()
+	})
 }
 
 func newWatcher(kr *kregistry, opts ...registry.WatchOption) (registry.Watcher, error) {
-	var wo registry.WatchOptions
+	wo := registry.WatchOptions{
+		Context: context.Background(),
+	}
+
 	for _, o := range opts {
 		o(&wo)
 	}
 
-	selector := podSelector
+	selector := kr.podSelector()
 	if len(wo.Service) > 0 {
-		selector = map[string]string{
-			svcSelectorPrefix + serviceName(wo.Service): svcSelectorValue,
+		selector = kr.serviceSelector(wo.Service)
+	}
+
+	pods := podSource{client: kr.client, payloadLabels: kr.payloadLabels, cipher: kr.cipher, logs: kr.logs}
+
+	var source watchSource = pods
+
+	selfPod := wo.Context.Value(selfPodKey{}) != nil
+
+	switch {
+	case kr.configMaps && selfPod:
+		return nil, ErrSelfPodConfigMaps
+	case kr.configMaps:
+		source = configMapSource{client: kr.client, cipher: kr.cipher, logs: kr.logs}
+	case selfPod:
+		podName, err := getPodName()
+		if err != nil {
+			return nil, err
 		}
+
+		source = selfPodSource{podSource: pods, name: podName, service: wo.Service}
 	}
 
 	// Create watch request
-	watcher, err := kr.client.WatchPods(selector)
+	watcher, err := source.watch(selector)
 	if err != nil {
 		return nil, err
 	}
 
 	k := &k8sWatcher{
-		registry: kr,
-		watcher:  watcher,
-		next:     make(chan *registry.Result),
-		pods:     make(map[string]*client.Pod),
+		registry:  kr,
+		source:    source,
+		selector:  selector,
+		watcher:   watcher,
+		next:      make(chan *registry.Result),
+		done:      make(chan struct{}),
+		actions:   kr.actions,
+		hooks:     kr.hooks,
+		pods:      make(map[string]*client.Pod),
+		refreshed: make(map[string]time.Time),
+		cordoned:  make(map[string]bool),
+
+		expiryChecked: time.Now(),
+	}
+
+	k.nodeResults, _ = wo.Context.Value(nodeResultsKey{}).(bool)
+
+	// a node comes and goes as the results of its own
+	if k.node, _ = wo.Context.Value(watchNodeKey{}).(string); len(k.node) > 0 {
+		k.nodeResults = true
+	}
+
+	k.metadataChanges, _ = wo.Context.Value(metadataChangesKey{}).(bool)
+	k.resetOnReconnect, _ = wo.Context.Value(resetOnReconnectKey{}).(bool)
+	k.dropOverflow, _ = wo.Context.Value(dropOverflowKey{}).(bool)
+	k.readyCounts, _ = wo.Context.Value(readyCountsKey{}).(bool)
+	k.shared, _ = wo.Context.Value(sharedKey{}).(*sharedWatch)
+
+	if window, _ := wo.Context.Value(coalesceRestartsKey{}).(time.Duration); window > 0 {
+		k.restarts = newRestarts(window)
+	}
+
+	interval, _ := wo.Context.Value(minIntervalKey{}).(time.Duration)
+	if compact, _ := wo.Context.Value(compactKey{}).(bool); compact || interval > 0 {
+		k.compactor = newCompactor(interval)
+	}
+
+	// label selectors can't OR distinct keys, so a set of services is
+	// watched through the pods of any service and filtered.
+	if names, _ := wo.Context.Value(servicesKey{}).([]string); len(names) > 0 {
+		k.services = make(map[string]bool, len(names))
+		for _, name := range names {
+			k.services[kr.normalize(name)] = true
+		}
+	}
+
+	// the pods of a service may hold another one sanitized to its key
+	if len(wo.Service) > 0 && k.services == nil {
+		k.services = map[string]bool{wo.Service: true}
+	}
+
+	if kr.skipCordoned {
+		if err := k.watchNodes(); err != nil {
+			watcher.Stop()
+			return nil, err
+		}
 	}
 
-	// update cache, but dont emit changes
-	if _, err := k.updateCache(); err != nil {
+	// update cache, only emitting the current state when asked to
+	results, err := k.updateCache()
+	if err != nil {
+		k.Stop()
 		return nil, err
 	}
 
+	var snapshot []*registry.Result
+
+	if replay, _ := wo.Context.Value(initialStateKey{}).(bool); replay {
+		action, _ := wo.Context.Value(initialStateActionKey{}).(string)
+		if len(action) == 0 {
+			action = k.actions.Create
+		}
+
+		for _, result := range results {
+			// only services that are up are part of the state
+			if result.Action != k.actions.Create {
+				continue
+			}
+
+			result.Action = action
+			snapshot = append(snapshot, result)
+		}
+	}
+
+	// the cache holds every service pod
+	if _, ok := source.(podSource); ok && len(wo.Service) == 0 {
+		kr.addCache(k)
+	}
+
+	k.hooks.watchEstablished()
+
 	// range over watch request changes, and invoke
 	// the update event
+	if k.compactor != nil {
+		k.wg.Add(1)
+
+		go func() {
+			defer k.wg.Done()
+
+			k.runCompacted()
+		}()
+	}
+
+	k.wg.Add(1)
+
 	go func() {
-		for event := range watcher.ResultChan() {
-			k.handleEvent(event)
+		defer k.wg.Done()
+
+		k.emit(snapshot)
+
+		// node changes apply on top of the initial state
+		if k.registry.skipCordoned {
+			k.wg.Add(1)
+
+			go func() {
+				defer k.wg.Done()
+
+				k.runNodes()
+			}()
 		}
 
-		k.Stop()
+		if k.registry.cacheTTL > 0 {
+			k.wg.Add(1)
+
+			go func() {
+				defer k.wg.Done()
+
+				k.runEviction()
+			}()
+		}
+
+		if kr.expireServices {
+			k.wg.Add(1)
+
+			go func() {
+				defer k.wg.Done()
+
+				k.runExpiry()
+			}()
+		}
+
+		k.run()
 	}()
 
 	return k, nil
 }
 
-func podBuildResult(pod *client.Pod, cache *client.Pod) ([]*registry.Result, map[string]bool) {
+func (k *k8sWatcher) podBuildResult(pod *client.Pod, cache *client.Pod) ([]*registry.Result, map[string]bool) {
 	results := make([]*registry.Result, 0, len(pod.Metadata.Annotations))
 	ignore := make(map[string]bool)
+	now := time.Now()
 
 	for annKey, annVal := range pod.Metadata.Annotations {
 		// check this annotation kv is a service notation
@@ -233,30 +1109,76 @@ func podBuildResult(pod *client.Pod, cache *client.Pod) ([]*registry.Result, map
 		// as we take care of it here
 		ignore[annKey] = true
 
+		// shards not all written yet, keep what was there
+		data, err := notation(pod.Metadata, annKey)
+		if err != nil {
+			continue
+		}
+
+		// expired, its delete is emitted when found expired
+		if k.registry.expiredBy(pod.Metadata, data, now) {
+			continue
+		}
+
 		// compare against cache.
-		var (
-			cacheExists bool
-			cav         *string
-		)
+		var cacheExists, relabeled, readinessChanged bool
 
 		if cache != nil && cache.Metadata != nil {
-			cav, cacheExists = cache.Metadata.Annotations[annKey]
-			if cacheExists && cav != nil && cav == annVal {
-				// service notation exists and is identical -
-				// no change result required.
-				continue
+			_, cacheExists = cache.Metadata.Annotations[annKey]
+			relabeled = k.versionRelabeled(pod, cache)
+			readinessChanged = k.portReadinessChanged(pod, cache)
+
+			if cached, err := notation(cache.Metadata, annKey); err == nil {
+				if cached == data && !relabeled && !readinessChanged && !k.labelMetadataChanged(pod, cache) &&
+					!versionsChanged(pod, cache, annKey) {
+					// service notation exists and is identical -
+					// no change result required.
+					continue
+				}
+
+				// deleted when found expired, so created again
+				if k.registry.expiredBy(cache.Metadata, cached, k.expiryChecked) {
+					cacheExists = false
+				}
+			}
+		}
+
+		// the service of the previous version is deleted, and the one of
+		// the new version created.
+		if cacheExists && relabeled {
+			if rslt := k.cachedDelete(cache, annKey); rslt != nil {
+				results = append(results, rslt)
 			}
+
+			cacheExists = false
 		}
 
 		rslt := &registry.Result{}
 		if cacheExists {
-			rslt.Action = "update"
+			rslt.Action = k.actions.Update
 		} else {
-			rslt.Action = "create"
+			rslt.Action = k.actions.Create
 		}
 
 		// unmarshal service notation from annotation value
-		if err := json.Unmarshal([]byte(*annVal), &rslt.Service); err != nil {
+		if rslt.Service, err = k.registry.decodeService(data); err != nil || rslt.Service == nil {
+			continue
+		}
+
+		if k.registry.rejectPayload(pod.Metadata.Name, rslt.Service) {
+			continue
+		}
+
+		k.registry.nodeMetadata(pod, rslt.Service)
+
+		// nodes at ports no longer ready are deleted, as updates merge
+		if (k.nodeResults || readinessChanged) && cacheExists && !versionsChanged(pod, cache, annKey) {
+			results = append(results, k.nodeChanges(rslt.Service, cache, annKey)...)
+			continue
+		}
+
+		// no port ready yet, created once one is
+		if !cacheExists && len(rslt.Service.Nodes) == 0 && len(k.registry.portGates) > 0 {
 			continue
 		}
 
@@ -265,3 +1187,141 @@ func podBuildResult(pod *client.Pod, cache *client.Pod) ([]*registry.Result, map
 
 	return results, ignore
 }
+
+// versionRelabeled reports whether the version label of a pod changed since
+// cached, when reading versions from it.
+func (k *k8sWatcher) versionRelabeled(pod *client.Pod, cache *client.Pod) bool {
+	if len(k.registry.versionLabel) == 0 {
+		return false
+	}
+
+	v, _ := k.registry.podVersion(pod.Metadata)
+	cached, _ := k.registry.podVersion(cache.Metadata)
+
+	return v != cached
+}
+
+// cachedDelete returns the delete of the service a cached pod advertised in
+// an annotation, nil when it didn't, or it expired and was deleted already,
+// or it was invalid so never returned.
+func (k *k8sWatcher) cachedDelete(cache *client.Pod, annKey string) *registry.Result {
+	data, err := notation(cache.Metadata, annKey)
+	if err != nil || k.registry.expiredBy(cache.Metadata, data, k.expiryChecked) {
+		return nil
+	}
+
+	rslt := &registry.Result{Action: k.actions.Delete}
+	if rslt.Service, err = k.registry.decodeService(data); err != nil || rslt.Service == nil {
+		return nil
+	}
+
+	if k.registry.validate && validService(rslt.Service) != nil {
+		return nil
+	}
+
+	k.registry.nodeMetadata(cache, rslt.Service)
+
+	return rslt
+}
+
+// labelMetadataChanged reports whether the metadata mirrored from the pod
+// labels changed since cached, when watching such changes.
+func (k *k8sWatcher) labelMetadataChanged(pod *client.Pod, cache *client.Pod) bool {
+	if !k.metadataChanges {
+		return false
+	}
+
+	return !reflect.DeepEqual(k.registry.mirroredLabels(pod.Metadata), k.registry.mirroredLabels(cache.Metadata))
+}
+
+// nodeChanges diffs the nodes of an updated service against the cached
+// ones, returning a create, update or delete result for each changed node.
+func (k *k8sWatcher) nodeChanges(svc *registry.Service, cache *client.Pod, annKey string) []*registry.Result {
+	old := make(map[string]*registry.Node)
+
+	if data, err := notation(cache.Metadata, annKey); err == nil {
+		if cached, err := k.registry.decodeService(data); err == nil {
+			k.registry.nodeMetadata(cache, cached)
+
+			for _, node := range cached.Nodes {
+				old[node.Id] = node
+			}
+		}
+	}
+
+	var results []*registry.Result
+
+	for _, node := range svc.Nodes {
+		prev, ok := old[node.Id]
+		delete(old, node.Id)
+
+		switch {
+		case !ok:
+			results = append(results, &registry.Result{Action: k.actions.Create, Service: withNodes(svc, node)})
+		case !reflect.DeepEqual(prev, node):
+			results = append(results, &registry.Result{Action: k.actions.Update, Service: withNodes(svc, node)})
+		}
+	}
+
+	for _, node := range old {
+		results = append(results, &registry.Result{Action: k.actions.Delete, Service: withNodes(svc, node)})
+	}
+
+	return results
+}
+
+// splitNodes returns a result per node of each result, when emitting node
+// results.
+func (k *k8sWatcher) splitNodes(results []*registry.Result) []*registry.Result {
+	if !k.nodeResults {
+		return results
+	}
+
+	split := make([]*registry.Result, 0, len(results))
+
+	for _, result := range results {
+		if len(result.Service.Nodes) <= 1 {
+			split = append(split, result)
+			continue
+		}
+
+		for _, node := range result.Service.Nodes {
+			split = append(split, &registry.Result{Action: result.Action, Service: withNodes(result.Service, node)})
+		}
+	}
+
+	return split
+}
+
+// filterNode keeps the creates and deletes of the node watched, when
+// watching a single node. Results hold a single node each then.
+func (k *k8sWatcher) filterNode(results []*registry.Result) []*registry.Result {
+	if len(k.node) == 0 {
+		return results
+	}
+
+	filtered := results[:0]
+
+	for _, result := range results {
+		if result.Action != k.actions.Create && !k.removal(result.Action) {
+			continue
+		}
+
+		for _, node := range result.Service.Nodes {
+			if node.Id == k.node || node.Address == k.node {
+				filtered = append(filtered, result)
+				break
+			}
+		}
+	}
+
+	return filtered
+}
+
+// withNodes returns a copy of a service holding the given nodes.
+func withNodes(svc *registry.Service, nodes ...*registry.Node) *registry.Service {
+	s := *svc
+	s.Nodes = nodes
+
+	return &s
+}
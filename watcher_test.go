@@ -0,0 +1,2619 @@
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-micro.dev/v4/logger"
+	"go-micro.dev/v4/registry"
+
+	"github.com/skiprco/go-micro-kubernetes-registry/client"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/mock"
+	"github.com/skiprco/go-micro-kubernetes-registry/client/watch"
+)
+
+func TestPodBuildResultIdenticalAnnotations(t *testing.T) {
+	// use distinct pointers holding the same value, as happens when
+	// the cached pod and the new pod are decoded from separate events.
+	cached := `{"name":"foo.service","version":"1"}`
+	current := `{"name":"foo.service","version":"1"}`
+
+	cache := &client.Pod{
+		Metadata: &client.Meta{
+			Name:        "pod-1",
+			Annotations: map[string]*string{annotationServiceKeyPrefix + "foo.service": &cached},
+		},
+	}
+	pod := &client.Pod{
+		Metadata: &client.Meta{
+			Name:        "pod-1",
+			Annotations: map[string]*string{annotationServiceKeyPrefix + "foo.service": &current},
+		},
+	}
+
+	r := setupRegistry().(*kregistry)
+	k := &k8sWatcher{registry: r, actions: r.actions}
+
+	results, ignore := k.podBuildResult(pod, cache)
+	if len(results) != 0 {
+		t.Fatalf("expected no results for identical annotations, got %d", len(results))
+	}
+
+	if !ignore[annotationServiceKeyPrefix+"foo.service"] {
+		t.Fatal("expected annotation to be marked as handled")
+	}
+
+	// a changed value should still produce an update
+	changed := `{"name":"foo.service","version":"2"}`
+	pod.Metadata.Annotations[annotationServiceKeyPrefix+"foo.service"] = &changed
+
+	results, _ = k.podBuildResult(pod, cache)
+	if len(results) != 1 || results[0].Action != "update" {
+		t.Fatalf("expected a single update result, got %+v", results)
+	}
+}
+
+func TestWatcherCustomActions(t *testing.T) {
+	r := setupRegistry(ResultActions(Actions{Create: "added", Delete: "removed"}))
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+
+	register(t, r, "pod-1", svc)
+	expectAction(t, w, svc.Name, "added")
+
+	svc.Version = "2"
+	if err := r.Register(svc); err != nil {
+		t.Fatalf("did not expect Register() to fail: %v", err)
+	}
+	// update was left empty so it keeps the default
+	expectAction(t, w, svc.Name, "update")
+
+	deregister(t, r, "pod-1", svc)
+	expectAction(t, w, svc.Name, "removed")
+}
+
+// expectAction reads results until one for the named service arrives and
+// checks its action, failing when none arrives in time. It returns the
+// result.
+func expectAction(t *testing.T, w registry.Watcher, name, action string) *registry.Result {
+	t.Helper()
+
+	results := make(chan *registry.Result, 1)
+	errs := make(chan error, 1)
+
+	go func() {
+		for {
+			res, err := w.Next()
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			if res.Service.Name == name {
+				results <- res
+				return
+			}
+		}
+	}()
+
+	select {
+	case res := <-results:
+		if res.Action != action {
+			t.Fatalf("expected %s result for %s, got %s", action, name, res.Action)
+		}
+
+		return res
+	case err := <-errs:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatalf("expected %s result for %s", action, name)
+	}
+
+	return nil
+}
+
+func TestWatcherConfigMaps(t *testing.T) {
+	r := setupRegistry(ConfigMaps(true))
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	v1 := `{"name":"foo.service","version":"1","nodes":[{"id":"foo-1","address":"10.0.0.1:80"}]}`
+	v2 := `{"name":"foo.service","version":"2","nodes":[{"id":"foo-1","address":"10.0.0.1:80"}]}`
+
+	cm := &client.ConfigMap{
+		Metadata: &client.Meta{
+			Name:   "services",
+			Labels: map[string]*string{labelTypeKey: &labelTypeValueService},
+		},
+		Data: map[string]string{
+			configMapServiceKeyPrefix + "foo.service": v1,
+			"unrelated": "value",
+		},
+	}
+
+	if err := mockClient.SetConfigMap(cm); err != nil {
+		t.Fatal(err)
+	}
+
+	expectAction(t, w, "foo.service", "create")
+
+	updated := *cm
+	updated.Data = map[string]string{configMapServiceKeyPrefix + "foo.service": v2}
+
+	if err := mockClient.SetConfigMap(&updated); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Action != "update" || res.Service.Version != "2" {
+		t.Fatalf("expected update to version 2, got %s %s", res.Action, res.Service.Version)
+	}
+
+	if err := mockClient.DeleteConfigMap("services"); err != nil {
+		t.Fatal(err)
+	}
+
+	expectAction(t, w, "foo.service", "delete")
+}
+
+func TestWatcherSelfPod(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	t.Setenv("HOSTNAME", "pod-1")
+
+	w, err := r.Watch(SelfPod())
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	other := &registry.Service{Name: "bar.service", Version: "1"}
+	register(t, r, "pod-2", other)
+
+	self := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", self)
+
+	res, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Action != "create" {
+		t.Fatalf("expected create result, got %s", res.Action)
+	}
+
+	validateSrv(t, self, res.Service)
+}
+
+func TestWatcherSelfPodService(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	t.Setenv("HOSTNAME", "pod-1")
+
+	w, err := r.Watch(SelfPod(), registry.WatchService("foo.service"))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	register(t, r, "pod-1", &registry.Service{Name: "bar.service", Version: "1"})
+
+	self := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", self)
+
+	// other services of the self pod are filtered out
+	res, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Action != "create" {
+		t.Fatalf("expected create result, got %s", res.Action)
+	}
+
+	validateSrv(t, self, res.Service)
+}
+
+func TestWatcherSelfPodConfigMaps(t *testing.T) {
+	r := setupRegistry(ConfigMaps(true))
+	defer teardownRegistry()
+
+	t.Setenv("HOSTNAME", "pod-1")
+
+	if _, err := r.Watch(SelfPod()); !errors.Is(err, ErrSelfPodConfigMaps) {
+		t.Fatalf("expected ErrSelfPodConfigMaps, got %v", err)
+	}
+}
+
+func TestWatcherLifecycleHooks(t *testing.T) {
+	established := make(chan bool, 10)
+	reconnects := make(chan error, 10)
+	resyncs := make(chan int, 10)
+
+	r := setupRegistry(
+		OnWatchEstablished(func() { established <- true }),
+		OnReconnect(func(err error) { reconnects <- err }),
+		OnResync(func(changes int) { resyncs <- changes }),
+	)
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	expectHook(t, established, "initial watch established")
+
+	// end the stream as the API server would
+	mockClient.Disconnect()
+
+	if err := expectHook(t, reconnects, "reconnect"); err != nil {
+		t.Fatalf("expected reconnect to succeed, got %v", err)
+	}
+
+	expectHook(t, established, "watch re-established")
+
+	if changes := expectHook(t, resyncs, "resync"); changes != 0 {
+		t.Fatalf("expected no changes on resync, got %d", changes)
+	}
+
+	// the new stream keeps delivering results
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+	expectAction(t, w, svc.Name, "create")
+}
+
+// expectHook waits for a hook to fire, returning the value it was called
+// with.
+func expectHook[T any](t *testing.T, ch chan T, what string) T {
+	t.Helper()
+
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(time.Second):
+		t.Fatalf("expected hook for %s", what)
+	}
+
+	var zero T
+
+	return zero
+}
+
+func TestWatcherResetOnReconnect(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+	register(t, r, "pod-2", &registry.Service{Name: "bar.service", Version: "1"})
+
+	w, err := r.Watch(ResetOnReconnect(true))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	// end the stream as the API server would
+	mockClient.Disconnect()
+
+	// everything is deleted, then created again
+	for _, expected := range []struct{ action, name string }{
+		{"delete", "bar.service"},
+		{"delete", "foo.service"},
+		{"create", "bar.service"},
+		{"create", "foo.service"},
+	} {
+		expectAction(t, w, expected.name, expected.action)
+	}
+}
+
+func TestWatcherRawEventHook(t *testing.T) {
+	events := make(chan watch.Event, 10)
+
+	r := setupRegistry(OnRawEvent(func(event watch.Event) { events <- event }))
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	// seen before being skipped
+	w.(*k8sWatcher).handleEvent(watch.Event{Type: watch.Modified, Object: json.RawMessage("null")})
+
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+	expectAction(t, w, "foo.service", "create")
+	register(t, r, "pod-2", &registry.Service{Name: "bar.service", Version: "1"})
+	expectAction(t, w, "bar.service", "create")
+
+	for _, expected := range []string{"", "pod-1", "pod-2"} {
+		event := expectHook(t, events, "raw event")
+
+		var pod client.Pod
+		if expected != "" {
+			if err := json.Unmarshal(event.Object, &pod); err != nil || pod.Metadata.Name != expected {
+				t.Fatalf("expected the event of %s, got %s", expected, event.Object)
+			}
+		} else if string(event.Object) != "null" {
+			t.Fatalf("expected the empty event first, got %s", event.Object)
+		}
+	}
+}
+
+func TestWatcherHookStopsWatcher(t *testing.T) {
+	var w registry.Watcher
+
+	stopped := make(chan bool, 1)
+
+	r := setupRegistry(OnReconnect(func(error) {
+		w.Stop()
+		stopped <- true
+	}))
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+
+	mockClient.Disconnect()
+	expectHook(t, stopped, "reconnect stopping the watcher")
+
+	if _, err := w.Next(); err == nil {
+		t.Fatal("expected Next() to fail once stopped")
+	}
+}
+
+func TestWatcherStopUnblocksNext(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+
+	const callers = 5
+
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			_, err := w.Next()
+			errs <- err
+		}()
+	}
+
+	w.Stop()
+
+	for i := 0; i < callers; i++ {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrWatcherStopped) {
+				t.Fatalf("expected ErrWatcherStopped, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected all blocked Next() calls to return, %d did", i)
+		}
+	}
+
+	// later calls don't block either
+	if _, err := w.Next(); !errors.Is(err, ErrWatcherStopped) {
+		t.Fatalf("expected ErrWatcherStopped, got %v", err)
+	}
+}
+
+func TestWatcherInitialStateReadiness(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+	register(t, r, "pod-2", &registry.Service{Name: "bar.service", Version: "1"})
+
+	notReady := mockClient.Pods["pod-2"]
+	notReady.Status.Conditions = []client.PodCondition{{Type: "Ready", Status: "False"}}
+
+	w, err := r.Watch(InitialState(true))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	// only the ready pod is part of the snapshot
+	expectAction(t, w, "foo.service", "create")
+
+	// the cached pod is advertised once ready
+	notReady.Status.Conditions[0].Status = "True"
+
+	if _, err := mockClient.UpdatePod("pod-2", &client.Pod{Metadata: &client.Meta{}}); err != nil {
+		t.Fatalf("did not expect UpdatePod() to fail: %v", err)
+	}
+
+	res, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Service.Name != "bar.service" || res.Action != "create" {
+		t.Fatalf("expected bar.service created once ready, got %s of %s", res.Action, res.Service.Name)
+	}
+}
+
+func TestWatcherCompletedAction(t *testing.T) {
+	r := setupRegistry(ResultActions(Actions{Completed: "completed"}))
+	defer teardownRegistry()
+
+	svc := &registry.Service{Name: "job.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	touch := func() {
+		t.Helper()
+
+		if _, err := mockClient.UpdatePod("pod-1", &client.Pod{Metadata: &client.Meta{}}); err != nil {
+			t.Fatalf("did not expect UpdatePod() to fail: %v", err)
+		}
+	}
+
+	// the job pod succeeds
+	mockClient.Pods["pod-1"].Status.Phase = "Succeeded"
+	touch()
+	expectAction(t, w, svc.Name, "completed")
+
+	// only its completion is reported as such
+	touch()
+	expectAction(t, w, svc.Name, "delete")
+}
+
+func TestWatcherInitialStateAction(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+
+	for _, tc := range []struct {
+		opts   []registry.WatchOption
+		action string
+	}{
+		{opts: []registry.WatchOption{InitialState(true)}, action: "create"},
+		{opts: []registry.WatchOption{InitialState(true), InitialStateAction("update")}, action: "update"},
+	} {
+		w, err := r.Watch(tc.opts...)
+		if err != nil {
+			t.Fatalf("failed to start watcher: %v", err)
+		}
+
+		res, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Action != tc.action {
+			t.Fatalf("expected initial state with %s action, got %s", tc.action, res.Action)
+		}
+
+		validateSrv(t, svc, res.Service)
+		w.Stop()
+	}
+}
+
+func TestWatcherSkipCordonedNodes(t *testing.T) {
+	r := setupRegistry(SkipCordonedNodes(true))
+	defer teardownRegistry()
+
+	node := &client.Node{Metadata: &client.Meta{Name: "node-1"}, Spec: &client.NodeSpec{}}
+	mockClient.Nodes[node.Metadata.Name] = node
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	setupPod("pod-1").Spec = &client.PodSpec{NodeName: "node-1"}
+
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+	expectAction(t, w, svc.Name, "create")
+
+	// cordon the node
+	if err := mockClient.SetNode(&client.Node{
+		Metadata: &client.Meta{Name: "node-1"},
+		Spec:     &client.NodeSpec{Unschedulable: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expectAction(t, w, svc.Name, "delete")
+
+	// uncordon it again
+	if err := mockClient.SetNode(&client.Node{
+		Metadata: &client.Meta{Name: "node-1"},
+		Spec:     &client.NodeSpec{},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expectAction(t, w, svc.Name, "create")
+}
+
+func TestWatcherSkipCordonedNodesOnStart(t *testing.T) {
+	r := setupRegistry(SkipCordonedNodes(true))
+	defer teardownRegistry()
+
+	mockClient.Nodes["node-1"] = &client.Node{
+		Metadata: &client.Meta{Name: "node-1"},
+		Spec:     &client.NodeSpec{Unschedulable: true},
+	}
+
+	setupPod("pod-1").Spec = &client.PodSpec{NodeName: "node-1"}
+	setupPod("pod-2").Spec = &client.PodSpec{NodeName: "node-2"}
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+	register(t, r, "pod-2", &registry.Service{Name: "bar.service", Version: "1"})
+
+	w, err := r.Watch(InitialState(true))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	// only the pod on the schedulable node is part of the initial state
+	res, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Service.Name != "bar.service" || res.Action != "create" {
+		t.Fatalf("expected create for bar.service, got %s for %s", res.Action, res.Service.Name)
+	}
+}
+
+func TestWatcherResultOrdering(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	for _, name := range []string{"d.service", "a.service", "b.service"} {
+		register(t, r, "pod-1", &registry.Service{Name: name, Version: "1"})
+	}
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	b := `{"name":"b.service","version":"2"}`
+	c := `{"name":"c.service","version":"1"}`
+
+	// a single patch removing, changing and adding services
+	go func() {
+		//nolint:errcheck
+		mockClient.UpdatePod("pod-1", &client.Pod{
+			Metadata: &client.Meta{
+				Annotations: map[string]*string{
+					annotationServiceKeyPrefix + "a.service": nil,
+					annotationServiceKeyPrefix + "b.service": &b,
+					annotationServiceKeyPrefix + "c.service": &c,
+					annotationServiceKeyPrefix + "d.service": nil,
+				},
+			},
+		})
+	}()
+
+	expected := []string{"delete a.service", "delete d.service", "update b.service", "create c.service"}
+
+	for _, e := range expected {
+		res, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := res.Action + " " + res.Service.Name; got != e {
+			t.Fatalf("expected %q, got %q", e, got)
+		}
+	}
+}
+
+func TestWatcherResyncAddsAndRemoves(t *testing.T) {
+	resyncs := make(chan int, 10)
+
+	var r registry.Registry
+
+	r = setupRegistry(
+		OnReconnect(func(error) {
+			// change pods while the watch was down
+			delete(mockClient.Pods, "pod-1")
+
+			svc := &registry.Service{Name: "bar.service", Version: "1"}
+			b, _ := compactEncode(svc)
+			notation := string(b)
+
+			pod := setupPod("pod-2")
+			pod.Metadata.Labels[labelTypeKey] = &labelTypeValueService
+			pod.Metadata.Annotations[annotationServiceKeyPrefix+"bar.service"] = &notation
+		}),
+		OnResync(func(changes int) { resyncs <- changes }),
+	)
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+	expectAction(t, w, svc.Name, "create")
+
+	mockClient.Disconnect()
+
+	expectAction(t, w, "foo.service", "delete")
+	expectAction(t, w, "bar.service", "create")
+
+	if changes := expectHook(t, resyncs, "resync"); changes != 2 {
+		t.Fatalf("expected 2 changes on resync, got %d", changes)
+	}
+}
+
+func TestPodBuildResultPriorityWeight(t *testing.T) {
+	r := setupRegistry(NodePriority("example.com/priority"), NodeWeight("example.com/weight")).(*kregistry)
+	k := &k8sWatcher{registry: r, actions: r.actions}
+
+	notation := `{"name":"foo.service","version":"1","nodes":[{"id":"foo-1","address":"10.0.0.1:80"}]}`
+	priority, weight, invalid := "10", "5", "70000"
+
+	tests := []struct {
+		name     string
+		meta     *client.Meta
+		priority string
+		weight   string
+	}{
+		{
+			name: "set",
+			meta: &client.Meta{
+				Labels:      map[string]*string{"example.com/priority": &priority},
+				Annotations: map[string]*string{"example.com/weight": &weight},
+			},
+			priority: "10",
+			weight:   "5",
+		},
+		{
+			name:     "absent",
+			meta:     &client.Meta{Annotations: map[string]*string{}},
+			priority: "0",
+			weight:   "1",
+		},
+		{
+			name: "out of range",
+			meta: &client.Meta{
+				Labels: map[string]*string{"example.com/priority": &invalid, "example.com/weight": &invalid},
+			},
+			priority: "0",
+			weight:   "1",
+		},
+	}
+
+	for _, test := range tests {
+		test.meta.Name = "pod-1"
+
+		if test.meta.Annotations == nil {
+			test.meta.Annotations = map[string]*string{}
+		}
+
+		test.meta.Annotations[annotationServiceKeyPrefix+"foo.service"] = &notation
+
+		results, _ := k.podBuildResult(&client.Pod{Metadata: test.meta}, nil)
+		if len(results) != 1 {
+			t.Fatalf("%s: expected a single result, got %d", test.name, len(results))
+		}
+
+		md := results[0].Service.Nodes[0].Metadata
+		if md["priority"] != test.priority || md["weight"] != test.weight {
+			t.Fatalf("%s: expected priority %s and weight %s, got %v", test.name, test.priority, test.weight, md)
+		}
+	}
+}
+
+func TestPodBuildResultHeadlessDNS(t *testing.T) {
+	r := setupRegistry(HeadlessDNS("cluster.local")).(*kregistry)
+	defer teardownRegistry()
+
+	k := &k8sWatcher{registry: r, actions: r.actions}
+
+	notation := `{"name":"foo.service","version":"1","nodes":[{"id":"foo-1","address":"10.0.0.1:8080"}]}`
+
+	tests := []struct {
+		name    string
+		spec    *client.PodSpec
+		address string
+	}{
+		{
+			name:    "statefulset",
+			spec:    &client.PodSpec{Hostname: "web-0", Subdomain: "web"},
+			address: "web-0.web.team.svc.cluster.local:8080",
+		},
+		{
+			name:    "no subdomain",
+			spec:    &client.PodSpec{Hostname: "web-0"},
+			address: "10.0.0.1:8080",
+		},
+		{
+			name:    "no spec",
+			address: "10.0.0.1:8080",
+		},
+	}
+
+	for _, test := range tests {
+		pod := &client.Pod{
+			Metadata: &client.Meta{
+				Name:        "web-0",
+				Namespace:   "team",
+				Annotations: map[string]*string{annotationServiceKeyPrefix + "foo.service": &notation},
+			},
+			Spec: test.spec,
+		}
+
+		results, _ := k.podBuildResult(pod, nil)
+		if len(results) != 1 {
+			t.Fatalf("%s: expected a single result, got %d", test.name, len(results))
+		}
+
+		if addr := results[0].Service.Nodes[0].Address; addr != test.address {
+			t.Fatalf("%s: expected address %s, got %s", test.name, test.address, addr)
+		}
+	}
+}
+
+func TestExpireServices(t *testing.T) {
+	defer func(d time.Duration) { expiryInterval = d }(expiryInterval)
+	expiryInterval = 20 * time.Millisecond
+
+	r := setupRegistry(ExpireServices(true), DefaultTTL(time.Hour))
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	for _, reg := range []struct {
+		pod  string
+		svc  string
+		opts []registry.RegisterOption
+	}{
+		{pod: "pod-1", svc: "batch.service", opts: []registry.RegisterOption{registry.RegisterTTL(500 * time.Millisecond)}},
+		{pod: "pod-2", svc: "api.service"},
+	} {
+		t.Setenv("HOSTNAME", reg.pod)
+		setupPod(reg.pod)
+
+		svc := &registry.Service{
+			Name:    reg.svc,
+			Version: "1",
+			Nodes:   []*registry.Node{{Id: reg.svc + "-1", Address: "10.0.0.1:80"}},
+		}
+		if err := r.Register(svc, reg.opts...); err != nil {
+			t.Fatalf("did not expect Register() to fail: %v", err)
+		}
+
+		expectAction(t, w, reg.svc, "create")
+	}
+
+	// only the service with the short TTL expires
+	expectAction(t, w, "batch.service", "delete")
+
+	if services, _ := r.GetService("batch.service"); len(services) != 0 {
+		t.Fatalf("expected batch.service to have expired, got %v", services)
+	}
+
+	if services, err := r.GetService("api.service"); err != nil || len(services) != 1 {
+		t.Fatalf("expected api.service to stay registered, got %v, %v", services, err)
+	}
+
+	// registering again brings it back
+	t.Setenv("HOSTNAME", "pod-1")
+
+	svc := &registry.Service{
+		Name:    "batch.service",
+		Version: "1",
+		Nodes:   []*registry.Node{{Id: "batch.service-1", Address: "10.0.0.1:80"}},
+	}
+	if err := r.Register(svc, registry.RegisterTTL(time.Hour)); err != nil {
+		t.Fatalf("did not expect Register() to fail: %v", err)
+	}
+
+	expectAction(t, w, "batch.service", "create")
+}
+
+func TestClockSkew(t *testing.T) {
+	r := setupRegistry(ExpireServices(true), ClockSkew(time.Minute))
+	defer teardownRegistry()
+
+	defaultLogger := logger.DefaultLogger
+	logs := &recordLogger{Logger: defaultLogger}
+	logger.DefaultLogger = logs
+
+	defer func() { logger.DefaultLogger = defaultLogger }()
+
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+
+	now := time.Now()
+	mockClient.Pods["pod-1"].Metadata.CreationTimestamp = now.Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+
+	// registers by the clock of a pod ahead of this one
+	stamp := func(registered time.Time) {
+		t.Helper()
+
+		b, err := json.Marshal(servicePayload{Service: svc, TTL: time.Hour.String(), Registered: registered})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		payload := string(b)
+		key := annotationServiceKeyPrefix + "foo.service"
+
+		if _, err := mockClient.UpdatePod("pod-1", &client.Pod{Metadata: &client.Meta{Annotations: map[string]*string{key: &payload}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// within the skew allowed, the stamp is trusted
+	stamp(now.Add(30 * time.Second))
+
+	if services, err := r.GetService("foo.service"); err != nil || len(services) != 1 {
+		t.Fatalf("expected foo.service registered within the skew allowed, got %v: %v", services, err)
+	}
+
+	if strings.Contains(logs.String(), "clock skew") {
+		t.Fatalf("did not expect a warning within the skew allowed, got %q", logs.String())
+	}
+
+	// beyond it, the service is dated from the creation of the pod so it
+	// expired an hour ago rather than in 3 hours
+	stamp(now.Add(2 * time.Hour))
+
+	if services, err := r.GetService("foo.service"); !serviceNotFound(services, err) {
+		t.Fatalf("expected foo.service to have expired, got %v", services)
+	}
+
+	if !strings.Contains(logs.String(), "pod pod-1 registered a service at") {
+		t.Fatalf("expected a warning about the registration ahead, got %q", logs.String())
+	}
+}
+
+// listCountingClient counts the pods lists.
+type listCountingClient struct {
+	*mock.Client
+	lists atomic.Int32
+}
+
+func (c *listCountingClient) ListPods(labels map[string]string) (*client.PodList, error) {
+	c.lists.Add(1)
+
+	return c.Client.ListPods(labels)
+}
+
+func TestServeFromCache(t *testing.T) {
+	kc := &listCountingClient{Client: mockClient}
+	r := NewRegistry(Client(kc), ServeFromCache(true))
+	defer teardownRegistry()
+
+	for _, p := range []string{"pod-1", "pod-2"} {
+		register(t, r, p, &registry.Service{Name: "foo.service", Version: "1", Metadata: map[string]string{"team": "a"}})
+	}
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+
+	kc.lists.Store(0)
+
+	cached, err := r.GetService("foo.service")
+	if err != nil {
+		t.Fatalf("did not expect GetService() to fail: %v", err)
+	}
+
+	if n := kc.lists.Load(); n != 0 {
+		t.Fatalf("expected GetService() to be served from cache, got %d lists", n)
+	}
+
+	listed, err := setupRegistry().GetService("foo.service")
+	if err != nil {
+		t.Fatalf("did not expect GetService() to fail: %v", err)
+	}
+
+	if len(cached) != 1 || len(listed) != 1 || !reflect.DeepEqual(cached[0].Metadata, listed[0].Metadata) ||
+		!hasNodes(cached[0].Nodes, listed[0].Nodes) || len(cached[0].Nodes) != 2 {
+		t.Fatalf("expected cached and listed services to match, got %+v and %+v", cached, listed)
+	}
+
+	// services not cached are listed
+	if _, err := r.GetService("bar.service"); !errors.Is(err, registry.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if n := kc.lists.Load(); n != 1 {
+		t.Fatalf("expected an uncached service to be listed, got %d lists", n)
+	}
+
+	// and so is everything once the watcher stops
+	w.Stop()
+
+	if _, err := r.GetService("foo.service"); err != nil || kc.lists.Load() != 2 {
+		t.Fatalf("expected GetService() to list pods without a watcher, got %d lists: %v", kc.lists.Load(), err)
+	}
+}
+
+// openBreakerClient short-circuits listing pods once open, as a client
+// whose circuit breaker opened.
+type openBreakerClient struct {
+	*mock.Client
+	open atomic.Bool
+}
+
+func TestReadYourWrites(t *testing.T) {
+	kc := &listCountingClient{Client: mockClient}
+	r := NewRegistry(Client(kc), ServeFromCache(true), ReadYourWrites(true))
+	defer teardownRegistry()
+
+	setupPod("pod-1")
+	t.Setenv("HOSTNAME", "pod-1")
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	// results are still returned, once per write
+	results := make(chan *registry.Result, 16)
+
+	go func() {
+		for {
+			res, err := w.Next()
+			if err != nil {
+				return
+			}
+
+			results <- res
+		}
+	}()
+
+	kc.lists.Store(0)
+
+	for i, action := range []string{"create", "update", "update"} {
+		version := strconv.Itoa(i + 1)
+
+		svc := &registry.Service{
+			Name:    "foo.service",
+			Version: version,
+			Nodes:   []*registry.Node{{Id: "foo-1", Address: "10.0.0.1:80"}},
+		}
+		if err := r.Register(svc); err != nil {
+			t.Fatalf("did not expect Register() to fail: %v", err)
+		}
+
+		// found in the cache right away
+		services, err := r.GetService("foo.service")
+		if err != nil || len(services) != 1 || services[0].Version != version {
+			t.Fatalf("expected version %s of foo.service right after registering it, got %v: %v", version, services, err)
+		}
+
+		if n := kc.lists.Load(); n != 0 {
+			t.Fatalf("expected GetService() to be served from cache, got %d lists", n)
+		}
+
+		if res := <-results; res.Action != action || res.Service.Version != version {
+			t.Fatalf("expected a %s of version %s, got %s of %s", action, version, res.Action, res.Service.Version)
+		}
+	}
+
+	select {
+	case res := <-results:
+		t.Fatalf("expected a single result per write, got another %s", res.Action)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func (c *openBreakerClient) ListPods(labels map[string]string) (*client.PodList, error) {
+	if c.open.Load() {
+		return nil, client.ErrCircuitOpen
+	}
+
+	return c.Client.ListPods(labels)
+}
+
+func TestGetServiceCircuitOpen(t *testing.T) {
+	kc := &openBreakerClient{Client: mockClient}
+	r := NewRegistry(Client(kc))
+	defer teardownRegistry()
+
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+
+	kc.open.Store(true)
+
+	// nothing cached yet
+	if _, err := r.GetService("foo.service"); !errors.Is(err, client.ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+
+	kc.open.Store(false)
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	kc.open.Store(true)
+
+	services, err := r.GetService("foo.service")
+	if err != nil || len(services) != 1 || len(services[0].Nodes) != 1 {
+		t.Fatalf("expected foo.service served from cache, got %v: %v", services, err)
+	}
+}
+
+func TestWatcherWatchServices(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	w, err := r.Watch(WatchServices("a.service", "c.service"))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	setupPod("pod-1")
+	t.Setenv("HOSTNAME", "pod-1")
+
+	go func() {
+		for _, name := range []string{"a.service", "b.service", "c.service"} {
+			//nolint:errcheck
+			r.Register(&registry.Service{
+				Name:    name,
+				Version: "1",
+				Nodes:   []*registry.Node{{Id: name + "-1", Address: "10.0.0.1:80"}},
+			})
+		}
+	}()
+
+	for _, e := range []string{"a.service", "c.service"} {
+		res, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Service.Name != e {
+			t.Fatalf("expected a result for %s, got %s", e, res.Service.Name)
+		}
+	}
+}
+
+// hidingClient lists pods but the hidden one, as if it went without its
+// delete event.
+type hidingClient struct {
+	*mock.Client
+	hidden atomic.Value
+}
+
+func (c *hidingClient) ListPods(labels map[string]string) (*client.PodList, error) {
+	pods, err := c.Client.ListPods(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	hidden, _ := c.hidden.Load().(string)
+	items := pods.Items[:0]
+
+	for _, pod := range pods.Items {
+		if pod.Metadata.Name != hidden {
+			items = append(items, pod)
+		}
+	}
+
+	pods.Items = items
+
+	return pods, nil
+}
+
+func TestWatcherCacheTTL(t *testing.T) {
+	kc := &hidingClient{Client: mockClient}
+	r := NewRegistry(Client(kc), CacheTTL(50*time.Millisecond))
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+	expectAction(t, w, svc.Name, "create")
+
+	bar := &registry.Service{Name: "bar.service", Version: "1"}
+	register(t, r, "pod-2", bar)
+	expectAction(t, w, bar.Name, "create")
+
+	// pod-2 goes without its delete event, no event refreshes the pods
+	// from here on.
+	kc.hidden.Store("pod-2")
+
+	results := make(chan *registry.Result, 10)
+
+	go func() {
+		for {
+			res, err := w.Next()
+			if err != nil {
+				return
+			}
+
+			results <- res
+		}
+	}()
+
+	select {
+	case res := <-results:
+		if res.Action != "delete" || res.Service.Name != bar.Name {
+			t.Fatalf("expected bar.service of pod-2 deleted, got %s of %s", res.Action, res.Service.Name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected bar.service of pod-2 deleted")
+	}
+
+	// pod-1 is listed again, so kept
+	select {
+	case res := <-results:
+		t.Fatalf("did not expect a %s of %s for a pod still running", res.Action, res.Service.Name)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestWatcherRefresh(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+	expectAction(t, w, svc.Name, "create")
+
+	// a change the watcher never got an event for
+	notation := `{"name":"foo.service","version":"2"}`
+	mockClient.Pods["pod-1"].Metadata.Annotations[annotationServiceKeyPrefix+"foo.service"] = &notation
+
+	refresher, ok := w.(Refresher)
+	if !ok {
+		t.Fatal("expected the watcher to implement Refresher")
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- refresher.Refresh()
+	}()
+
+	expectAction(t, w, svc.Name, "update")
+
+	if err := expectHook(t, errs, "refresh"); err != nil {
+		t.Fatalf("did not expect Refresh() to fail: %v", err)
+	}
+}
+
+func TestWatcherNodeResults(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	setupPod("pod-1")
+	t.Setenv("HOSTNAME", "pod-1")
+
+	svc := &registry.Service{
+		Name:    "foo.service",
+		Version: "1",
+		Nodes: []*registry.Node{
+			{Id: "foo-1", Address: "10.0.0.1:80"},
+			{Id: "foo-2", Address: "10.0.0.1:81"},
+		},
+	}
+	if err := r.Register(svc); err != nil {
+		t.Fatalf("did not expect Register() to fail: %v", err)
+	}
+
+	w, err := r.Watch(NodeResults(true))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	svc.Nodes = []*registry.Node{
+		{Id: "foo-2", Address: "10.0.0.1:82"},
+		{Id: "foo-3", Address: "10.0.0.1:83"},
+	}
+
+	go func() {
+		//nolint:errcheck
+		r.Register(svc)
+	}()
+
+	expected := map[string]string{"foo-1": "delete", "foo-2": "update", "foo-3": "create"}
+
+	for len(expected) > 0 {
+		res, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(res.Service.Nodes) != 1 {
+			t.Fatalf("expected a single node per result, got %d", len(res.Service.Nodes))
+		}
+
+		id := res.Service.Nodes[0].Id
+		if action, ok := expected[id]; !ok || res.Action != action {
+			t.Fatalf("unexpected %s result for %s", res.Action, id)
+		}
+
+		delete(expected, id)
+	}
+}
+
+func TestWatcherNode(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	setupPod("pod-1")
+	t.Setenv("HOSTNAME", "pod-1")
+
+	foo1 := &registry.Node{Id: "foo-1", Address: "10.0.0.1:80"}
+	foo2 := &registry.Node{Id: "foo-2", Address: "10.0.0.1:81"}
+
+	svc := &registry.Service{Name: "foo.service", Version: "1", Nodes: []*registry.Node{foo1, foo2}}
+	if err := r.Register(svc); err != nil {
+		t.Fatalf("did not expect Register() to fail: %v", err)
+	}
+
+	w, err := r.Watch(WatchNode("10.0.0.1:81"))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	go func() {
+		// the other node changing, then the one watched going away and
+		// coming back
+		for _, nodes := range [][]*registry.Node{
+			{{Id: "foo-1", Address: "10.0.0.1:82"}, foo2},
+			{foo1},
+			{foo1, foo2},
+		} {
+			//nolint:errcheck
+			r.Register(&registry.Service{Name: "foo.service", Version: "1", Nodes: nodes})
+		}
+	}()
+
+	for _, action := range []string{"delete", "create"} {
+		res, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Action != action || len(res.Service.Nodes) != 1 || res.Service.Nodes[0].Id != "foo-2" {
+			t.Fatalf("expected a %s of foo-2 only, got %s of %+v", action, res.Action, res.Service.Nodes)
+		}
+	}
+}
+
+func TestWatcherVersionMap(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	register(t, setupRegistry(), "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+	expectAction(t, w, "foo.service", "create")
+
+	setVersions := func(versions string) {
+		t.Helper()
+
+		key := annotationVersionsKeyPrefix + "foo.service"
+		if _, err := mockClient.UpdatePod("pod-1", &client.Pod{Metadata: &client.Meta{Annotations: map[string]*string{key: &versions}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions := func() map[string]*registry.Service {
+		t.Helper()
+
+		services, err := r.GetService("foo.service")
+		if err != nil {
+			t.Fatalf("did not expect GetService() to fail: %v", err)
+		}
+
+		byVersion := make(map[string]*registry.Service, len(services))
+		for _, svc := range services {
+			byVersion[svc.Version] = svc
+		}
+
+		return byVersion
+	}
+
+	// a version added, at the same node, the existing one unchanged
+	setVersions(`{"1":{},"2":{"compat":"1"}}`)
+
+	res := expectAction(t, w, "foo.service", "create")
+	if res.Service.Version != "2" || res.Service.Metadata["compat"] != "1" {
+		t.Fatalf("expected version 2 created with its metadata, got %+v", res.Service)
+	}
+
+	found := versions()
+	if len(found) != 2 || found["1"] == nil || found["2"] == nil {
+		t.Fatalf("expected versions 1 and 2 of foo.service, got %v", found)
+	}
+
+	if found["1"].Nodes[0].Address != found["2"].Nodes[0].Address {
+		t.Fatalf("expected both versions at the same node, got %s and %s", found["1"].Nodes[0].Address, found["2"].Nodes[0].Address)
+	}
+
+	// a version removed
+	setVersions(`{"2":{"compat":"1"}}`)
+
+	if res := expectAction(t, w, "foo.service", "delete"); res.Service.Version != "1" {
+		t.Fatalf("expected version 1 deleted, got version %s", res.Service.Version)
+	}
+
+	if found := versions(); len(found) != 1 || found["2"] == nil {
+		t.Fatalf("expected version 2 of foo.service only, got %v", found)
+	}
+}
+
+func TestWatcherEmptyEventObject(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	var logs bytes.Buffer
+
+	defaultLogger := logger.DefaultLogger
+	logger.DefaultLogger = logger.NewLogger(logger.WithOutput(&logs))
+
+	defer func() { logger.DefaultLogger = defaultLogger }()
+
+	for _, object := range []string{"", "  \n", `""`, "null"} {
+		w.(*k8sWatcher).handleEvent(watch.Event{Type: watch.Modified, Object: json.RawMessage(object)})
+	}
+
+	if logs.Len() > 0 {
+		t.Fatalf("expected empty objects to be skipped quietly, got %q", logs.String())
+	}
+
+	// the next result is the one of the next change
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+	expectAction(t, w, svc.Name, "create")
+}
+
+func TestWatcherStatusEventObject(t *testing.T) {
+	resynced := make(chan struct{}, 1)
+
+	r := setupRegistry(OnResync(func(int) { resynced <- struct{}{} }))
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	kw := w.(*k8sWatcher)
+
+	// neither is decoded as a pod
+	kw.handleEvent(watch.Event{Type: watch.Added, Object: json.RawMessage(`{"kind":"Node","metadata":{"name":"node-1"}}`)})
+	kw.handleEvent(watch.Event{Type: watch.Error, Object: json.RawMessage(
+		`{"kind":"Status","status":"Failure","reason":"Forbidden","message":"pods is forbidden","code":403}`,
+	)})
+
+	select {
+	case <-resynced:
+	case <-time.After(time.Second):
+		t.Fatal("expected a Status object to relist")
+	}
+
+	kw.RLock()
+	cached := len(kw.pods)
+	kw.RUnlock()
+
+	if cached != 0 {
+		t.Fatalf("expected nothing cached, got %d pods", cached)
+	}
+
+	// the next result is the one of the next change
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+	expectAction(t, w, svc.Name, "create")
+}
+
+func TestWatcherCompact(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	w, err := r.Watch(Compact(true))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	setupPod("pod-1")
+	t.Setenv("HOSTNAME", "pod-1")
+
+	// a create then two updates, none received
+	for _, addr := range []string{"10.0.0.1:80", "10.0.0.1:81", "10.0.0.1:82"} {
+		svc := &registry.Service{
+			Name:    "foo.service",
+			Version: "1",
+			Nodes:   []*registry.Node{{Id: "foo-1", Address: addr}},
+		}
+		if err := r.Register(svc); err != nil {
+			t.Fatalf("did not expect Register() to fail: %v", err)
+		}
+	}
+
+	// wait for the last change to be queued
+	c := w.(*k8sWatcher).compactor
+	deadline := time.After(time.Second)
+
+	for {
+		c.Lock()
+		done := len(c.pending) == 1 && c.pending[0].Service.Nodes[0].Address == "10.0.0.1:82"
+		c.Unlock()
+
+		if done {
+			break
+		}
+
+		select {
+		case <-deadline:
+			t.Fatal("expected the burst to be compacted into a single result")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	res, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Action != "update" || res.Service.Nodes[0].Address != "10.0.0.1:82" {
+		t.Fatalf("expected the latest update, got %s of %s", res.Action, res.Service.Nodes[0].Address)
+	}
+}
+
+func TestWatcherMetadataChanges(t *testing.T) {
+	r := setupRegistry(LabelPrefixToMetadata("route.example.com/"))
+	defer teardownRegistry()
+
+	shard := "a"
+	pod := setupPod("pod-1")
+	pod.Metadata.Labels["route.example.com/shard"] = &shard
+
+	register(t, r, "pod-1", &registry.Service{
+		Name:    "foo.service",
+		Version: "1",
+		Nodes:   []*registry.Node{{Id: "foo-1", Address: "10.0.0.1:80"}},
+	})
+
+	w, err := r.Watch(MetadataChanges(true))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	relabel := func(key, value string) {
+		labels := map[string]*string{key: &value}
+		if _, err := mockClient.UpdatePod("pod-1", &client.Pod{Metadata: &client.Meta{Labels: labels}}); err != nil {
+			t.Fatalf("did not expect UpdatePod() to fail: %v", err)
+		}
+	}
+
+	// labels not mirrored into metadata are no change
+	relabel("example.com/other", "x")
+	relabel("route.example.com/shard", "b")
+
+	res, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Action != "update" || res.Service.Name != "foo.service" {
+		t.Fatalf("expected foo.service updated, got %s of %s", res.Action, res.Service.Name)
+	}
+
+	if md := res.Service.Nodes[0].Metadata; md["shard"] != "b" {
+		t.Fatalf("expected the changed label in node metadata, got %v", md)
+	}
+}
+
+func TestWatcherVersionLabel(t *testing.T) {
+	r := setupRegistry(VersionLabel("app.kubernetes.io/version"))
+	defer teardownRegistry()
+
+	version := "1.0.0"
+	pod := setupPod("pod-1")
+	pod.Metadata.Labels["app.kubernetes.io/version"] = &version
+
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service"})
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	next := "1.1.0"
+	labels := map[string]*string{"app.kubernetes.io/version": &next}
+
+	if _, err := mockClient.UpdatePod("pod-1", &client.Pod{Metadata: &client.Meta{Labels: labels}}); err != nil {
+		t.Fatalf("did not expect UpdatePod() to fail: %v", err)
+	}
+
+	// the previous version is deleted first
+	for _, expected := range []struct{ action, version string }{{"delete", "1.0.0"}, {"create", "1.1.0"}} {
+		res, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Action != expected.action || res.Service.Version != expected.version {
+			t.Fatalf("expected %s of version %s, got %s of %s", expected.action, expected.version, res.Action, res.Service.Version)
+		}
+	}
+}
+
+func TestWatcherPodFilter(t *testing.T) {
+	var active atomic.Value
+	active.Store("blue")
+
+	r := setupRegistry(PodFilter(func(pod *client.Pod) bool {
+		color := pod.Metadata.Annotations["deploy/color"]
+		return color != nil && *color == active.Load().(string)
+	}))
+	defer teardownRegistry()
+
+	blue := "blue"
+	pod := setupPod("pod-1")
+	pod.Metadata.Annotations["deploy/color"] = &blue
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+	expectAction(t, w, svc.Name, "create")
+
+	// the pod no longer matches
+	green := "green"
+	annotations := map[string]*string{"deploy/color": &green}
+
+	if _, err := mockClient.UpdatePod("pod-1", &client.Pod{Metadata: &client.Meta{Annotations: annotations}}); err != nil {
+		t.Fatalf("did not expect UpdatePod() to fail: %v", err)
+	}
+
+	expectAction(t, w, svc.Name, "delete")
+
+	if services, err := r.GetService(svc.Name); err == nil && len(services) > 0 {
+		t.Fatalf("expected the filtered pod skipped by lookups, got %d services", len(services))
+	}
+
+	// the predicate matches it again
+	active.Store("green")
+
+	done := make(chan error, 1)
+	go func() { done <- w.(Refresher).Refresh() }()
+
+	expectAction(t, w, svc.Name, "create")
+
+	if err := <-done; err != nil {
+		t.Fatalf("did not expect Refresh() to fail: %v", err)
+	}
+}
+
+func TestWatcherMinInterval(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	const interval = 200 * time.Millisecond
+
+	w, err := r.Watch(MinInterval(interval))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	setupPod("pod-1")
+	t.Setenv("HOSTNAME", "pod-1")
+
+	register := func(addr string) {
+		svc := &registry.Service{
+			Name:    "foo.service",
+			Version: "1",
+			Nodes:   []*registry.Node{{Id: "foo-1", Address: addr}},
+		}
+		if err := r.Register(svc); err != nil {
+			t.Fatalf("did not expect Register() to fail: %v", err)
+		}
+	}
+
+	register("10.0.0.1:80")
+
+	if _, err := w.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	first := time.Now()
+
+	// both updates come within the interval, only the latest is received
+	register("10.0.0.1:81")
+	register("10.0.0.1:82")
+
+	res, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(first); elapsed < interval-20*time.Millisecond {
+		t.Fatalf("expected results at least %v apart, got %v", interval, elapsed)
+	}
+
+	if res.Action != "update" || res.Service.Nodes[0].Address != "10.0.0.1:82" {
+		t.Fatalf("expected the latest update, got %s of %s", res.Action, res.Service.Nodes[0].Address)
+	}
+}
+
+func TestWatcherPauseResume(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	p, ok := w.(Pauser)
+	if !ok {
+		t.Fatal("expected the watcher to implement Pauser")
+	}
+
+	p.Pause()
+
+	for _, reg := range []struct{ pod, name, addr string }{
+		{"pod-1", "foo.service", "10.0.0.1:80"},
+		{"pod-2", "bar.service", "10.0.0.2:80"},
+		{"pod-1", "foo.service", "10.0.0.1:81"},
+	} {
+		setupPod(reg.pod)
+		t.Setenv("HOSTNAME", reg.pod)
+
+		svc := &registry.Service{
+			Name:    reg.name,
+			Version: "1",
+			Nodes:   []*registry.Node{{Id: reg.name + "-1", Address: reg.addr}},
+		}
+		if err := r.Register(svc); err != nil {
+			t.Fatalf("did not expect Register() to fail: %v", err)
+		}
+	}
+
+	// wait for the last change to be buffered
+	k := w.(*k8sWatcher)
+	deadline := time.After(time.Second)
+
+	for {
+		k.pause.Lock()
+		done := len(k.pause.pending) == 2 && k.pause.pending[0].Service.Nodes[0].Address == "10.0.0.1:81"
+		k.pause.Unlock()
+
+		if done {
+			break
+		}
+
+		select {
+		case <-deadline:
+			t.Fatal("expected the results to be buffered while paused")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	p.Resume()
+
+	// in the order they first came, foo created with its latest address
+	for _, want := range []struct{ name, action, addr string }{
+		{"foo.service", "create", "10.0.0.1:81"},
+		{"bar.service", "create", "10.0.0.2:80"},
+	} {
+		res, err := w.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Service.Name != want.name || res.Action != want.action || res.Service.Nodes[0].Address != want.addr {
+			t.Fatalf("expected %s of %s at %s, got %s of %s at %s", want.action, want.name, want.addr,
+				res.Action, res.Service.Name, res.Service.Nodes[0].Address)
+		}
+	}
+
+	// results are delivered as they come again
+	t.Setenv("HOSTNAME", "pod-2")
+
+	if err := r.Deregister(&registry.Service{Name: "bar.service", Version: "1", Nodes: []*registry.Node{{Id: "bar.service-1"}}}); err != nil {
+		t.Fatalf("did not expect Deregister() to fail: %v", err)
+	}
+
+	expectAction(t, w, "bar.service", "delete")
+}
+
+func TestWatcherIgnoreSelf(t *testing.T) {
+	r := setupRegistry(IgnoreSelf(true))
+	other := setupRegistry(IgnoreSelf(true))
+
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	// changes made by the registry watched are suppressed
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+
+	// but not the ones of other instances, even on the same pod
+	register(t, other, "pod-1", &registry.Service{Name: "bar.service", Version: "1"})
+
+	results := make(chan *registry.Result, 1)
+
+	go func() {
+		if res, err := w.Next(); err == nil {
+			results <- res
+		}
+	}()
+
+	select {
+	case res := <-results:
+		if res.Service.Name != "bar.service" || res.Action != "create" {
+			t.Fatalf("expected only the create of bar.service, got %s of %s", res.Action, res.Service.Name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a result for bar.service")
+	}
+}
+
+func TestWatcherValidatePayloads(t *testing.T) {
+	r := setupRegistry(ValidatePayloads(true))
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	setupPod("pod-1")
+	t.Setenv("HOSTNAME", "pod-1")
+
+	invalid := &registry.Service{Name: "bad.service", Nodes: []*registry.Node{{Id: "bad-1", Address: "nowhere"}}}
+	if err := r.Register(invalid); err != nil {
+		t.Fatalf("did not expect Register() to fail: %v", err)
+	}
+
+	valid := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", valid)
+
+	res, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Service.Name != valid.Name {
+		t.Fatalf("expected the invalid service to be skipped, got %s", res.Service.Name)
+	}
+
+	if rejected := r.(RejectCounter).RejectedPayloads(); rejected != 1 {
+		t.Fatalf("expected 1 rejected payload, got %d", rejected)
+	}
+}
+
+func BenchmarkPodBuildResult(b *testing.B) {
+	r := setupRegistry().(*kregistry)
+	k := &k8sWatcher{registry: r, actions: r.actions}
+
+	pod := &client.Pod{
+		Metadata: &client.Meta{
+			Name:        "pod-1",
+			Annotations: make(map[string]*string),
+		},
+	}
+
+	for i := 0; i < 5; i++ {
+		svc := &registry.Service{
+			Name:     fmt.Sprintf("foo-%d.service", i),
+			Version:  "1.0.0",
+			Metadata: map[string]string{"team": "platform", "tier": "backend"},
+			Nodes: []*registry.Node{{
+				Id:       fmt.Sprintf("foo-%d-e6b1b7f2", i),
+				Address:  "10.0.0.1:8080",
+				Metadata: map[string]string{"broker": "http", "protocol": "grpc", "registry": "kubernetes", "server": "grpc", "transport": "grpc"},
+			}},
+		}
+
+		data, err := json.Marshal(svc)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		notation := string(data)
+		pod.Metadata.Annotations[annotationServiceKeyPrefix+svc.Name] = &notation
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if results, _ := k.podBuildResult(pod, nil); len(results) != 5 {
+			b.Fatalf("expected 5 results, got %d", len(results))
+		}
+	}
+}
+
+// watchCountingClient counts the pod watches opened.
+type watchCountingClient struct {
+	*mock.Client
+	watches atomic.Int32
+}
+
+func (c *watchCountingClient) WatchPods(labels map[string]string) (watch.Watch, error) {
+	c.watches.Add(1)
+	return c.Client.WatchPods(labels)
+}
+
+func TestWatcherSharedWatch(t *testing.T) {
+	kc := &watchCountingClient{Client: mockClient}
+
+	r := NewRegistry(Client(kc), SharedWatch(true))
+	defer teardownRegistry()
+
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+
+	all, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer all.Stop()
+
+	bar, err := r.Watch(registry.WatchService("bar.service"), InitialState(true))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer bar.Stop()
+
+	if n := kc.watches.Load(); n != 1 {
+		t.Fatalf("expected a single watch for both watchers, got %d", n)
+	}
+
+	// each gets the results of its services
+	register(t, r, "pod-2", &registry.Service{Name: "bar.service", Version: "1"})
+	expectAction(t, all, "bar.service", "create")
+	expectAction(t, bar, "bar.service", "create")
+
+	foo3 := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-3", foo3)
+	expectAction(t, all, "foo.service", "create")
+
+	foo, err := r.Watch(registry.WatchService("foo.service"), InitialState(true))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer foo.Stop()
+
+	// joining late, the initial state comes from the shared cache
+	nodes := make(map[string]bool)
+
+	for i := 0; i < 2; i++ {
+		res, err := foo.Next()
+		if err != nil || res.Action != "create" || res.Service.Name != "foo.service" {
+			t.Fatalf("expected the create of foo.service, got %+v, %v", res, err)
+		}
+
+		nodes[res.Service.Nodes[0].Id] = true
+	}
+
+	if !nodes["foo.service:pod-1"] || !nodes["foo.service:pod-3"] {
+		t.Fatalf("expected the nodes on pod-1 and pod-3, got %v", nodes)
+	}
+
+	// stopping one watcher keeps the shared watch for the others
+	bar.Stop()
+
+	if _, err := bar.Next(); !errors.Is(err, ErrWatcherStopped) {
+		t.Fatalf("expected the stopped watcher to fail, got %v", err)
+	}
+
+	deregister(t, r, "pod-3", foo3)
+	expectAction(t, all, "foo.service", "delete")
+	expectAction(t, foo, "foo.service", "delete")
+
+	if n := kc.watches.Load(); n != 1 {
+		t.Fatalf("expected no other watch, got %d", n)
+	}
+
+	// the last watcher stopping stops the shared watch, a new one starts it
+	all.Stop()
+	foo.Stop()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	if n := kc.watches.Load(); n != 2 {
+		t.Fatalf("expected the shared watch to restart, got %d watches", n)
+	}
+}
+
+func TestWatcherPortReadiness(t *testing.T) {
+	r := setupRegistry(PortReadiness(map[string]string{"9090": "grpc"}))
+	defer teardownRegistry()
+
+	pod := setupPod("pod-1")
+	pod.Status.ContainerStatuses = []client.ContainerStatus{{Name: "http", Ready: true}, {Name: "grpc", Ready: false}}
+	pod.Status.Conditions = []client.PodCondition{{Type: "Ready", Status: "True"}}
+
+	t.Setenv("HOSTNAME", "pod-1")
+
+	svc := &registry.Service{Name: "foo.service", Version: "1", Nodes: []*registry.Node{
+		{Id: "foo.service-http", Address: pod.Status.PodIP + ":8080"},
+		{Id: "foo.service-grpc", Address: pod.Status.PodIP + ":9090"},
+	}}
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	if err := r.Register(svc); err != nil {
+		t.Fatalf("did not expect Register() to fail: %v", err)
+	}
+
+	// only the ready port is advertised
+	res := expectAction(t, w, "foo.service", "create")
+	if len(res.Service.Nodes) != 1 || res.Service.Nodes[0].Id != "foo.service-http" {
+		t.Fatalf("expected the node at the ready port only, got %+v", res.Service.Nodes)
+	}
+
+	services, err := r.GetService("foo.service")
+	if err != nil || len(services) != 1 || len(services[0].Nodes) != 1 || services[0].Nodes[0].Id != "foo.service-http" {
+		t.Fatalf("expected GetService() to find the node at the ready port only, got %+v, %v", services, err)
+	}
+
+	setGrpcReady := func(ready bool) {
+		pod.Status.ContainerStatuses[1].Ready = ready
+
+		if _, err := mockClient.UpdatePod("pod-1", &client.Pod{Metadata: &client.Meta{}}); err != nil {
+			t.Fatalf("did not expect UpdatePod() to fail: %v", err)
+		}
+	}
+
+	// the port getting ready adds its node
+	setGrpcReady(true)
+
+	res = expectAction(t, w, "foo.service", "create")
+	if len(res.Service.Nodes) != 1 || res.Service.Nodes[0].Id != "foo.service-grpc" {
+		t.Fatalf("expected the node at the port getting ready, got %+v", res.Service.Nodes)
+	}
+
+	// and no longer ready deletes it
+	setGrpcReady(false)
+
+	res = expectAction(t, w, "foo.service", "delete")
+	if len(res.Service.Nodes) != 1 || res.Service.Nodes[0].Id != "foo.service-grpc" {
+		t.Fatalf("expected the node at the port no longer ready, got %+v", res.Service.Nodes)
+	}
+}
+
+// recordLogger records the messages logged, as the default logger always
+// prints to stdout.
+type recordLogger struct {
+	logger.Logger
+
+	mtx  sync.Mutex
+	logs []string
+}
+
+func (l *recordLogger) Log(level logger.Level, v ...interface{}) {
+	l.record(fmt.Sprint(v...))
+}
+
+func (l *recordLogger) Logf(level logger.Level, format string, v ...interface{}) {
+	l.record(fmt.Sprintf(format, v...))
+}
+
+func (l *recordLogger) record(msg string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.logs = append(l.logs, msg)
+}
+
+func (l *recordLogger) String() string {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return strings.Join(l.logs, "\n")
+}
+
+func TestWatcherLogThrottle(t *testing.T) {
+	r := setupRegistry(LogThrottle(100 * time.Millisecond))
+	defer teardownRegistry()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	defaultLogger := logger.DefaultLogger
+	logs := &recordLogger{Logger: defaultLogger}
+	logger.DefaultLogger = logs
+
+	defer func() { logger.DefaultLogger = defaultLogger }()
+
+	kw := w.(*k8sWatcher)
+	node := watch.Event{Type: watch.Added, Object: json.RawMessage(`{"kind":"Node","metadata":{"name":"node-1"}}`)}
+
+	for i := 0; i < 5; i++ {
+		kw.handleEvent(node)
+	}
+
+	if n := strings.Count(logs.String(), "skipping unexpected Node object"); n != 1 {
+		t.Fatalf("expected the error logged once within the window, got %d times", n)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if !strings.Contains(logs.String(), "(4 more occurrences in the last 100ms)") {
+		t.Fatalf("expected the repeated errors summed up, got %q", logs.String())
+	}
+
+	// the window ended, so the error is logged again
+	kw.handleEvent(node)
+
+	if n := strings.Count(logs.String(), "skipping unexpected Node object"); n != 3 {
+		t.Fatalf("expected the error logged again after the window, got %d times", n)
+	}
+}
+
+func TestWatcherDropOverflow(t *testing.T) {
+	defer func(max int) { maxPausedResults = max }(maxPausedResults)
+	maxPausedResults = 1
+
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	setupPod("pod-1")
+	setupPod("pod-2")
+
+	w, err := r.Watch(DropOverflow(true))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	stats, ok := w.(WatcherStats)
+	if !ok {
+		t.Fatal("expected the watcher to implement WatcherStats")
+	}
+
+	w.(Pauser).Pause()
+
+	// the first is buffered, the second dropped
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+	register(t, r, "pod-2", &registry.Service{Name: "bar.service", Version: "1"})
+
+	deadline := time.After(time.Second)
+
+	for stats.DroppedEvents() != 1 {
+		select {
+		case <-deadline:
+			t.Fatalf("expected a result dropped, got %d", stats.DroppedEvents())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	stats.ResetStats()
+
+	if dropped := stats.DroppedEvents(); dropped != 0 {
+		t.Fatalf("expected the counter reset, got %d", dropped)
+	}
+
+	if dropped := r.(DropCounter).DroppedEvents(); dropped != 1 {
+		t.Fatalf("expected the registry to count 1 result dropped, got %d", dropped)
+	}
+
+	w.(Pauser).Resume()
+
+	expectAction(t, w, "foo.service", "create")
+}
+
+func TestWatcherDeliveryTimeout(t *testing.T) {
+	r := setupRegistry(SharedWatch(true), DeliveryTimeout(50*time.Millisecond))
+	defer teardownRegistry()
+
+	setupPod("pod-1")
+	setupPod("pod-2")
+
+	// never calls Next
+	stuck, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer stuck.Stop()
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+	expectAction(t, w, "foo.service", "create")
+
+	register(t, r, "pod-2", &registry.Service{Name: "bar.service", Version: "1"})
+	expectAction(t, w, "bar.service", "create")
+
+	if dropped := stuck.(WatcherStats).DroppedEvents(); dropped != 2 {
+		t.Fatalf("expected the results of the stuck watcher dropped, got %d", dropped)
+	}
+
+	if dropped := w.(WatcherStats).DroppedEvents(); dropped != 0 {
+		t.Fatalf("expected no result dropped for the watcher receiving them, got %d", dropped)
+	}
+}
+
+func TestWatcherReadyCountChanges(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	setupPod("pod-1")
+	setupPod("pod-2")
+
+	w, err := r.Watch(registry.WatchService("foo.service"), ReadyCountChanges(true))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	expectCount := func(count int) {
+		t.Helper()
+
+		res := expectAction(t, w, "foo.service", "update")
+		if len(res.Service.Nodes) != count {
+			t.Fatalf("expected an update with %d nodes, got %d", count, len(res.Service.Nodes))
+		}
+	}
+
+	// scaled up
+	foo1 := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", foo1)
+	expectAction(t, w, "foo.service", "create")
+	expectCount(1)
+
+	foo2 := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-2", foo2)
+	expectAction(t, w, "foo.service", "create")
+	expectCount(2)
+
+	// scaled down
+	deregister(t, r, "pod-2", foo2)
+	expectAction(t, w, "foo.service", "delete")
+	expectCount(1)
+
+	// the last delete tells the service is gone
+	deregister(t, r, "pod-1", foo1)
+	expectAction(t, w, "foo.service", "delete")
+
+	register(t, r, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+	expectAction(t, w, "foo.service", "create")
+	expectCount(1)
+}
+
+func TestWatcherDeregisterOnTerminating(t *testing.T) {
+	for _, deregister := range []bool{true, false} {
+		t.Run(fmt.Sprintf("deregister=%v", deregister), func(t *testing.T) {
+			r := setupRegistry(DeregisterOnTerminating(deregister))
+			defer teardownRegistry()
+
+			// registering through another registry, so the watcher
+			// handles every change of the pods
+			reg := setupRegistry()
+
+			setupPod("pod-1")
+			setupPod("pod-2")
+
+			w, err := r.Watch()
+			if err != nil {
+				t.Fatalf("failed to start watcher: %v", err)
+			}
+			defer w.Stop()
+
+			register(t, reg, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+			expectAction(t, w, "foo.service", "create")
+
+			// the grace period starts
+			if err := mockClient.TerminatePod("pod-1"); err != nil {
+				t.Fatalf("failed to terminate pod: %v", err)
+			}
+
+			// a change of another service tells the termination was handled
+			register(t, reg, "pod-2", &registry.Service{Name: "bar.service", Version: "1"})
+
+			res, err := w.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if deregister && (res.Service.Name != "foo.service" || res.Action != "delete") {
+				t.Fatalf("expected foo.service deleted once terminating, got %s of %s", res.Action, res.Service.Name)
+			}
+
+			if !deregister && res.Service.Name != "bar.service" {
+				t.Fatalf("expected foo.service kept while terminating, got %s of %s", res.Action, res.Service.Name)
+			}
+
+			services, err := r.GetService("foo.service")
+			if found := err == nil && len(services) > 0; found == deregister {
+				t.Fatalf("expected foo.service found %v while terminating, got %v: %v", !deregister, services, err)
+			}
+
+			// gone once the pod is
+			if !deregister {
+				if err := mockClient.DeletePod("pod-1"); err != nil {
+					t.Fatalf("failed to delete pod: %v", err)
+				}
+
+				expectAction(t, w, "foo.service", "delete")
+			}
+		})
+	}
+}
+
+func TestWatcherCacheGauges(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	// registering through another registry, so the watcher handles every
+	// change of the pods
+	reg := setupRegistry()
+
+	setupPod("pod-1").Metadata.Namespace = "team-a"
+	setupPod("pod-2").Metadata.Namespace = "team-a"
+	setupPod("pod-3").Metadata.Namespace = "team-b"
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	expectGauges := func(ns string, pods, services int) {
+		t.Helper()
+
+		deadline := time.After(time.Second)
+
+		for {
+			g, ok := r.(CacheGauges).CacheGauges()[ns]
+			if ok && g.Pods == pods && g.Services == services {
+				if g.EventAge < 0 || g.EventAge > time.Second {
+					t.Fatalf("expected the event of %s to be recent, got %s old", ns, g.EventAge)
+				}
+
+				return
+			}
+
+			select {
+			case <-deadline:
+				t.Fatalf("expected %s to cache %d pods and %d services, got %+v", ns, pods, services, g)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	register(t, reg, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+	expectAction(t, w, "foo.service", "create")
+	expectGauges("team-a", 1, 1)
+
+	register(t, reg, "pod-2", &registry.Service{Name: "bar.service", Version: "1"})
+	expectAction(t, w, "bar.service", "create")
+	register(t, reg, "pod-3", &registry.Service{Name: "foo.service", Version: "1"})
+	expectAction(t, w, "foo.service", "create")
+
+	expectGauges("team-a", 2, 2)
+	expectGauges("team-b", 1, 1)
+
+	// the age rises while no event comes
+	time.Sleep(50 * time.Millisecond)
+
+	if age := r.(CacheGauges).CacheGauges()["team-b"].EventAge; age < 50*time.Millisecond {
+		t.Fatalf("expected the event age to rise, got %s", age)
+	}
+
+	if err := mockClient.DeletePod("pod-3"); err != nil {
+		t.Fatalf("failed to delete pod: %v", err)
+	}
+
+	expectAction(t, w, "foo.service", "delete")
+	expectGauges("team-b", 0, 0)
+}
+
+func TestWatcherPendingPodIP(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	// registering through another registry, so the watcher handles every
+	// change of the pods
+	reg := setupRegistry()
+
+	setupPod("pod-1").Status.PodIP = ""
+	setupPod("pod-2")
+
+	w, err := r.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	register(t, reg, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+
+	// a change of another service tells the registration was handled
+	register(t, reg, "pod-2", &registry.Service{Name: "bar.service", Version: "1"})
+	expectAction(t, w, "bar.service", "create")
+
+	if services, err := r.GetService("foo.service"); !serviceNotFound(services, err) {
+		t.Fatalf("expected a pod without IP not to be found, got %v: %v", services, err)
+	}
+
+	if err := mockClient.SetPodIP("pod-1", "10.0.0.200"); err != nil {
+		t.Fatalf("failed to set pod IP: %v", err)
+	}
+
+	expectAction(t, w, "foo.service", "create")
+
+	if services, err := r.GetService("foo.service"); serviceNotFound(services, err) {
+		t.Fatalf("expected the pod to be found once it has an IP, got %v", err)
+	}
+}
+
+func TestMesh(t *testing.T) {
+	ra := setupRegistry(Mesh("payments"))
+	rb := setupRegistry(Mesh("search"))
+	defer teardownRegistry()
+
+	w, err := rb.Watch()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	register(t, ra, "pod-1", &registry.Service{Name: "foo.service", Version: "1"})
+	register(t, rb, "pod-2", &registry.Service{Name: "foo.service", Version: "1"})
+
+	// the pod of the other mesh is ignored
+	res, err := w.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Action != "create" || len(res.Service.Nodes) != 1 || res.Service.Nodes[0].Id != "foo.service:pod-2" {
+		t.Fatalf("expected foo.service created on pod-2 only, got %s of %+v", res.Action, res.Service.Nodes)
+	}
+
+	pod, err := mockClient.GetPod("pod-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{labelTypeKey, svcSelectorPrefix + "foo.service"} {
+		if v := pod.Metadata.Labels[key]; v == nil || *v != "service.payments" {
+			t.Fatalf("expected label %s to be service.payments, got %v", key, v)
+		}
+	}
+
+	for r, node := range map[registry.Registry]string{ra: "foo.service:pod-1", rb: "foo.service:pod-2"} {
+		services, err := r.GetService("foo.service")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(services) != 1 || len(services[0].Nodes) != 1 || services[0].Nodes[0].Id != node {
+			t.Fatalf("expected foo.service on %s only, got %+v", node, services)
+		}
+
+		services, err = r.ListServices()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(services) != 1 || len(services[0].Nodes) != 1 || services[0].Nodes[0].Id != node {
+			t.Fatalf("expected foo.service listed on %s only, got %+v", node, services)
+		}
+	}
+
+	// registries of no mesh ignore the pods of meshes
+	if services, err := setupRegistry().GetService("foo.service"); err == nil && len(services) > 0 {
+		t.Fatalf("expected foo.service not to be found outside the meshes, got %+v", services)
+	}
+}
+
+func TestWatcherCoalesceRestarts(t *testing.T) {
+	r := setupRegistry()
+	defer teardownRegistry()
+
+	svc := &registry.Service{Name: "foo.service", Version: "1"}
+	register(t, r, "pod-1", svc)
+	register(t, r, "pod-2", &registry.Service{Name: "bar.service", Version: "1"})
+
+	w, err := r.Watch(CoalesceRestarts(time.Second))
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer w.Stop()
+
+	results := make(chan *registry.Result, 10)
+
+	go func() {
+		for {
+			res, err := w.Next()
+			if err != nil {
+				return
+			}
+
+			results <- res
+		}
+	}()
+
+	next := func() *registry.Result {
+		t.Helper()
+
+		select {
+		case res := <-results:
+			return res
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected a result for foo.service")
+		}
+
+		return nil
+	}
+
+	none := func() {
+		t.Helper()
+
+		select {
+		case res := <-results:
+			t.Fatalf("did not expect another result, got %s of %s", res.Action, res.Service.Name)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// pod-1 restarts in place: its IP goes, then it registers again at its
+	// new IP once it has it, under the node ID given.
+	restart := func(id, ip string) {
+		t.Helper()
+
+		if err := mockClient.SetPodIP("pod-1", ""); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Setenv("HOSTNAME", "pod-1")
+
+		svc.Nodes = []*registry.Node{{Id: id, Address: ip + ":80", Metadata: map[string]string{}}}
+		if err := r.Register(svc); err != nil {
+			t.Fatalf("did not expect Register() to fail: %v", err)
+		}
+
+		if err := mockClient.SetPodIP("pod-1", ip); err != nil {
+			t.Fatal(err)
+		}
+
+		res := next()
+		if res.Action != "update" || res.Service.Name != "foo.service" || res.Service.Nodes[0].Id != id ||
+			res.Service.Nodes[0].Address != ip+":80" {
+			t.Fatalf("expected foo.service replaced by %s at %s:80, got %s of %s with %+v", id, ip, res.Action, res.Service.Name, res.Service.Nodes[0])
+		}
+	}
+
+	// under the same node ID, a single result replaces the node
+	restart("foo.service:pod-1", "10.0.1.1")
+	none()
+
+	// under a new one, as processes do, the old node is deleted once
+	// replaced
+	restart("foo.service:pod-1-2", "10.0.1.2")
+
+	res := next()
+	if res.Action != "delete" || len(res.Service.Nodes) != 1 || res.Service.Nodes[0].Id != "foo.service:pod-1" {
+		t.Fatalf("expected the node before the restart deleted, got %s of %+v", res.Action, res.Service.Nodes)
+	}
+
+	none()
+
+	// pods gone for good are deleted once the window is over
+	if err := mockClient.DeletePod("pod-2"); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+
+	select {
+	case res := <-results:
+		if res.Action != "delete" || res.Service.Name != "bar.service" {
+			t.Fatalf("expected bar.service deleted, got %s of %s", res.Action, res.Service.Name)
+		}
+
+		if d := time.Since(start); d < 900*time.Millisecond {
+			t.Fatalf("expected the delete to be held back for the window, got it after %s", d)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected bar.service to be deleted")
+	}
+}